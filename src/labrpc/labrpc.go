@@ -22,8 +22,13 @@ package labrpc
 // net.Connect(endname, servername) -- connect a client to a server.
 // net.Enable(endname, enabled) -- enable/disable a client.
 // net.Reliable(bool) -- false means drop/delay messages
+// net.SetDelay(servername, delay) -- delay every request to the named server.
 //
 // end.Call("Raft.AppendEntries", &args, &reply) -- send an RPC, wait for reply.
+// end.CallContext(ctx, "Raft.AppendEntries", &args, &reply) -- same, but
+//   give up when ctx is done, e.g. when its deadline passes.
+// end.CallWithError("Raft.AppendEntries", &args, &reply) -- same as Call,
+//   but returns the error of the handler, or ErrNoReply.
 // the "Raft" is the name of the server struct to be called.
 // the "AppendEntries" is the name of the method to be called.
 // Call() returns true to indicate that the server executed the request
@@ -39,6 +44,9 @@ package labrpc
 // the server RPC handler function must declare its args and reply arguments
 // as pointers, so that their types exactly match the types of the arguments
 // to Call().
+// a handler may also return an error, which is sent back to the caller as
+// an *Error in place of the reply, keeping the code of the handler's error
+// (see ErrorCoder).
 //
 // srv := MakeServer()
 // srv.AddService(svc) -- a server can have multiple services, e.g. Raft and k/v
@@ -51,6 +59,8 @@ package labrpc
 
 import (
 	"../labgob"
+	"context"
+	"errors"
 	"fmt"
 )
 import "bytes"
@@ -73,6 +83,34 @@ type reqMsg struct {
 type replyMsg struct {
 	ok    bool
 	reply []byte
+	err   *Error // error returned by the handler, reply is empty then
+}
+
+// ErrNoReply is returned by CallWithError if no reply was received
+// from the server.
+var ErrNoReply = errors.New("labrpc: no reply from the server")
+
+// Error is an error returned by an RPC handler, as received by the
+// caller.
+type Error struct {
+	Code    string // see ErrorCoder, empty if the error has no code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorCode returns the code of the handler's error.
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
+
+// ErrorCoder is implemented by errors that classify themselves
+// with a code. the code of an error returned by a handler, or of
+// any error it wraps, is sent back to the caller in Error.Code.
+type ErrorCoder interface {
+	ErrorCode() string
 }
 
 type ClientEnd struct {
@@ -83,13 +121,44 @@ type ClientEnd struct {
 
 // send an RPC, wait for the reply.
 // the return value indicates success; false means that
-// no reply was received from the server.
+// no reply was received from the server. a call whose handler
+// returned an error succeeds, but reply is left untouched.
 func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bool {
+	ok, _, _ := e.CallWithBytes(context.Background(), svcMeth, args, reply)
+	return ok
+}
+
+// same as Call(), but gives up and returns false as soon as ctx
+// is done, e.g. when the deadline of ctx passes. the server may
+// still execute a request that was given up, but its reply is
+// dropped and reply is left untouched.
+func (e *ClientEnd) CallContext(ctx context.Context, svcMeth string, args interface{}, reply interface{}) bool {
+	ok, _, _ := e.CallWithBytes(ctx, svcMeth, args, reply)
+	return ok
+}
+
+// same as Call(), but returns the error of the handler as an
+// *Error, or ErrNoReply if no reply was received.
+func (e *ClientEnd) CallWithError(svcMeth string, args interface{}, reply interface{}) error {
+	ok, _, err := e.CallWithBytes(context.Background(), svcMeth, args, reply)
+	if !ok {
+		return ErrNoReply
+	}
+	return err
+}
+
+// same as CallContext(), but also returns the number of bytes of
+// the encoded request and reply, which are only counted if the
+// reply is received, and the error of the handler as an *Error.
+func (e *ClientEnd) CallWithBytes(ctx context.Context, svcMeth string, args interface{},
+	reply interface{}) (bool, int64, error) {
 	req := reqMsg{}
 	req.endname = e.endname
 	req.svcMeth = svcMeth
 	req.argsType = reflect.TypeOf(args)
-	req.replyCh = make(chan replyMsg)
+	// buffered, so that the network does not block on the reply
+	// of a call that was given up.
+	req.replyCh = make(chan replyMsg, 1)
 
 	qb := new(bytes.Buffer)
 	qe := labgob.NewEncoder(qb)
@@ -107,23 +176,32 @@ func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bo
 		// the request has been sent.
 	case <-e.done:
 		// entire Network has been destroyed.
-		return false
+		return false, 0, nil
+	case <-ctx.Done():
+		return false, 0, nil
 	}
 
 	//
 	// wait for the reply.
 	//
-	rep := <-req.replyCh
-	if rep.ok {
-		rb := bytes.NewBuffer(rep.reply)
-		rd := labgob.NewDecoder(rb)
-		if err := rd.Decode(reply); err != nil {
-			log.Fatalf("ClientEnd.Call(): decode reply: %v\n", err)
-		}
-		return true
-	} else {
-		return false
+	var rep replyMsg
+	select {
+	case rep = <-req.replyCh:
+	case <-ctx.Done():
+		return false, 0, nil
+	}
+	if !rep.ok {
+		return false, 0, nil
 	}
+	if rep.err != nil {
+		return true, int64(len(req.args)), rep.err
+	}
+	rb := bytes.NewBuffer(rep.reply)
+	rd := labgob.NewDecoder(rb)
+	if err := rd.Decode(reply); err != nil {
+		log.Fatalf("ClientEnd.Call(): decode reply: %v\n", err)
+	}
+	return true, int64(len(req.args) + len(rep.reply)), nil
 }
 
 type Network struct {
@@ -136,9 +214,10 @@ type Network struct {
 	servers        map[interface{}]*Server     // servers, by name
 	connections    map[interface{}]interface{} // endname -> servername
 	endCh          chan reqMsg
-	done           chan struct{} // closed when Network is cleaned up
-	count          int32         // total RPC count, for statistics
-	bytes          int64         // total bytes send, for statistics
+	done           chan struct{}                 // closed when Network is cleaned up
+	count          int32                         // total RPC count, for statistics
+	bytes          int64                         // total bytes send, for statistics
+	delays         map[interface{}]time.Duration // extra delay of requests, by server name
 }
 
 func MakeNetwork() *Network {
@@ -148,6 +227,7 @@ func MakeNetwork() *Network {
 	rn.enabled = map[interface{}]bool{}
 	rn.servers = map[interface{}]*Server{}
 	rn.connections = map[interface{}](interface{}){}
+	rn.delays = map[interface{}]time.Duration{}
 	rn.endCh = make(chan reqMsg)
 	rn.done = make(chan struct{})
 
@@ -193,6 +273,22 @@ func (rn *Network) LongDelays(yes bool) {
 	rn.longDelays = yes
 }
 
+// delay every request to the named server before it is executed,
+// e.g. to simulate a slow or distant server. zero removes the delay.
+func (rn *Network) SetDelay(servername interface{}, delay time.Duration) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.delays[servername] = delay
+}
+
+func (rn *Network) readServerDelay(servername interface{}) time.Duration {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.delays[servername]
+}
+
 func (rn *Network) readEndnameInfo(endname interface{}) (enabled bool,
 	servername interface{}, server *Server, reliable bool, longreordering bool,
 ) {
@@ -229,9 +325,13 @@ func (rn *Network) processReq(req reqMsg) {
 			time.Sleep(time.Duration(ms) * time.Millisecond)
 		}
 
+		if delay := rn.readServerDelay(servername); delay > 0 {
+			time.Sleep(delay)
+		}
+
 		if reliable == false && (rand.Int()%1000) < 100 {
 			// drop the request, return as if timeout
-			req.replyCh <- replyMsg{false, nil}
+			req.replyCh <- replyMsg{false, nil, nil}
 			return
 		}
 
@@ -275,10 +375,10 @@ func (rn *Network) processReq(req reqMsg) {
 
 		if replyOK == false || serverDead == true {
 			// server was killed while we were waiting; return error.
-			req.replyCh <- replyMsg{false, nil}
+			req.replyCh <- replyMsg{false, nil, nil}
 		} else if reliable == false && (rand.Int()%1000) < 100 {
 			// drop the reply, return as if timeout
-			req.replyCh <- replyMsg{false, nil}
+			req.replyCh <- replyMsg{false, nil, nil}
 		} else if longreordering == true && rand.Intn(900) < 600 {
 			// delay the response for a while
 			ms := 200 + rand.Intn(1+rand.Intn(2000))
@@ -306,7 +406,7 @@ func (rn *Network) processReq(req reqMsg) {
 			ms = (rand.Int() % 100)
 		}
 		time.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
-			req.replyCh <- replyMsg{false, nil}
+			req.replyCh <- replyMsg{false, nil, nil}
 		})
 	}
 
@@ -429,7 +529,7 @@ func (rs *Server) dispatch(req reqMsg) replyMsg {
 		}
 		log.Fatalf("labrpc.Server.dispatch(): unknown service %v in %v.%v; expecting one of %v\n",
 			serviceName, serviceName, methodName, choices)
-		return replyMsg{false, nil}
+		return replyMsg{false, nil, nil}
 	}
 }
 
@@ -448,6 +548,8 @@ type Service struct {
 	methods map[string]reflect.Method
 }
 
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
 func MakeService(rcvr interface{}) *Service {
 	svc := &Service{}
 	svc.typ = reflect.TypeOf(rcvr)
@@ -467,7 +569,8 @@ func MakeService(rcvr interface{}) *Service {
 			mtype.NumIn() != 3 ||
 			//mtype.In(1).Kind() != reflect.Ptr ||
 			mtype.In(2).Kind() != reflect.Ptr ||
-			mtype.NumOut() != 0 {
+			mtype.NumOut() > 1 ||
+			(mtype.NumOut() == 1 && mtype.Out(0) != errorType) {
 			// the method is not suitable for a handler
 			//fmt.Printf("bad method: %v\n", mname)
 		} else {
@@ -479,6 +582,17 @@ func MakeService(rcvr interface{}) *Service {
 	return svc
 }
 
+// makeError copies the message and the code of a handler's error
+// into an Error, so that no reference to the error is sent back.
+func makeError(err error) *Error {
+	e := &Error{Message: err.Error()}
+	var coder ErrorCoder
+	if errors.As(err, &coder) {
+		e.Code = coder.ErrorCode()
+	}
+	return e
+}
+
 func (svc *Service) dispatch(methname string, req reqMsg) replyMsg {
 	if method, ok := svc.methods[methname]; ok {
 		// prepare space into which to read the argument.
@@ -497,14 +611,19 @@ func (svc *Service) dispatch(methname string, req reqMsg) replyMsg {
 
 		// call the method.
 		function := method.Func
-		function.Call([]reflect.Value{svc.rcvr, args.Elem(), replyv})
+		results := function.Call([]reflect.Value{svc.rcvr, args.Elem(), replyv})
+
+		// send back the error of the handler instead of the reply.
+		if len(results) == 1 && !results[0].IsNil() {
+			return replyMsg{true, nil, makeError(results[0].Interface().(error))}
+		}
 
 		// encode the reply.
 		rb := new(bytes.Buffer)
 		re := labgob.NewEncoder(rb)
 		re.EncodeValue(replyv)
 
-		return replyMsg{true, rb.Bytes()}
+		return replyMsg{true, rb.Bytes(), nil}
 	} else {
 		choices := []string{}
 		for k := range svc.methods {
@@ -512,6 +631,6 @@ func (svc *Service) dispatch(methname string, req reqMsg) replyMsg {
 		}
 		log.Fatalf("labrpc.Service.dispatch(): unknown method %v in %v; expecting one of %v\n",
 			methname, req.svcMeth, choices)
-		return replyMsg{false, nil}
+		return replyMsg{false, nil, nil}
 	}
 }
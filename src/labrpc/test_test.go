@@ -6,6 +6,8 @@ import "sync"
 import "runtime"
 import "time"
 import "fmt"
+import "context"
+import "errors"
 
 type JunkArgs struct {
 	X int
@@ -66,6 +68,27 @@ func (js *JunkServer) Handler7(args int, reply *string) {
 	}
 }
 
+type junkError struct {
+	code string
+}
+
+func (je *junkError) Error() string {
+	return "junk error " + je.code
+}
+
+func (je *junkError) ErrorCode() string {
+	return je.code
+}
+
+// returns an error if args is negative
+func (js *JunkServer) Handler8(args int, reply *string) error {
+	if args < 0 {
+		return fmt.Errorf("handler8: %w", &junkError{strconv.Itoa(-args)})
+	}
+	*reply = "handler8-" + strconv.Itoa(args)
+	return nil
+}
+
 func TestBasic(t *testing.T) {
 	runtime.GOMAXPROCS(4)
 
@@ -565,6 +588,109 @@ func TestKilled(t *testing.T) {
 	}
 }
 
+//
+// a call gives up when the deadline of its context passes,
+// and the reply of the server is dropped.
+//
+func TestCallContext(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e := rn.MakeEnd("end1-99")
+
+	js := &JunkServer{}
+	svc := MakeService(js)
+
+	rs := MakeServer()
+	rs.AddService(svc)
+	rn.AddServer("server99", rs)
+
+	rn.Connect("end1-99", "server99")
+	rn.Enable("end1-99", true)
+	rn.SetDelay("server99", 200*time.Millisecond)
+
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		reply := ""
+		t0 := time.Now()
+		ok := e.CallContext(ctx, "JunkServer.Handler2", 111, &reply)
+		if ok || reply != "" {
+			t.Fatalf("expected the call to time out, got ok=%v reply=%v", ok, reply)
+		}
+		if time.Since(t0) > 150*time.Millisecond {
+			t.Fatalf("the call did not give up at its deadline")
+		}
+	}
+
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		reply := ""
+		ok, bytes, err := e.CallWithBytes(ctx, "JunkServer.Handler2", 111, &reply)
+		if !ok || err != nil || reply != "handler2-111" || bytes <= 0 {
+			t.Fatalf("wrong reply from Handler2 within the deadline")
+		}
+	}
+}
+
+//
+// the error returned by a handler is sent back with its code,
+// and the reply is left untouched.
+//
+func TestHandlerError(t *testing.T) {
+	runtime.GOMAXPROCS(4)
+
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+
+	e := rn.MakeEnd("end1-99")
+
+	js := &JunkServer{}
+	svc := MakeService(js)
+
+	rs := MakeServer()
+	rs.AddService(svc)
+	rn.AddServer("server99", rs)
+
+	rn.Connect("end1-99", "server99")
+	rn.Enable("end1-99", true)
+
+	{
+		reply := ""
+		err := e.CallWithError("JunkServer.Handler8", 111, &reply)
+		if err != nil || reply != "handler8-111" {
+			t.Fatalf("wrong reply from Handler8: %v %v", reply, err)
+		}
+	}
+
+	{
+		reply := "untouched"
+		err := e.CallWithError("JunkServer.Handler8", -7, &reply)
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) || rpcErr.Code != "7" || rpcErr.Message != "handler8: junk error 7" {
+			t.Fatalf("wrong error from Handler8: %v", err)
+		}
+		if reply != "untouched" {
+			t.Fatalf("the reply should be left untouched on error, got %v", reply)
+		}
+		// an error still counts as a reply
+		if !e.Call("JunkServer.Handler8", -7, &reply) {
+			t.Fatalf("Call should succeed when the handler returns an error")
+		}
+	}
+
+	rn.Enable("end1-99", false)
+	{
+		reply := ""
+		if err := e.CallWithError("JunkServer.Handler8", 111, &reply); err != ErrNoReply {
+			t.Fatalf("expected ErrNoReply from a disabled end, got %v", err)
+		}
+	}
+}
+
 func TestBenchmark(t *testing.T) {
 	runtime.GOMAXPROCS(4)
 
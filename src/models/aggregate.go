@@ -0,0 +1,442 @@
+package models
+
+import (
+	"sort"
+)
+
+// enumeration of aggregate functions
+const (
+	AggregateCount = "COUNT"
+	AggregateSum   = "SUM"
+	AggregateAvg   = "AVG"
+	AggregateMin   = "MIN"
+	AggregateMax   = "MAX"
+)
+
+// Aggregate is an aggregate function on a column, like SUM(grade). Column can be "*" for COUNT(*), which counts rows
+// instead of non-nil values.
+type Aggregate struct {
+	Func   string
+	Column string
+	// name of the result column, "FUNC(column)" is used if it is empty
+	Alias string
+}
+
+// AggregateQuery computes aggregates over the rows of a table that satisfy Where, grouped by the values of the GroupBy
+// columns, like SELECT GroupBy..., Aggregates... FROM TableName WHERE Where GROUP BY GroupBy...
+type AggregateQuery struct {
+	TableName string
+	// Where[colName] -> [Condition1, Condition2, ...], see SatisfiesPredicate
+	Where      map[string][]Condition
+	GroupBy    []string
+	Aggregates []Aggregate
+}
+
+// AggregateState is the partial state of an aggregate, partial states of disjoint rows can be merged.
+type AggregateState struct {
+	// number of aggregated rows (COUNT(*)) or non-nil values
+	Count int64
+	Sum   float64
+	Min   interface{}
+	Max   interface{}
+}
+
+// PartialAggregateGroup holds the partial states of the aggregates of a group.
+type PartialAggregateGroup struct {
+	// values of the GroupBy columns
+	GroupValues Row
+	// States[i] -> state of the i-th aggregate
+	States []AggregateState
+}
+
+// PartialAggregateArgs asks a node to compute the partial aggregates of a query over some fragments.
+type PartialAggregateArgs struct {
+	Query AggregateQuery
+	// names of the fragments on the node, their rows should be disjoint
+	FragmentNames []string
+	// the snapshot to read, see Node.snapshotRowIterator
+	Snapshot Snapshot
+}
+
+// PartialAggregateReply is the reply of Node.PartialAggregate.
+type PartialAggregateReply struct {
+	Groups []PartialAggregateGroup
+}
+
+// GetName returns the name of the result column of the aggregate.
+func (aggregate *Aggregate) GetName() string {
+	if aggregate.Alias != "" {
+		return aggregate.Alias
+	}
+	return aggregate.Func + "(" + aggregate.Column + ")"
+}
+
+// isIdempotent returns true if aggregating a row more than once does not change the result.
+func (aggregate *Aggregate) isIdempotent() bool {
+	return aggregate.Func == AggregateMin || aggregate.Func == AggregateMax
+}
+
+// getResultType returns the data type of the aggregate results, given the data type of the column.
+func (aggregate *Aggregate) getResultType(colType int) int {
+	switch aggregate.Func {
+	case AggregateCount:
+		return TypeInt64
+	case AggregateAvg:
+		return TypeDouble
+	case AggregateSum:
+		if colType == TypeFloat || colType == TypeDouble {
+			return TypeDouble
+		}
+		return TypeInt64
+	default:
+		return colType
+	}
+}
+
+// getNeededColumns returns the columns that have to be read to answer the query.
+func (query *AggregateQuery) getNeededColumns() []string {
+	colNames := make([]string, 0)
+	isAdded := make(map[string]bool)
+	add := func(colName string) {
+		if !isAdded[colName] {
+			isAdded[colName] = true
+			colNames = append(colNames, colName)
+		}
+	}
+	// sort the columns in Where so that the order is deterministic
+	whereColNames := make([]string, 0, len(query.Where))
+	for colName := range query.Where {
+		whereColNames = append(whereColNames, colName)
+	}
+	sort.Strings(whereColNames)
+	for _, colName := range whereColNames {
+		add(colName)
+	}
+	for _, colName := range query.GroupBy {
+		add(colName)
+	}
+	for _, aggregate := range query.Aggregates {
+		if aggregate.Column != "*" {
+			add(aggregate.Column)
+		}
+	}
+	return colNames
+}
+
+// validate checks that the query is well-formed against the schema of its table.
+func (query *AggregateQuery) validate(schema TableSchema) error {
+	for _, colName := range query.getNeededColumns() {
+		if schema.GetColIndexByName(colName) == -1 {
+			return missingColumnError(query.TableName, colName)
+		}
+	}
+	for _, aggregate := range query.Aggregates {
+		switch aggregate.Func {
+		case AggregateCount, AggregateMin, AggregateMax:
+		case AggregateSum, AggregateAvg:
+			colType := schema.GetColTypeByName(aggregate.Column)
+			if aggregate.Column == "*" || colType == TypeString || colType == TypeBoolean {
+				return newError(ErrSchemaMismatch, aggregate.Func+" needs a numeric column")
+			}
+		default:
+			return newError(ErrInvalidArgument, "unknown aggregate function "+aggregate.Func)
+		}
+		if aggregate.Column == "*" && aggregate.Func != AggregateCount {
+			return newError(ErrInvalidArgument, "only COUNT can be applied on *")
+		}
+	}
+	return nil
+}
+
+// toFloat64 converts a numeric value into float64.
+func toFloat64(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// aggregator computes the partial aggregates of a query over rows following a schema.
+type aggregator struct {
+	query  *AggregateQuery
+	schema TableSchema
+	// indices of the GroupBy columns and the aggregated columns in the rows, -1 for "*"
+	groupColIdxs     []int
+	aggregateColIdxs []int
+	// groups[group key] -> partial states of the group
+	groups map[string]*PartialAggregateGroup
+}
+
+func newAggregator(query *AggregateQuery, schema TableSchema) *aggregator {
+	agg := &aggregator{query: query, schema: schema, groups: make(map[string]*PartialAggregateGroup)}
+	for _, colName := range query.GroupBy {
+		agg.groupColIdxs = append(agg.groupColIdxs, schema.GetColIndexByName(colName))
+	}
+	for _, aggregate := range query.Aggregates {
+		agg.aggregateColIdxs = append(agg.aggregateColIdxs, schema.GetColIndexByName(aggregate.Column))
+	}
+	return agg
+}
+
+// getGroup returns the group of the given group values, the group is created if it does not exist.
+func (agg *aggregator) getGroup(groupValues Row) *PartialAggregateGroup {
+	key := getValuesKey(groupValues)
+	group, ok := agg.groups[key]
+	if !ok {
+		group = &PartialAggregateGroup{GroupValues: groupValues,
+			States: make([]AggregateState, len(agg.query.Aggregates))}
+		agg.groups[key] = group
+	}
+	return group
+}
+
+// add aggregates a row if it satisfies the Where predicate of the query.
+func (agg *aggregator) add(row Row) {
+	if !row.SatisfiesPredicate(agg.schema, agg.query.Where) {
+		return
+	}
+
+	groupValues := make(Row, len(agg.groupColIdxs))
+	for i, colIdx := range agg.groupColIdxs {
+		groupValues[i] = row[colIdx]
+	}
+	group := agg.getGroup(groupValues)
+
+	for i, colIdx := range agg.aggregateColIdxs {
+		if colIdx == -1 {
+			// COUNT(*)
+			group.States[i].Count++
+			continue
+		}
+		value := row[colIdx]
+		if value == nil {
+			continue
+		}
+		colType := agg.schema.ColumnSchemas[colIdx].DataType
+		agg.mergeState(&group.States[i], AggregateState{Count: 1, Sum: toFloat64(value), Min: value, Max: value},
+			colType)
+	}
+}
+
+// mergeState merges a partial state into another one.
+func (agg *aggregator) mergeState(state *AggregateState, another AggregateState, colType int) {
+	if another.Count == 0 {
+		return
+	}
+	if state.Count == 0 || CompareValues(colType, another.Min, state.Min) < 0 {
+		state.Min = another.Min
+	}
+	if state.Count == 0 || CompareValues(colType, another.Max, state.Max) > 0 {
+		state.Max = another.Max
+	}
+	state.Count += another.Count
+	state.Sum += another.Sum
+}
+
+// merge merges the partial states of a group computed elsewhere.
+func (agg *aggregator) merge(another PartialAggregateGroup) {
+	group := agg.getGroup(another.GroupValues)
+	for i, aggregate := range agg.query.Aggregates {
+		agg.mergeState(&group.States[i], another.States[i], agg.schema.GetColTypeByName(aggregate.Column))
+	}
+}
+
+// getGroups returns the partial states of all groups.
+func (agg *aggregator) getGroups() []PartialAggregateGroup {
+	groups := make([]PartialAggregateGroup, 0, len(agg.groups))
+	for _, group := range agg.groups {
+		groups = append(groups, *group)
+	}
+	return groups
+}
+
+// getResult computes the final aggregates of all groups. The results contain a single group if there are no GroupBy
+// columns, even if no rows are aggregated.
+func (agg *aggregator) getResult() Dataset {
+	result := Dataset{}
+	result.Schema.TableName = agg.query.TableName
+	for _, colName := range agg.query.GroupBy {
+		result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas,
+			ColumnSchema{Name: colName, DataType: agg.schema.GetColTypeByName(colName)})
+	}
+	for _, aggregate := range agg.query.Aggregates {
+		colType := agg.schema.GetColTypeByName(aggregate.Column)
+		result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas,
+			ColumnSchema{Name: aggregate.GetName(), DataType: aggregate.getResultType(colType)})
+	}
+
+	if len(agg.query.GroupBy) == 0 {
+		agg.getGroup(Row{})
+	}
+
+	for _, group := range agg.groups {
+		row := make(Row, 0, len(result.Schema.ColumnSchemas))
+		row = append(row, group.GroupValues...)
+		for i, aggregate := range agg.query.Aggregates {
+			state := group.States[i]
+			colType := agg.schema.GetColTypeByName(aggregate.Column)
+			var value interface{}
+			switch aggregate.Func {
+			case AggregateCount:
+				value = state.Count
+			case AggregateSum:
+				if state.Count > 0 {
+					if aggregate.getResultType(colType) == TypeInt64 {
+						value = int64(state.Sum)
+					} else {
+						value = state.Sum
+					}
+				}
+			case AggregateAvg:
+				if state.Count > 0 {
+					value = state.Sum / float64(state.Count)
+				}
+			case AggregateMin:
+				value = state.Min
+			case AggregateMax:
+				value = state.Max
+			}
+			row = append(row, value)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result
+}
+
+// AggregateDataset computes the aggregates of a query over the rows of a dataset (like the results of a join) instead
+// of a table, the TableName of the query only names the results.
+func AggregateDataset(dataset Dataset, query AggregateQuery) (Dataset, error) {
+	if err := query.validate(dataset.Schema); err != nil {
+		return Dataset{}, err
+	}
+	agg := newAggregator(&query, dataset.Schema)
+	for _, row := range dataset.Rows {
+		agg.add(row)
+	}
+	return agg.getResult(), nil
+}
+
+// PartialAggregate computes the partial aggregates of a query over the given fragments on this node, whose rows should
+// be disjoint. Every fragment should hold all columns needed by the query.
+func (n *Node) PartialAggregate(args PartialAggregateArgs, reply *PartialAggregateReply) error {
+	var agg *aggregator
+	for _, fragmentName := range args.FragmentNames {
+		table, ok := n.getTable(fragmentName)
+		if !ok {
+			return noSuchTableError(fragmentName)
+		}
+		// the fragments of a table hold the same columns in the same order
+		if agg == nil {
+			agg = newAggregator(&args.Query, *table.schema)
+		}
+		rowIterator := n.snapshotRowIterator(table, fragmentName, args.Snapshot)
+		for rowIterator.HasNext() {
+			// skip the row idx in the first column
+			agg.add((*rowIterator.Next())[1:])
+		}
+	}
+	if agg != nil {
+		reply.Groups = agg.getGroups()
+	}
+	return nil
+}
+
+// planPartialAggregate chooses the fragments of the table to aggregate on the nodes, it returns the node rules holding
+// the rows that may satisfy the query, or false if the query cannot be answered by merging their partial aggregates.
+// It is the case when some fragment does not hold all needed columns, or when a row may be held by the fragments of
+// more than one rule (thus aggregated more than once) and some aggregate is not idempotent.
+func (c *Cluster) planPartialAggregate(query *AggregateQuery) ([]NodeRule, bool) {
+	schema, _ := c.getTableSchema(query.TableName)
+	nodeRules, ok := c.getQueriedNodeRules(query.TableName, query.Where, query.getNeededColumns())
+	if !ok {
+		return nil, false
+	}
+
+	isIdempotent := true
+	for _, aggregate := range query.Aggregates {
+		isIdempotent = isIdempotent && aggregate.isIdempotent()
+	}
+	if !isIdempotent {
+		for i := range nodeRules {
+			for j := i + 1; j < len(nodeRules); j++ {
+				if !arePredicatesDisjoint(schema, nodeRules[i].Rule.Predicate, nodeRules[j].Rule.Predicate) {
+					return nil, false
+				}
+			}
+		}
+	}
+	return nodeRules, true
+}
+
+// Aggregate computes the aggregates of a query, see AggregateQuery.
+// When possible, each node computes the partial aggregates of its fragments (only one replica of each fragment is
+// chosen by SetCover), and the coordinator merges the partial aggregates, so only one row per group is sent by a node.
+// Otherwise, the needed columns of the table are fetched and aggregated at the coordinator.
+// Set reply as a Dataset holding the GroupBy columns followed by the aggregates, with one row per group.
+func (c *Cluster) Aggregate(query AggregateQuery, reply *Dataset) error {
+	result, err := c.aggregate(query, c.takeSnapshot())
+	if err != nil {
+		return err
+	}
+	*reply = result
+	return nil
+}
+
+// aggregate computes the aggregates of a query reading the nodes as of the given snapshot, see Aggregate.
+func (c *Cluster) aggregate(query AggregateQuery, snapshot Snapshot) (Dataset, error) {
+	schema, ok := c.getTableSchema(query.TableName)
+	if !ok {
+		return Dataset{}, noSuchTableError(query.TableName)
+	}
+	if err := query.validate(schema); err != nil {
+		return Dataset{}, err
+	}
+
+	nodeRules, ok := c.planPartialAggregate(&query)
+	if !ok {
+		// aggregate at the coordinator
+		pkRowMap, projectedSchema, err := c.projectTable(query.TableName, query.getNeededColumns(), nil, false,
+			snapshot)
+		if err != nil {
+			return Dataset{}, err
+		}
+		agg := newAggregator(&query, projectedSchema)
+		for _, row := range pkRowMap {
+			agg.add(row)
+		}
+		return agg.getResult(), nil
+	}
+
+	var agg *aggregator
+	if err := c.readWithFailover(query.TableName, nodeRules, func(nodeIdxs []int,
+		nodeRuleIdxsMap map[int][]int) error {
+		agg = newAggregator(&query, schema)
+		for _, nodeIdx := range nodeIdxs {
+			args := PartialAggregateArgs{Query: query, Snapshot: snapshot}
+			for _, ruleIdx := range nodeRuleIdxsMap[nodeIdx] {
+				args.FragmentNames = append(args.FragmentNames, getFragmentName(query.TableName, ruleIdx))
+			}
+
+			var nodeReply PartialAggregateReply
+			if _, err := c.callNode(nodeIdx, "Node.PartialAggregate", args, &nodeReply); err != nil {
+				return err
+			}
+			for _, group := range nodeReply.Groups {
+				agg.merge(group)
+			}
+		}
+		return nil
+	}); err != nil {
+		return Dataset{}, err
+	}
+	return agg.getResult(), nil
+}
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// build student table with the given partition rules and some students of different ages
+func aggregateSetup(rules map[string]interface{}) {
+	setupLab3()
+
+	studentRows = []Row{
+		{0, "John", 22, 4.0},
+		{1, "Smith", 23, 3.5},
+		{2, "Hana", 21, 4.0},
+		{3, "Lewis", 21, 3.0},
+		{4, "Tom", 23, 4.0},
+	}
+	courseRegistrationRows = []Row{}
+	studentTablePartitionRules, _ = json.Marshal(rules)
+	courseRegistrationTablePartitionRules, _ = json.Marshal(map[string]interface{}{})
+
+	buildTablesLab3(cli)
+	insertDataLab3(cli)
+}
+
+func gradeRule(op string, val float64, columns ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"predicate": map[string]interface{}{
+			"grade": [...]map[string]interface{}{{
+				"op":  op,
+				"val": val,
+			},
+			},
+		},
+		"column": columns,
+	}
+}
+
+func TestAggregateGroupBy(t *testing.T) {
+	aggregateSetup(map[string]interface{}{
+		"0|1": gradeRule("<=", 3.6, "sid", "name", "age", "grade"),
+		"1|2": gradeRule(">", 3.6, "sid", "name", "age", "grade"),
+	})
+
+	query := AggregateQuery{
+		TableName: studentTableName,
+		GroupBy:   []string{"age"},
+		Aggregates: []Aggregate{
+			{Func: AggregateCount, Column: "*"},
+			{Func: AggregateSum, Column: "sid"},
+			{Func: AggregateAvg, Column: "grade"},
+			{Func: AggregateMin, Column: "name"},
+			{Func: AggregateMax, Column: "grade", Alias: "best"},
+		},
+	}
+	if _, ok := c.planPartialAggregate(&query); !ok {
+		t.Errorf("The aggregates should be computed on the nodes")
+	}
+
+	results := Dataset{}
+	cli.Call("Cluster.Aggregate", query, &results)
+	expectedDataset := Dataset{
+		Schema: TableSchema{
+			studentTableName,
+			[]ColumnSchema{
+				{"age", TypeInt32},
+				{"COUNT(*)", TypeInt64},
+				{"SUM(sid)", TypeInt64},
+				{"AVG(grade)", TypeDouble},
+				{"MIN(name)", TypeString},
+				{"best", TypeFloat},
+			},
+		},
+		Rows: []Row{
+			{21, int64(2), int64(5), 3.5, "Hana", 4.0},
+			{22, int64(1), int64(0), 4.0, "John", 4.0},
+			{23, int64(2), int64(5), 3.75, "Smith", 4.0},
+		},
+	}
+	if !compareDataset(expectedDataset, results) {
+		t.Errorf("Incorrect aggregate results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+func TestAggregateWhere(t *testing.T) {
+	aggregateSetup(map[string]interface{}{
+		"0|1": gradeRule("<=", 3.6, "sid", "name", "age", "grade"),
+		"1|2": gradeRule(">", 3.6, "sid", "name", "age", "grade"),
+	})
+
+	query := AggregateQuery{
+		TableName: studentTableName,
+		Where:     map[string][]Condition{"grade": {{Op: ">=", Val: 3.9}}},
+		Aggregates: []Aggregate{
+			{Func: AggregateCount, Column: "*"},
+			{Func: AggregateAvg, Column: "age"},
+		},
+	}
+	// the fragment with lower grades is pruned
+	if nodeRules, ok := c.planPartialAggregate(&query); !ok || len(nodeRules) != 1 {
+		t.Errorf("Only one fragment should be aggregated, actual %v", nodeRules)
+	}
+
+	results := Dataset{}
+	cli.Call("Cluster.Aggregate", query, &results)
+	expectedDataset := Dataset{
+		Schema: TableSchema{
+			studentTableName,
+			[]ColumnSchema{
+				{"COUNT(*)", TypeInt64},
+				{"AVG(age)", TypeDouble},
+			},
+		},
+		Rows: []Row{{int64(3), 22.0}},
+	}
+	if !compareDataset(expectedDataset, results) {
+		t.Errorf("Incorrect aggregate results, expected %v, actual %v", expectedDataset, results)
+	}
+
+	// no rows satisfy the query, but there is still a result without GROUP BY
+	query.Where = map[string][]Condition{"grade": {{Op: ">", Val: 5.0}}}
+	results = Dataset{}
+	cli.Call("Cluster.Aggregate", query, &results)
+	expectedDataset.Rows = []Row{{int64(0), nil}}
+	if !compareDataset(expectedDataset, results) {
+		t.Errorf("Incorrect aggregate results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+func TestAggregateOverlappingPartitions(t *testing.T) {
+	// Smith is held by both fragments
+	aggregateSetup(map[string]interface{}{
+		"0":   gradeRule("<=", 3.6, "sid", "name", "age", "grade"),
+		"1|2": gradeRule(">=", 3.5, "sid", "name", "age", "grade"),
+	})
+
+	query := AggregateQuery{
+		TableName:  studentTableName,
+		Aggregates: []Aggregate{{Func: AggregateCount, Column: "*"}, {Func: AggregateMin, Column: "age"}},
+	}
+	if _, ok := c.planPartialAggregate(&query); ok {
+		t.Errorf("COUNT cannot be computed on overlapping fragments")
+	}
+	results := Dataset{}
+	cli.Call("Cluster.Aggregate", query, &results)
+	expectedDataset := Dataset{
+		Schema: TableSchema{studentTableName, []ColumnSchema{{"COUNT(*)", TypeInt64}, {"MIN(age)", TypeInt32}}},
+		Rows:   []Row{{int64(5), 21}},
+	}
+	if !compareDataset(expectedDataset, results) {
+		t.Errorf("Incorrect aggregate results, expected %v, actual %v", expectedDataset, results)
+	}
+
+	// MIN and MAX do not care about duplicates
+	query.Aggregates = []Aggregate{{Func: AggregateMin, Column: "age"}, {Func: AggregateMax, Column: "name"}}
+	if _, ok := c.planPartialAggregate(&query); !ok {
+		t.Errorf("MIN and MAX should be computed on the nodes")
+	}
+	results = Dataset{}
+	cli.Call("Cluster.Aggregate", query, &results)
+	expectedDataset = Dataset{
+		Schema: TableSchema{studentTableName, []ColumnSchema{{"MIN(age)", TypeInt32}, {"MAX(name)", TypeString}}},
+		Rows:   []Row{{21, "Tom"}},
+	}
+	if !compareDataset(expectedDataset, results) {
+		t.Errorf("Incorrect aggregate results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+func TestAggregateVerticalPartitions(t *testing.T) {
+	aggregateSetup(map[string]interface{}{
+		"0": gradeRule(">=", 0.0, "sid", "name"),
+		"1": gradeRule(">=", 0.0, "age", "grade"),
+	})
+
+	query := AggregateQuery{
+		TableName:  studentTableName,
+		GroupBy:    []string{"age"},
+		Aggregates: []Aggregate{{Func: AggregateMax, Column: "sid"}},
+	}
+	if _, ok := c.planPartialAggregate(&query); ok {
+		t.Errorf("No fragment holds both age and sid")
+	}
+	results := Dataset{}
+	cli.Call("Cluster.Aggregate", query, &results)
+	expectedDataset := Dataset{
+		Schema: TableSchema{studentTableName, []ColumnSchema{{"age", TypeInt32}, {"MAX(sid)", TypeInt32}}},
+		Rows:   []Row{{21, 3}, {22, 0}, {23, 4}},
+	}
+	if !compareDataset(expectedDataset, results) {
+		t.Errorf("Incorrect aggregate results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+func TestArePredicatesDisjoint(t *testing.T) {
+	schema := *studentTableSchema
+	cases := []struct {
+		predicate1 map[string][]Condition
+		predicate2 map[string][]Condition
+		disjoint   bool
+	}{
+		{map[string][]Condition{"grade": {{"<=", 3.6}}}, map[string][]Condition{"grade": {{">", 3.6}}}, true},
+		{map[string][]Condition{"grade": {{"<=", 3.6}}}, map[string][]Condition{"grade": {{">=", 3.6}}}, false},
+		{map[string][]Condition{"grade": {{"<", 3.6}}}, map[string][]Condition{"grade": {{"==", 3.6}}}, true},
+		{map[string][]Condition{"grade": {{"==", 3.6}}}, map[string][]Condition{"grade": {{"!=", 3.6}}}, true},
+		{map[string][]Condition{"name": {{">", "M"}}}, map[string][]Condition{"name": {{"<", "N"}}}, false},
+		{map[string][]Condition{"age": {{">", 20}}}, map[string][]Condition{"grade": {{"<", 3.0}}}, false},
+	}
+	for i, testCase := range cases {
+		if arePredicatesDisjoint(schema, testCase.predicate1, testCase.predicate2) != testCase.disjoint {
+			t.Errorf("Case %d: expected disjoint = %v for %v and %v", i, testCase.disjoint, testCase.predicate1,
+				testCase.predicate2)
+		}
+	}
+}
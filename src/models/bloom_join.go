@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// defaultBloomFalsePositiveRate is used by BloomSemiJoin when the caller does not provide a false positive rate.
+const defaultBloomFalsePositiveRate = 0.01
+
+// BloomFilter is a compact set of values. It may report that a value is in the set while it is not (a false positive),
+// but never the opposite, so it can be used to filter rows before sending them through the network.
+// The fields are exported so that the filter can be sent to the nodes through RPC.
+type BloomFilter struct {
+	// bit array of the filter, packed into 64-bit words
+	Bits []uint64
+	// number of bits in the bit array
+	BitCount uint64
+	// number of hash functions, i.e., number of bits set by each value
+	HashCount int
+}
+
+// NewBloomFilter creates an empty Bloom filter sized for the expected number of values, so that its false positive
+// rate is about the given one once all the values are added.
+func NewBloomFilter(expectedCount int, falsePositiveRate float64) *BloomFilter {
+	if expectedCount < 1 {
+		expectedCount = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = defaultBloomFalsePositiveRate
+	}
+
+	// optimal number of bits: m = -n * ln(p) / ln(2)^2
+	bitCount := uint64(math.Ceil(-float64(expectedCount) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if bitCount < 64 {
+		bitCount = 64
+	}
+	// optimal number of hash functions: k = m / n * ln(2)
+	hashCount := int(math.Round(float64(bitCount) / float64(expectedCount) * math.Ln2))
+	if hashCount < 1 {
+		hashCount = 1
+	}
+
+	return &BloomFilter{
+		Bits:      make([]uint64, (bitCount+63)/64),
+		BitCount:  bitCount,
+		HashCount: hashCount,
+	}
+}
+
+// bloomHashes returns two independent hashes of a value, which are combined to simulate the hash functions of a
+// filter (double hashing). Values are hashed by their printed form, so that the same number stored in different go
+// types (e.g., int and int32) is hashed into the same bits.
+func bloomHashes(value interface{}) (uint64, uint64) {
+	key := []byte(fmt.Sprint(value))
+
+	h1 := fnv.New64a()
+	_, _ = h1.Write(key)
+	h2 := fnv.New64()
+	_, _ = h2.Write(key)
+
+	// an odd step never cycles back to the first bit too early
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+// Add puts a value into the filter.
+func (f *BloomFilter) Add(value interface{}) {
+	h1, h2 := bloomHashes(value)
+	for i := 0; i < f.HashCount; i++ {
+		bit := (h1 + uint64(i)*h2) % f.BitCount
+		f.Bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// MayContain returns false if the value was definitely not added into the filter, or true if it probably was.
+func (f *BloomFilter) MayContain(value interface{}) bool {
+	if f.BitCount == 0 {
+		return false
+	}
+	h1, h2 := bloomHashes(value)
+	for i := 0; i < f.HashCount; i++ {
+		bit := (h1 + uint64(i)*h2) % f.BitCount
+		if f.Bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// BloomSemiJoin works like SemiJoin, but sends a Bloom filter built from the join column of table2 to the nodes
+// instead of the full set of join values, which is much smaller when table2 has many distinct values.
+// As the filter may let some rows that should be filtered pass, the rows are checked again with the exact join values
+// at the coordinator.
+// Set reply as a Dataset of the rows in table1 that can be joined with table2.
+func (c *Cluster) BloomSemiJoin(params []interface{}, reply *Dataset) error {
+	// params[0] = the column name to join the two tables on
+	// params[1] = table1 name
+	// params[2] = table2 name
+	// params[3] = (optional) false positive rate of the Bloom filter
+	onJoinColName := params[0].(string)
+	table1Name := params[1].(string)
+	table2Name := params[2].(string)
+	falsePositiveRate := defaultBloomFalsePositiveRate
+	if len(params) > 3 {
+		falsePositiveRate = params[3].(float64)
+	}
+
+	table1Schema, _ := c.getTableSchema(table1Name)
+	table2Schema, _ := c.getTableSchema(table2Name)
+
+	// short circuit and return if both tables doesn't have the column to join on
+	joinColIdx := table1Schema.GetColIndexByName(onJoinColName)
+	if joinColIdx == -1 || table2Schema.GetColIndexByName(onJoinColName) == -1 {
+		return newError(ErrSchemaMismatch, "Column to join doesn't exist in both table")
+	}
+
+	// get full dataset for table2 (filter table), both tables are read as of the same snapshot
+	snapshot := c.takeSnapshot()
+	dataset2, err := c.getFullTableDataset(table2Name, snapshot)
+	if err != nil {
+		return err
+	}
+
+	// the exact join values stay at the coordinator, and only the Bloom filter is sent to the nodes
+	srcColIndex := table2Schema.GetColIndexByName(onJoinColName)
+	possibleJoinValueSet := make(ValueSet)
+	for _, row := range dataset2.Rows {
+		possibleJoinValueSet[row[srcColIndex]] = true
+	}
+	filter := NewBloomFilter(len(possibleJoinValueSet), falsePositiveRate)
+	for value := range possibleJoinValueSet {
+		filter.Add(value)
+	}
+
+	pkRowMap, err := c.reduceTableByColumn(table1Name, onJoinColName, "Node.FilterTableWithBloomFilter", *filter,
+		snapshot)
+	if err != nil {
+		return err
+	}
+
+	*reply = Dataset{}
+	reply.Schema = table1Schema
+
+	// drop the false positives
+	for _, row := range pkRowMap {
+		if possibleJoinValueSet[row[joinColIdx]] {
+			reply.Rows = append(reply.Rows, row)
+		}
+	}
+	return nil
+}
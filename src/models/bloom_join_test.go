@@ -0,0 +1,158 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := NewBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		filter.Add(i)
+	}
+
+	// a Bloom filter never reports false negatives
+	for i := 0; i < 1000; i++ {
+		if !filter.MayContain(i) {
+			t.Fatalf("Value %d was added but the filter reports it is absent", i)
+		}
+	}
+
+	falsePositiveCount := 0
+	for i := 1000; i < 11000; i++ {
+		if filter.MayContain(i) {
+			falsePositiveCount++
+		}
+	}
+	// allow some deviation from the expected 1% false positive rate
+	if falsePositiveCount > 300 {
+		t.Errorf("Too many false positives, expected about 100, actual %d", falsePositiveCount)
+	}
+}
+
+func TestBloomSemiJoin(t *testing.T) {
+	for _, falsePositiveRate := range []float64{0.01, 0.9} {
+		semiJoinSetup()
+
+		// the second fragment of student table does not hold the join column
+		m := map[string]interface{}{
+			"0": map[string]interface{}{
+				"predicate": map[string]interface{}{
+					"grade": [...]map[string]interface{}{{
+						"op":  "<=",
+						"val": 3.6,
+					},
+					},
+				},
+				"column": [...]string{
+					"sid", "name", "age", "grade",
+				},
+			},
+			"1": map[string]interface{}{
+				"predicate": map[string]interface{}{
+					"grade": [...]map[string]interface{}{{
+						"op":  ">",
+						"val": 3.6,
+					},
+					},
+				},
+				"column": [...]string{
+					"sid", "name",
+				},
+			},
+			"2": map[string]interface{}{
+				"predicate": map[string]interface{}{
+					"grade": [...]map[string]interface{}{{
+						"op":  ">",
+						"val": 3.6,
+					},
+					},
+				},
+				"column": [...]string{
+					"age", "grade",
+				},
+			},
+		}
+		studentTablePartitionRules, _ = json.Marshal(m)
+
+		m = map[string]interface{}{
+			"2": map[string]interface{}{
+				"predicate": map[string]interface{}{
+					"courseId": [...]map[string]interface{}{{
+						"op":  ">=",
+						"val": 0,
+					},
+					},
+				},
+				"column": [...]string{
+					"sid", "courseId",
+				},
+			},
+		}
+		courseRegistrationTablePartitionRules, _ = json.Marshal(m)
+
+		buildTables(cli)
+		insertData(cli)
+
+		results := Dataset{}
+		cli.Call("Cluster.BloomSemiJoin",
+			[]interface{}{"sid", studentTableName, courseRegistrationTableName, falsePositiveRate}, &results)
+
+		expectedDataset := Dataset{
+			Schema: *studentTableSchema,
+			Rows: []Row{
+				{0, "John", 22, 4.0},
+				{1, "Smith", 23, 3.6},
+				{2, "Hana", 21, 4.0},
+				{4, "Lewis", 21, 3.0},
+			},
+		}
+		if !compareDataset(expectedDataset, results) {
+			t.Errorf("Incorrect bloom semi join results with false positive rate %v, expected %v, actual %v",
+				falsePositiveRate, expectedDataset, results)
+		}
+	}
+}
+
+func TestFilterTableSkipsRowIdx(t *testing.T) {
+	semiJoinSetup()
+	// node 0 holds the whole student table
+	studentTablePartitionRules, _ = json.Marshal(map[string]interface{}{
+		"0": map[string]interface{}{
+			"predicate": map[string]interface{}{},
+			"column":    []string{"sid", "name", "age", "grade"},
+		},
+	})
+	courseRegistrationTablePartitionRules, _ = json.Marshal(map[string]interface{}{
+		"1": map[string]interface{}{
+			"predicate": map[string]interface{}{},
+			"column":    []string{"sid", "courseId"},
+		},
+	})
+	buildTables(cli)
+	insertData(cli)
+
+	end := network.MakeEnd("filterClient")
+	network.Connect("filterClient", "Node0")
+	network.Enable("filterClient", true)
+
+	// the rows of a fragment start with their row idxs, which are not compared with the filtered column
+	for _, testCase := range []struct {
+		method string
+		filter interface{}
+	}{
+		{"Node.FilterTableWithColumnValues", ValueSet{"Lewis": true}},
+		{"Node.FilterTableWithBloomFilter", func() BloomFilter {
+			filter := NewBloomFilter(1, 0.01)
+			filter.Add("Lewis")
+			return *filter
+		}()},
+	} {
+		dataset := Dataset{}
+		end.Call(testCase.method, []interface{}{getFragmentName(studentTableName, 0), "name", testCase.filter},
+			&dataset)
+		if len(dataset.Rows) != 1 || dataset.Rows[0][1] != 4 {
+			t.Errorf("%s: expected the row of Lewis, actual %v", testCase.method, dataset.Rows)
+		}
+	}
+}
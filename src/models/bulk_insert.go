@@ -0,0 +1,100 @@
+package models
+
+import (
+	"sort"
+)
+
+// BulkInsert inserts many rows into a table at once, see Cluster.BulkInsert.
+type BulkInsert struct {
+	TableName string
+	// Un-partitioned rows (follow cluster's table schema)
+	Rows []Row
+	// identifies the insert, so that the rows are inserted only once if it is retried, see RequestId
+	RequestId RequestId
+}
+
+// BulkInsert inserts the rows into a table, and sets reply as the number of inserted rows. Unlike FragmentWrite, which
+// writes each row by its own calls, the rows are routed to the fragments by the partition rules at the coordinator,
+// and the rows of each fragment are sent to each of its replicas in one call, the row idxs of the rows are allocated
+// as a block. The rows are staged on the nodes (see Node.Stage), and committed by two-phase commit, so either all or
+// none of them are inserted.
+func (c *Cluster) BulkInsert(args BulkInsert, reply *int) error {
+	if _, ok := c.getTableSchema(args.TableName); !ok {
+		return noSuchTableError(args.TableName)
+	}
+	// check all rows before writing any of them
+	for _, row := range args.Rows {
+		if _, err := c.checkRow(args.TableName, row); err != nil {
+			return err
+		}
+	}
+
+	insertedCount, err := c.dedup.applyCount(args.RequestId, func() (int, error) {
+		if len(args.Rows) == 0 {
+			return 0, nil
+		}
+		firstRowIdx := c.allocateRowIdxs(args.TableName, len(args.Rows))
+		if err := c.bulkInsert(args.TableName, firstRowIdx, args.Rows); err != nil {
+			c.releaseRowIdxs(args.TableName, firstRowIdx, len(args.Rows))
+			return 0, err
+		}
+		return len(args.Rows), nil
+	})
+	if err != nil {
+		return err
+	}
+	*reply = insertedCount
+	return nil
+}
+
+// bulkInsert writes the rows into a table atomically, the row idxs of the rows start from firstRowIdx. See BulkInsert.
+func (c *Cluster) bulkInsert(tableName string, firstRowIdx int, rows []Row) error {
+	c.resolveTxns()
+	txnId := c.newTxnId()
+	schema, _ := c.getTableSchema(tableName)
+
+	calls := make([]nodeCall, 0)
+	// the nodes called by the transaction, which are its participants even if a call is lost
+	isParticipant := make(map[int]bool)
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		rule := nodeRule.Rule
+		fragmentName := getFragmentName(tableName, rule.RuleIdx)
+		writes := make([]FragmentRow, 0)
+		for i, row := range rows {
+			if !row.SatisfiesPredicate(schema, rule.Predicate) {
+				continue
+			}
+			fragmentRow := make(Row, 1, len(rule.Column)+1)
+			fragmentRow[0] = firstRowIdx + i
+			for _, colName := range rule.Column {
+				fragmentRow = append(fragmentRow, row[schema.GetColIndexByName(colName)])
+			}
+			writes = append(writes, FragmentRow{FragmentName: fragmentName, Row: fragmentRow})
+		}
+		if len(writes) == 0 {
+			continue
+		}
+		for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+			calls = append(calls, nodeCall{nodeIdx: nodeIdx, method: "Node.Stage",
+				args: TxnPrepare{TxnId: txnId, Writes: writes}})
+			isParticipant[nodeIdx] = true
+		}
+	}
+
+	// a staged row is skipped when it is staged again, so the calls can be retried
+	if err := c.callNodes(calls, c.getRetryPolicy()); err != nil {
+		nodeIdxs := make([]int, 0, len(isParticipant))
+		for nodeIdx := range isParticipant {
+			nodeIdxs = append(nodeIdxs, nodeIdx)
+		}
+		sort.Ints(nodeIdxs)
+		c.decideTxn(txnId, false, nodeIdxs)
+		return err
+	}
+	// the rows are staged on the participants already
+	nodeWrites := make(map[int][]FragmentRow)
+	for nodeIdx := range isParticipant {
+		nodeWrites[nodeIdx] = nil
+	}
+	return c.commitTxn(txnId, nodeWrites)
+}
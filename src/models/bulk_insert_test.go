@@ -0,0 +1,137 @@
+package models
+
+import (
+	"testing"
+)
+
+// bulkStudentCount is the number of students inserted by a bulk insert in the tests.
+const bulkStudentCount = 1000
+
+// makeBulkStudents returns bulkStudentCount students with sids from 100, half of them have high grades.
+func makeBulkStudents() []Row {
+	rows := make([]Row, bulkStudentCount)
+	for i := range rows {
+		rows[i] = Row{100 + i, "Ann", 20, 3.0 + float64(i%2)}
+	}
+	return rows
+}
+
+func TestBulkInsert(t *testing.T) {
+	setOperationSetup()
+	nodeCounts := make([]int, 3)
+	for nodeIdx := range nodeCounts {
+		nodeCounts[nodeIdx] = network.GetCount(c.nodeIds[nodeIdx])
+	}
+
+	insertedCount := 0
+	if err := cli.CallWithError("Cluster.BulkInsert", BulkInsert{TableName: studentTableName,
+		Rows: makeBulkStudents()}, &insertedCount); err != nil {
+		t.Fatal(err)
+	}
+	if insertedCount != bulkStudentCount {
+		t.Errorf("Expected %d students to be inserted, actual %d", bulkStudentCount, insertedCount)
+	}
+
+	// each replica of a fragment is called once to stage its rows, then the nodes prepare and commit
+	for nodeIdx, expectedCount := range []int{3, 4, 3} {
+		if count := network.GetCount(c.nodeIds[nodeIdx]) - nodeCounts[nodeIdx]; count != expectedCount {
+			t.Errorf("Expected %d calls to node %d, actual %d", expectedCount, nodeIdx, count)
+		}
+	}
+
+	// the row idxs are allocated as a block after the existing rows
+	studentCount := len(studentRows) + bulkStudentCount
+	if c.TableRowCountMap[studentTableName] != studentCount {
+		t.Errorf("Expected %d rows in student, actual %d", studentCount, c.TableRowCountMap[studentTableName])
+	}
+	for _, fragment := range []struct {
+		nodeIndices   string
+		nodeIdxs      []int
+		expectedCount int
+	}{{"0|1", []int{0, 1}, 2 + bulkStudentCount/2}, {"1|2", []int{1, 2}, 3 + bulkStudentCount/2}} {
+		fragmentName := getStudentFragmentName(fragment.nodeIndices)
+		for _, nodeIdx := range fragment.nodeIdxs {
+			rowIdxs := getFragmentRowIdxs(t, nodeIdx, fragmentName)
+			if len(rowIdxs) != fragment.expectedCount {
+				t.Errorf("Expected %d rows in %s on node %d, actual %d", fragment.expectedCount, fragmentName,
+					nodeIdx, len(rowIdxs))
+			}
+			for rowIdx := range rowIdxs {
+				if rowIdx.(int) >= studentCount {
+					t.Errorf("Row idx %v in %s on node %d is not allocated", rowIdx, fragmentName, nodeIdx)
+				}
+			}
+		}
+	}
+	if sids := selectSids(t, studentTableName, ""); len(sids) != studentCount {
+		t.Errorf("Expected %d students, actual %d", studentCount, len(sids))
+	}
+}
+
+func TestBulkInsertAllOrNone(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+
+	// node 2 cannot stage the students with high grades, so none of the students is inserted
+	network.DeleteServer("Node2")
+	insertedCount := 0
+	err := cli.CallWithError("Cluster.BulkInsert", BulkInsert{TableName: studentTableName,
+		Rows: makeBulkStudents()}, &insertedCount)
+	checkErrorCode(t, "Inserting students into a deleted node", err, ErrUnavailable)
+	if c.TableRowCountMap[studentTableName] != len(studentRows) {
+		t.Errorf("The row idxs of the students should be released, actual %d rows in student",
+			c.TableRowCountMap[studentTableName])
+	}
+	for nodeIdx, fragment := range []struct {
+		nodeIndices   string
+		expectedCount int
+	}{{"0|1", 2}, {"1|2", 3}} {
+		fragmentName := getStudentFragmentName(fragment.nodeIndices)
+		if rowIdxs := getFragmentRowIdxs(t, nodeIdx, fragmentName); len(rowIdxs) != fragment.expectedCount {
+			t.Errorf("No student should be inserted into %s on node %d, actual %d rows", fragmentName, nodeIdx,
+				len(rowIdxs))
+		}
+	}
+}
+
+func TestBulkInsertRetried(t *testing.T) {
+	setOperationSetup()
+
+	args := BulkInsert{TableName: studentTableName, Rows: makeBulkStudents(),
+		RequestId: RequestId{ClientId: "ClientA", Seq: 1}}
+	for i := 0; i < 2; i++ {
+		insertedCount := 0
+		if err := cli.CallWithError("Cluster.BulkInsert", args, &insertedCount); err != nil {
+			t.Fatal(err)
+		}
+		if insertedCount != bulkStudentCount {
+			t.Errorf("Expected %d students to be inserted, actual %d", bulkStudentCount, insertedCount)
+		}
+	}
+	if sids := selectSids(t, studentTableName, ""); len(sids) != len(studentRows)+bulkStudentCount {
+		t.Errorf("Expected the students to be inserted once, actual %d students", len(sids))
+	}
+
+	// a row not matching the schema fails the whole insert
+	insertedCount := 0
+	err := cli.CallWithError("Cluster.BulkInsert", BulkInsert{TableName: studentTableName,
+		Rows: []Row{{2000, "Ben", 20, 3.0}, {2001, "Ben"}}}, &insertedCount)
+	checkErrorCode(t, "Inserting a row not matching the schema", err, ErrSchemaMismatch)
+	if sids := selectSids(t, studentTableName, ""); len(sids) != len(studentRows)+bulkStudentCount {
+		t.Errorf("No student should be inserted by the failed insert, actual %d students", len(sids))
+	}
+}
+
+func TestBulkInsertNullPartitionColumn(t *testing.T) {
+	setOperationSetup()
+
+	// the student without a grade satisfies no partition rule, so none of the students is inserted
+	insertedCount := 0
+	err := cli.CallWithError("Cluster.BulkInsert", BulkInsert{TableName: studentTableName,
+		Rows: []Row{{100, "Ann", 20, 3.0}, {101, "Ben", 20, nil}}}, &insertedCount)
+	checkErrorCode(t, "Inserting a student without a grade", err, ErrInvalidArgument)
+	checkSids(t, "After the failed insert", studentTableName, "", 0, 1, 2, 3, 4)
+	if c.TableRowCountMap[studentTableName] != len(studentRows) {
+		t.Errorf("No row idx should be allocated, actual %d rows in student", c.TableRowCountMap[studentTableName])
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"../labgob"
 	"../labrpc"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Cluster consists of a group of nodes to manage distributed tables defined in models/table.go.
@@ -32,6 +34,27 @@ type Cluster struct {
 	TableSchemasMap map[string]TableSchema
 	// TableRowCountMap[tableName] -> Table's row count
 	TableRowCountMap map[string]int
+	// guards the maps above, as the requests of the clients are served concurrently
+	tablesMu sync.RWMutex
+
+	// how the nodes are called, see RetryPolicy
+	retryPolicy RetryPolicy
+	// guards retryPolicy, as it may be changed while the nodes are called
+	retryPolicyMu sync.RWMutex
+
+	// cursorId -> cursor opened by OpenCursor
+	cursors      map[string]*cursor
+	nextCursorId int
+	cursorsMu    sync.Mutex
+	// see cursorIdleTimeout, it is shortened by the tests
+	cursorIdleTimeout time.Duration
+
+	// the decisions of the transactions writing rows, see writeAtomically
+	txnLog *txnLog
+	// the last write requests of the clients, see RequestId
+	dedup *dedupTable
+	// serializes the upserts, see Upsert
+	upsertMu sync.Mutex
 }
 
 // NewCluster creates a Cluster with the given number of nodes and register the nodes to the given network.
@@ -49,6 +72,10 @@ func NewCluster(nodeNum int, network *labrpc.Network, clusterName string) *Clust
 	labgob.Register(TableSchema{})
 	labgob.Register(Row{})
 	labgob.Register(ValueSet{})
+	labgob.Register(BloomFilter{})
+	labgob.Register(Snapshot{})
+	labgob.Register(TxnOptions{})
+	labgob.Register(RequestId{})
 
 	tableNodeRulesMap := make(map[string][]NodeRule)
 	tableSchemasMap := make(map[string]TableSchema)
@@ -76,7 +103,9 @@ func NewCluster(nodeNum int, network *labrpc.Network, clusterName string) *Clust
 
 	// create a cluster with the nodes and the network
 	c := &Cluster{nodeIds: nodeIds, network: network, Name: clusterName,
-		TableNodeRulesMap: tableNodeRulesMap, TableSchemasMap: tableSchemasMap, TableRowCountMap: tableRowCountMap}
+		TableNodeRulesMap: tableNodeRulesMap, TableSchemasMap: tableSchemasMap, TableRowCountMap: tableRowCountMap,
+		retryPolicy: DefaultRetryPolicy, cursors: make(map[string]*cursor),
+		cursorIdleTimeout: cursorIdleTimeout, txnLog: newTxnLog(), dedup: newDedupTable()}
 	// create a coordinator for the cluster to receive external requests, the steps are similar to those above.
 	// notice that we use the reference of the cluster as the name of the coordinator server,
 	// and the names can be more than strings.
@@ -87,11 +116,88 @@ func NewCluster(nodeNum int, network *labrpc.Network, clusterName string) *Clust
 	return c
 }
 
+// getNodeEnd creates (or reuses) the internal client end of the node with the given index, connects it to the node
+// and enables it, so that it is ready for calls.
+func (c *Cluster) getNodeEnd(nodeIdx int) *labrpc.ClientEnd {
+	nodeId := c.nodeIds[nodeIdx]
+	endName := "InternalClient" + nodeId
+	end := c.network.MakeEnd(endName)
+	// connect the client to the node
+	c.network.Connect(endName, nodeId)
+	// a client should be enabled before being used
+	c.network.Enable(endName, true)
+	return end
+}
+
+// getTableSchema returns the schema of the table with the given name, and whether the table exists.
+func (c *Cluster) getTableSchema(tableName string) (TableSchema, bool) {
+	c.tablesMu.RLock()
+	defer c.tablesMu.RUnlock()
+	schema, ok := c.TableSchemasMap[tableName]
+	return schema, ok
+}
+
+// getNodeRules returns the partition rules of the table with the given name, or nil if the table does not exist. The
+// returned rules are not changed after the table is built, so they can be read without locking.
+func (c *Cluster) getNodeRules(tableName string) []NodeRule {
+	c.tablesMu.RLock()
+	defer c.tablesMu.RUnlock()
+	return c.TableNodeRulesMap[tableName]
+}
+
+// allocateRowIdx allocates the row idx of a new row of the table with the given name, so that the concurrent writes
+// never write two rows with the same idx.
+func (c *Cluster) allocateRowIdx(tableName string) int {
+	return c.allocateRowIdxs(tableName, 1)
+}
+
+// allocateRowIdxs allocates a block of consecutive row idxs for the given number of new rows of a table, and returns
+// the first of them.
+func (c *Cluster) allocateRowIdxs(tableName string, count int) int {
+	c.tablesMu.Lock()
+	defer c.tablesMu.Unlock()
+	firstRowIdx := c.TableRowCountMap[tableName]
+	c.TableRowCountMap[tableName] += count
+	return firstRowIdx
+}
+
+// releaseRowIdx releases the row idx of a row that is not written, it is reused by the next row unless a row idx is
+// allocated after it.
+func (c *Cluster) releaseRowIdx(tableName string, rowIdx int) {
+	c.releaseRowIdxs(tableName, rowIdx, 1)
+}
+
+// releaseRowIdxs releases a block of row idxs allocated by allocateRowIdxs, like releaseRowIdx.
+func (c *Cluster) releaseRowIdxs(tableName string, firstRowIdx int, count int) {
+	c.tablesMu.Lock()
+	defer c.tablesMu.Unlock()
+	if rowCount, ok := c.TableRowCountMap[tableName]; ok && rowCount == firstRowIdx+count {
+		c.TableRowCountMap[tableName] = firstRowIdx
+	}
+}
+
+// parseNodeIndices converts node indices like "0|1|2" in a NodeRule into a list of node indices.
+func parseNodeIndices(nodeIdxStr string) []int {
+	nodeIdxs := make([]int, 0)
+	for _, nodeStrId := range strings.Split(nodeIdxStr, "|") {
+		if nodeIdx, err := strconv.Atoi(nodeStrId); err == nil {
+			nodeIdxs = append(nodeIdxs, nodeIdx)
+		}
+	}
+	return nodeIdxs
+}
+
+// getFragmentName returns the name of the table fragment stored on the nodes for the given rule.
+func getFragmentName(tableName string, ruleIdx int) string {
+	return tableName + "_R" + strconv.Itoa(ruleIdx)
+}
+
 // SayHello is an example to show how the coordinator communicates with other nodes in the cluster.
 // Any method that can be accessed by network clients should have EXACTLY TWO parameters, while the first one is the
 // actual parameter desired by the method (can be a list if there are more than one desired parameters), and the second
-// one is a reference to the return value. The caller must ensure that the reference is valid (not nil).
-func (c *Cluster) SayHello(visitor string, reply *string) {
+// one is a reference to the return value. The caller must ensure that the reference is valid (not nil). The method
+// returns an error if the request fails, which is surfaced to the client by labrpc.ClientEnd.CallWithError.
+func (c *Cluster) SayHello(visitor string, reply *string) error {
 	endNamePrefix := "InternalClient"
 	for _, nodeId := range c.nodeIds {
 		// create a client (end) to each node
@@ -109,348 +215,129 @@ func (c *Cluster) SayHello(visitor string, reply *string) {
 		// a Node object to create a service, so the first part of the parameter will be the class name "Node", and as
 		// we want to call the method SayHello(), so the second part is "SayHello", and the two parts are separated by
 		// a dot
-		end.Call("Node.SayHello", argument, &reply)
+		// Call returns false if no reply is received, as the network may lose the request or the reply
+		if !end.Call("Node.SayHello", argument, &reply) {
+			fmt.Println("No reply from " + nodeId)
+			continue
+		}
 		fmt.Println(reply)
 	}
 	*reply = fmt.Sprintf("Hello %s, I am the coordinator of %s", visitor, c.Name)
+	return nil
 }
 
 // GetFullTableDataset by joining all the tables with the same name in all relevant nodes.
 // The return Dataset will have a complete tableSchema as stored in the cluster.
 // The join is based on primary key of each table. The first column in each nodes' tableSchema is assumed to be the PK.
+// All nodes are read as of the same snapshot, see takeSnapshot.
 func (c *Cluster) GetFullTableDataset(tableName string, result *Dataset) error {
-	// Get table schema
-	// Check if the table exists
-	if _, ok := c.TableSchemasMap[tableName]; ok {
-		*result = Dataset{}
-		result.Schema = c.TableSchemasMap[tableName]
-
-		// Map of primary key to its row
-		// The first column in each row (un-partitioned table row index) is assumed to be the PK.
-		pkRowMap := make(map[interface{}]Row)
-
-		// get approximated minimum number of nodes to retrieve table
-		criticalNodeRulesMap := SetCover(c.TableNodeRulesMap[tableName])
-
-		// Iterate node by relevant rule
-		// Get partial row data from each node
-		endNamePrefix := "InternalClient"
-		for nodeIdxStr, rules := range criticalNodeRulesMap {
-			nodeIdx := nodeIdxStr[0] - '0'
-			nodeId := c.nodeIds[nodeIdx]
-			endName := endNamePrefix + nodeId
-			end := c.network.MakeEnd(endName)
-			// connect the client to the node
-			c.network.Connect(endName, nodeId)
-			// a client should be enabled before being used
-			c.network.Enable(endName, true)
-
-			mergeTableArgs := make([]interface{}, 1)
-			mergeTableArgs[0] = result.Schema
-
-			// get a list of table names partitioned using different rules
-			for _, ruleIdx := range rules {
-				mergeTableArgs = append(mergeTableArgs, tableName+"_R"+strconv.Itoa(ruleIdx))
-			}
-
-			var nodeTableDataset Dataset
-
-			// merge all partitioned table on this node into one
-			end.Call("Node.GetMergedTableDataset", mergeTableArgs, &nodeTableDataset)
-
-			nodeTableDataset.ReconstructTable(pkRowMap, result.Schema, true)
-		}
-
-		// Add rows to result
-		for _, row := range pkRowMap {
-			result.Rows = append(result.Rows, row)
-		}
+	var err error
+	*result, err = c.getFullTableDataset(tableName, c.takeSnapshot())
+	return err
+}
 
-		return nil
-	} else {
-		// If table doesn't exist
-		return errors.New("table " + tableName + " doesn't exist")
+// getFullTableDataset fetches a full table reading the nodes as of the given snapshot, see GetFullTableDataset.
+func (c *Cluster) getFullTableDataset(tableName string, snapshot Snapshot) (Dataset, error) {
+	plan, err := c.planTableScan(tableName, nil)
+	if err != nil {
+		return Dataset{}, err
 	}
-
+	plan.setSnapshot(snapshot)
+	return c.executePlan(plan)
 }
 
 // NaturalJoinDatasets by matching all common columns.
 // Datasets are passed as references to avoid expensive copying.
 func (c *Cluster) NaturalJoinDatasets(datasetPtrs []*Dataset) (Dataset, error) {
-
-	datasetPtrsLen := len(datasetPtrs)
-
-	if datasetPtrsLen < 2 {
-		return Dataset{}, errors.New("number of datasetPtrs should be more than 2")
-	}
-
-	result := Dataset{}
-
-	// Joined tableName should be empty
-	result.Schema.TableName = ""
-	result.Schema = datasetPtrs[0].Schema
-	result.Rows = datasetPtrs[0].Rows
-
-	for datasetPtrIdx := 1; datasetPtrIdx < datasetPtrsLen; datasetPtrIdx++ {
-
-		dataset := *datasetPtrs[datasetPtrIdx]
-
-		// Map dataset -> result common column indexes
-		commonColsIdxMap := make(map[int]int)
-		var tempColSchemas []ColumnSchema
-		for datasetColIdx, datasetColSchema := range dataset.Schema.ColumnSchemas {
-			resultColIdx := result.Schema.GetColIndexByName(datasetColSchema.Name)
-			// If there is a common column, add it to commonColsIdx
-			if resultColIdx != -1 {
-				commonColsIdxMap[datasetColIdx] = resultColIdx
-			} else {
-				//	Else add the schema into tempColSchemas
-				tempColSchemas = append(tempColSchemas, datasetColSchema)
-			}
-		}
-
-		// If there are no common columns, clear result and short-circuit
-		if len(commonColsIdxMap) == 0 {
-			result = Dataset{}
-			fmt.Println("Natural Join(s) has(have) no common columns.")
-			return result, nil
-		}
-
-		// Add tempColSchemas to result
-		beforeJoinResultRowLen := len(result.Rows)
-		beforeJoinResultColLen := len(result.Schema.ColumnSchemas)
-		result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas, tempColSchemas...)
-
-		// Join dataset and result
-		hasJoinResult := false
-		for _, datasetRow := range dataset.Rows {
-			for resultRowIdx := 0; resultRowIdx < beforeJoinResultRowLen; resultRowIdx++ {
-
-				resultRow := result.Rows[resultRowIdx]
-
-				// Check conditions
-				matched := true
-				for datasetColIdx, resultColIdx := range commonColsIdxMap {
-					if resultRow[resultColIdx] != datasetRow[datasetColIdx] {
-						matched = false
-						break
-					}
-				}
-
-				if matched {
-					hasJoinResult = true
-					var appendRowPtr *Row = &result.Rows[resultRowIdx]
-
-					// If there are duplicate matches, copy and insert data
-					if len(*appendRowPtr) > beforeJoinResultColLen {
-						newRow := make(Row, beforeJoinResultColLen)
-						// Note: copy(dst, src) copies min(len(dst), len(src)) elements.
-						copy(newRow, result.Rows[resultRowIdx])
-						result.Rows = append(result.Rows, newRow)
-						// Set the appended row
-						appendRowPtr = &result.Rows[len(result.Rows)-1]
-					}
-
-					// Append non-common columns data
-					for datasetColIdx, datasetColVal := range datasetRow {
-						if _, ok := commonColsIdxMap[datasetColIdx]; !ok {
-							*appendRowPtr = append(*appendRowPtr, datasetColVal)
-						}
-					}
-				}
-			}
-		}
-
-		// If a dataset has no join result, clear result's rows and short-circuit
-		if !hasJoinResult {
-			result.Rows = nil
-			//fmt.Println("Natural Join(s) has(have) no matching results.")
-			return result, nil
-		}
-
-		// Remove unmatched row after join
-		validLastRowIdx := len(result.Rows) - 1
-		for idx := validLastRowIdx; idx >= 0; idx-- {
-			if len(result.Rows[idx]) == beforeJoinResultColLen {
-				result.Rows[idx] = result.Rows[validLastRowIdx]
-				result.Rows = result.Rows[:validLastRowIdx]
-				validLastRowIdx -= 1
-			}
-		}
-
-	}
-
-	return result, nil
+	return NaturalJoin(datasetPtrs)
 }
 
 // Join all tables in the given list using NATURAL JOIN (join on the common columns)
 // Set reply as a Dataset of the joined results.
-func (c *Cluster) Join(tableNames []string, reply *Dataset) {
-	// GetFullTableDataset of tableNames
-	datasetPtrs := make([]*Dataset, len(tableNames))
-	var err error
-	for i, tableName := range tableNames {
-		datasetPtrs[i] = &Dataset{}
-		err = c.GetFullTableDataset(tableName, datasetPtrs[i])
-		if err != nil {
-			reply = nil
-			fmt.Println(err.Error())
-			return
-		}
+func (c *Cluster) Join(tableNames []string, reply *Dataset) error {
+	// If the tables are partitioned in the same way and co-located, the nodes join their local fragments, otherwise
+	// the full tables are joined using NaturalJoinDataset
+	plan, err := c.planJoin(tableNames, nil)
+	if err != nil {
+		return err
 	}
-
-	// Then join them using NaturalJoinDataset
-	if result, err := c.NaturalJoinDatasets(datasetPtrs); err != nil {
-		reply = nil
-		fmt.Println(err.Error())
-	} else {
-		*reply = result
+	result, err := c.executePlanAtSnapshot(plan)
+	if err != nil {
+		return err
 	}
+	*reply = result
+	return nil
 }
 
 // SemiJoin Semi Join first TWO* tables in the given list using provided column name
 // Set reply as a Dataset of the joined results.
-func (c *Cluster) SemiJoin(params []string, reply *Dataset) {
+func (c *Cluster) SemiJoin(params []string, reply *Dataset) error {
 	// the column name to join the two tables on
 	var onJoinColName = params[0]
 	var table1Name = params[1]
 	var table2Name = params[2]
 
-	table1Schema := c.TableSchemasMap[table1Name]
-	table2Schema := c.TableSchemasMap[table2Name]
-
-	// short circuit and return if both tables doesn't have the column to join on
-	if table1Schema.GetColIndexByName(onJoinColName) == -1 || table2Schema.GetColIndexByName(onJoinColName) == -1 {
-		reply = nil
-		fmt.Println("Column to join doesn't exist in both table")
-		return
-	}
-
-	// get full dataset for table2 (filter table)
-	var dataset2 = Dataset{}
-	var err error
-	err = c.GetFullTableDataset(table2Name, &dataset2)
+	reducedDataset1, _, err := c.semiJoin(onJoinColName, table1Name, table2Name)
 	if err != nil {
-		reply = nil
-		fmt.Println(err.Error())
-		return
-	}
-
-	// index of column to be joined on, in table 2
-	srcColIndex := int(table2Schema.GetColIndexByName(onJoinColName))
-
-	// a hashmap storing the possible values in the on-join column in table 2
-	possibleJoinValueSet := make(ValueSet)
-
-	// set the value to true indicating it exists
-	for _, row := range dataset2.Rows {
-		possibleJoinValueSet[row[srcColIndex]] = true
+		return err
 	}
+	*reply = reducedDataset1
+	return nil
+}
 
-	var missingJoinColumnRules = make([]NodeRule, 0)
-
-	// arguments to filter rows in table1
-	var filterArgs []interface{} = make([]interface{}, 3)
-	//filterArgs[0] = table1Name
-	filterArgs[1] = onJoinColName
-	filterArgs[2] = possibleJoinValueSet
-
-	// filtered & restructured table1
-	var pkRowMap = make(map[interface{}]Row)
-
-	endNamePrefix := "InternalClient"
-
-	// Foreach rule of table
-	// TableNodeRulesMap[tableName][nodeIdxStr] -> Rule for node[nodeIdxStr]
-	for _, nodeRule := range c.TableNodeRulesMap[table1Name] {
-		nodeIdxStr := nodeRule.NodeIndices
-		rule := nodeRule.Rule
-		nodeIdx, _ := strconv.Atoi(nodeIdxStr)
-		nodeId := c.nodeIds[nodeIdx]
-		endName := endNamePrefix + nodeId
-		end := c.network.MakeEnd(endName)
-		// connect the client to the node
-		c.network.Connect(endName, nodeId)
-		// a client should be enabled before being used
-		c.network.Enable(endName, true)
-
-		// arguments to check if the on join column exists on the table living on this node
-		var tableHasOnJoinColumn bool
-		var checkJoinColumnExistsArgs []string = make([]string, 2)
-		checkJoinColumnExistsArgs[0] = table1Name + "_R" + strconv.Itoa(rule.RuleIdx)
-		checkJoinColumnExistsArgs[1] = onJoinColName
-
-		filterArgs[0] = table1Name + "_R" + strconv.Itoa(rule.RuleIdx)
-
-		var nodeDataset = Dataset{}
-
-		end.Call("Node.TableHasColumn", checkJoinColumnExistsArgs, &tableHasOnJoinColumn)
-
-		if tableHasOnJoinColumn == true {
-
-			end.Call("Node.FilterTableWithColumnValues", filterArgs, &nodeDataset)
-			nodeDataset.ReconstructTable(pkRowMap, table1Schema, true)
-
-		} else {
-			// save the rule (for .Column) and nodeIdxStr for next loop
-			missingJoinColumnRules = append(missingJoinColumnRules, NodeRule{NodeIndices: nodeIdxStr, Rule: rule})
-		}
-	}
-
-	// args to filter fragmented tables that does not have the column to do the semi join
-	// filterByPKArgs[0] = table name
-	// filterByPKArgs[1...n] = list of primary keys we use to filter rows we need
-	filterByPKArgs := make([]interface{}, len(pkRowMap)+1)
-
-	for pk := range pkRowMap {
-		filterByPKArgs = append(filterByPKArgs, pk)
+// semiJoin reduces table1 by table2 on the given column. It returns the reduced table1 and the full table2, which is
+// fetched to collect the join values.
+func (c *Cluster) semiJoin(onJoinColName string, table1Name string, table2Name string) (Dataset, Dataset, error) {
+	plan, err := c.planSemiJoin(onJoinColName, table1Name, table2Name, nil)
+	if err != nil {
+		return Dataset{}, Dataset{}, err
 	}
-
-	for _, nodeRule := range missingJoinColumnRules {
-		nodeStrIdx := nodeRule.NodeIndices
-		rule := nodeRule.Rule
-		nodeIdx, _ := strconv.Atoi(nodeStrIdx)
-		nodeId := c.nodeIds[nodeIdx]
-		endName := endNamePrefix + nodeId
-		end := c.network.MakeEnd(endName)
-		// connect the client to the node
-		c.network.Connect(endName, nodeId)
-		// a client should be enabled before being used
-		c.network.Enable(endName, true)
-
-		filterByPKArgs[0] = table1Name + "_R" + strconv.Itoa(rule.RuleIdx)
-
-		var nodeDataset = Dataset{}
-		end.Call("Node.FilterTableWithPKs", filterByPKArgs, &nodeDataset)
-		nodeDataset.ReconstructTable(pkRowMap, table1Schema, true)
-
+	reducedDataset1, err := c.executePlanAtSnapshot(plan)
+	if err != nil {
+		return Dataset{}, Dataset{}, err
 	}
+	// the full table2 is the result of the first step of the plan
+	return reducedDataset1, plan.children[0].result, nil
+}
 
-	// initialize returned dataset
-	*reply = Dataset{}
-	reply.Schema = table1Schema
-
-	// Add rows to result
-	for _, row := range pkRowMap {
-		reply.Rows = append(reply.Rows, row)
+// reduceTableByColumn filters the rows of a table on the nodes by the values of one of its columns, and returns the
+// remaining rows of the table (following the table schema) keyed by their row idx.
+// Fragments that hold the column are filtered by calling filterMethod on the nodes with arguments
+// [fragment name, column name, filter]. Then the fragments without the column (vertical fragments) only return the
+// rows whose row idx survived the filtering. The fragments are read as of the given snapshot.
+func (c *Cluster) reduceTableByColumn(tableName string, colName string, filterMethod string,
+	filter interface{}, snapshot Snapshot) (map[interface{}]Row, error) {
+	steps := c.planReduceTableByColumn(tableName, colName, filterMethod)
+	for _, step := range steps {
+		step.setSnapshot(snapshot)
 	}
+	schema, _ := c.getTableSchema(tableName)
+	return c.executeReduceTableByColumn(steps, schema, filter)
 }
 
-func (c *Cluster) BuildTable(params []interface{}, reply *string) {
+// BuildTable creates a table with the given schema, and builds its fragments on the nodes by the given partition rules.
+func (c *Cluster) BuildTable(params []interface{}, reply *string) error {
 	//schema := params[0]
 	//rules := params[1]
 
 	schema := params[0].(TableSchema)
-	c.TableSchemasMap[schema.TableName] = schema
 
+	// the table is registered before its fragments are built, so that it cannot be built twice concurrently
+	c.tablesMu.Lock()
 	// Check if the table already exists
 	if _, ok := c.TableNodeRulesMap[schema.TableName]; ok {
-		reply = nil
-		_ = fmt.Sprintf("Table %s already exists in %s cluster", schema.TableName, c.Name)
+		c.tablesMu.Unlock()
+		return newError(ErrTableExists, fmt.Sprintf("Table %s already exists in %s cluster", schema.TableName,
+			c.Name))
 	} else {
 		// Parse rules from unstructured json to map
 		var rulesMap map[string]Rule
-		_ = json.Unmarshal(params[1].([]byte), &rulesMap)
+		if err := json.Unmarshal(params[1].([]byte), &rulesMap); err != nil {
+			c.tablesMu.Unlock()
+			return newError(ErrInvalidArgument, "invalid partition rules of table "+schema.TableName+": "+
+				err.Error())
+		}
+		c.TableSchemasMap[schema.TableName] = schema
 
 		// Set Rule Idx
 		ruleCount := 0
@@ -466,16 +353,19 @@ func (c *Cluster) BuildTable(params []interface{}, reply *string) {
 
 		}
 		c.TableRowCountMap[schema.TableName] = 0
+		nodeRules := c.TableNodeRulesMap[schema.TableName]
+		c.tablesMu.Unlock()
 		// Example usage of rules
 		// fmt.Println("Rules")
 		// fmt.Println(c.TableNodeRulesMap[schema.TableName]["0"].Predicate["BUDGET"][0].Op)
 		// fmt.Println(c.TableNodeRulesMap[schema.TableName]["0"].Predicate["BUDGET"][0].Val)
 		// fmt.Println(c.TableNodeRulesMap[schema.TableName]["0"].Column)
 
-		endNamePrefix := "InternalClient"
+		// the fragments are built on different nodes concurrently
+		calls := make([]nodeCall, 0)
 		// Foreach rule of table
 		// TableNodeRulesMap[tableName][nodeIdxStr] -> Rule for node[nodeIdxStr]
-		for _, nodeRule := range c.TableNodeRulesMap[schema.TableName] {
+		for _, nodeRule := range nodeRules {
 			nodeIdxStr := nodeRule.NodeIndices
 			rule := nodeRule.Rule
 			//fmt.Println(nodeIdxStr)
@@ -486,14 +376,6 @@ func (c *Cluster) BuildTable(params []interface{}, reply *string) {
 				}
 			}
 			for _, idx := range nodeIdxs {
-				nodeId := c.nodeIds[idx]
-				endName := endNamePrefix + nodeId
-				end := c.network.MakeEnd(endName)
-				// connect the client to the node
-				c.network.Connect(endName, nodeId)
-				// a client should be enabled before being used
-				c.network.Enable(endName, true)
-
 				var colSchemas = make([]ColumnSchema, len(rule.Column))
 
 				// create column schemas from rules
@@ -505,44 +387,108 @@ func (c *Cluster) BuildTable(params []interface{}, reply *string) {
 				argument := TableSchema{
 					TableName:     schema.TableName + "_R" + strconv.Itoa(rule.RuleIdx),
 					ColumnSchemas: colSchemas}
-				reply := ""
 
 				// ampersand (&) to pass as reference. Needed by Node.CreateTable
-				end.Call("Node.BuildTable", &argument, &reply)
-				//fmt.Println(reply)
+				calls = append(calls, nodeCall{nodeIdx: idx, method: "Node.BuildTable", args: &argument,
+					doneCode: ErrTableExists})
 			}
 		}
+		// a fragment built by a lost call is taken as built, so the calls can be retried
+		if err := c.callNodes(calls, c.getRetryPolicy()); err != nil {
+			return err
+		}
 	}
-
+	return nil
 }
 
+// FragmentWrite inserts a row into a table, the row is written to the fragments whose predicates it satisfies.
 // First column of stored row will be the row idx of its un-partitioned table.
-func (c *Cluster) FragmentWrite(params []interface{}, reply *string) {
+// The row may be followed by the RequestId of the write, so that the row is inserted only once if the write is
+// retried.
+func (c *Cluster) FragmentWrite(params []interface{}, reply *string) error {
 	//tableName := params[0]
 	//row := params[1]
+	//requestId := params[2] (optional)
 
+	params, requestId := splitRequestId(params)
 	tableName := params[0].(string)
 	// Un-partitioned row (follows cluster's table schema)
 	row := params[1].(Row)
-	rowIdx := c.TableRowCountMap[tableName]
-	schema := c.TableSchemasMap[tableName]
+	_, err := c.dedup.apply(requestId, func() (string, error) {
+		return "", c.fragmentWrite(tableName, row)
+	})
+	return err
+}
 
-	endNamePrefix := "InternalClient"
+// fragmentWrite inserts a row into a table, see FragmentWrite.
+func (c *Cluster) fragmentWrite(tableName string, row Row) error {
+	schema, err := c.checkRow(tableName, row)
+	if err != nil {
+		return err
+	}
+	rowIdx := c.allocateRowIdx(tableName)
+
+	// the row is written to all of its fragments or none of them
+	if err := c.writeAtomically(c.routeRow(schema, rowIdx, row)); err != nil {
+		c.releaseRowIdx(tableName, rowIdx)
+		return err
+	}
+	return nil
+}
+
+// checkRow checks that a row can be written into a table, and returns the schema of the table.
+func (c *Cluster) checkRow(tableName string, row Row) (TableSchema, error) {
+	schema, ok := c.getTableSchema(tableName)
+	if !ok {
+		return TableSchema{}, noSuchTableError(tableName)
+	}
+	if len(row) != len(schema.ColumnSchemas) {
+		return TableSchema{}, newError(ErrSchemaMismatch, fmt.Sprintf("row %v doesn't match the %d columns of table %s",
+			row, len(schema.ColumnSchemas), tableName))
+	}
+	for colIdx, val := range row {
+		if err := checkValue(schema, colIdx, val); err != nil {
+			return TableSchema{}, err
+		}
+	}
+	return schema, c.checkRouted(schema, row)
+}
+
+// checkValue returns an error if a value cannot be written into a column of a table, see MatchesDataType.
+func checkValue(schema TableSchema, colIdx int, val interface{}) error {
+	colSchema := schema.ColumnSchemas[colIdx]
+	if !MatchesDataType(colSchema.DataType, val) {
+		return newError(ErrSchemaMismatch, fmt.Sprintf("value %v of type %T doesn't match column %s of table %s", val,
+			val, colSchema.Name, schema.TableName))
+	}
+	return nil
+}
+
+// checkRouted returns an error if a row of a table satisfies the predicate of no partition rule, so that no fragment
+// would hold it. A NULL value satisfies no condition (see SatisfiesPredicate), so a row with a NULL partition column
+// is rejected unless some rule has no condition on the column.
+func (c *Cluster) checkRouted(schema TableSchema, row Row) error {
+	for _, nodeRule := range c.getNodeRules(schema.TableName) {
+		if row.SatisfiesPredicate(schema, nodeRule.Rule.Predicate) {
+			return nil
+		}
+	}
+	return newError(ErrInvalidArgument, fmt.Sprintf("row %v of table %s satisfies no partition rule",
+		row, schema.TableName))
+}
+
+// routeRow returns the rows to write into the fragments of a table for a row with the given row idx, keyed by the
+// nodes holding the fragments. The row is written to the fragments whose predicates it satisfies, see checkRouted.
+func (c *Cluster) routeRow(schema TableSchema, rowIdx int, row Row) map[int][]FragmentRow {
+	tableName := schema.TableName
+	// nodeIdx -> rows to write into the fragments on the node
+	nodeWrites := make(map[int][]FragmentRow)
 	// Foreach rule of table
 	// TableNodeRulesMap[tableName][nodeIdxStr] -> Rule for node[nodeIdxStr]
-	for _, nodeRule := range c.TableNodeRulesMap[tableName] {
+	for _, nodeRule := range c.getNodeRules(tableName) {
 		nodeIdxStr := nodeRule.NodeIndices
 		rule := nodeRule.Rule
-		isAllPredicatesSatisfied := true
-
-		for colName, colConditions := range rule.Predicate {
-			if !row.SatisfiesColumnConditions(schema, colName, colConditions) {
-				isAllPredicatesSatisfied = false
-				break
-			}
-		}
-
-		if isAllPredicatesSatisfied == false {
+		if !row.SatisfiesPredicate(schema, rule.Predicate) {
 			continue
 		}
 		//fmt.Println(nodeIdxStr)
@@ -553,26 +499,54 @@ func (c *Cluster) FragmentWrite(params []interface{}, reply *string) {
 			}
 		}
 		for _, idx := range nodeIdxs {
-			nodeId := c.nodeIds[idx]
-			endName := endNamePrefix + nodeId
-			end := c.network.MakeEnd(endName)
-			// connect the client to the node
-			c.network.Connect(endName, nodeId)
-			// a client should be enabled before being used
-			c.network.Enable(endName, true)
-
 			newRow := make(Row, 1)
 			newRow[0] = rowIdx
 			for _, colName := range rule.Column {
 				newRow = append(newRow, row[schema.GetColIndexByName(colName)])
 			}
-			reply := ""
-			end.Call("Node.FragmentWrite",
-				[]interface{}{tableName + "_R" + strconv.Itoa(rule.RuleIdx), newRow}, &reply)
-			//fmt.Println(reply)
+			nodeWrites[idx] = append(nodeWrites[idx],
+				FragmentRow{FragmentName: tableName + "_R" + strconv.Itoa(rule.RuleIdx), Row: newRow})
 		}
 	}
+	return nodeWrites
+}
+
+// GetTableSchema sets reply as the schema of the table with the given name.
+func (c *Cluster) GetTableSchema(tableName string, reply *TableSchema) error {
+	schema, ok := c.getTableSchema(tableName)
+	if !ok {
+		return noSuchTableError(tableName)
+	}
+	*reply = schema
+	return nil
+}
+
+// DropTable removes the table with the given name from the cluster, and the fragments of the table are removed from
+// the nodes.
+func (c *Cluster) DropTable(tableName string, reply *string) error {
+	if _, ok := c.getTableSchema(tableName); !ok {
+		return noSuchTableError(tableName)
+	}
+
+	calls := make([]nodeCall, 0)
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		fragmentName := getFragmentName(tableName, nodeRule.Rule.RuleIdx)
+		for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+			calls = append(calls, nodeCall{nodeIdx: nodeIdx, method: "Node.DropTable", args: fragmentName,
+				doneCode: ErrNoSuchTable})
+		}
+	}
+	// a fragment dropped by a lost call is taken as dropped, so the table is kept to be dropped again if some node
+	// failed
+	if err := c.callNodes(calls, c.getRetryPolicy()); err != nil {
+		return err
+	}
 
-	// Increment row count of table
-	c.TableRowCountMap[tableName] += 1
+	c.tablesMu.Lock()
+	delete(c.TableSchemasMap, tableName)
+	delete(c.TableNodeRulesMap, tableName)
+	delete(c.TableRowCountMap, tableName)
+	c.tablesMu.Unlock()
+	*reply = fmt.Sprintf("Successfully dropped table %s in %s cluster", tableName, c.Name)
+	return nil
 }
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// coLocatedJoinTask is a partial join executed by a single node. The node joins one fragment of each table, and all
+// the fragments of a task are partitioned by the same predicate.
+type coLocatedJoinTask struct {
+	nodeIdx int
+	// fragmentNames[i] is the fragment of the i-th joined table on the node
+	fragmentNames []string
+}
+
+// predicateKey returns a canonical string of a partition predicate, two predicates with the same key select the same
+// rows.
+func predicateKey(predicate map[string][]Condition) string {
+	colNames := make([]string, 0, len(predicate))
+	for colName := range predicate {
+		colNames = append(colNames, colName)
+	}
+	sort.Strings(colNames)
+
+	var builder strings.Builder
+	for _, colName := range colNames {
+		builder.WriteString(colName)
+		for _, condition := range predicate[colName] {
+			builder.WriteString(fmt.Sprintf(" %s %v", condition.Op, condition.Val))
+		}
+		builder.WriteString(";")
+	}
+	return builder.String()
+}
+
+// getRowIdxColumnName returns the name of the hidden column carrying the row idx of a table in node-side joins.
+func getRowIdxColumnName(tableName string) string {
+	return "_rowIdx_" + tableName
+}
+
+// planCoLocatedJoin checks whether the natural join of the given tables can be pushed down to the nodes.
+// This is possible when
+//  1. every table is only horizontally partitioned (each rule holds all columns of the table),
+//  2. the partition predicates only use columns shared by all tables, so that joinable rows satisfy the same predicate,
+//  3. all tables are partitioned by the same set of predicates, and
+//  4. for each predicate, there is a node holding the fragments of all tables.
+//
+// If so, it returns one task per predicate, otherwise false is returned.
+func (c *Cluster) planCoLocatedJoin(tableNames []string) ([]coLocatedJoinTask, bool) {
+	if len(tableNames) < 2 {
+		return nil, false
+	}
+
+	// count in how many tables each column appears
+	colTableCount := make(map[string]int)
+	for _, tableName := range tableNames {
+		schema, ok := c.getTableSchema(tableName)
+		if !ok {
+			return nil, false
+		}
+		for _, colSchema := range schema.ColumnSchemas {
+			colTableCount[colSchema.Name]++
+		}
+	}
+
+	// predicate key -> table idx -> node idx -> fragment name
+	predicateFragmentsMap := make(map[string][]map[int]string)
+	for tableIdx, tableName := range tableNames {
+		schema, _ := c.getTableSchema(tableName)
+		for _, nodeRule := range c.getNodeRules(tableName) {
+			rule := nodeRule.Rule
+			// a vertical fragment cannot be joined without the other columns of its rows
+			if len(rule.Column) != len(schema.ColumnSchemas) {
+				return nil, false
+			}
+			for colName := range rule.Predicate {
+				if colTableCount[colName] != len(tableNames) {
+					return nil, false
+				}
+			}
+
+			key := predicateKey(rule.Predicate)
+			if _, ok := predicateFragmentsMap[key]; !ok {
+				predicateFragmentsMap[key] = make([]map[int]string, len(tableNames))
+			}
+			if predicateFragmentsMap[key][tableIdx] == nil {
+				predicateFragmentsMap[key][tableIdx] = make(map[int]string)
+			}
+			for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+				predicateFragmentsMap[key][tableIdx][nodeIdx] = getFragmentName(tableName, rule.RuleIdx)
+			}
+		}
+	}
+
+	// visit predicates in a fixed order so that the plan is deterministic
+	keys := make([]string, 0, len(predicateFragmentsMap))
+	for key := range predicateFragmentsMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	plan := make([]coLocatedJoinTask, 0, len(keys))
+	for _, key := range keys {
+		tableFragmentsMaps := predicateFragmentsMap[key]
+
+		// find the nodes holding a fragment of every table
+		candidateNodeIdxs := make([]int, 0)
+		for nodeIdx := range tableFragmentsMaps[0] {
+			isCoLocated := true
+			for _, fragmentsMap := range tableFragmentsMaps {
+				if _, ok := fragmentsMap[nodeIdx]; !ok {
+					isCoLocated = false
+					break
+				}
+			}
+			if isCoLocated {
+				candidateNodeIdxs = append(candidateNodeIdxs, nodeIdx)
+			}
+		}
+		if len(candidateNodeIdxs) == 0 {
+			return nil, false
+		}
+		sort.Ints(candidateNodeIdxs)
+
+		task := coLocatedJoinTask{nodeIdx: candidateNodeIdxs[0], fragmentNames: make([]string, len(tableNames))}
+		for tableIdx, fragmentsMap := range tableFragmentsMaps {
+			task.fragmentNames[tableIdx] = fragmentsMap[task.nodeIdx]
+		}
+		plan = append(plan, task)
+	}
+
+	return plan, true
+}
+
+// coLocatedJoin executes the partial joins of the co-located fragments on the nodes and unions their results.
+func (c *Cluster) coLocatedJoin(tableNames []string, nodeJoinSteps []*planStep) (Dataset, error) {
+	// the schema of the result is the same as joining the full tables at the coordinator
+	result := Dataset{}
+	for tableIdx, tableName := range tableNames {
+		hasCommonColumn := false
+		schema, _ := c.getTableSchema(tableName)
+		for _, colSchema := range schema.ColumnSchemas {
+			if result.Schema.GetColIndexByName(colSchema.Name) == -1 {
+				result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas, colSchema)
+			} else {
+				hasCommonColumn = true
+			}
+		}
+		// same as NaturalJoin, the result is empty if a table has no common columns with the previous ones
+		if tableIdx > 0 && !hasCommonColumn {
+			return Dataset{}, nil
+		}
+	}
+
+	// fragments with overlapping predicates may produce the same joined row more than once, so the joined rows are
+	// identified by the row idx of each joined row
+	joinedRowIdxsSet := make(map[string]bool)
+
+	// the nodes join their fragments concurrently, and the joined rows are merged afterwards
+	nodeDatasets, err := c.executePlanSteps(nodeJoinSteps)
+	if err != nil {
+		return Dataset{}, err
+	}
+	for _, nodeDataset := range nodeDatasets {
+
+		// map node columns to result columns, hidden row idx columns are mapped to -1
+		colMapping := make([]int, len(nodeDataset.Schema.ColumnSchemas))
+		for nodeColIdx, colSchema := range nodeDataset.Schema.ColumnSchemas {
+			colMapping[nodeColIdx] = result.Schema.GetColIndexByName(colSchema.Name)
+		}
+
+		for _, nodeRow := range nodeDataset.Rows {
+			row := make(Row, len(result.Schema.ColumnSchemas))
+			var rowIdxs strings.Builder
+			for nodeColIdx, val := range nodeRow {
+				if colMapping[nodeColIdx] == -1 {
+					rowIdxs.WriteString(fmt.Sprintf("%v|", val))
+				} else {
+					row[colMapping[nodeColIdx]] = val
+				}
+			}
+			if !joinedRowIdxsSet[rowIdxs.String()] {
+				joinedRowIdxsSet[rowIdxs.String()] = true
+				result.Rows = append(result.Rows, row)
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// both tables are partitioned on sid in the same way, so the join can be done on the nodes
+func TestCoLocatedJoin(t *testing.T) {
+	setupLab3()
+
+	m := map[string]interface{}{
+		"0|1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"sid": [...]map[string]interface{}{{
+					"op":  "<=",
+					"val": 0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+		"2|3": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"sid": [...]map[string]interface{}{{
+					"op":  ">",
+					"val": 0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+	}
+	studentTablePartitionRules, _ = json.Marshal(m)
+
+	m = map[string]interface{}{
+		"1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"sid": [...]map[string]interface{}{{
+					"op":  "<=",
+					"val": 0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "courseId",
+			},
+		},
+		"3|4": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"sid": [...]map[string]interface{}{{
+					"op":  ">",
+					"val": 0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "courseId",
+			},
+		},
+	}
+	courseRegistrationTablePartitionRules, _ = json.Marshal(m)
+
+	buildTablesLab3(cli)
+	insertDataLab3(cli)
+
+	plan, ok := c.planCoLocatedJoin([]string{studentTableName, courseRegistrationTableName})
+	if !ok {
+		t.Fatalf("The join should be pushed down to the nodes")
+	}
+	planNodeIdxs := make(map[int]bool)
+	for _, task := range plan {
+		planNodeIdxs[task.nodeIdx] = true
+	}
+	if len(plan) != 2 || !planNodeIdxs[1] || !planNodeIdxs[3] {
+		t.Errorf("The partial joins should be executed on node 1 and node 3, actual plan %v", plan)
+	}
+
+	results := Dataset{}
+	cli.Call("Cluster.Join", []string{studentTableName, courseRegistrationTableName}, &results)
+	expectedDataset := Dataset{
+		Schema: joinedTableSchema,
+		Rows:   joinedTableContent,
+	}
+	if !datasetDuplicateChecking(expectedDataset, results) {
+		t.Errorf("Incorrect join results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+// the partitions overlap on sid = 1, the rows joined by both nodes should only be returned once
+func TestCoLocatedJoinOverlappingPartitions(t *testing.T) {
+	setupLab3()
+
+	m := map[string]interface{}{
+		"0": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"sid": [...]map[string]interface{}{{
+					"op":  "<=",
+					"val": 1,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+		"1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"sid": [...]map[string]interface{}{{
+					"op":  ">=",
+					"val": 1,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+	}
+	studentTablePartitionRules, _ = json.Marshal(m)
+
+	m = map[string]interface{}{
+		"0": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"sid": [...]map[string]interface{}{{
+					"op":  "<=",
+					"val": 1,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "courseId",
+			},
+		},
+		"1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"sid": [...]map[string]interface{}{{
+					"op":  ">=",
+					"val": 1,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "courseId",
+			},
+		},
+	}
+	courseRegistrationTablePartitionRules, _ = json.Marshal(m)
+
+	buildTablesLab3(cli)
+	insertDataLab3(cli)
+
+	if _, ok := c.planCoLocatedJoin([]string{studentTableName, courseRegistrationTableName}); !ok {
+		t.Fatalf("The join should be pushed down to the nodes")
+	}
+
+	results := Dataset{}
+	cli.Call("Cluster.Join", []string{studentTableName, courseRegistrationTableName}, &results)
+	expectedDataset := Dataset{
+		Schema: joinedTableSchema,
+		Rows:   joinedTableContent,
+	}
+	if !datasetDuplicateChecking(expectedDataset, results) {
+		t.Errorf("Incorrect join results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+// the tables are partitioned on different columns, so the join must be done at the coordinator
+func TestCoLocatedJoinNotApplicable(t *testing.T) {
+	setupLab3()
+
+	m := map[string]interface{}{
+		"0|1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{
+					"op":  "<=",
+					"val": 3.6,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+		"1|2": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{
+					"op":  ">",
+					"val": 3.6,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+	}
+	studentTablePartitionRules, _ = json.Marshal(m)
+
+	m = map[string]interface{}{
+		"0|1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"courseId": [...]map[string]interface{}{{
+					"op":  ">=",
+					"val": 0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "courseId",
+			},
+		},
+	}
+	courseRegistrationTablePartitionRules, _ = json.Marshal(m)
+
+	buildTablesLab3(cli)
+	insertDataLab3(cli)
+
+	if _, ok := c.planCoLocatedJoin([]string{studentTableName, courseRegistrationTableName}); ok {
+		t.Errorf("The join should not be pushed down to the nodes")
+	}
+}
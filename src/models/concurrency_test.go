@@ -0,0 +1,184 @@
+package models
+
+import (
+	"../labrpc"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const (
+	concurrentClientCount = 10
+	concurrentWriteCount  = 10
+)
+
+// makeClients creates the given number of clients connected to the cluster, each of them is a separate ClientEnd.
+func makeClients(clientCount int) []*labrpc.ClientEnd {
+	clients := make([]*labrpc.ClientEnd, clientCount)
+	for i := range clients {
+		clientName := "ConcurrentClient" + strconv.Itoa(i)
+		clients[i] = network.MakeEnd(clientName)
+		network.Connect(clientName, c.Name)
+		network.Enable(clientName, true)
+	}
+	return clients
+}
+
+// writeStudentsConcurrently writes concurrentWriteCount students by each client concurrently, the sids start from 100,
+// and half of the students are written to each fragment of student.
+func writeStudentsConcurrently(t *testing.T, clients []*labrpc.ClientEnd) {
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *labrpc.ClientEnd) {
+			defer wg.Done()
+			for j := 0; j < concurrentWriteCount; j++ {
+				sid := 100 + i*concurrentWriteCount + j
+				reply := ""
+				if err := client.CallWithError("Cluster.FragmentWrite",
+					[]interface{}{studentTableName, Row{sid, "Ann", 20, 3.0 + float64(j%2)}}, &reply); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i, client)
+	}
+	wg.Wait()
+}
+
+// getFragmentRowIdxs returns the row idxs of the rows in a fragment on a node, and fails if some row idx is repeated.
+func getFragmentRowIdxs(t *testing.T, nodeIdx int, fragmentName string) ValueSet {
+	dataset := Dataset{}
+	if err := c.getNodeEnd(nodeIdx).CallWithError("Node.GetTableDataset", fragmentName, &dataset); err != nil {
+		t.Fatal(err)
+	}
+	rowIdxs := make(ValueSet)
+	for _, row := range dataset.Rows {
+		if rowIdxs[row[0]] {
+			t.Errorf("Row idx %v is repeated in %s on node %d", row[0], fragmentName, nodeIdx)
+		}
+		rowIdxs[row[0]] = true
+	}
+	return rowIdxs
+}
+
+func TestConcurrentFragmentWrite(t *testing.T) {
+	setOperationSetup()
+	writeStudentsConcurrently(t, makeClients(concurrentClientCount))
+
+	studentCount := len(studentRows) + concurrentClientCount*concurrentWriteCount
+	if c.TableRowCountMap[studentTableName] != studentCount {
+		t.Errorf("Expected %d rows in student, actual %d", studentCount, c.TableRowCountMap[studentTableName])
+	}
+	// every row has its own row idx, and the replicas of a fragment hold the same rows
+	rowIdxs := make(ValueSet)
+	for _, fragment := range []struct {
+		nodeIndices string
+		nodeIdxs    []int
+	}{{"0|1", []int{0, 1}}, {"1|2", []int{1, 2}}} {
+		fragmentName := getStudentFragmentName(fragment.nodeIndices)
+		replicaRowIdxs := getFragmentRowIdxs(t, fragment.nodeIdxs[0], fragmentName)
+		if otherRowIdxs := getFragmentRowIdxs(t, fragment.nodeIdxs[1], fragmentName); len(otherRowIdxs) !=
+			len(replicaRowIdxs) {
+			t.Errorf("The replicas of %s hold %d and %d rows", fragmentName, len(replicaRowIdxs), len(otherRowIdxs))
+		}
+		for rowIdx := range replicaRowIdxs {
+			if rowIdxs[rowIdx] {
+				t.Errorf("Row idx %v is written to both fragments of student", rowIdx)
+			}
+			rowIdxs[rowIdx] = true
+		}
+	}
+	if len(rowIdxs) != studentCount {
+		t.Errorf("Expected %d row idxs in student, actual %d", studentCount, len(rowIdxs))
+	}
+	if sids := selectSids(t, studentTableName, ""); len(sids) != studentCount {
+		t.Errorf("Expected %d students, actual %d", studentCount, len(sids))
+	}
+}
+
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	setOperationSetup()
+	clients := makeClients(2 * concurrentClientCount)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writeStudentsConcurrently(t, clients[:concurrentClientCount])
+	}()
+	// the readers see the students of a snapshot, which grows while the writers write
+	for _, client := range clients[concurrentClientCount:] {
+		wg.Add(1)
+		go func(client *labrpc.ClientEnd) {
+			defer wg.Done()
+			for j := 0; j < concurrentWriteCount; j++ {
+				results := Dataset{}
+				if err := client.CallWithError("Cluster.Select", SelectQuery{TableName: studentTableName,
+					Columns: []string{"sid"}}, &results); err != nil {
+					t.Error(err)
+					return
+				}
+				sids := make(ValueSet)
+				for _, row := range results.Rows {
+					if sids[row[0]] {
+						t.Errorf("Student %v is selected twice", row[0])
+					}
+					sids[row[0]] = true
+				}
+				for _, row := range studentRows {
+					if !sids[row[0]] {
+						t.Errorf("Student %v is missing from the selected students %v", row[0], sids)
+					}
+				}
+			}
+		}(client)
+	}
+	wg.Wait()
+
+	studentCount := len(studentRows) + concurrentClientCount*concurrentWriteCount
+	if sids := selectSids(t, studentTableName, ""); len(sids) != studentCount {
+		t.Errorf("Expected %d students, actual %d", studentCount, len(sids))
+	}
+}
+
+func TestConcurrentBuildTable(t *testing.T) {
+	setOperationSetup()
+	teacherSchema := TableSchema{TableName: "teacher", ColumnSchemas: []ColumnSchema{
+		{Name: "tid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+	}}
+	teacherRules, _ := json.Marshal(map[string]interface{}{
+		"3|4": map[string]interface{}{
+			"predicate": map[string]interface{}{},
+			"column":    []string{"tid", "name"},
+		},
+	})
+
+	// only one of the clients builds the table
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	builtCount := 0
+	for _, client := range makeClients(concurrentClientCount) {
+		wg.Add(1)
+		go func(client *labrpc.ClientEnd) {
+			defer wg.Done()
+			reply := ""
+			err := client.CallWithError("Cluster.BuildTable", []interface{}{teacherSchema, teacherRules}, &reply)
+			if err == nil {
+				mu.Lock()
+				builtCount++
+				mu.Unlock()
+				return
+			}
+			checkErrorCode(t, "Building a table concurrently", err, ErrTableExists)
+		}(client)
+	}
+	wg.Wait()
+	if builtCount != 1 {
+		t.Errorf("Expected the table to be built once, actual %d times", builtCount)
+	}
+	if len(c.TableNodeRulesMap["teacher"]) != 1 {
+		t.Errorf("Expected 1 fragment of teacher, actual %v", c.TableNodeRulesMap["teacher"])
+	}
+}
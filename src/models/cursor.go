@@ -0,0 +1,485 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultCursorBatchSize is the number of rows fetched at a time if the batch size is not given.
+const defaultCursorBatchSize = 100
+
+// cursorIdleTimeout is how long a cursor is kept without being fetched, a cursor abandoned by its client is closed
+// after that, see closeIdleCursors. The scans on the nodes are kept twice as long (see Node.OpenScan), so that the scan
+// of a live cursor is never reclaimed before the cursor.
+const cursorIdleTimeout = time.Minute
+
+// NodeScan opens a scan over some fragments of a table on a node, whose rows are fetched in batches by FetchScan.
+// The rows start with their row idx, followed by the values of Columns.
+type NodeScan struct {
+	// identifier of the scan, chosen by the coordinator
+	ScanId string
+	// names of the fragments on the node, each of them should hold all columns in Columns and Where
+	FragmentNames []string
+	Columns       []string
+	Where         map[string][]Condition
+	// fragment name -> the predicates of the fragments scanned by other scans, the rows of the fragment satisfying any
+	// of them are skipped, as they are returned by the other scans
+	Excluded map[string][]map[string][]Condition
+	// the snapshot to read, see Node.snapshotRowIterator
+	Snapshot Snapshot
+}
+
+// NodeScanFetch asks for the next rows of a scan.
+type NodeScanFetch struct {
+	ScanId    string
+	BatchSize int
+}
+
+// ScanBatch is a batch of rows of a scan or a cursor.
+type ScanBatch struct {
+	Rows Dataset
+	// true if there are no more rows, the scan or the cursor is closed then
+	Done bool
+}
+
+// nodeScan is the state of an open scan on a node.
+type nodeScan struct {
+	args NodeScan
+	// index of the fragment being scanned
+	fragmentIdx int
+	iterator    RowIterator
+	// when the scan is opened or fetched last time, guarded by n.scansMu
+	lastUsedAt time.Time
+}
+
+// OpenScan opens a scan on this node, the rows are read when they are fetched, so that only a batch of rows is held in
+// memory at a time. The scans that are not fetched within n.scanIdleTimeout are closed meanwhile, as their
+// coordinator has closed their cursors, see cursorIdleTimeout.
+func (n *Node) OpenScan(args NodeScan, reply *string) error {
+	for _, fragmentName := range args.FragmentNames {
+		if _, ok := n.getTable(fragmentName); !ok {
+			return newError(ErrNoSuchTable, "fragment "+fragmentName+" doesn't exist on "+n.Identifier)
+		}
+	}
+
+	n.scansMu.Lock()
+	defer n.scansMu.Unlock()
+	for scanId, scan := range n.scans {
+		if time.Since(scan.lastUsedAt) >= n.scanIdleTimeout {
+			delete(n.scans, scanId)
+		}
+	}
+	n.scans[args.ScanId] = &nodeScan{args: args, lastUsedAt: time.Now()}
+	*reply = fmt.Sprintf("Successfully opened scan %s on Node %s", args.ScanId, n.Identifier)
+	return nil
+}
+
+// FetchScan returns the next batch of rows of a scan, the scan is closed after its last rows are returned.
+func (n *Node) FetchScan(args NodeScanFetch, reply *ScanBatch) error {
+	n.scansMu.Lock()
+	scan, ok := n.scans[args.ScanId]
+	if ok {
+		scan.lastUsedAt = time.Now()
+	}
+	n.scansMu.Unlock()
+	if !ok {
+		return newError(ErrInvalidArgument, "scan "+args.ScanId+" doesn't exist on "+n.Identifier)
+	}
+
+	reply.Rows.Schema = TableSchema{ColumnSchemas: []ColumnSchema{{Name: "_rowIdx_", DataType: TypeInt64}}}
+	for len(reply.Rows.Rows) < args.BatchSize && scan.fragmentIdx < len(scan.args.FragmentNames) {
+		table, ok := n.getTable(scan.args.FragmentNames[scan.fragmentIdx])
+		if !ok {
+			return noSuchTableError(scan.args.FragmentNames[scan.fragmentIdx])
+		}
+		if scan.iterator == nil {
+			scan.iterator = n.snapshotRowIterator(table, scan.args.FragmentNames[scan.fragmentIdx], scan.args.Snapshot)
+		}
+		if !scan.iterator.HasNext() {
+			scan.fragmentIdx++
+			scan.iterator = nil
+			continue
+		}
+
+		row := *scan.iterator.Next()
+		// skip the row idx in the first column
+		values := row[1:]
+		if !values.SatisfiesPredicate(*table.schema, scan.args.Where) ||
+			values.satisfiesAnyPredicate(*table.schema, scan.args.Excluded[scan.args.FragmentNames[scan.fragmentIdx]]) {
+			continue
+		}
+		scannedRow := Row{row[0]}
+		for _, colName := range scan.args.Columns {
+			scannedRow = append(scannedRow, values[table.schema.GetColIndexByName(colName)])
+		}
+		reply.Rows.Rows = append(reply.Rows.Rows, scannedRow)
+	}
+
+	if scan.fragmentIdx >= len(scan.args.FragmentNames) {
+		reply.Done = true
+		n.scansMu.Lock()
+		delete(n.scans, args.ScanId)
+		n.scansMu.Unlock()
+	}
+	return nil
+}
+
+// CloseScan closes a scan before all of its rows are fetched. Closing a scan that does not exist does nothing.
+func (n *Node) CloseScan(scanId string, reply *string) error {
+	n.scansMu.Lock()
+	defer n.scansMu.Unlock()
+	delete(n.scans, scanId)
+	*reply = fmt.Sprintf("Successfully closed scan %s on Node %s", scanId, n.Identifier)
+	return nil
+}
+
+// satisfiesAnyPredicate returns true if the row satisfies some of the predicates.
+func (r *Row) satisfiesAnyPredicate(schema TableSchema, predicates []map[string][]Condition) bool {
+	for _, predicate := range predicates {
+		if r.SatisfiesPredicate(schema, predicate) {
+			return true
+		}
+	}
+	return false
+}
+
+// CursorFetch asks for the next rows of a cursor.
+type CursorFetch struct {
+	CursorId string
+	// number of rows to fetch, defaultCursorBatchSize is used if it is not positive
+	BatchSize int
+}
+
+// cursor is the state of an open cursor at the coordinator.
+type cursor struct {
+	query    SelectQuery
+	snapshot Snapshot
+	// the output columns, and the columns returned by the node scans
+	outputColNames []string
+	scanColNames   []string
+	// the nodes to scan in order, and the fragments to scan on each of them
+	nodeIdxs      []int
+	fragmentNames map[int][]string
+	// see NodeScan.Excluded
+	excluded map[string][]map[string][]Condition
+	// index of the node being scanned in nodeIdxs, and whether its scan is open
+	scanIdx    int
+	isScanOpen bool
+	// number of rows skipped by the offset, and returned
+	skippedCount  int
+	returnedCount int
+	// when the cursor is opened or fetched last time, guarded by c.cursorsMu, see closeIdleCursors
+	lastUsedAt time.Time
+	// guards the cursor against concurrent fetches
+	mu sync.Mutex
+}
+
+// getScanId returns the identifier of the scan of the cursor on a node.
+func getScanId(cursorId string, nodeIdx int) string {
+	return cursorId + "@" + strconv.Itoa(nodeIdx)
+}
+
+// OpenCursor opens a cursor over the results of a query, so that they are streamed in batches by FetchCursor instead
+// of being returned at once. The nodes are scanned one after another and only one batch of rows is held by a node or
+// the coordinator at a time, while the output columns held by other fragments are fetched for each batch. One fragment
+// of each partition predicate is scanned on one of its replicas, and a row held by the fragments of overlapping
+// predicates is only returned by the first of them (see NodeScan.Excluded), so the returned rows are not tracked.
+// The rows are returned in the order they are stored, so OrderBy and Distinct are not supported.
+// Set reply as the identifier of the cursor, which should be closed by CloseCursor if not all rows are fetched, or it
+// is closed after cursorIdleTimeout.
+func (c *Cluster) OpenCursor(query SelectQuery, reply *string) error {
+	schema, ok := c.getTableSchema(query.TableName)
+	if !ok {
+		return noSuchTableError(query.TableName)
+	}
+	if err := query.validate(schema); err != nil {
+		return err
+	}
+	if len(query.OrderBy) > 0 || query.Distinct {
+		return newError(ErrInvalidArgument,
+			"cursors return rows in storage order, ORDER BY and DISTINCT are not supported")
+	}
+	// the rows are fetched from the snapshot taken when the cursor is opened
+	snapshot, err := c.getReadSnapshot(query.TxnId, query.TableName)
+	if err != nil {
+		return err
+	}
+
+	cur := &cursor{query: query, snapshot: snapshot, outputColNames: query.Columns,
+		fragmentNames: make(map[int][]string), excluded: make(map[string][]map[string][]Condition)}
+	if len(cur.outputColNames) == 0 {
+		for _, colSchema := range schema.ColumnSchemas {
+			cur.outputColNames = append(cur.outputColNames, colSchema.Name)
+		}
+	}
+
+	// the fragments to scan should hold the columns in Where
+	whereColNames := make([]string, 0)
+	for colName := range query.Where {
+		whereColNames = append(whereColNames, colName)
+	}
+	nodeRules, ok := c.planSortedScan(query.TableName, query.Where, whereColNames)
+	if !ok {
+		return newError(ErrInvalidArgument, "the rows of table "+query.TableName+" cannot be streamed, as no "+
+			"fragment holds all the columns in Where")
+	}
+	nodeRules = pickRulePerPredicate(nodeRules, cur.outputColNames)
+
+	// the output columns held by every scanned fragment are returned by the scans
+	for _, colName := range cur.outputColNames {
+		isHeld := true
+		for _, nodeRule := range nodeRules {
+			isHeld = isHeld && nodeRule.Rule.HasColumn(colName)
+		}
+		if isHeld {
+			cur.scanColNames = appendIfAbsent(cur.scanColNames, colName)
+		}
+	}
+
+	// a row held by the fragments of overlapping predicates is returned by the first of them
+	for i, nodeRule := range nodeRules {
+		fragmentName := getFragmentName(query.TableName, nodeRule.Rule.RuleIdx)
+		for _, prevNodeRule := range nodeRules[:i] {
+			if arePredicatesDisjoint(schema, nodeRule.Rule.Predicate, prevNodeRule.Rule.Predicate) {
+				continue
+			}
+			for colName := range prevNodeRule.Rule.Predicate {
+				if !nodeRule.Rule.HasColumn(colName) {
+					return newError(ErrInvalidArgument, "the rows of table "+query.TableName+" cannot be streamed, "+
+						"as fragment "+fragmentName+" overlaps another fragment but lacks its column "+colName)
+				}
+			}
+			cur.excluded[fragmentName] = append(cur.excluded[fragmentName], prevNodeRule.Rule.Predicate)
+		}
+	}
+	if len(nodeRules) > 0 {
+		for nodeIdxStr, ruleIdxs := range SetCover(nodeRules) {
+			nodeIdx, _ := strconv.Atoi(nodeIdxStr)
+			cur.nodeIdxs = append(cur.nodeIdxs, nodeIdx)
+			for _, ruleIdx := range ruleIdxs {
+				cur.fragmentNames[nodeIdx] = append(cur.fragmentNames[nodeIdx], getFragmentName(query.TableName, ruleIdx))
+			}
+		}
+	}
+
+	c.closeIdleCursors()
+	c.cursorsMu.Lock()
+	defer c.cursorsMu.Unlock()
+	c.nextCursorId++
+	cursorId := "cursor" + strconv.Itoa(c.nextCursorId)
+	cur.lastUsedAt = time.Now()
+	c.cursors[cursorId] = cur
+	*reply = cursorId
+	return nil
+}
+
+// pickRulePerPredicate returns one node rule of each partition predicate among the given node rules, in the order of
+// their first appearance. The rule holding the most of the given columns is picked, so that fewer columns are fetched
+// from other fragments.
+func pickRulePerPredicate(nodeRules []NodeRule, colNames []string) []NodeRule {
+	heldCount := func(nodeRule NodeRule) int {
+		count := 0
+		for _, colName := range colNames {
+			if nodeRule.Rule.HasColumn(colName) {
+				count++
+			}
+		}
+		return count
+	}
+	picked := make([]NodeRule, 0, len(nodeRules))
+	// predicate key -> index of the picked rule
+	pickedIdxMap := make(map[string]int)
+	for _, nodeRule := range nodeRules {
+		key := predicateKey(nodeRule.Rule.Predicate)
+		if i, ok := pickedIdxMap[key]; !ok {
+			pickedIdxMap[key] = len(picked)
+			picked = append(picked, nodeRule)
+		} else if heldCount(nodeRule) > heldCount(picked[i]) {
+			picked[i] = nodeRule
+		}
+	}
+	return picked
+}
+
+// closeIdleCursors closes the cursors that are not fetched within c.cursorIdleTimeout, e.g. as their clients are gone.
+func (c *Cluster) closeIdleCursors() {
+	c.cursorsMu.Lock()
+	idleCursors := make(map[string]*cursor)
+	for cursorId, cur := range c.cursors {
+		if time.Since(cur.lastUsedAt) >= c.cursorIdleTimeout {
+			idleCursors[cursorId] = cur
+		}
+	}
+	c.cursorsMu.Unlock()
+
+	for cursorId, cur := range idleCursors {
+		cur.mu.Lock()
+		c.closeCursor(cursorId, cur)
+		cur.mu.Unlock()
+	}
+}
+
+// FetchCursor returns the next batch of rows of a cursor, which hold the output columns of its query. The cursor is
+// closed after its last rows are returned.
+func (c *Cluster) FetchCursor(args CursorFetch, reply *ScanBatch) error {
+	c.cursorsMu.Lock()
+	cur, ok := c.cursors[args.CursorId]
+	if ok {
+		cur.lastUsedAt = time.Now()
+	}
+	c.cursorsMu.Unlock()
+	if !ok {
+		return newError(ErrInvalidArgument, "cursor "+args.CursorId+" doesn't exist")
+	}
+	batchSize := args.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCursorBatchSize
+	}
+
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	query := &cur.query
+	isDone := false
+	rows := make([]Row, 0, batchSize)
+	for len(rows) < batchSize && !isDone {
+		if cur.scanIdx >= len(cur.nodeIdxs) || (query.HasLimit && cur.returnedCount >= query.Limit) {
+			isDone = true
+			break
+		}
+
+		nodeIdx := cur.nodeIdxs[cur.scanIdx]
+		scanId := getScanId(args.CursorId, nodeIdx)
+		if !cur.isScanOpen {
+			// opening a scan again restarts it, so it can be retried before any rows are fetched
+			var openReply string
+			cur.isScanOpen = true
+			if _, err := c.callNode(nodeIdx, "Node.OpenScan", NodeScan{ScanId: scanId,
+				FragmentNames: cur.fragmentNames[nodeIdx], Columns: cur.scanColNames, Where: query.Where,
+				Excluded: cur.excluded, Snapshot: cur.snapshot},
+				&openReply); err != nil {
+				c.closeCursor(args.CursorId, cur)
+				return err
+			}
+		}
+
+		// a fetch moves the scan forward, so it is not retried, as the rows of a lost reply would be skipped
+		var nodeBatch ScanBatch
+		if _, err := c.callNodeWithPolicy(c.getRetryPolicy().withoutRetries(), nodeIdx, "Node.FetchScan",
+			NodeScanFetch{ScanId: scanId, BatchSize: batchSize - len(rows)}, &nodeBatch); err != nil {
+			// the scan cannot be continued, so the cursor is closed
+			c.closeCursor(args.CursorId, cur)
+			return err
+		}
+		for _, row := range nodeBatch.Rows.Rows {
+			if cur.skippedCount < query.Offset {
+				cur.skippedCount++
+				continue
+			}
+			if query.HasLimit && cur.returnedCount >= query.Limit {
+				break
+			}
+			cur.returnedCount++
+			rows = append(rows, row)
+		}
+		if nodeBatch.Done {
+			cur.scanIdx++
+			cur.isScanOpen = false
+		}
+	}
+
+	dataset, err := c.getCursorBatchDataset(cur, rows)
+	if err != nil {
+		c.closeCursor(args.CursorId, cur)
+		return err
+	}
+	reply.Rows, reply.Done = dataset, isDone
+	if isDone {
+		c.closeCursor(args.CursorId, cur)
+	}
+	return nil
+}
+
+// getCursorBatchDataset converts the scanned rows of a batch into the output columns of the cursor, fetching the
+// columns not returned by the scans.
+func (c *Cluster) getCursorBatchDataset(cur *cursor, rows []Row) (Dataset, error) {
+	schema, _ := c.getTableSchema(cur.query.TableName)
+	// scanned column name -> index in the scanned rows
+	scanColIdxMap := make(map[string]int)
+	for i, colName := range cur.scanColNames {
+		scanColIdxMap[colName] = i + 1
+	}
+	missingColNames := make([]string, 0)
+	for _, colName := range cur.outputColNames {
+		if _, ok := scanColIdxMap[colName]; !ok {
+			missingColNames = append(missingColNames, colName)
+		}
+	}
+	var missingSchema TableSchema
+	missingPKRowMap := make(map[interface{}]Row)
+	if len(missingColNames) > 0 && len(rows) > 0 {
+		pks := make(ValueSet)
+		for _, row := range rows {
+			pks[row[0]] = true
+		}
+		var err error
+		if missingPKRowMap, missingSchema, err = c.projectTable(cur.query.TableName, missingColNames, pks,
+			true, cur.snapshot); err != nil {
+			return Dataset{}, err
+		}
+	}
+
+	result := Dataset{Schema: TableSchema{TableName: cur.query.TableName}}
+	for _, colName := range cur.outputColNames {
+		result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas,
+			ColumnSchema{Name: colName, DataType: schema.GetColTypeByName(colName)})
+	}
+	for _, row := range rows {
+		resultRow := make(Row, len(cur.outputColNames))
+		for i, colName := range cur.outputColNames {
+			if colIdx, ok := scanColIdxMap[colName]; ok {
+				resultRow[i] = row[colIdx]
+			} else if missingRow, ok := missingPKRowMap[row[0]]; ok {
+				resultRow[i] = missingRow[missingSchema.GetColIndexByName(colName)]
+			}
+		}
+		result.Rows = append(result.Rows, resultRow)
+	}
+	return result, nil
+}
+
+// closeCursor removes a cursor and closes its open scan on the nodes. The cursor is removed even if the scan cannot be
+// closed, which is returned as an error.
+func (c *Cluster) closeCursor(cursorId string, cur *cursor) error {
+	c.cursorsMu.Lock()
+	delete(c.cursors, cursorId)
+	c.cursorsMu.Unlock()
+
+	if !cur.isScanOpen {
+		return nil
+	}
+	cur.isScanOpen = false
+	nodeIdx := cur.nodeIdxs[cur.scanIdx]
+	var closeReply string
+	_, err := c.callNode(nodeIdx, "Node.CloseScan", getScanId(cursorId, nodeIdx), &closeReply)
+	return err
+}
+
+// CloseCursor closes a cursor before all of its rows are fetched, the scan of the cursor on the nodes is closed as
+// well. Closing a cursor that does not exist does nothing.
+func (c *Cluster) CloseCursor(cursorId string, reply *string) error {
+	c.cursorsMu.Lock()
+	cur, ok := c.cursors[cursorId]
+	c.cursorsMu.Unlock()
+	if ok {
+		cur.mu.Lock()
+		err := c.closeCursor(cursorId, cur)
+		cur.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	*reply = "Successfully closed cursor " + cursorId
+	return nil
+}
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"strconv"
+	"testing"
+)
+
+// fetchAll fetches the rows of a cursor in batches of the given size until it is done.
+func fetchAll(t *testing.T, cursorId string, batchSize int) Dataset {
+	result := Dataset{}
+	for i := 0; ; i++ {
+		batch := ScanBatch{}
+		cli.Call("Cluster.FetchCursor", CursorFetch{CursorId: cursorId, BatchSize: batchSize}, &batch)
+		if len(batch.Rows.Rows) > batchSize {
+			t.Errorf("Expected at most %d rows in a batch, actual %d", batchSize, len(batch.Rows.Rows))
+		}
+		result.Schema = batch.Rows.Schema
+		result.Rows = append(result.Rows, batch.Rows.Rows...)
+		if batch.Done {
+			return result
+		}
+		if i > 1000 {
+			t.Fatalf("The cursor is never done")
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	joinStrategySetup()
+
+	queries := []SelectQuery{
+		{TableName: studentTableName},
+		{TableName: studentTableName, Columns: []string{"name", "grade"},
+			Where: map[string][]Condition{"grade": {{Op: ">=", Val: 3.5}}}},
+		{TableName: courseRegistrationTableName, Columns: []string{"courseId"}},
+	}
+	for _, query := range queries {
+		expectedDataset := Dataset{}
+		cli.Call("Cluster.Select", query, &expectedDataset)
+
+		var cursorId string
+		cli.Call("Cluster.OpenCursor", query, &cursorId)
+		if cursorId == "" {
+			t.Fatalf("Failed to open a cursor for %v", query)
+		}
+		results := fetchAll(t, cursorId, 7)
+		if !compareDataset(results, expectedDataset) {
+			t.Errorf("Incorrect results of %v, expected %v, actual %v", query, expectedDataset, results)
+		}
+		if len(c.cursors) != 0 {
+			t.Errorf("The cursor should be closed after its last batch")
+		}
+	}
+
+	// the offset and the limit apply across batches
+	var cursorId string
+	cli.Call("Cluster.OpenCursor", SelectQuery{TableName: studentTableName, Offset: 5, HasLimit: true, Limit: 12},
+		&cursorId)
+	if results := fetchAll(t, cursorId, 5); len(results.Rows) != 12 {
+		t.Errorf("Expected 12 rows, actual %d", len(results.Rows))
+	}
+}
+
+func TestCursorFetchesMissingColumns(t *testing.T) {
+	// name and grade are held by different fragments
+	aggregateSetup(map[string]interface{}{
+		"0": gradeRule(">=", 0, "sid", "name"),
+		"1": gradeRule(">=", 0, "sid", "age", "grade"),
+	})
+
+	query := SelectQuery{TableName: studentTableName, Columns: []string{"name", "grade"},
+		Where: map[string][]Condition{"grade": {{Op: ">", Val: 3.2}}}}
+	var cursorId string
+	cli.Call("Cluster.OpenCursor", query, &cursorId)
+	results := fetchAll(t, cursorId, 2)
+	expectedDataset := Dataset{
+		Schema: TableSchema{studentTableName, []ColumnSchema{{"name", TypeString}, {"grade", TypeFloat}}},
+		Rows:   []Row{{"John", 4.0}, {"Smith", 3.5}, {"Hana", 4.0}, {"Tom", 4.0}},
+	}
+	if !compareDataset(results, expectedDataset) {
+		t.Errorf("Incorrect results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+func TestCursorOverlappingFragments(t *testing.T) {
+	// Smith is held by both fragments, and the vertical fragments of the same predicate hold the same students
+	aggregateSetup(map[string]interface{}{
+		"0":   gradeRule("<=", 3.6, "sid", "name", "age", "grade"),
+		"1":   gradeRule(">=", 3.5, "sid", "name", "grade"),
+		"2":   gradeRule(">=", 3.5, "sid", "age", "grade"),
+		"1|2": gradeRule(">", 3.9, "sid", "name", "age", "grade"),
+	})
+
+	var cursorId string
+	if err := cli.CallWithError("Cluster.OpenCursor", SelectQuery{TableName: studentTableName,
+		Columns: []string{"sid"}}, &cursorId); err != nil {
+		t.Fatal(err)
+	}
+	// each student is returned once, by the first fragment holding it
+	sids := make(map[interface{}]int)
+	for _, row := range fetchAll(t, cursorId, 2).Rows {
+		sids[row[0]]++
+	}
+	for _, sid := range []int{0, 1, 2, 3, 4} {
+		if sids[sid] != 1 {
+			t.Errorf("Expected student %d to be returned once, actual %v", sid, sids)
+		}
+	}
+	if len(sids) != 5 {
+		t.Errorf("Expected 5 students, actual %v", sids)
+	}
+}
+
+func TestCursorIdleTimeout(t *testing.T) {
+	joinStrategySetup()
+	defer func() { c.cursorIdleTimeout = cursorIdleTimeout }()
+
+	var cursorId string
+	cli.Call("Cluster.OpenCursor", SelectQuery{TableName: studentTableName}, &cursorId)
+	batch := ScanBatch{}
+	cli.Call("Cluster.FetchCursor", CursorFetch{CursorId: cursorId, BatchSize: 10}, &batch)
+	if len(batch.Rows.Rows) != 10 || batch.Done {
+		t.Fatalf("Expected the first 10 rows, actual %v", batch)
+	}
+
+	// the abandoned cursor is closed when another cursor is opened, along with its scan
+	c.cursorsMu.Lock()
+	c.cursorIdleTimeout = 0
+	cur := c.cursors[cursorId]
+	c.cursorsMu.Unlock()
+	var otherCursorId string
+	cli.Call("Cluster.OpenCursor", SelectQuery{TableName: studentTableName}, &otherCursorId)
+	c.cursorsMu.Lock()
+	_, ok := c.cursors[cursorId]
+	c.cursorsMu.Unlock()
+	if ok || cur.isScanOpen {
+		t.Errorf("The idle cursor should be closed with its scan")
+	}
+	err := cli.CallWithError("Cluster.FetchCursor", CursorFetch{CursorId: cursorId}, &batch)
+	checkErrorCode(t, "Fetching an idle cursor", err, ErrInvalidArgument)
+}
+
+func TestNodeScanIdleTimeout(t *testing.T) {
+	n := NewNode("0")
+	if err := n.CreateTable(&TableSchema{TableName: "fragment0",
+		ColumnSchemas: []ColumnSchema{{Name: "sid", DataType: TypeInt32}}}); err != nil {
+		t.Fatal(err)
+	}
+	reply := ""
+	if err := n.OpenScan(NodeScan{ScanId: "abandoned", FragmentNames: []string{"fragment0"}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// the scan abandoned by its coordinator is closed when another scan is opened
+	n.scanIdleTimeout = 0
+	if err := n.OpenScan(NodeScan{ScanId: "live", FragmentNames: []string{"fragment0"}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	err := n.FetchScan(NodeScanFetch{ScanId: "abandoned", BatchSize: 1}, &ScanBatch{})
+	checkErrorCode(t, "Fetching an idle scan", err, ErrInvalidArgument)
+	if err := n.FetchScan(NodeScanFetch{ScanId: "live", BatchSize: 1}, &ScanBatch{}); err != nil {
+		t.Errorf("The scan opened last should be kept, actual %v", err)
+	}
+}
+
+func TestCloseCursor(t *testing.T) {
+	joinStrategySetup()
+
+	var cursorId string
+	cli.Call("Cluster.OpenCursor", SelectQuery{TableName: studentTableName}, &cursorId)
+	batch := ScanBatch{}
+	cli.Call("Cluster.FetchCursor", CursorFetch{CursorId: cursorId, BatchSize: 10}, &batch)
+	if len(batch.Rows.Rows) != 10 || batch.Done {
+		t.Fatalf("Expected the first 10 rows, actual %v", batch)
+	}
+
+	// the scan of the cursor is still open on the node
+	cur := c.cursors[cursorId]
+	nodeIdx := cur.nodeIdxs[cur.scanIdx]
+	nodeName := "Node" + strconv.Itoa(nodeIdx)
+	end := network.MakeEnd("cursorClient")
+	network.Connect("cursorClient", nodeName)
+	network.Enable("cursorClient", true)
+	scanId := getScanId(cursorId, nodeIdx)
+	nodeBatch := ScanBatch{}
+	end.Call("Node.FetchScan", NodeScanFetch{ScanId: scanId, BatchSize: 1}, &nodeBatch)
+	if len(nodeBatch.Rows.Rows) != 1 {
+		t.Fatalf("The scan on %s should be open", nodeName)
+	}
+
+	var reply string
+	cli.Call("Cluster.CloseCursor", cursorId, &reply)
+	nodeBatch = ScanBatch{}
+	end.Call("Node.FetchScan", NodeScanFetch{ScanId: scanId, BatchSize: 1}, &nodeBatch)
+	if len(nodeBatch.Rows.Rows) != 0 || nodeBatch.Done {
+		t.Errorf("The scan on %s should be closed with the cursor, actual %v", nodeName, nodeBatch)
+	}
+	batch = ScanBatch{}
+	cli.Call("Cluster.FetchCursor", CursorFetch{CursorId: cursorId}, &batch)
+	if len(batch.Rows.Rows) != 0 || batch.Done {
+		t.Errorf("A closed cursor should not be fetched, actual %v", batch)
+	}
+
+	// rows cannot be streamed in order
+	cursorId = ""
+	cli.Call("Cluster.OpenCursor", SelectQuery{TableName: studentTableName, OrderBy: []OrderBy{{Column: "sid"}}},
+		&cursorId)
+	if cursorId != "" {
+		t.Errorf("A cursor with ORDER BY should not be opened")
+	}
+}
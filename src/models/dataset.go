@@ -1,5 +1,10 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+)
+
 type Dataset struct {
 	Schema TableSchema
 	Rows   []Row
@@ -53,3 +58,113 @@ func (dataset *Dataset) ReconstructTable(
 		}
 	}
 }
+
+// NaturalJoin joins datasets by matching all common columns.
+// Datasets are passed as references to avoid expensive copying.
+// It does not depend on any cluster state, so both the coordinator and the nodes can use it.
+func NaturalJoin(datasetPtrs []*Dataset) (Dataset, error) {
+
+	datasetPtrsLen := len(datasetPtrs)
+
+	if datasetPtrsLen < 2 {
+		return Dataset{}, errors.New("number of datasetPtrs should be more than 2")
+	}
+
+	result := Dataset{}
+
+	// Joined tableName should be empty
+	result.Schema.TableName = ""
+	result.Schema = datasetPtrs[0].Schema
+	result.Rows = datasetPtrs[0].Rows
+
+	for datasetPtrIdx := 1; datasetPtrIdx < datasetPtrsLen; datasetPtrIdx++ {
+
+		dataset := *datasetPtrs[datasetPtrIdx]
+
+		// Map dataset -> result common column indexes
+		commonColsIdxMap := make(map[int]int)
+		var tempColSchemas []ColumnSchema
+		for datasetColIdx, datasetColSchema := range dataset.Schema.ColumnSchemas {
+			resultColIdx := result.Schema.GetColIndexByName(datasetColSchema.Name)
+			// If there is a common column, add it to commonColsIdx
+			if resultColIdx != -1 {
+				commonColsIdxMap[datasetColIdx] = resultColIdx
+			} else {
+				//	Else add the schema into tempColSchemas
+				tempColSchemas = append(tempColSchemas, datasetColSchema)
+			}
+		}
+
+		// If there are no common columns, clear result and short-circuit
+		if len(commonColsIdxMap) == 0 {
+			result = Dataset{}
+			fmt.Println("Natural Join(s) has(have) no common columns.")
+			return result, nil
+		}
+
+		// Add tempColSchemas to result
+		beforeJoinResultRowLen := len(result.Rows)
+		beforeJoinResultColLen := len(result.Schema.ColumnSchemas)
+		result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas, tempColSchemas...)
+
+		// Join dataset and result
+		hasJoinResult := false
+		for _, datasetRow := range dataset.Rows {
+			for resultRowIdx := 0; resultRowIdx < beforeJoinResultRowLen; resultRowIdx++ {
+
+				resultRow := result.Rows[resultRowIdx]
+
+				// Check conditions
+				matched := true
+				for datasetColIdx, resultColIdx := range commonColsIdxMap {
+					if resultRow[resultColIdx] != datasetRow[datasetColIdx] {
+						matched = false
+						break
+					}
+				}
+
+				if matched {
+					hasJoinResult = true
+					var appendRowPtr *Row = &result.Rows[resultRowIdx]
+
+					// If there are duplicate matches, copy and insert data
+					if len(*appendRowPtr) > beforeJoinResultColLen {
+						newRow := make(Row, beforeJoinResultColLen)
+						// Note: copy(dst, src) copies min(len(dst), len(src)) elements.
+						copy(newRow, result.Rows[resultRowIdx])
+						result.Rows = append(result.Rows, newRow)
+						// Set the appended row
+						appendRowPtr = &result.Rows[len(result.Rows)-1]
+					}
+
+					// Append non-common columns data
+					for datasetColIdx, datasetColVal := range datasetRow {
+						if _, ok := commonColsIdxMap[datasetColIdx]; !ok {
+							*appendRowPtr = append(*appendRowPtr, datasetColVal)
+						}
+					}
+				}
+			}
+		}
+
+		// If a dataset has no join result, clear result's rows and short-circuit
+		if !hasJoinResult {
+			result.Rows = nil
+			//fmt.Println("Natural Join(s) has(have) no matching results.")
+			return result, nil
+		}
+
+		// Remove unmatched row after join
+		validLastRowIdx := len(result.Rows) - 1
+		for idx := validLastRowIdx; idx >= 0; idx-- {
+			if len(result.Rows[idx]) == beforeJoinResultColLen {
+				result.Rows[idx] = result.Rows[validLastRowIdx]
+				result.Rows = result.Rows[:validLastRowIdx]
+				validLastRowIdx -= 1
+			}
+		}
+
+	}
+
+	return result, nil
+}
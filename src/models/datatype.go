@@ -12,6 +12,11 @@ const (
 
 func Compare(dataType int, operator string, valA interface{}, valB interface{}) bool {
 
+	// valB is a ValueSet for set membership
+	if operator == OpIn || operator == OpNotIn {
+		return valB.(ValueSet)[valA] == (operator == OpIn)
+	}
+
 	if operator == "==" {
 		return valA == valB
 	} else if operator == "!=" {
@@ -230,3 +235,51 @@ func Compare(dataType int, operator string, valA interface{}, valB interface{})
 
 	return false
 }
+
+// CompareValues orders two values of the given data type with the semantics of Compare, it returns -1 if valA is less
+// than valB, 1 if valA is greater than valB, and 0 otherwise. nil is less than any other value.
+func CompareValues(dataType int, valA interface{}, valB interface{}) int {
+	if valA == nil || valB == nil {
+		if valA == valB {
+			return 0
+		} else if valA == nil {
+			return -1
+		}
+		return 1
+	}
+
+	// false is less than true
+	if dataType == TypeBoolean {
+		boolA, boolB := valA.(bool), valB.(bool)
+		if boolA == boolB {
+			return 0
+		} else if !boolA {
+			return -1
+		}
+		return 1
+	}
+
+	if Compare(dataType, "<", valA, valB) {
+		return -1
+	} else if Compare(dataType, ">", valA, valB) {
+		return 1
+	}
+	return 0
+}
+
+// MatchesDataType returns true if a value can be held by a column of the given data type, so that it can be compared
+// by Compare. A numeric value of any Go type matches a numeric data type, as Compare converts it, and nil (NULL)
+// matches any data type.
+func MatchesDataType(dataType int, val interface{}) bool {
+	switch val.(type) {
+	case nil:
+		return true
+	case int, int32, int64, float32, float64:
+		return dataType == TypeInt32 || dataType == TypeInt64 || dataType == TypeFloat || dataType == TypeDouble
+	case bool:
+		return dataType == TypeBoolean
+	case string:
+		return dataType == TypeString
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RequestId identifies a write request of a client, so that a request retried after its reply is lost is applied only
+// once, see dedupTable. A client numbers its requests by Seq from 1 in ascending order, and sends a request after the
+// previous one gets its reply. The zero value does not identify a request, and such a request is applied whenever it
+// is received.
+type RequestId struct {
+	ClientId string
+	Seq      int64
+}
+
+// dedupRetention is how long a dedupTable keeps the last request of a client that sends no other request, so the table
+// holds the clients that sent requests within about twice the retention. A client should stop retrying a request well
+// before then, as a request retried after its client is evicted is applied again.
+const dedupRetention = 10 * time.Minute
+
+// dedupTable remembers the last request applied for each client and its reply, so that a retried request returns the
+// reply of the request instead of being applied again. The coordinator keeps one for the requests of the clients to
+// the cluster, and each node keeps one for the writes sent to the node directly (see Node.FragmentWrite).
+type dedupTable struct {
+	// clientId -> the last request of the client
+	clients map[string]*dedupEntry
+	// see dedupRetention, it is shortened by the tests
+	retention time.Duration
+	// when the idle clients were evicted last time, see evictIdleLocked
+	lastEvictedAt time.Time
+	mu            sync.Mutex
+}
+
+// dedupEntry is the last request applied for a client.
+type dedupEntry struct {
+	seq   int64
+	reply string
+	// when the client sent its last request, guarded by the mutex of the table
+	lastUsedAt time.Time
+	// held while a request of the client is applied, so that a retry received meanwhile waits for the original request
+	mu sync.Mutex
+}
+
+func newDedupTable() *dedupTable {
+	return &dedupTable{clients: make(map[string]*dedupEntry), retention: dedupRetention, lastEvictedAt: time.Now()}
+}
+
+// evictIdleLocked removes the clients that sent no request within the retention, it scans the clients at most once
+// per retention. The caller should hold table.mu.
+func (table *dedupTable) evictIdleLocked() {
+	if time.Since(table.lastEvictedAt) < table.retention {
+		return
+	}
+	table.lastEvictedAt = time.Now()
+	for clientId, entry := range table.clients {
+		if time.Since(entry.lastUsedAt) >= table.retention {
+			delete(table.clients, clientId)
+		}
+	}
+}
+
+// apply applies a request by calling write, unless the request is applied already, in which case the reply of the
+// applied request is returned. A request older than the last applied one of its client is rejected, as its reply is
+// no longer kept. A failed request is not remembered, so it is applied again if it is retried, and write should not
+// change anything if it fails.
+func (table *dedupTable) apply(requestId RequestId, write func() (string, error)) (string, error) {
+	if requestId.ClientId == "" {
+		return write()
+	}
+	table.mu.Lock()
+	table.evictIdleLocked()
+	entry, ok := table.clients[requestId.ClientId]
+	if !ok {
+		entry = &dedupEntry{}
+		table.clients[requestId.ClientId] = entry
+	}
+	entry.lastUsedAt = time.Now()
+	table.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if requestId.Seq == entry.seq {
+		return entry.reply, nil
+	}
+	if requestId.Seq < entry.seq {
+		return "", newError(ErrInvalidArgument, fmt.Sprintf("request %d of client %s is older than its applied "+
+			"request %d", requestId.Seq, requestId.ClientId, entry.seq))
+	}
+	reply, err := write()
+	if err != nil {
+		return "", err
+	}
+	entry.seq, entry.reply = requestId.Seq, reply
+	return reply, nil
+}
+
+// applyCount is apply for a write whose reply is the number of rows it changes.
+func (table *dedupTable) applyCount(requestId RequestId, write func() (int, error)) (int, error) {
+	reply, err := table.apply(requestId, func() (string, error) {
+		count, err := write()
+		return strconv.Itoa(count), err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(reply)
+}
+
+// splitRequestId returns the arguments of a write without the trailing RequestId, and the request identifier, which is
+// the zero value if there is none.
+func splitRequestId(params []interface{}) ([]interface{}, RequestId) {
+	if len(params) > 0 {
+		if requestId, ok := params[len(params)-1].(RequestId); ok {
+			return params[:len(params)-1], requestId
+		}
+	}
+	return params, RequestId{}
+}
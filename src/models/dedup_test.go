@@ -0,0 +1,187 @@
+package models
+
+import (
+	"../labrpc"
+	"context"
+	"testing"
+	"time"
+)
+
+// retriedCallTimeout is how long a test client waits for the reply of each attempt of a retried call.
+const retriedCallTimeout = 100 * time.Millisecond
+
+// callUntilReplied calls a method through an end until a reply is received, retrying the call if the request or the
+// reply is lost, or if a node is unavailable. It returns the error returned by the method.
+func callUntilReplied(t *testing.T, end *labrpc.ClientEnd, method string, args interface{}, reply interface{}) error {
+	for attempt := 0; attempt < 100; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), retriedCallTimeout)
+		ok, _, err := end.CallWithBytes(ctx, method, args, reply)
+		cancel()
+		if ok && ErrorCodeOf(err) != ErrUnavailable {
+			return err
+		}
+	}
+	t.Fatalf("%s got no reply after 100 attempts", method)
+	return nil
+}
+
+func TestDedupTable(t *testing.T) {
+	table := newDedupTable()
+	appliedCount := 0
+	write := func() (string, error) {
+		appliedCount++
+		return "applied", nil
+	}
+
+	for _, requestId := range []RequestId{{"a", 1}, {"a", 1}, {"b", 1}, {"a", 2}, {"a", 2}} {
+		if reply, err := table.apply(requestId, write); err != nil || reply != "applied" {
+			t.Errorf("Request %v: expected the reply of the applied request, actual %q, %v", requestId, reply, err)
+		}
+	}
+	if appliedCount != 3 {
+		t.Errorf("Expected 3 requests to be applied, actual %d", appliedCount)
+	}
+
+	_, err := table.apply(RequestId{"a", 1}, write)
+	checkErrorCode(t, "Applying an outdated request", err, ErrInvalidArgument)
+	table.apply(RequestId{}, write)
+	table.apply(RequestId{}, write)
+	if appliedCount != 5 {
+		t.Errorf("Expected the requests without identifiers to be applied, actual %d applied", appliedCount)
+	}
+
+	// a failed request is applied again when it is retried
+	failure := newError(ErrUnavailable, "unavailable")
+	table.apply(RequestId{"a", 3}, func() (string, error) { return "", failure })
+	table.apply(RequestId{"a", 3}, write)
+	if appliedCount != 6 {
+		t.Errorf("Expected the failed request to be applied again, actual %d applied", appliedCount)
+	}
+}
+
+func TestDedupTableEvictsIdleClients(t *testing.T) {
+	table := newDedupTable()
+	table.retention = 10 * time.Millisecond
+	appliedCount := 0
+	write := func() (string, error) {
+		appliedCount++
+		return "applied", nil
+	}
+
+	// the client that sends no request within the retention is evicted, so its request is applied again
+	table.apply(RequestId{"a", 1}, write)
+	time.Sleep(2 * table.retention)
+	table.apply(RequestId{"b", 1}, write)
+	table.mu.Lock()
+	clientCount := len(table.clients)
+	table.mu.Unlock()
+	if clientCount != 1 {
+		t.Errorf("Expected only the active client to be kept, actual %d clients", clientCount)
+	}
+	table.apply(RequestId{"a", 1}, write)
+	if appliedCount != 3 {
+		t.Errorf("Expected the request of the evicted client to be applied again, actual %d applied", appliedCount)
+	}
+}
+
+func TestIdempotentNodeWritesLongReordering(t *testing.T) {
+	setOperationSetup()
+	fragmentName := getStudentFragmentName("0|1")
+	nodeEnd := c.getNodeEnd(0)
+
+	network.Reliable(false)
+	network.LongReordering(true)
+	// the replies come late, so most of the writes and the deletes are retried while they are applied
+	seq := int64(0)
+	for rowIdx := 100; rowIdx < 105; rowIdx++ {
+		seq++
+		reply := ""
+		if err := callUntilReplied(t, nodeEnd, "Node.FragmentWrite", []interface{}{fragmentName,
+			Row{rowIdx, rowIdx, "Ann", 20, 3.0}, RequestId{ClientId: "ClientA", Seq: seq}}, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for rowIdx := 100; rowIdx < 102; rowIdx++ {
+		seq++
+		reply := ""
+		if err := callUntilReplied(t, nodeEnd, "Node.FragmentDelete", []interface{}{fragmentName, rowIdx,
+			RequestId{ClientId: "ClientA", Seq: seq}}, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	network.LongReordering(false)
+	network.Reliable(true)
+
+	sids := getFragmentSids(t, 0, fragmentName)
+	for _, sid := range []int{1, 3, 102, 103, 104} {
+		if !sids[sid] {
+			t.Errorf("Student %d should be in %s on node 0, actual %v", sid, fragmentName, sids)
+		}
+	}
+	if len(sids) != 5 {
+		t.Errorf("Expected 5 students in %s on node 0, actual %v", fragmentName, sids)
+	}
+}
+
+func TestIdempotentFragmentWriteUnreliable(t *testing.T) {
+	setOperationSetup()
+
+	network.Reliable(false)
+	expectedSids := []interface{}{0, 1, 2, 3, 4}
+	for i := 0; i < 20; i++ {
+		sid := 100 + i
+		reply := ""
+		if err := callUntilReplied(t, cli, "Cluster.FragmentWrite", []interface{}{studentTableName,
+			Row{sid, "Ann", 20, 3.0 + float64(i%2)}, RequestId{ClientId: "ClientA", Seq: int64(i + 1)}},
+			&reply); err != nil {
+			t.Fatal(err)
+		}
+		expectedSids = append(expectedSids, sid)
+	}
+	network.Reliable(true)
+
+	// every student is written once, although some writes are applied more than once by the network
+	c.resolveTxns()
+	checkSids(t, "After the retried writes", studentTableName, "", expectedSids...)
+}
+
+func TestIdempotentWritesLongReordering(t *testing.T) {
+	setOperationSetup()
+
+	network.Reliable(false)
+	network.LongReordering(true)
+	// the replies come late, so most of the writes and the delete are retried while they are applied
+	seq := int64(0)
+	for sid := 100; sid < 103; sid++ {
+		seq++
+		reply := ""
+		if err := callUntilReplied(t, cli, "Cluster.FragmentWrite", []interface{}{studentTableName,
+			Row{sid, "Ann", 20, 3.0 + float64(sid%2)}, RequestId{ClientId: "ClientA", Seq: seq}}, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	network.LongReordering(false)
+	network.Reliable(true)
+	// the commits whose replies are lost are resolved, so that the delete finds every written student
+	c.resolveTxns()
+	checkSids(t, "After the retried writes", studentTableName, "", 0, 1, 2, 3, 4, 100, 101, 102)
+
+	network.Reliable(false)
+	network.LongReordering(true)
+	seq++
+	deletedCount := 0
+	if err := callUntilReplied(t, cli, "Cluster.Delete", DeleteQuery{TableName: studentTableName,
+		Where:     map[string][]Condition{"sid": {{Op: ">=", Val: 101}}},
+		RequestId: RequestId{ClientId: "ClientA", Seq: seq}}, &deletedCount); err != nil {
+		t.Fatal(err)
+	}
+	network.LongReordering(false)
+	network.Reliable(true)
+
+	// a retried delete gets the reply of the applied one, instead of deleting no row
+	if deletedCount != 2 {
+		t.Errorf("Expected 2 students to be deleted, actual %d", deletedCount)
+	}
+	c.resolveTxns()
+	checkSids(t, "After the retried delete", studentTableName, "", 0, 1, 2, 3, 4, 100)
+}
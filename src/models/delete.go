@@ -0,0 +1,135 @@
+package models
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// DeleteQuery deletes the rows of a table that satisfy Where, like
+// DELETE FROM TableName WHERE Where
+type DeleteQuery struct {
+	TableName string
+	// Where[colName] -> [Condition1, Condition2, ...], see SatisfiesPredicate, every row is deleted if it is empty
+	Where map[string][]Condition
+	// identifies the delete, so that the rows are deleted only once if it is retried, see RequestId
+	RequestId RequestId
+}
+
+// Delete deletes the rows of a table that satisfy the predicate of the query, and sets reply as the number of deleted
+// rows. The row idxs of the rows are found on the fragments holding the columns of the predicate (see findRowIdxs),
+// then they are removed from every fragment and replica of the table atomically (see writeAtomically), so that no
+// fragment keeps a part of a deleted row. The rows are found as of a snapshot, so a row written meanwhile is not
+// deleted, and if a found row is changed by others before it is deleted, the delete is retried on a new snapshot (see
+// retryConflicts).
+func (c *Cluster) Delete(query DeleteQuery, reply *int) error {
+	schema, ok := c.getTableSchema(query.TableName)
+	if !ok {
+		return noSuchTableError(query.TableName)
+	}
+	for colName := range query.Where {
+		if schema.GetColIndexByName(colName) == -1 {
+			return missingColumnError(query.TableName, colName)
+		}
+	}
+
+	deletedCount, err := c.dedup.applyCount(query.RequestId, func() (int, error) {
+		return retryConflicts(func() (int, error) {
+			snapshot := c.takeSnapshot()
+			rowIdxs, err := c.findRowIdxs(query.TableName, query.Where, snapshot)
+			if err != nil {
+				return 0, err
+			}
+			if len(rowIdxs) > 0 {
+				if err := c.writeAtomically(c.routeDeletes(query.TableName, rowIdxs, snapshot.Ts)); err != nil {
+					return 0, err
+				}
+			}
+			return len(rowIdxs), nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	*reply = deletedCount
+	return nil
+}
+
+// findRowIdxs returns the row idxs of the rows of a table that satisfy the predicate as of the given snapshot. If the
+// rows can be found in the fragments holding all columns of the predicate (see planSortedScan), the nodes evaluate the
+// predicate on those fragments. Otherwise, the columns of the predicate are fetched from the fragments holding them,
+// and the predicate is evaluated at the coordinator.
+func (c *Cluster) findRowIdxs(tableName string, predicate map[string][]Condition, snapshot Snapshot) (ValueSet,
+	error) {
+	colNames := make([]string, 0, len(predicate))
+	for colName := range predicate {
+		colNames = append(colNames, colName)
+	}
+	sort.Strings(colNames)
+
+	rowIdxs := make(ValueSet)
+	if nodeRules, ok := c.planSortedScan(tableName, predicate, colNames); ok {
+		args := SortedScan{TableName: tableName, Where: predicate, Snapshot: snapshot}
+		err := c.readWithFailover(tableName, nodeRules, func(nodeIdxs []int, nodeRuleIdxsMap map[int][]int) error {
+			rowLists, err := c.scanSortedNodes(args, nodeIdxs, nodeRuleIdxsMap)
+			if err != nil {
+				return err
+			}
+			rowIdxs = make(ValueSet)
+			for _, rows := range rowLists {
+				for _, row := range rows {
+					rowIdxs[row[0]] = true
+				}
+			}
+			return nil
+		})
+		return rowIdxs, err
+	}
+
+	pkRowMap, projectedSchema, err := c.projectTable(tableName, colNames, nil, false, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	for pk, row := range pkRowMap {
+		if row.SatisfiesPredicate(projectedSchema, predicate) {
+			rowIdxs[pk] = true
+		}
+	}
+	return rowIdxs, nil
+}
+
+// maxConflictRetries is the number of times a write is retried when the rows it found are changed by others before
+// they are written, see retryConflicts.
+const maxConflictRetries = 20
+
+// retryConflicts calls write, which finds the rows to write by a new snapshot, again while it fails with ErrConflict,
+// at most maxConflictRetries times. The retries wait for a random short time, so that the writes of the same rows
+// that conflicted are not retried at the same time again.
+func retryConflicts(write func() (int, error)) (int, error) {
+	for attempt := 0; ; attempt++ {
+		count, err := write()
+		if ErrorCodeOf(err) != ErrConflict || attempt == maxConflictRetries {
+			return count, err
+		}
+		time.Sleep(time.Duration(rand.Intn(attempt+1)+1) * time.Millisecond)
+	}
+}
+
+// routeDeletes returns the deletes of the rows with the given row idxs from every fragment of a table, keyed by the
+// nodes holding the fragments. As a row may be held by any horizontal fragment, every fragment is asked to delete it,
+// and a fragment without the row skips the delete. The rows are found by the snapshot at readTs, so a delete is
+// rejected if its row has changed since then, or readTs is 0 if it is not checked (see FragmentRow).
+func (c *Cluster) routeDeletes(tableName string, rowIdxs ValueSet, readTs int64) map[int][]FragmentRow {
+	// nodeIdx -> deletes from the fragments on the node
+	nodeWrites := make(map[int][]FragmentRow)
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		fragmentName := getFragmentName(tableName, nodeRule.Rule.RuleIdx)
+		for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+			for rowIdx := range rowIdxs {
+				nodeWrites[nodeIdx] = append(nodeWrites[nodeIdx],
+					FragmentRow{FragmentName: fragmentName, Row: Row{rowIdx}, IsDelete: true, ReadTs: readTs})
+			}
+		}
+	}
+	return nodeWrites
+}
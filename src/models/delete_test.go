@@ -0,0 +1,152 @@
+package models
+
+import (
+	"testing"
+)
+
+// deleteRows deletes the rows of a table satisfying the predicate, and returns the number of deleted rows.
+func deleteRows(t *testing.T, query DeleteQuery) int {
+	deletedCount := 0
+	if err := cli.CallWithError("Cluster.Delete", query, &deletedCount); err != nil {
+		t.Fatal(err)
+	}
+	return deletedCount
+}
+
+// checkStudentRowIdxs checks that every replica of every fragment of student only holds the rows with the given row
+// idxs.
+func checkStudentRowIdxs(t *testing.T, name string, expectedRowIdxs ...int) {
+	isExpected := make(ValueSet)
+	for _, rowIdx := range expectedRowIdxs {
+		isExpected[rowIdx] = true
+	}
+	for _, nodeRule := range c.TableNodeRulesMap[studentTableName] {
+		fragmentName := getFragmentName(studentTableName, nodeRule.Rule.RuleIdx)
+		for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+			for rowIdx := range getFragmentRowIdxs(t, nodeIdx, fragmentName) {
+				if !isExpected[rowIdx] {
+					t.Errorf("%s: row %v should be deleted from %s on node %d", name, rowIdx, fragmentName, nodeIdx)
+				}
+			}
+		}
+	}
+}
+
+func TestDeleteFromHorizontalFragments(t *testing.T) {
+	setOperationSetup()
+
+	if deletedCount := deleteRows(t, DeleteQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"grade": {{Op: ">", Val: 3.6}}}}); deletedCount != 3 {
+		t.Errorf("Expected 3 students to be deleted, actual %d", deletedCount)
+	}
+	checkSids(t, "After deleting the students with high grades", studentTableName, "", 1, 3)
+	checkStudentRowIdxs(t, "After deleting the students with high grades", 1, 3)
+
+	if deletedCount := deleteRows(t, DeleteQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"grade": {{Op: ">", Val: 3.6}}}}); deletedCount != 0 {
+		t.Errorf("Expected no student to be deleted again, actual %d", deletedCount)
+	}
+	checkSids(t, "After deleting no student", studentTableName, "", 1, 3)
+}
+
+func TestDeleteFromVerticalFragments(t *testing.T) {
+	// the students with low grades are split into two vertical fragments
+	aggregateSetup(map[string]interface{}{
+		"0|1": gradeRule("<=", 3.6, "sid", "name"),
+		"1|2": gradeRule("<=", 3.6, "sid", "age", "grade"),
+		"3|4": gradeRule(">", 3.6, "sid", "name", "age", "grade"),
+	})
+
+	// the fragments holding age find the students
+	if deletedCount := deleteRows(t, DeleteQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"age": {{Op: "==", Val: 21}}}}); deletedCount != 2 {
+		t.Errorf("Expected 2 students to be deleted, actual %d", deletedCount)
+	}
+	checkSids(t, "After deleting the students of age 21", studentTableName, "", 0, 1, 4)
+	checkStudentRowIdxs(t, "After deleting the students of age 21", 0, 1, 4)
+
+	// name and age of the students with low grades are not held by the same fragment, so they are joined at the
+	// coordinator
+	where := map[string][]Condition{"name": {{Op: "!=", Val: "Tom"}}, "age": {{Op: "==", Val: 23}}}
+	if _, ok := c.planSortedScan(studentTableName, where, []string{"age", "name"}); ok {
+		t.Errorf("The predicate should be evaluated at the coordinator")
+	}
+	if deletedCount := deleteRows(t, DeleteQuery{TableName: studentTableName, Where: where}); deletedCount != 1 {
+		t.Errorf("Expected 1 student to be deleted, actual %d", deletedCount)
+	}
+	checkSids(t, "After deleting Smith", studentTableName, "", 0, 4)
+	checkStudentRowIdxs(t, "After deleting Smith", 0, 4)
+
+	if deletedCount := deleteRows(t, DeleteQuery{TableName: studentTableName}); deletedCount != 2 {
+		t.Errorf("Expected 2 students to be deleted, actual %d", deletedCount)
+	}
+	checkSids(t, "After deleting every student", studentTableName, "")
+	checkStudentRowIdxs(t, "After deleting every student")
+}
+
+func TestDeleteChangedRow(t *testing.T) {
+	setOperationSetup()
+	fragmentName := getStudentFragmentName("0|1")
+	node := c.getNodeEnd(0)
+
+	// the student is replaced after it is found by the snapshot, so the delete by the snapshot is rejected
+	snapshot := c.takeSnapshot()
+	updatedCount := 0
+	if err := cli.CallWithError("Cluster.Update", UpdateQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"sid": {{Op: "==", Val: 1}}}, Set: map[string]interface{}{"age": 30}},
+		&updatedCount); err != nil {
+		t.Fatal(err)
+	}
+	reply := ""
+	err := node.CallWithError("Node.Prepare", TxnPrepare{TxnId: "t1", Writes: []FragmentRow{
+		{FragmentName: fragmentName, Row: Row{1}, IsDelete: true, ReadTs: snapshot.Ts}}}, &reply)
+	checkErrorCode(t, "Deleting a row changed since the snapshot", err, ErrConflict)
+
+	// the delete by a new snapshot is prepared
+	if err := node.CallWithError("Node.Prepare", TxnPrepare{TxnId: "t2", Writes: []FragmentRow{
+		{FragmentName: fragmentName, Row: Row{1}, IsDelete: true, ReadTs: c.takeSnapshot().Ts}}},
+		&reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.CallWithError("Node.Abort", "t2", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if deletedCount := deleteRows(t, DeleteQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"sid": {{Op: "==", Val: 1}}}}); deletedCount != 1 {
+		t.Errorf("Expected 1 student to be deleted, actual %d", deletedCount)
+	}
+	checkStudentRowIdxs(t, "After deleting the updated student", 0, 2, 3, 4)
+}
+
+func TestDeleteRetried(t *testing.T) {
+	setOperationSetup()
+
+	query := DeleteQuery{TableName: studentTableName, Where: map[string][]Condition{"grade": {{Op: ">", Val: 3.6}}},
+		RequestId: RequestId{ClientId: "ClientA", Seq: 1}}
+	if deletedCount := deleteRows(t, query); deletedCount != 3 {
+		t.Errorf("Expected 3 students to be deleted, actual %d", deletedCount)
+	}
+	reply := ""
+	if err := cli.CallWithError("Cluster.FragmentWrite", []interface{}{studentTableName, Row{5, "Ann", 20, 3.9}},
+		&reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// the retried delete gets the reply of the applied one, and the student written meanwhile is kept
+	if deletedCount := deleteRows(t, query); deletedCount != 3 {
+		t.Errorf("Expected the retried delete to reply 3 deleted students, actual %d", deletedCount)
+	}
+	checkSids(t, "After the retried delete", studentTableName, "", 1, 3, 5)
+}
+
+func TestDeleteErrors(t *testing.T) {
+	setOperationSetup()
+
+	deletedCount := 0
+	err := cli.CallWithError("Cluster.Delete", DeleteQuery{TableName: "teacher"}, &deletedCount)
+	checkErrorCode(t, "Deleting from a missing table", err, ErrNoSuchTable)
+	err = cli.CallWithError("Cluster.Delete", DeleteQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"height": {{Op: ">", Val: 180}}}}, &deletedCount)
+	checkErrorCode(t, "Deleting by a missing column", err, ErrSchemaMismatch)
+	checkSids(t, "After the failed deletes", studentTableName, "", 0, 1, 2, 3, 4)
+}
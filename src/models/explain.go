@@ -0,0 +1,275 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ExplainQuery is the argument of Explain.
+type ExplainQuery struct {
+	// the explained operation, one of JoinStrategyJoin and JoinStrategySemiJoin
+	Method string
+	// parameters of the operation, same as the ones of Cluster.Join and Cluster.SemiJoin respectively
+	Params []string
+	// execute the plan and report the actual rows, bytes and latency of each step (EXPLAIN ANALYZE)
+	Analyze bool
+}
+
+// planEstimator estimates the rows and bytes of plan steps from the statistics of the fragments. The statistics are
+// cached, so that each fragment is only asked once while planning.
+type planEstimator struct {
+	c *Cluster
+	// tableStatsMap[tableName] -> statistics of the table
+	tableStatsMap map[string]TableStatistics
+	// fragmentStatsMap[fragmentName] -> statistics of the fragment
+	fragmentStatsMap map[string]FragmentStatistics
+	// the first error collecting the statistics, the estimates are not reliable if it is set
+	err error
+}
+
+// newPlanEstimator creates an estimator of the plans of the cluster.
+func (c *Cluster) newPlanEstimator() *planEstimator {
+	return &planEstimator{
+		c:                c,
+		tableStatsMap:    make(map[string]TableStatistics),
+		fragmentStatsMap: make(map[string]FragmentStatistics),
+	}
+}
+
+// getTableStatistics returns the statistics of a table.
+func (e *planEstimator) getTableStatistics(tableName string) TableStatistics {
+	stats, ok := e.tableStatsMap[tableName]
+	if !ok {
+		var err error
+		if stats, err = e.c.getTableStatistics(tableName); err != nil && e.err == nil {
+			e.err = err
+		}
+		e.tableStatsMap[tableName] = stats
+	}
+	return stats
+}
+
+// getFragmentStatistics returns the statistics of a fragment, asking the given node holding a replica of it.
+// Statistics of fragments are converted to TableStatistics so that they can be combined in the same way.
+func (e *planEstimator) getFragmentStatistics(nodeIdx int, fragmentName string) TableStatistics {
+	fragmentStats, ok := e.fragmentStatsMap[fragmentName]
+	if !ok {
+		if _, err := e.c.callNode(nodeIdx, "Node.GetFragmentStatistics", fragmentName, &fragmentStats); err != nil &&
+			e.err == nil {
+			e.err = err
+		}
+		e.fragmentStatsMap[fragmentName] = fragmentStats
+	}
+
+	stats := TableStatistics{
+		RowCount:       fragmentStats.RowCount,
+		DistinctCounts: fragmentStats.DistinctCounts,
+		ColumnWidths:   make(map[string]float64),
+		FragmentCount:  1,
+	}
+	for colName, colBytes := range fragmentStats.ColumnBytes {
+		if fragmentStats.RowCount > 0 {
+			stats.ColumnWidths[colName] = float64(colBytes) / float64(fragmentStats.RowCount)
+		}
+	}
+	return stats
+}
+
+// estimateFragmentScan estimates merging fragments on a node. Fragments on the same node may hold different columns
+// of the same rows, so the number of rows is the one of the largest fragment.
+func (e *planEstimator) estimateFragmentScan(node *PlanNode) {
+	for _, fragmentName := range node.Fragments {
+		stats := e.getFragmentStatistics(node.NodeIdx, fragmentName)
+		if int64(stats.RowCount) > node.EstimatedRows {
+			node.EstimatedRows = int64(stats.RowCount)
+		}
+		node.EstimatedBytes += int64(float64(stats.RowCount) * stats.RowWidth())
+	}
+}
+
+// estimateJoinedRows estimates the number of rows in the natural join of tables with the given schemas and statistics,
+// assuming uniformly distributed join values.
+func estimateJoinedRows(schemas []TableSchema, statsList []TableStatistics) float64 {
+	if len(statsList) == 0 {
+		return 0
+	}
+	rowCount := float64(statsList[0].RowCount)
+	for i := 1; i < len(schemas); i++ {
+		commonColNames := make([]string, 0)
+		for j := 0; j < i; j++ {
+			for _, colName := range getCommonColumns(schemas[j], schemas[i]) {
+				commonColNames = appendIfAbsent(commonColNames, colName)
+			}
+		}
+		// same as NaturalJoin, the result is empty if a table has no common columns with the previous ones
+		if len(commonColNames) == 0 {
+			return 0
+		}
+
+		distinctCount := statsList[i].KeyDistinctCount(commonColNames)
+		for j := 0; j < i; j++ {
+			distinctCount = math.Max(distinctCount, statsList[j].KeyDistinctCount(commonColNames))
+		}
+		rowCount = rowCount * float64(statsList[i].RowCount) / math.Max(1, distinctCount)
+	}
+	return rowCount
+}
+
+// estimateJoinedRowCount estimates the number of rows in the natural join of the given tables.
+func (e *planEstimator) estimateJoinedRowCount(tableNames []string) float64 {
+	schemas := make([]TableSchema, len(tableNames))
+	statsList := make([]TableStatistics, len(tableNames))
+	for i, tableName := range tableNames {
+		schemas[i], _ = e.c.getTableSchema(tableName)
+		statsList[i] = e.getTableStatistics(tableName)
+	}
+	return estimateJoinedRows(schemas, statsList)
+}
+
+// estimateNodeJoin estimates joining the fragments of the given tables on a node. Each joined row is sent with the row
+// idx of every table.
+func (e *planEstimator) estimateNodeJoin(node *PlanNode, tableNames []string) {
+	schemas := make([]TableSchema, len(tableNames))
+	statsList := make([]TableStatistics, len(tableNames))
+	rowWidth := 0.0
+	for i, tableName := range tableNames {
+		schemas[i], _ = e.c.getTableSchema(tableName)
+		statsList[i] = e.getFragmentStatistics(node.NodeIdx, node.Fragments[i])
+		rowWidth += statsList[i].RowWidth()
+	}
+	rowCount := estimateJoinedRows(schemas, statsList)
+	node.EstimatedRows = int64(rowCount)
+	node.EstimatedBytes = int64(rowCount * rowWidth)
+}
+
+// estimateSelectivity estimates the ratio of rows of table1 remaining after reducing it by table2 on the given column.
+func (e *planEstimator) estimateSelectivity(table1Name string, table2Name string, onJoinColName string) float64 {
+	stats1 := e.getTableStatistics(table1Name)
+	stats2 := e.getTableStatistics(table2Name)
+	if stats1.DistinctCounts[onJoinColName] == 0 {
+		return 1
+	}
+	return math.Min(1, float64(stats2.DistinctCounts[onJoinColName])/float64(stats1.DistinctCounts[onJoinColName]))
+}
+
+// estimateReduceTableByColumn estimates filtering the fragments of table1 by the join values of table2. The fragments
+// holding the column receive the join values, and the others receive the row idx of the remaining rows.
+func (e *planEstimator) estimateReduceTableByColumn(steps []*planStep, table1Name string, table2Name string,
+	onJoinColName string) {
+	selectivity := e.estimateSelectivity(table1Name, table2Name, onJoinColName)
+	stats1 := e.getTableStatistics(table1Name)
+	stats2 := e.getTableStatistics(table2Name)
+	for _, step := range steps {
+		node := step.node
+		stats := e.getFragmentStatistics(node.NodeIdx, node.Fragments[0])
+		rowCount := selectivity * float64(stats.RowCount)
+		var requestBytes float64
+		if node.Operator == PlanFilterByColumn {
+			requestBytes = float64(stats2.DistinctCounts[onJoinColName]) * stats2.ColumnWidths[onJoinColName]
+		} else {
+			requestBytes = selectivity * float64(stats1.RowCount) * rowIdxWidth
+		}
+		node.EstimatedRows = int64(rowCount)
+		node.EstimatedBytes = int64(requestBytes + rowCount*stats.RowWidth())
+	}
+}
+
+// getPrunedFragments returns the replicas of the fragments of the given tables that are not read by the plan.
+func (c *Cluster) getPrunedFragments(tableNames []string, plan *PlanNode) []string {
+	readReplicaSet := make(map[string]bool)
+	var visit func(node *PlanNode)
+	visit = func(node *PlanNode) {
+		for _, fragmentName := range node.Fragments {
+			readReplicaSet[fmt.Sprintf("%s@%d", fragmentName, node.NodeIdx)] = true
+		}
+		for _, child := range node.Children {
+			visit(child)
+		}
+	}
+	visit(plan)
+
+	prunedFragments := make([]string, 0)
+	for _, tableName := range tableNames {
+		for _, nodeRule := range c.getNodeRules(tableName) {
+			for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+				replica := fmt.Sprintf("%s@%d", getFragmentName(tableName, nodeRule.Rule.RuleIdx), nodeIdx)
+				if !readReplicaSet[replica] {
+					prunedFragments = append(prunedFragments, replica)
+				}
+			}
+		}
+	}
+	return prunedFragments
+}
+
+// Explain returns the physical plan of a Join or a SemiJoin: the nodes and fragments each step reads, the RPCs issued
+// to the nodes, the join algorithm, and the estimated rows and bytes of each step. With query.Analyze, the plan is
+// executed and the actual rows, bytes and latency of each step are reported as well.
+// Set reply as the root step of the plan.
+func (c *Cluster) Explain(query ExplainQuery, reply *PlanNode) error {
+	estimator := c.newPlanEstimator()
+
+	var plan *planStep
+	var tableNames []string
+	var err error
+	switch query.Method {
+	case JoinStrategyJoin:
+		tableNames = query.Params
+		plan, err = c.planJoin(tableNames, estimator)
+	case JoinStrategySemiJoin:
+		if len(query.Params) < 3 {
+			err = newError(ErrInvalidArgument, "SemiJoin needs the column name and the names of two tables")
+			break
+		}
+		tableNames = query.Params[1:3]
+		plan, err = c.planSemiJoin(query.Params[0], query.Params[1], query.Params[2], estimator)
+	default:
+		err = newError(ErrInvalidArgument, "unknown method to explain: "+query.Method)
+	}
+	if err == nil {
+		err = estimator.err
+	}
+	if err != nil {
+		return err
+	}
+	plan.node.PrunedFragments = c.getPrunedFragments(tableNames, plan.node)
+
+	if query.Analyze {
+		if _, err := c.executePlanAtSnapshot(plan); err != nil {
+			return err
+		}
+	}
+	*reply = *plan.node
+	return nil
+}
+
+// String formats the plan as an indented tree, one step per line.
+func (plan *PlanNode) String() string {
+	var builder strings.Builder
+	plan.writeTo(&builder, 0)
+	return builder.String()
+}
+
+// writeTo writes the step and its children at the given depth.
+func (plan *PlanNode) writeTo(builder *strings.Builder, depth int) {
+	builder.WriteString(strings.Repeat("  ", depth))
+	builder.WriteString(plan.Operator + " " + plan.Description)
+	if plan.NodeIdx == coordinatorNodeIdx {
+		builder.WriteString(" on coordinator")
+	} else {
+		builder.WriteString(fmt.Sprintf(" on node %d: %s %v", plan.NodeIdx, plan.Method, plan.Fragments))
+	}
+	builder.WriteString(fmt.Sprintf(" (estimated rows=%d bytes=%d)", plan.EstimatedRows, plan.EstimatedBytes))
+	if plan.Analyzed {
+		builder.WriteString(fmt.Sprintf(" (actual rows=%d bytes=%d time=%v)", plan.ActualRows, plan.ActualBytes,
+			plan.Latency))
+	}
+	if len(plan.PrunedFragments) > 0 {
+		builder.WriteString(fmt.Sprintf(" pruned %v", plan.PrunedFragments))
+	}
+	builder.WriteString("\n")
+	for _, child := range plan.Children {
+		child.writeTo(builder, depth+1)
+	}
+}
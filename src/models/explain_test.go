@@ -0,0 +1,99 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplainSemiJoin(t *testing.T) {
+	joinStrategySetup()
+
+	plan := PlanNode{}
+	cli.Call("Cluster.Explain", ExplainQuery{
+		Method: JoinStrategySemiJoin,
+		Params: []string{"sid", studentTableName, courseRegistrationTableName},
+	}, &plan)
+
+	if plan.Operator != PlanSemiJoin || plan.Analyzed {
+		t.Fatalf("Expected an unexecuted SemiJoin, actual plan:\n%v", plan.String())
+	}
+	// courseRegistration is fetched from node 3, then both fragments of student are filtered by the join values
+	if len(plan.Children) != 3 || plan.Children[0].Operator != PlanTableScan ||
+		len(plan.Children[0].Children) != 1 || plan.Children[0].Children[0].NodeIdx != 3 {
+		t.Fatalf("Incorrect plan:\n%v", plan.String())
+	}
+	for _, child := range plan.Children[1:] {
+		if child.Operator != PlanFilterByColumn || child.Method != "Node.FilterTableWithColumnValues" ||
+			len(child.Fragments) != 1 {
+			t.Errorf("Incorrect filter step:\n%v", plan.String())
+		}
+	}
+	// one of the two replicas of the first student fragment is not read
+	if len(plan.PrunedFragments) != 1 || !strings.HasPrefix(plan.PrunedFragments[0], studentTableName) {
+		t.Errorf("Expected a pruned replica of student, actual %v", plan.PrunedFragments)
+	}
+	if plan.EstimatedRows <= 0 || plan.EstimatedBytes <= 0 {
+		t.Errorf("The rows and bytes should be estimated, actual plan:\n%v", plan.String())
+	}
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	joinStrategySetup()
+
+	expectedDataset := Dataset{}
+	cli.Call("Cluster.SemiJoin", []string{"sid", studentTableName, courseRegistrationTableName}, &expectedDataset)
+
+	plan := PlanNode{}
+	cli.Call("Cluster.Explain", ExplainQuery{
+		Method:  JoinStrategySemiJoin,
+		Params:  []string{"sid", studentTableName, courseRegistrationTableName},
+		Analyze: true,
+	}, &plan)
+
+	if !plan.Analyzed || plan.ActualRows != len(expectedDataset.Rows) {
+		t.Fatalf("Expected %d rows, actual plan:\n%v", len(expectedDataset.Rows), plan.String())
+	}
+	var childrenBytes int64
+	for _, child := range plan.Children {
+		if !child.Analyzed || child.ActualBytes <= 0 {
+			t.Errorf("Every step should be executed, actual plan:\n%v", plan.String())
+		}
+		childrenBytes += child.ActualBytes
+	}
+	if plan.ActualBytes < childrenBytes {
+		t.Errorf("The bytes of a step should include its children, actual plan:\n%v", plan.String())
+	}
+}
+
+func TestExplainJoin(t *testing.T) {
+	joinStrategySetup()
+
+	plan := PlanNode{}
+	cli.Call("Cluster.Explain", ExplainQuery{
+		Method:  JoinStrategyJoin,
+		Params:  []string{studentTableName, courseRegistrationTableName},
+		Analyze: true,
+	}, &plan)
+
+	expectedDataset := Dataset{}
+	cli.Call("Cluster.Join", []string{studentTableName, courseRegistrationTableName}, &expectedDataset)
+
+	// the tables are not co-located, so both of them are fetched and joined at the coordinator
+	if plan.Operator != PlanNaturalJoin || len(plan.Children) != 2 || plan.NodeIdx != coordinatorNodeIdx {
+		t.Fatalf("Incorrect plan:\n%v", plan.String())
+	}
+	for _, child := range plan.Children {
+		if child.Operator != PlanTableScan || child.EstimatedRows <= 0 {
+			t.Errorf("Incorrect table scan:\n%v", plan.String())
+		}
+		for _, leaf := range child.Children {
+			if leaf.Operator != PlanFragmentScan || leaf.Method != "Node.GetMergedTableDataset" {
+				t.Errorf("Incorrect fragment scan:\n%v", plan.String())
+			}
+		}
+	}
+	if plan.ActualRows != len(expectedDataset.Rows) || plan.Children[0].ActualRows != 100 {
+		t.Errorf("Expected %d joined rows from 100 students, actual plan:\n%v", len(expectedDataset.Rows),
+			plan.String())
+	}
+}
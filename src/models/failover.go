@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getLiveNodeRules returns the node rules of a table without the given unavailable nodes. It returns an error if all
+// nodes holding the fragment of some rule are unavailable, as the rows of the fragment cannot be read then.
+func getLiveNodeRules(tableName string, nodeRules []NodeRule, unavailableNodes map[int]bool) ([]NodeRule, error) {
+	liveNodeRules := make([]NodeRule, 0, len(nodeRules))
+	for _, nodeRule := range nodeRules {
+		liveNodeIdxStrs := make([]string, 0)
+		for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+			if !unavailableNodes[nodeIdx] {
+				liveNodeIdxStrs = append(liveNodeIdxStrs, strconv.Itoa(nodeIdx))
+			}
+		}
+		if len(liveNodeIdxStrs) == 0 {
+			return nil, newError(ErrUnavailable, "fragment "+getFragmentName(tableName, nodeRule.Rule.RuleIdx)+
+				" has no live replica, all of its nodes "+nodeRule.NodeIndices+" are unavailable")
+		}
+		nodeRule.NodeIndices = strings.Join(liveNodeIdxStrs, "|")
+		liveNodeRules = append(liveNodeRules, nodeRule)
+	}
+	return liveNodeRules, nil
+}
+
+// coverLiveNodes chooses the nodes to read the fragments of the given node rules by SetCover, skipping the
+// unavailable nodes (see getLiveNodeRules). It returns the chosen node indices in ascending order, and the rule
+// indices of the fragments to read on each of them.
+func coverLiveNodes(tableName string, nodeRules []NodeRule, unavailableNodes map[int]bool) ([]int, map[int][]int,
+	error) {
+	liveNodeRules, err := getLiveNodeRules(tableName, nodeRules, unavailableNodes)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodeIdxs := make([]int, 0)
+	nodeRuleIdxsMap := make(map[int][]int)
+	if len(liveNodeRules) == 0 {
+		return nodeIdxs, nodeRuleIdxsMap, nil
+	}
+	for nodeIdxStr, ruleIdxs := range SetCover(liveNodeRules) {
+		nodeIdx, _ := strconv.Atoi(nodeIdxStr)
+		nodeIdxs = append(nodeIdxs, nodeIdx)
+		nodeRuleIdxsMap[nodeIdx] = ruleIdxs
+	}
+	sort.Ints(nodeIdxs)
+	return nodeIdxs, nodeRuleIdxsMap, nil
+}
+
+// readWithFailover reads the fragments of the given node rules of a table by calling read with the nodes chosen by
+// coverLiveNodes. If read fails as a node is unavailable (see NodeUnavailableError), the node is excluded and the
+// fragments are read again from a new cover, until every fragment is read or some fragment has no live replica.
+// Partial results of a failed read should be discarded by read itself.
+func (c *Cluster) readWithFailover(tableName string, nodeRules []NodeRule,
+	read func(nodeIdxs []int, nodeRuleIdxsMap map[int][]int) error) error {
+	unavailableNodes := make(map[int]bool)
+	for {
+		nodeIdxs, nodeRuleIdxsMap, err := coverLiveNodes(tableName, nodeRules, unavailableNodes)
+		if err != nil {
+			return err
+		}
+		err = read(nodeIdxs, nodeRuleIdxsMap)
+		var unavailableErr *NodeUnavailableError
+		// a node that was already excluded is not in the cover, so the read failed on another call
+		if !errors.As(err, &unavailableErr) || unavailableNodes[unavailableErr.NodeIdx] {
+			return err
+		}
+		unavailableNodes[unavailableErr.NodeIdx] = true
+	}
+}
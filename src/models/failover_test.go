@@ -0,0 +1,62 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFailoverReads(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+
+	expectedDataset := Dataset{Schema: *studentTableSchema, Rows: studentRows}
+	expectedSemiJoin := Dataset{}
+	cli.Call("Cluster.SemiJoin", []string{"sid", studentTableName, courseRegistrationTableName}, &expectedSemiJoin)
+
+	// node 1 holds a replica of both fragments of student, so it is chosen by SetCover
+	network.DeleteServer("Node1")
+
+	dataset := Dataset{}
+	if err := c.GetFullTableDataset(studentTableName, &dataset); err != nil {
+		t.Fatal(err)
+	}
+	if !compareDataset(dataset, expectedDataset) {
+		t.Errorf("Incorrect student table without node 1, expected %v, actual %v", expectedDataset, dataset)
+	}
+
+	semiJoin := Dataset{}
+	cli.Call("Cluster.SemiJoin", []string{"sid", studentTableName, courseRegistrationTableName}, &semiJoin)
+	if !compareDataset(semiJoin, expectedSemiJoin) {
+		t.Errorf("Incorrect semi-join results without node 1, expected %v, actual %v", expectedSemiJoin, semiJoin)
+	}
+
+	results := Dataset{}
+	cli.Call("Cluster.Select", SelectQuery{TableName: studentTableName, Columns: []string{"name"},
+		OrderBy: []OrderBy{{Column: "sid"}}}, &results)
+	if !compareOrderedDataset(results, singleColumnDataset("name", TypeString,
+		Row{"John"}, Row{"Smith"}, Row{"Hana"}, Row{"Lewis"}, Row{"Tom"})) {
+		t.Errorf("Incorrect names without node 1: %v", results)
+	}
+
+	results = Dataset{}
+	cli.Call("Cluster.Aggregate", AggregateQuery{TableName: studentTableName,
+		Aggregates: []Aggregate{{Func: AggregateCount, Column: "*"}}}, &results)
+	if len(results.Rows) != 1 || results.Rows[0][0] != int64(len(studentRows)) {
+		t.Errorf("Expected %d students without node 1, actual %v", len(studentRows), results)
+	}
+}
+
+func TestFailoverNoLiveReplica(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+
+	// both replicas of the first fragment of student are lost
+	network.DeleteServer("Node0")
+	network.DeleteServer("Node1")
+
+	dataset := Dataset{}
+	err := c.GetFullTableDataset(studentTableName, &dataset)
+	if err == nil || !strings.Contains(err.Error(), "no live replica") {
+		t.Errorf("Expected an error as a fragment has no live replica, actual %v with %v", err, dataset)
+	}
+}
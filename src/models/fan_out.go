@@ -0,0 +1,84 @@
+package models
+
+import "sync"
+
+// maxParallelCalls is the maximum number of node RPCs a coordinator request issues at the same time.
+const maxParallelCalls = 8
+
+// fanOut calls call(0), call(1), ..., call(n-1) concurrently, with at most maxParallelCalls of them running at a time,
+// and returns after all of them return. The calls should not share unguarded state, so each of them usually writes
+// its own slot of a result slice, which is merged by the caller afterwards.
+func fanOut(n int, call func(i int)) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxParallelCalls)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			call(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// executePlanSteps executes the given steps concurrently (see fanOut) and returns their results in the same order as
+// the steps, or the error of the first failed step.
+func (c *Cluster) executePlanSteps(steps []*planStep) ([]Dataset, error) {
+	results := make([]Dataset, len(steps))
+	errs := make([]error, len(steps))
+	fanOut(len(steps), func(i int) {
+		results[i], errs[i] = c.executePlan(steps[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// nodeCall is an RPC to a node whose reply is a message.
+type nodeCall struct {
+	nodeIdx int
+	method  string
+	args    interface{}
+	// an error with this code is taken as a success, as it is returned when a retried call finds its work done
+	doneCode ErrorCode
+}
+
+// callNodes issues the given calls with the given retry policy and returns after all of them return. Different nodes
+// are called concurrently (see fanOut), while the calls to the same node are issued one after another in the given
+// order, so that a node does not modify its tables concurrently. It returns the error of the first node that failed,
+// the calls after the failed one on the same node are not issued.
+func (c *Cluster) callNodes(calls []nodeCall, policy RetryPolicy) error {
+	nodeCallsMap := make(map[int][]nodeCall)
+	nodeIdxs := make([]int, 0)
+	for _, call := range calls {
+		if _, ok := nodeCallsMap[call.nodeIdx]; !ok {
+			nodeIdxs = append(nodeIdxs, call.nodeIdx)
+		}
+		nodeCallsMap[call.nodeIdx] = append(nodeCallsMap[call.nodeIdx], call)
+	}
+
+	errs := make([]error, len(nodeIdxs))
+	fanOut(len(nodeIdxs), func(i int) {
+		for _, call := range nodeCallsMap[nodeIdxs[i]] {
+			reply := ""
+			_, errs[i] = c.callNodeWithPolicy(policy, call.nodeIdx, call.method, call.args, &reply)
+			if call.doneCode != "" && ErrorCodeOf(errs[i]) == call.doneCode {
+				errs[i] = nil
+			}
+			if errs[i] != nil {
+				return
+			}
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
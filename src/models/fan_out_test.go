@@ -0,0 +1,154 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// delays of the nodes holding the fragments of student, the calls take the sum of them if the nodes are called
+// sequentially
+var fanOutNodeDelays = []time.Duration{30 * time.Millisecond, 60 * time.Millisecond, 90 * time.Millisecond}
+
+// build student table with a fragment on each of node 0, 1 and 2, and courseRegistration table on node 3
+func fanOutSetup() {
+	setupLab3()
+
+	sidRule := func(conditions ...map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"predicate": map[string]interface{}{"sid": conditions},
+			"column":    []string{"sid", "name", "age", "grade"},
+		}
+	}
+	studentTablePartitionRules, _ = json.Marshal(map[string]interface{}{
+		"0": sidRule(map[string]interface{}{"op": "<", "val": 1}),
+		"1": sidRule(map[string]interface{}{"op": ">=", "val": 1}, map[string]interface{}{"op": "<", "val": 2}),
+		"2": sidRule(map[string]interface{}{"op": ">=", "val": 2}),
+	})
+	courseRegistrationTablePartitionRules, _ = json.Marshal(map[string]interface{}{
+		"3": map[string]interface{}{
+			"predicate": map[string]interface{}{},
+			"column":    []string{"sid", "courseId"},
+		},
+	})
+
+	buildTablesLab3(cli)
+	insertDataLab3(cli)
+
+	for i, delay := range fanOutNodeDelays {
+		network.SetDelay("Node"+strconv.Itoa(i), delay)
+	}
+}
+
+// checkFanOutElapsed checks that the calls to the nodes took about as long as the slowest node.
+func checkFanOutElapsed(t *testing.T, name string, elapsed time.Duration) {
+	checkFanOutRoundsElapsed(t, name, 1, elapsed)
+}
+
+// checkFanOutRoundsElapsed checks that the given rounds of calls to the nodes, e.g. the prepare and the commit of a
+// write, took about as long as the slowest node in each round.
+func checkFanOutRoundsElapsed(t *testing.T, name string, rounds int, elapsed time.Duration) {
+	slowest := fanOutNodeDelays[len(fanOutNodeDelays)-1] * time.Duration(rounds)
+	var sum time.Duration
+	for _, delay := range fanOutNodeDelays {
+		sum += delay * time.Duration(rounds)
+	}
+	if elapsed < slowest || elapsed >= sum-slowest/2 {
+		t.Errorf("%s should take about %v as the nodes are called concurrently, actual %v (sequential calls take %v)",
+			name, slowest, elapsed, sum)
+	}
+}
+
+func TestFanOutGetFullTableDataset(t *testing.T) {
+	fanOutSetup()
+
+	startTime := time.Now()
+	dataset := Dataset{}
+	if err := c.GetFullTableDataset(studentTableName, &dataset); err != nil {
+		t.Fatal(err)
+	}
+	checkFanOutElapsed(t, "GetFullTableDataset", time.Since(startTime))
+
+	expectedDataset := Dataset{Schema: *studentTableSchema, Rows: studentRows}
+	if !compareDataset(dataset, expectedDataset) {
+		t.Errorf("Incorrect student table, expected %v, actual %v", expectedDataset, dataset)
+	}
+}
+
+func TestFanOutSemiJoin(t *testing.T) {
+	fanOutSetup()
+
+	startTime := time.Now()
+	results := Dataset{}
+	cli.Call("Cluster.SemiJoin", []string{"sid", studentTableName, courseRegistrationTableName}, &results)
+	checkFanOutElapsed(t, "SemiJoin", time.Since(startTime))
+
+	expectedDataset := Dataset{Schema: *studentTableSchema, Rows: studentRows}
+	if !compareDataset(results, expectedDataset) {
+		t.Errorf("Incorrect semi-join results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+func TestFanOutSortedScan(t *testing.T) {
+	fanOutSetup()
+
+	// every fragment holds all columns, so the nodes sort and filter their own rows
+	startTime := time.Now()
+	results := Dataset{}
+	cli.Call("Cluster.Select", SelectQuery{TableName: studentTableName, OrderBy: []OrderBy{{Column: "sid"}}},
+		&results)
+	checkFanOutElapsed(t, "Select", time.Since(startTime))
+	expectedDataset := Dataset{Schema: *studentTableSchema, Rows: studentRows}
+	if !compareDataset(results, expectedDataset) {
+		t.Errorf("Incorrect select results, expected %v, actual %v", expectedDataset, results)
+	}
+
+	startTime = time.Now()
+	deletedCount := 0
+	cli.Call("Cluster.Delete", DeleteQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"sid": {{Op: ">=", Val: 1}}}}, &deletedCount)
+	// the rows to delete are found, and then they are prepared and committed
+	checkFanOutRoundsElapsed(t, "Delete", 3, time.Since(startTime))
+	if deletedCount != len(studentRows)-1 {
+		t.Errorf("Expected %d deleted students, actual %d", len(studentRows)-1, deletedCount)
+	}
+}
+
+func TestFanOutBuildTableAndWrite(t *testing.T) {
+	fanOutSetup()
+
+	// the table is replicated on node 0, 1 and 2
+	schema := TableSchema{TableName: "course", ColumnSchemas: []ColumnSchema{
+		{Name: "courseId", DataType: TypeInt32},
+		{Name: "title", DataType: TypeString},
+	}}
+	rules, _ := json.Marshal(map[string]interface{}{
+		"0|1|2": map[string]interface{}{
+			"predicate": map[string]interface{}{},
+			"column":    []string{"courseId", "title"},
+		},
+	})
+
+	startTime := time.Now()
+	reply := ""
+	cli.Call("Cluster.BuildTable", []interface{}{schema, rules}, &reply)
+	checkFanOutElapsed(t, "BuildTable", time.Since(startTime))
+
+	startTime = time.Now()
+	cli.Call("Cluster.FragmentWrite", []interface{}{schema.TableName, Row{0, "Databases"}}, &reply)
+	// the nodes are called to prepare the write, and then to commit it
+	checkFanOutRoundsElapsed(t, "FragmentWrite", 2, time.Since(startTime))
+
+	for i := range fanOutNodeDelays {
+		network.SetDelay("Node"+strconv.Itoa(i), 0)
+	}
+	expectedDataset := Dataset{Schema: schema, Rows: []Row{{0, "Databases"}}}
+	for i := range fanOutNodeDelays {
+		dataset := Dataset{}
+		c.getNodeEnd(i).Call("Node.ScanTable", getFragmentName(schema.TableName, 0), &dataset)
+		if !compareDataset(dataset, expectedDataset) {
+			t.Errorf("Incorrect replica on node %d, expected %v, actual %v", i, expectedDataset, dataset)
+		}
+	}
+}
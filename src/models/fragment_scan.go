@@ -0,0 +1,138 @@
+package models
+
+import "fmt"
+
+// FragmentScan describes a scan of a table fragment on a node. The rows returned by a scan always start with their
+// row idx, followed by the values of the scanned columns.
+type FragmentScan struct {
+	// name of the fragment on the node, see getFragmentName
+	FragmentName string
+	// return all columns of the fragment, otherwise only the columns in Columns are returned
+	AllColumns bool
+	// columns to return, columns that the fragment does not hold are skipped
+	Columns []string
+	// only return the rows whose row idx is in PKs
+	FilterByPK bool
+	PKs        ValueSet
+	// only return the rows whose values of KeyColumns form a key (see getRowKey) in Keys
+	FilterByKey bool
+	KeyColumns  []string
+	Keys        ValueSet
+	// the snapshot to read, see Node.snapshotRowIterator
+	Snapshot Snapshot
+}
+
+// getRowKey returns the value of the given columns of a row, which can be put into a ValueSet. The value itself is
+// used if there is only one column, otherwise the values are printed into a string.
+func getRowKey(row Row, colIdxs []int) interface{} {
+	if len(colIdxs) == 1 {
+		return row[colIdxs[0]]
+	}
+	values := make([]interface{}, len(colIdxs))
+	for i, colIdx := range colIdxs {
+		values[i] = row[colIdx]
+	}
+	return fmt.Sprintf("%#v", values)
+}
+
+// ScanFragment returns the rows of a fragment on this node as described by args.
+// The returned Dataset holds the scanned columns in its schema, and each row starts with its row idx.
+func (n *Node) ScanFragment(args FragmentScan, reply *Dataset) error {
+	table, ok := n.getTable(args.FragmentName)
+	if !ok {
+		return noSuchTableError(args.FragmentName)
+	}
+	schema := table.schema
+
+	// indices of returned columns in the rows, shifted by one to skip the row idx
+	var colIdxs []int
+	reply.Schema.TableName = schema.TableName
+	if args.AllColumns {
+		for colIdx, colSchema := range schema.ColumnSchemas {
+			colIdxs = append(colIdxs, colIdx+1)
+			reply.Schema.ColumnSchemas = append(reply.Schema.ColumnSchemas, colSchema)
+		}
+	} else {
+		for _, colName := range args.Columns {
+			if colIdx, dataType := schema.GetColumnByName(colName); colIdx != -1 {
+				colIdxs = append(colIdxs, colIdx+1)
+				reply.Schema.ColumnSchemas = append(reply.Schema.ColumnSchemas,
+					ColumnSchema{Name: colName, DataType: dataType})
+			}
+		}
+	}
+
+	var keyColIdxs []int
+	if args.FilterByKey {
+		for _, colName := range args.KeyColumns {
+			colIdx := schema.GetColIndexByName(colName)
+			// the keys cannot be checked on this fragment
+			if colIdx == -1 {
+				return missingColumnError(args.FragmentName, colName)
+			}
+			keyColIdxs = append(keyColIdxs, colIdx+1)
+		}
+	}
+
+	rowIterator := n.snapshotRowIterator(table, args.FragmentName, args.Snapshot)
+	for rowIterator.HasNext() {
+		row := *rowIterator.Next()
+		if args.FilterByPK && !args.PKs[row[0]] {
+			continue
+		}
+		if args.FilterByKey && !args.Keys[getRowKey(row, keyColIdxs)] {
+			continue
+		}
+
+		scannedRow := make(Row, len(colIdxs)+1)
+		scannedRow[0] = row[0]
+		for i, colIdx := range colIdxs {
+			scannedRow[i+1] = row[colIdx]
+		}
+		reply.Rows = append(reply.Rows, scannedRow)
+	}
+	return nil
+}
+
+// projectTable returns the values of the given columns of a table keyed by row idx, only the rows whose row idx is in
+// pks are returned if filterByPK is true. It reads every fragment holding some of the columns as of the given
+// snapshot, and the returned rows follow the returned schema, which holds the given columns in the given order. It
+// returns an error if no replica of some fragment replies.
+func (c *Cluster) projectTable(tableName string, colNames []string, pks ValueSet, filterByPK bool,
+	snapshot Snapshot) (map[interface{}]Row, TableSchema, error) {
+	tableSchema, _ := c.getTableSchema(tableName)
+	projectedSchema := TableSchema{TableName: tableName}
+	for _, colName := range colNames {
+		projectedSchema.ColumnSchemas = append(projectedSchema.ColumnSchemas,
+			ColumnSchema{Name: colName, DataType: tableSchema.GetColTypeByName(colName)})
+	}
+
+	pkRowMap := make(map[interface{}]Row)
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		rule := nodeRule.Rule
+		scan := FragmentScan{
+			FragmentName: getFragmentName(tableName, rule.RuleIdx),
+			FilterByPK:   filterByPK,
+			PKs:          pks,
+			Snapshot:     snapshot,
+		}
+		for _, colName := range rule.Column {
+			if projectedSchema.GetColIndexByName(colName) != -1 {
+				scan.Columns = append(scan.Columns, colName)
+			}
+		}
+		// the fragment holds none of the columns
+		if len(scan.Columns) == 0 {
+			continue
+		}
+
+		// any replica of the fragment will do
+		var nodeDataset Dataset
+		if err := c.callReplica(nodeRule, "Node.ScanFragment", scan, &nodeDataset); err != nil {
+			return nil, TableSchema{}, err
+		}
+		nodeDataset.ReconstructTable(pkRowMap, projectedSchema, true)
+	}
+
+	return pkRowMap, projectedSchema, nil
+}
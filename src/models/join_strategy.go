@@ -0,0 +1,129 @@
+package models
+
+import (
+	"math"
+)
+
+// strategies to join two tables, see AutoJoin
+const (
+	// fetch both tables and join them at the coordinator, or join the co-located fragments on the nodes
+	JoinStrategyJoin = "Join"
+	// reduce table1 by the join values of table2 on the nodes before joining them at the coordinator
+	JoinStrategySemiJoin = "SemiJoin"
+)
+
+// JoinStrategyReply is the reply of AutoJoin.
+type JoinStrategyReply struct {
+	// the chosen strategy, one of JoinStrategyJoin and JoinStrategySemiJoin
+	Strategy string
+	// estimated bytes sent through the network by each strategy
+	EstimatedJoinBytes     int64
+	EstimatedSemiJoinBytes int64
+	// bytes sent through the network while executing the chosen strategy
+	ActualBytes int64
+	// the joined results
+	Result Dataset
+}
+
+// estimateJoinStrategyBytes estimates the bytes sent through the network when joining table1 and table2 on the given
+// column with Join and SemiJoin respectively.
+func (c *Cluster) estimateJoinStrategyBytes(onJoinColName string, table1Name string, table2Name string) (float64,
+	float64, error) {
+	stats1, err := c.getTableStatistics(table1Name)
+	if err != nil {
+		return 0, 0, err
+	}
+	stats2, err := c.getTableStatistics(table2Name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Join fetches both tables, unless the join can be done on the nodes
+	var joinBytes float64
+	if _, ok := c.planCoLocatedJoin([]string{table1Name, table2Name}); ok {
+		// only the joined rows are returned, whose number is estimated assuming uniformly distributed join values
+		joinedRowCount := float64(stats1.RowCount) * float64(stats2.RowCount) /
+			math.Max(1, math.Max(float64(stats1.DistinctCounts[onJoinColName]),
+				float64(stats2.DistinctCounts[onJoinColName])))
+		joinBytes = joinedRowCount * (stats1.RowWidth() + stats2.RowWidth())
+	} else {
+		joinBytes = float64(stats1.RowCount)*stats1.RowWidth() + float64(stats2.RowCount)*stats2.RowWidth()
+	}
+
+	// SemiJoin fetches table2, then sends its join values to the fragments of table1 holding the column
+	semiJoinBytes := float64(stats2.RowCount) * stats2.RowWidth()
+	selectivity := 1.0
+	if stats1.DistinctCounts[onJoinColName] > 0 {
+		selectivity = math.Min(1,
+			float64(stats2.DistinctCounts[onJoinColName])/float64(stats1.DistinctCounts[onJoinColName]))
+	}
+	for _, nodeRule := range c.getNodeRules(table1Name) {
+		if nodeRule.Rule.HasColumn(onJoinColName) {
+			semiJoinBytes += float64(stats2.DistinctCounts[onJoinColName]) * stats2.ColumnWidths[onJoinColName]
+		} else {
+			// the other fragments receive the row idx of the remaining rows
+			semiJoinBytes += selectivity * float64(stats1.RowCount) * rowIdxWidth
+		}
+	}
+	// and only the remaining rows of table1 are returned
+	semiJoinBytes += selectivity * float64(stats1.RowCount) * stats1.RowWidth()
+
+	return joinBytes, semiJoinBytes, nil
+}
+
+// AutoJoin joins table1 and table2 using NATURAL JOIN, choosing between Join and SemiJoin by estimating the bytes that
+// each of them sends through the network from the statistics of the fragments.
+// With SemiJoin, table1 is reduced by the values of the given column in table2, and then joined with table2 at the
+// coordinator.
+// Set reply as the chosen strategy, the estimated and actual bytes, and the joined results. The actual bytes are the
+// ones of the executed plan (see PlanNode.ActualBytes), so they do not include the traffic of other concurrent
+// requests.
+func (c *Cluster) AutoJoin(params []string, reply *JoinStrategyReply) error {
+	// params[0] = the column name to join the two tables on
+	// params[1] = table1 name
+	// params[2] = table2 name
+	onJoinColName := params[0]
+	table1Name := params[1]
+	table2Name := params[2]
+
+	table1Schema, ok1 := c.getTableSchema(table1Name)
+	table2Schema, ok2 := c.getTableSchema(table2Name)
+	if !ok1 || !ok2 {
+		return newError(ErrNoSuchTable, "table "+table1Name+" or "+table2Name+" doesn't exist")
+	}
+	if table1Schema.GetColIndexByName(onJoinColName) == -1 || table2Schema.GetColIndexByName(onJoinColName) == -1 {
+		return newError(ErrSchemaMismatch, "Column to join doesn't exist in both table")
+	}
+
+	joinBytes, semiJoinBytes, err := c.estimateJoinStrategyBytes(onJoinColName, table1Name, table2Name)
+	if err != nil {
+		return err
+	}
+	reply.EstimatedJoinBytes = int64(joinBytes)
+	reply.EstimatedSemiJoinBytes = int64(semiJoinBytes)
+
+	var plan *planStep
+	if semiJoinBytes < joinBytes {
+		reply.Strategy = JoinStrategySemiJoin
+		plan, err = c.planSemiJoin(onJoinColName, table1Name, table2Name, nil)
+	} else {
+		reply.Strategy = JoinStrategyJoin
+		plan, err = c.planJoin([]string{table1Name, table2Name}, nil)
+	}
+	if err != nil {
+		return err
+	}
+	result, err := c.executePlanAtSnapshot(plan)
+	if err != nil {
+		return err
+	}
+	if reply.Strategy == JoinStrategySemiJoin {
+		// the reduced table1 is joined with the full table2, which is the result of the first step of the plan
+		if result, err = NaturalJoin([]*Dataset{&result, &plan.children[0].result}); err != nil {
+			return err
+		}
+	}
+	reply.Result = result
+	reply.ActualBytes = plan.node.ActualBytes
+	return nil
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// student table is large and held by three nodes, while courseRegistration table only holds a few students
+func joinStrategySetup() {
+	setupLab3()
+
+	studentRows = make([]Row, 0)
+	for i := 0; i < 100; i++ {
+		studentRows = append(studentRows,
+			Row{i, fmt.Sprintf("Student %03d with a rather long name", i), 20 + i%5, 3.0 + float64(i%10)/10})
+	}
+
+	m := map[string]interface{}{
+		"0|1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{
+					"op":  "<=",
+					"val": 3.6,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+		"2": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{
+					"op":  ">",
+					"val": 3.6,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+	}
+	studentTablePartitionRules, _ = json.Marshal(m)
+
+	m = map[string]interface{}{
+		"3": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"courseId": [...]map[string]interface{}{{
+					"op":  ">=",
+					"val": 0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "courseId",
+			},
+		},
+	}
+	courseRegistrationTablePartitionRules, _ = json.Marshal(m)
+
+	buildTablesLab3(cli)
+	insertDataLab3(cli)
+}
+
+func TestAutoJoinChoosesSemiJoin(t *testing.T) {
+	joinStrategySetup()
+
+	startBytes := network.GetTotalBytes()
+	expectedDataset := Dataset{}
+	cli.Call("Cluster.Join", []string{studentTableName, courseRegistrationTableName}, &expectedDataset)
+	joinBytes := network.GetTotalBytes() - startBytes
+
+	reply := JoinStrategyReply{}
+	cli.Call("Cluster.AutoJoin", []string{"sid", studentTableName, courseRegistrationTableName}, &reply)
+	if reply.Strategy != JoinStrategySemiJoin {
+		t.Errorf("SemiJoin should be chosen to reduce the large table, actual reply %v", reply)
+	}
+	if reply.EstimatedSemiJoinBytes >= reply.EstimatedJoinBytes {
+		t.Errorf("SemiJoin should be estimated to send fewer bytes, actual reply %v", reply)
+	}
+	if reply.ActualBytes <= 0 || reply.ActualBytes >= joinBytes {
+		t.Errorf("SemiJoin should send fewer bytes than Join (%d), actual %d", joinBytes, reply.ActualBytes)
+	}
+	if !datasetDuplicateChecking(expectedDataset, reply.Result) {
+		t.Errorf("Incorrect join results, expected %v, actual %v", expectedDataset, reply.Result)
+	}
+
+	// the traffic of the concurrent requests is not counted
+	done := make(chan bool)
+	go func() {
+		client := makeClients(1)[0]
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				client.Call("Cluster.Select", SelectQuery{TableName: studentTableName}, &Dataset{})
+			}
+		}
+	}()
+	concurrentReply := JoinStrategyReply{}
+	cli.Call("Cluster.AutoJoin", []string{"sid", studentTableName, courseRegistrationTableName}, &concurrentReply)
+	close(done)
+	if concurrentReply.ActualBytes != reply.ActualBytes {
+		t.Errorf("Expected %d bytes along with the concurrent requests, actual %d", reply.ActualBytes,
+			concurrentReply.ActualBytes)
+	}
+}
+
+func TestAutoJoinChoosesJoin(t *testing.T) {
+	joinStrategySetup()
+
+	expectedDataset := Dataset{}
+	cli.Call("Cluster.Join", []string{courseRegistrationTableName, studentTableName}, &expectedDataset)
+
+	// reducing the small table by the large one costs more than it saves
+	reply := JoinStrategyReply{}
+	cli.Call("Cluster.AutoJoin", []string{"sid", courseRegistrationTableName, studentTableName}, &reply)
+	if reply.Strategy != JoinStrategyJoin {
+		t.Errorf("Join should be chosen, actual reply %v", reply)
+	}
+	if reply.EstimatedJoinBytes > reply.EstimatedSemiJoinBytes {
+		t.Errorf("Join should be estimated to send fewer bytes, actual reply %v", reply)
+	}
+	if reply.ActualBytes <= 0 {
+		t.Errorf("The actual bytes should be measured, actual reply %v", reply)
+	}
+	if !datasetDuplicateChecking(expectedDataset, reply.Result) {
+		t.Errorf("Incorrect join results, expected %v, actual %v", expectedDataset, reply.Result)
+	}
+}
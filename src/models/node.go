@@ -1,9 +1,10 @@
 package models
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
+	"sync"
+	"time"
 )
 
 // Node manages some tables defined in models/table.go
@@ -12,20 +13,42 @@ type Node struct {
 	Identifier string
 	// tableName -> table
 	TableMap map[string]*Table
+	// guards TableMap, as the RPCs are served concurrently
+	tablesMu sync.RWMutex
+	// scanId -> scan opened by OpenScan
+	scans   map[string]*nodeScan
+	scansMu sync.Mutex
+	// how long a scan is kept without being fetched, see OpenScan, it is shortened by the tests
+	scanIdleTimeout time.Duration
+	// the transactions of two-phase commit, see Prepare
+	txns *nodeTxns
+	// the last write requests of the clients, see RequestId
+	dedup *dedupTable
 }
 type ValueSet map[interface{}]bool
 
 // NewNode creates a new node with the given name and an empty set of tables
 func NewNode(id string) *Node {
-	return &Node{TableMap: make(map[string]*Table), Identifier: id}
+	return &Node{TableMap: make(map[string]*Table), Identifier: id, scans: make(map[string]*nodeScan),
+		scanIdleTimeout: 2 * cursorIdleTimeout, txns: newNodeTxns(), dedup: newDedupTable()}
 }
 
 // SayHello is an example about how to create a method that can be accessed by RPC (remote procedure call, methods that
 // can be called through network from another node). RPC methods should have exactly two arguments, the first one is the
-// actual argument (or an argument list), while the second one is a reference to the result.
-func (n *Node) SayHello(args interface{}, reply *string) {
+// actual argument (or an argument list), while the second one is a reference to the result. They return an error if
+// the request fails, which is sent back to the caller instead of the result (see Error).
+func (n *Node) SayHello(args interface{}, reply *string) error {
 	// NOTICE: use reply (the second parameter) to pass the return value instead of "return" statements.
 	*reply = fmt.Sprintf("Hello %s, I am Node %s", args, n.Identifier)
+	return nil
+}
+
+// getTable returns the table with the given name on this node, and whether it exists.
+func (n *Node) getTable(tableName string) (*Table, bool) {
+	n.tablesMu.RLock()
+	defer n.tablesMu.RUnlock()
+	t, ok := n.TableMap[tableName]
+	return t, ok
 }
 
 // helper function to print table column name and datatype
@@ -38,6 +61,8 @@ func (n *Node) SayHello(args interface{}, reply *string) {
    5 - TypeString
 */
 func (n *Node) PrintTableColumnSchemas() {
+	n.tablesMu.RLock()
+	defer n.tablesMu.RUnlock()
 	for _, v := range n.TableMap {
 		fmt.Print("\n")
 		fmt.Printf("------------ Table %s Columns -------------- \n", v.schema.TableName)
@@ -50,66 +75,151 @@ func (n *Node) PrintTableColumnSchemas() {
 	}
 }
 
-func (n *Node) BuildTable(args interface{}, reply *string) {
-	// TODO: error handling
-	_ = n.CreateTable(args.(*TableSchema))
+func (n *Node) BuildTable(args interface{}, reply *string) error {
+	if err := n.CreateTable(args.(*TableSchema)); err != nil {
+		return err
+	}
 
 	// uncomment to debug
 	//n.PrintTableColumnSchemas()
 
 	*reply = fmt.Sprintf("Successfully built table %s for Node %s", args.(*TableSchema).TableName, n.Identifier)
+	return nil
 }
 
-func (n *Node) FragmentWrite(params []interface{}, reply *string) {
+// FragmentWrite inserts a row of a fragment, whose first column is the row idx, into the fragment on this node. It
+// fails if the row does not match the schema of the fragment, or if the fragment already has a row with the same idx.
+// The row may be followed by the RequestId of the write, so that a retried write gets the reply of the write that is
+// applied instead of failing with ErrDuplicateKey. The writes of the coordinator are staged by their transactions
+// instead (see Node.Stage), which skip a row staged again, so they need no RequestId.
+func (n *Node) FragmentWrite(params []interface{}, reply *string) error {
 	//tableName := params[0]
 	//row := params[1]
+	//requestId := params[2] (optional)
 
+	params, requestId := splitRequestId(params)
 	tableName := params[0].(string)
 	row := params[1].(Row)
-	err := n.Insert(tableName, &row)
+	result, err := n.dedup.apply(requestId, func() (string, error) {
+		if err := n.insertRow(tableName, row); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Successfully insert row %s into Table %s for Node %s", row, tableName, n.Identifier), nil
+	})
 	if err != nil {
-		*reply = fmt.Sprintf("Insertion Error")
+		return err
 	}
+	*reply = result
+	return nil
+}
 
-	*reply = fmt.Sprintf("Successfully insert row %s into Table %s for Node %s", row, tableName, n.Identifier)
-	//*reply = fmt.Sprintf("Successfully insert row  into Table %s\n for Node %s", tableName, n.Identifier)
-	//*reply = fmt.Sprintf("Successfully insert row")
+// insertRow inserts a row of a fragment into the fragment on this node, see FragmentWrite.
+func (n *Node) insertRow(tableName string, row Row) error {
+	t, ok := n.getTable(tableName)
+	if !ok {
+		return noSuchTableError(tableName)
+	}
+	if len(row) != len(t.schema.ColumnSchemas)+1 {
+		return newError(ErrSchemaMismatch, fmt.Sprintf("row %v doesn't match the %d columns of table %s", row,
+			len(t.schema.ColumnSchemas), tableName))
+	}
+	// the writes of the node are serialized by n.txns.mu, so that no other write takes the row idx after it is checked
+	n.txns.mu.Lock()
+	defer n.txns.mu.Unlock()
+	for iterator := t.RowIterator(); iterator.HasNext(); {
+		if (*iterator.Next())[0] == row[0] {
+			return newError(ErrDuplicateKey, fmt.Sprintf("row %v already exists in table %s", row[0], tableName))
+		}
+	}
+	t.Insert(&row)
+	return nil
+}
+
+// FragmentDelete deletes the row with the given row idx from a fragment on this node. It succeeds if the fragment has
+// no such row. The row idx may be followed by the RequestId of the delete, so that a retried delete gets the reply of
+// the delete that is applied.
+func (n *Node) FragmentDelete(params []interface{}, reply *string) error {
+	//fragmentName := params[0]
+	//rowIdx := params[1]
+	//requestId := params[2] (optional)
+
+	params, requestId := splitRequestId(params)
+	fragmentName := params[0].(string)
+	rowIdx := params[1]
+	result, err := n.dedup.apply(requestId, func() (string, error) {
+		t, ok := n.getTable(fragmentName)
+		if !ok {
+			return "", noSuchTableError(fragmentName)
+		}
+		n.txns.mu.Lock()
+		defer n.txns.mu.Unlock()
+		deletedCount := 0
+		for iterator := t.RowIterator(); iterator.HasNext(); {
+			if row := iterator.Next(); (*row)[0] == rowIdx {
+				t.Remove(row)
+				deletedCount++
+				break
+			}
+		}
+		return fmt.Sprintf("Successfully deleted %d rows from Table %s for Node %s", deletedCount, fragmentName,
+			n.Identifier), nil
+	})
+	if err != nil {
+		return err
+	}
+	*reply = result
+	return nil
 }
 
 // CreateTable creates a Table on this node with the provided schema. It returns nil if the table is created
 // successfully, or an error if another table with the same name already exists.
 func (n *Node) CreateTable(schema *TableSchema) error {
+	n.tablesMu.Lock()
+	defer n.tablesMu.Unlock()
 	// check if the table already exists
 	if _, ok := n.TableMap[schema.TableName]; ok {
-		return errors.New("table already exists")
+		return newError(ErrTableExists, "table "+schema.TableName+" already exists")
 	}
 	// create a table and store it in the map
 	t := NewTable(
 		schema,
-		NewMemoryListRowStore(),
+		NewMemoryVersionedRowStore(),
 	)
 	n.TableMap[schema.TableName] = t
 	return nil
 }
 
+// DropTable removes the table with the given name from this node.
+func (n *Node) DropTable(tableName string, reply *string) error {
+	n.tablesMu.Lock()
+	if _, ok := n.TableMap[tableName]; !ok {
+		n.tablesMu.Unlock()
+		return noSuchTableError(tableName)
+	}
+	delete(n.TableMap, tableName)
+	n.tablesMu.Unlock()
+	*reply = fmt.Sprintf("Successfully dropped table %s for Node %s", tableName, n.Identifier)
+	return nil
+}
+
 // Insert inserts a row into the specified table, and returns nil if succeeds or an error if the table does not exist.
 func (n *Node) Insert(tableName string, row *Row) error {
-	if t, ok := n.TableMap[tableName]; ok {
+	if t, ok := n.getTable(tableName); ok {
 		t.Insert(row)
 		return nil
 	} else {
-		return errors.New("no such table")
+		return noSuchTableError(tableName)
 	}
 }
 
 // Remove removes a row from the specified table, and returns nil if succeeds or an error if the table does not exist.
 // It does not concern whether the provided row exists in the table.
 func (n *Node) Remove(tableName string, row *Row) error {
-	if t, ok := n.TableMap[tableName]; ok {
+	if t, ok := n.getTable(tableName); ok {
 		t.Remove(row)
 		return nil
 	} else {
-		return errors.New("no such table")
+		return noSuchTableError(tableName)
 	}
 }
 
@@ -117,18 +227,18 @@ func (n *Node) Remove(tableName string, row *Row) error {
 // order they are inserted. It returns (iterator, nil) if the Table can be found, or (nil, err) if the Table does not
 // exist.
 func (n *Node) IterateTable(tableName string) (RowIterator, error) {
-	if t, ok := n.TableMap[tableName]; ok {
+	if t, ok := n.getTable(tableName); ok {
 		return t.RowIterator(), nil
 	} else {
-		return nil, errors.New("no such table")
+		return nil, noSuchTableError(tableName)
 	}
 }
 
 // Dataset row will include rowIdx primary key
-func (n *Node) GetTableDataset(args interface{}, reply *Dataset) {
+func (n *Node) GetTableDataset(args interface{}, reply *Dataset) error {
 	tableName := args.(string)
 
-	if table, ok := n.TableMap[tableName]; ok {
+	if table, ok := n.getTable(tableName); ok {
 		reply.Schema = *table.schema
 		rowIterator, _ := n.IterateTable(tableName)
 
@@ -137,15 +247,17 @@ func (n *Node) GetTableDataset(args interface{}, reply *Dataset) {
 		}
 
 	} else {
-		reply = nil
+		return noSuchTableError(tableName)
 	}
+	return nil
 }
 
 // GetMergedTableDataset Merge multiple partition of the same table on this node into one dataset
 // Returned row has primary key (row index) on first column (row[0])
-func (n *Node) GetMergedTableDataset(args []interface{}, reply *Dataset) {
+func (n *Node) GetMergedTableDataset(args []interface{}, reply *Dataset) error {
 
-	// args -> array of table names
+	// args -> array of table names, optionally followed by the snapshot to read (see splitSnapshot)
+	args, snapshot := splitSnapshot(args)
 	pkRowMap := make(map[interface{}]Row)
 
 	fullTableSchema := args[0].(TableSchema)
@@ -157,9 +269,9 @@ func (n *Node) GetMergedTableDataset(args []interface{}, reply *Dataset) {
 
 		var dataset Dataset
 
-		if table, ok := n.TableMap[tableName.(string)]; ok {
+		if table, ok := n.getTable(tableName.(string)); ok {
 			dataset.Schema = *table.schema
-			rowIterator, _ := n.IterateTable(tableName.(string))
+			rowIterator := n.snapshotRowIterator(table, tableName.(string), snapshot)
 
 			for rowIterator.HasNext() {
 				dataset.Rows = append(dataset.Rows, *rowIterator.Next())
@@ -168,8 +280,7 @@ func (n *Node) GetMergedTableDataset(args []interface{}, reply *Dataset) {
 			// don't skip row primary key on node & skip on cluster
 			dataset.ReconstructTable(pkRowMap, fullTableSchema, false)
 		} else {
-			reply = nil
-			return
+			return noSuchTableError(tableName.(string))
 		}
 
 	}
@@ -177,41 +288,82 @@ func (n *Node) GetMergedTableDataset(args []interface{}, reply *Dataset) {
 	for _, row := range pkRowMap {
 		reply.Rows = append(reply.Rows, row)
 	}
+	return nil
+}
+
+// JoinFragments joins table fragments on this node using NATURAL JOIN (join on the common columns).
+// Each fragment should hold all columns of its table. Every joined row carries the row idx of the rows it is joined
+// from in hidden columns named by getRowIdxColumnName, so that the coordinator can remove duplicated results.
+func (n *Node) JoinFragments(args []interface{}, reply *Dataset) error {
+	// args[2i] = full schema of the i-th table
+	// args[2i+1] = name of the fragment of the i-th table on this node
+	// args[2n] = (optional) the snapshot to read, see splitSnapshot
+	args, snapshot := splitSnapshot(args)
+	datasetPtrs := make([]*Dataset, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		fullTableSchema := args[i].(TableSchema)
+		fragmentName := args[i+1].(string)
+		var fragmentDataset Dataset
+		if err := n.GetMergedTableDataset([]interface{}{fullTableSchema, fragmentName, snapshot},
+			&fragmentDataset); err != nil {
+			return err
+		}
+
+		// the merged rows start with the row idx, so expose it as the first column
+		colSchemas := []ColumnSchema{{Name: getRowIdxColumnName(fullTableSchema.TableName), DataType: TypeInt64}}
+		colSchemas = append(colSchemas, fullTableSchema.ColumnSchemas...)
+		datasetPtrs = append(datasetPtrs, &Dataset{
+			Schema: TableSchema{TableName: fullTableSchema.TableName, ColumnSchemas: colSchemas},
+			Rows:   fragmentDataset.Rows,
+		})
+	}
+
+	result, err := NaturalJoin(datasetPtrs)
+	if err != nil {
+		return err
+	}
+	*reply = result
+	return nil
 }
 
-func (n *Node) TableHasColumn(args []string, reply *bool) {
+func (n *Node) TableHasColumn(args []string, reply *bool) error {
 	// args[0] = table name
 	// args[1] = column name
 	tableName := args[0]
 	columnName := args[1]
-	tableSchema := n.TableMap[tableName].schema
-	if tableSchema.GetColIndexByName(columnName) == -1 {
+	table, ok := n.getTable(tableName)
+	if !ok {
+		return noSuchTableError(tableName)
+	}
+	if table.schema.GetColIndexByName(columnName) == -1 {
 		*reply = false
 	} else {
 		*reply = true
 	}
+	return nil
 }
 
-func (n *Node) FilterTableWithColumnValues(args []interface{}, reply *Dataset) {
+func (n *Node) FilterTableWithColumnValues(args []interface{}, reply *Dataset) error {
 	// args[0] = name of table to be filtered
 	// args[1] = column of table that should be filtered on
 	// args[2] = hashmap that stores possible column values on other table
+	// args[3] = (optional) the snapshot to read, see splitSnapshot
+	args, snapshot := splitSnapshot(args)
 
 	tableName := args[0].(string)
 	filterColumnName := args[1].(string)
-	filterColumnIndex := int(n.TableMap[tableName].schema.GetColIndexByName(filterColumnName))
 	possibleJoinValueSet := args[2].(ValueSet)
 
-	// the table fragment in this node does not has the column
-	if filterColumnIndex == -1 {
-		reply = nil
-		return
-	}
-
 	// if table exists
-	if table, ok := n.TableMap[tableName]; ok {
+	if table, ok := n.getTable(tableName); ok {
+		filterColumnIndex := table.schema.GetColIndexByName(filterColumnName)
+		// the table fragment in this node does not has the column
+		if filterColumnIndex == -1 {
+			return missingColumnError(tableName, filterColumnName)
+		}
+
 		reply.Schema = *table.schema
-		rowIterator, _ := n.IterateTable(tableName)
+		rowIterator := n.snapshotRowIterator(table, tableName, snapshot)
 
 		// get all rows one by one
 		for rowIterator.HasNext() {
@@ -219,27 +371,65 @@ func (n *Node) FilterTableWithColumnValues(args []interface{}, reply *Dataset) {
 			row := *rowIterator.Next()
 
 			// only add row to reply dataset if the column value exists on other table
-			if possibleJoinValueSet[row[filterColumnIndex]] == true {
+			// skip the row idx in the first column
+			if possibleJoinValueSet[row[filterColumnIndex+1]] == true {
 				reply.Rows = append(reply.Rows, row)
 			}
 		}
 
 	} else {
-		reply = nil
+		return noSuchTableError(tableName)
 	}
+	return nil
+}
+
+// FilterTableWithBloomFilter is similar to FilterTableWithColumnValues, but the possible column values are given as a
+// Bloom filter, so the returned rows may contain some false positives.
+func (n *Node) FilterTableWithBloomFilter(args []interface{}, reply *Dataset) error {
+	// args[0] = name of table to be filtered
+	// args[1] = column of table that should be filtered on
+	// args[2] = Bloom filter of possible column values on other table
+	// args[3] = (optional) the snapshot to read, see splitSnapshot
+	args, snapshot := splitSnapshot(args)
+	tableName := args[0].(string)
+	filterColumnName := args[1].(string)
+	filter := args[2].(BloomFilter)
 
+	// if table exists
+	if table, ok := n.getTable(tableName); ok {
+		filterColumnIndex := table.schema.GetColIndexByName(filterColumnName)
+		// the table fragment in this node does not has the column
+		if filterColumnIndex == -1 {
+			return missingColumnError(tableName, filterColumnName)
+		}
+
+		reply.Schema = *table.schema
+		rowIterator := n.snapshotRowIterator(table, tableName, snapshot)
+		for rowIterator.HasNext() {
+			row := *rowIterator.Next()
+			// skip the row idx in the first column
+			if filter.MayContain(row[filterColumnIndex+1]) {
+				reply.Rows = append(reply.Rows, row)
+			}
+		}
+	} else {
+		return noSuchTableError(tableName)
+	}
+	return nil
 }
 
-func (n *Node) FilterTableWithPKs(args []interface{}, reply *Dataset) {
+func (n *Node) FilterTableWithPKs(args []interface{}, reply *Dataset) error {
 	// args[0] = tableName
 	// args[1...n] list of PKs
+	// args[n+1] = (optional) the snapshot to read, see splitSnapshot
+	args, snapshot := splitSnapshot(args)
 	tableName := args[0].(string)
 	primaryKeys := args[1:]
 
 	// if table exists
-	if table, ok := n.TableMap[tableName]; ok {
+	if table, ok := n.getTable(tableName); ok {
 		reply.Schema = *table.schema
-		rowIterator, _ := n.IterateTable(tableName)
+		rowIterator := n.snapshotRowIterator(table, tableName, snapshot)
 
 		// get all rows one by one
 		for rowIterator.HasNext() {
@@ -255,42 +445,43 @@ func (n *Node) FilterTableWithPKs(args []interface{}, reply *Dataset) {
 		}
 
 	} else {
-		reply = nil
+		return noSuchTableError(tableName)
 	}
-
+	return nil
 }
 
 // IterateTable returns the count of rows in a table. It returns (cnt, nil) if the Table can be found, or (-1, err)
 // if the Table does not exist.
 func (n *Node) count(tableName string) (int, error) {
-	if t, ok := n.TableMap[tableName]; ok {
+	if t, ok := n.getTable(tableName); ok {
 		return t.Count(), nil
 	} else {
-		return -1, errors.New("no such table")
+		return -1, noSuchTableError(tableName)
 	}
 }
 
-// ScanTable returns all rows in a table by the specified name or nothing if it does not exist.
+// ScanTable returns all rows in a table by the specified name or an error if it does not exist.
 // This method is recommended only to be used for TEST PURPOSE, and try not to use this method in your implementation,
 // but you can use it in your own test cases.
 // The reason why we deprecate this method is that in practice, every table is so large that you cannot transfer a whole
 // table through network all at once, so sending a whole table in one RPC is very impractical. One recommended way is to
 // fetch a batch of Rows a time.
-func (n *Node) ScanTable(tableName string, dataset *Dataset) {
-	if t, ok := n.TableMap[tableName]; ok {
+func (n *Node) ScanTable(tableName string, dataset *Dataset) error {
+	if t, ok := n.getTable(tableName); ok {
 		resultSet := Dataset{}
 
-		tableRows := make([]Row, t.Count())
-		i := 0
+		// the rows may be written while they are iterated, so the count is only a hint of the capacity
+		tableRows := make([]Row, 0, t.Count())
 		iterator := t.RowIterator()
 		for iterator.HasNext() {
 			// Skip the un-partitioned table row idx of each row
-			tableRows[i] = (*iterator.Next())[1:]
-			i = i + 1
+			tableRows = append(tableRows, (*iterator.Next())[1:])
 		}
 
 		resultSet.Rows = tableRows
 		resultSet.Schema = *t.schema
 		*dataset = resultSet
+		return nil
 	}
+	return noSuchTableError(tableName)
 }
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"sort"
+)
+
+// OrderBy sorts rows by a column, in ascending order unless Desc is true.
+type OrderBy struct {
+	Column string
+	Desc   bool
+}
+
+// getRowComparator returns a function that compares two rows following the schema by the columns in orderBy with the
+// semantics of CompareValues, it returns a negative number if the first row comes first, a positive number if the
+// second row comes first, and 0 if their order is undefined.
+func getRowComparator(schema TableSchema, orderBy []OrderBy) (func(rowA Row, rowB Row) int, error) {
+	colIdxs := make([]int, len(orderBy))
+	colTypes := make([]int, len(orderBy))
+	for i, order := range orderBy {
+		colIdxs[i], colTypes[i] = schema.GetColumnByName(order.Column)
+		if colIdxs[i] == -1 {
+			return nil, newError(ErrSchemaMismatch, "column "+order.Column+" to order by doesn't exist")
+		}
+	}
+
+	return func(rowA Row, rowB Row) int {
+		for i, colIdx := range colIdxs {
+			result := CompareValues(colTypes[i], rowA[colIdx], rowB[colIdx])
+			if orderBy[i].Desc {
+				result = -result
+			}
+			if result != 0 {
+				return result
+			}
+		}
+		return 0
+	}, nil
+}
+
+// SortRows sorts the rows of the dataset by the columns in orderBy, rows that are equal on these columns keep their
+// original order.
+func (dataset *Dataset) SortRows(orderBy []OrderBy) error {
+	compareRows, err := getRowComparator(dataset.Schema, orderBy)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(dataset.Rows, func(i, j int) bool {
+		return compareRows(dataset.Rows[i], dataset.Rows[j]) < 0
+	})
+	return nil
+}
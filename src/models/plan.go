@@ -0,0 +1,449 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// operators of the steps in a physical plan
+const (
+	// fetch a full table by merging the fragments read from the nodes chosen by SetCover
+	PlanTableScan = "TableScan"
+	// merge the fragments of a table on a single node
+	PlanFragmentScan = "FragmentScan"
+	// join datasets at the coordinator by matching all common columns
+	PlanNaturalJoin = "NaturalJoin"
+	// union the partial joins of co-located fragments
+	PlanCoLocatedJoin = "CoLocatedJoin"
+	// join the co-located fragments of the tables on a single node
+	PlanNodeJoin = "NodeJoin"
+	// reduce table1 by the join values of table2
+	PlanSemiJoin = "SemiJoin"
+	// filter a fragment holding the join column by the join values
+	PlanFilterByColumn = "FilterByColumn"
+	// filter a fragment without the join column by the row idx of the remaining rows
+	PlanFilterByPKs = "FilterByPKs"
+)
+
+// coordinatorNodeIdx is the node idx of the steps executed by the coordinator itself.
+const coordinatorNodeIdx = -1
+
+// PlanNode is a step of a physical plan. The steps without children issue a single RPC to a node, while the other
+// steps are executed by the coordinator combining the results of their children.
+type PlanNode struct {
+	// one of the Plan* operators
+	Operator string
+	// details of the step, like the table name or the join column
+	Description string
+	// index of the node executing the step, or coordinatorNodeIdx
+	NodeIdx int
+	// the RPC issued to the node
+	Method string
+	// fragments read by the step
+	Fragments []string
+	// replicas not read by the plan, formatted as "fragment@nodeIdx", only filled for the root step
+	PrunedFragments []string
+
+	// estimated number of result rows and bytes sent through the network by the step, including its children
+	EstimatedRows  int64
+	EstimatedBytes int64
+
+	// filled by EXPLAIN ANALYZE
+	Analyzed bool
+	// number of result rows
+	ActualRows int
+	// bytes sent through the network while executing the step, including its children
+	ActualBytes int64
+	// time spent executing the step, including its children
+	Latency time.Duration
+
+	Children []*PlanNode
+}
+
+// planStep binds a PlanNode to the code executing it.
+type planStep struct {
+	node     *PlanNode
+	children []*planStep
+	// run computes the result of the step, usually by executing its children first
+	run func(c *Cluster, step *planStep) (Dataset, error)
+	// input set by the parent step before executing the step, like the values to filter by
+	input interface{}
+	// result of the step after it is executed
+	result Dataset
+	// bytes of the RPCs issued by the step itself, excluding its children
+	bytes int64
+	// other nodes holding the fragment read by a leaf step, which are called if its node is unavailable
+	replicas []int
+	// the snapshot read by the step, see executePlanAtSnapshot
+	snapshot Snapshot
+}
+
+// newPlanStep creates a step and links the nodes of its children.
+func newPlanStep(node *PlanNode, children []*planStep,
+	run func(c *Cluster, step *planStep) (Dataset, error)) *planStep {
+	for _, child := range children {
+		node.Children = append(node.Children, child.node)
+	}
+	return &planStep{node: node, children: children, run: run}
+}
+
+// setChildren replaces the children of a step and their nodes.
+func (step *planStep) setChildren(children []*planStep) {
+	step.children = children
+	step.node.Children = nil
+	for _, child := range children {
+		step.node.Children = append(step.node.Children, child.node)
+		child.setSnapshot(step.snapshot)
+	}
+}
+
+// executePlan executes a step and records its actual rows, bytes and latency in its PlanNode.
+// The bytes are counted per RPC, so the steps executed concurrently (see executePlanSteps) do not count the traffic of
+// each other.
+func (c *Cluster) executePlan(step *planStep) (Dataset, error) {
+	startTime := time.Now()
+	result, err := step.run(c, step)
+	step.node.Latency = time.Since(startTime)
+	step.node.ActualBytes = step.bytes
+	for _, child := range step.children {
+		step.node.ActualBytes += child.node.ActualBytes
+	}
+	step.node.ActualRows = len(result.Rows)
+	step.node.Analyzed = true
+	step.result = result
+	return result, err
+}
+
+// callNodeStep issues the RPC of a leaf step with the given arguments, retrying it if no reply is received (see
+// callNode). The RPCs of the steps only read the fragments, so they can be retried safely. If the node of the step is
+// unavailable, the replicas of the step are called in order, and the node of the step is set to the one that replied.
+// The snapshot of the step is appended to the arguments, which are split by the node (see splitSnapshot).
+func (c *Cluster) callNodeStep(step *planStep, args []interface{}) (Dataset, error) {
+	if step.snapshot != (Snapshot{}) {
+		args = append(append([]interface{}{}, args...), step.snapshot)
+	}
+	var nodeDataset Dataset
+	bytes, err := c.callNode(step.node.NodeIdx, step.node.Method, args, &nodeDataset)
+	for _, nodeIdx := range step.replicas {
+		var unavailableErr *NodeUnavailableError
+		if !errors.As(err, &unavailableErr) {
+			break
+		}
+		step.node.NodeIdx = nodeIdx
+		bytes, err = c.callNode(nodeIdx, step.node.Method, args, &nodeDataset)
+	}
+	step.bytes += bytes
+	return nodeDataset, err
+}
+
+// planTableScan plans fetching a full table, reading the fragments on the nodes chosen by SetCover. If a node turns
+// out to be unavailable, the fragments are read again from the nodes chosen without it (see readWithFailover), and
+// the steps of the plan are replaced by the ones executed at last.
+func (c *Cluster) planTableScan(tableName string, estimator *planEstimator) (*planStep, error) {
+	schema, ok := c.getTableSchema(tableName)
+	if !ok {
+		return nil, noSuchTableError(tableName)
+	}
+
+	// get approximated minimum number of nodes to retrieve table, visiting the nodes in order
+	nodeRules := c.getNodeRules(tableName)
+	nodeIdxs, nodeRuleIdxsMap, err := coverLiveNodes(tableName, nodeRules, nil)
+	if err != nil {
+		return nil, err
+	}
+	children := c.planFragmentScans(tableName, nodeIdxs, nodeRuleIdxsMap, estimator)
+
+	node := &PlanNode{Operator: PlanTableScan, Description: "table " + tableName, NodeIdx: coordinatorNodeIdx}
+	if estimator != nil {
+		node.EstimatedRows = int64(estimator.getTableStatistics(tableName).RowCount)
+		node.EstimatedBytes = sumEstimatedBytes(children)
+	}
+	return newPlanStep(node, children, func(c *Cluster, step *planStep) (Dataset, error) {
+		var nodeDatasets []Dataset
+		isFirstAttempt := true
+		err := c.readWithFailover(tableName, nodeRules, func(nodeIdxs []int, nodeRuleIdxsMap map[int][]int) error {
+			if !isFirstAttempt {
+				// a node failed in the previous attempt
+				step.setChildren(c.planFragmentScans(tableName, nodeIdxs, nodeRuleIdxsMap, estimator))
+			}
+			isFirstAttempt = false
+			// the nodes are read concurrently, and their rows are merged afterwards
+			var err error
+			nodeDatasets, err = c.executePlanSteps(step.children)
+			return err
+		})
+		if err != nil {
+			return Dataset{}, err
+		}
+		// Map of primary key to its row
+		pkRowMap := make(map[interface{}]Row)
+		for _, nodeDataset := range nodeDatasets {
+			nodeDataset.ReconstructTable(pkRowMap, schema, true)
+		}
+
+		result := Dataset{Schema: schema}
+		for _, row := range pkRowMap {
+			result.Rows = append(result.Rows, row)
+		}
+		return result, nil
+	}), nil
+}
+
+// planFragmentScans plans reading the fragments of a table on the given nodes, the fragments of the rules in
+// nodeRuleIdxsMap[nodeIdx] are merged and read at once on each node.
+func (c *Cluster) planFragmentScans(tableName string, nodeIdxs []int, nodeRuleIdxsMap map[int][]int,
+	estimator *planEstimator) []*planStep {
+	schema, _ := c.getTableSchema(tableName)
+	children := make([]*planStep, 0, len(nodeIdxs))
+	for _, nodeIdx := range nodeIdxs {
+		// merge all partitioned table on this node into one
+		mergeTableArgs := []interface{}{schema}
+		fragmentNames := make([]string, 0)
+		for _, ruleIdx := range nodeRuleIdxsMap[nodeIdx] {
+			fragmentNames = append(fragmentNames, getFragmentName(tableName, ruleIdx))
+			mergeTableArgs = append(mergeTableArgs, getFragmentName(tableName, ruleIdx))
+		}
+		node := &PlanNode{
+			Operator:    PlanFragmentScan,
+			Description: "table " + tableName,
+			NodeIdx:     nodeIdx,
+			Method:      "Node.GetMergedTableDataset",
+			Fragments:   fragmentNames,
+		}
+		if estimator != nil {
+			estimator.estimateFragmentScan(node)
+		}
+		children = append(children, newPlanStep(node, nil, func(c *Cluster, step *planStep) (Dataset, error) {
+			return c.callNodeStep(step, mergeTableArgs)
+		}))
+	}
+	return children
+}
+
+// planJoin plans the natural join of the given tables. The tables are joined on the nodes if their fragments are
+// co-located (see planCoLocatedJoin), otherwise they are fetched and joined at the coordinator.
+func (c *Cluster) planJoin(tableNames []string, estimator *planEstimator) (*planStep, error) {
+	if tasks, ok := c.planCoLocatedJoin(tableNames); ok {
+		return c.planCoLocatedJoinTasks(tableNames, tasks, estimator), nil
+	}
+
+	children := make([]*planStep, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		child, err := c.planTableScan(tableName, estimator)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	node := &PlanNode{
+		Operator:    PlanNaturalJoin,
+		Description: "tables " + strings.Join(tableNames, ", "),
+		NodeIdx:     coordinatorNodeIdx,
+	}
+	if estimator != nil {
+		node.EstimatedRows = int64(estimator.estimateJoinedRowCount(tableNames))
+		node.EstimatedBytes = sumEstimatedBytes(children)
+	}
+	return newPlanStep(node, children, func(c *Cluster, step *planStep) (Dataset, error) {
+		datasets, err := c.executePlanSteps(step.children)
+		if err != nil {
+			return Dataset{}, err
+		}
+		datasetPtrs := make([]*Dataset, len(datasets))
+		for i := range datasets {
+			datasetPtrs[i] = &datasets[i]
+		}
+		return c.NaturalJoinDatasets(datasetPtrs)
+	}), nil
+}
+
+// planCoLocatedJoinTasks plans the partial joins of co-located fragments, one step per task.
+func (c *Cluster) planCoLocatedJoinTasks(tableNames []string, tasks []coLocatedJoinTask,
+	estimator *planEstimator) *planStep {
+	children := make([]*planStep, 0, len(tasks))
+	for _, task := range tasks {
+		// joinArgs[2i] = full schema of the i-th table, joinArgs[2i+1] = fragment of the i-th table
+		joinArgs := make([]interface{}, 0, 2*len(tableNames))
+		for tableIdx, tableName := range tableNames {
+			schema, _ := c.getTableSchema(tableName)
+			joinArgs = append(joinArgs, schema, task.fragmentNames[tableIdx])
+		}
+		node := &PlanNode{
+			Operator:    PlanNodeJoin,
+			Description: "tables " + strings.Join(tableNames, ", "),
+			NodeIdx:     task.nodeIdx,
+			Method:      "Node.JoinFragments",
+			Fragments:   task.fragmentNames,
+		}
+		if estimator != nil {
+			estimator.estimateNodeJoin(node, tableNames)
+		}
+		children = append(children, newPlanStep(node, nil, func(c *Cluster, step *planStep) (Dataset, error) {
+			return c.callNodeStep(step, joinArgs)
+		}))
+	}
+
+	node := &PlanNode{
+		Operator:    PlanCoLocatedJoin,
+		Description: "tables " + strings.Join(tableNames, ", "),
+		NodeIdx:     coordinatorNodeIdx,
+	}
+	if estimator != nil {
+		node.EstimatedRows = int64(estimator.estimateJoinedRowCount(tableNames))
+		node.EstimatedBytes = sumEstimatedBytes(children)
+	}
+	return newPlanStep(node, children, func(c *Cluster, step *planStep) (Dataset, error) {
+		return c.coLocatedJoin(tableNames, step.children)
+	})
+}
+
+// planSemiJoin plans reducing table1 by table2 on the given column. The first child of the returned step fetches
+// table2, and the others filter the fragments of table1.
+func (c *Cluster) planSemiJoin(onJoinColName string, table1Name string, table2Name string,
+	estimator *planEstimator) (*planStep, error) {
+	table1Schema, _ := c.getTableSchema(table1Name)
+	table2Schema, _ := c.getTableSchema(table2Name)
+
+	// short circuit and return if both tables doesn't have the column to join on
+	if table1Schema.GetColIndexByName(onJoinColName) == -1 || table2Schema.GetColIndexByName(onJoinColName) == -1 {
+		return nil, newError(ErrSchemaMismatch, "Column to join doesn't exist in both table")
+	}
+
+	// get full dataset for table2 (filter table)
+	table2Scan, err := c.planTableScan(table2Name, estimator)
+	if err != nil {
+		return nil, err
+	}
+	filterSteps := c.planReduceTableByColumn(table1Name, onJoinColName, "Node.FilterTableWithColumnValues")
+	if estimator != nil {
+		estimator.estimateReduceTableByColumn(filterSteps, table1Name, table2Name, onJoinColName)
+	}
+
+	node := &PlanNode{
+		Operator:    PlanSemiJoin,
+		Description: fmt.Sprintf("%s by %s on %s", table1Name, table2Name, onJoinColName),
+		NodeIdx:     coordinatorNodeIdx,
+	}
+	children := append([]*planStep{table2Scan}, filterSteps...)
+	if estimator != nil {
+		stats1 := estimator.getTableStatistics(table1Name)
+		node.EstimatedRows = int64(float64(stats1.RowCount) *
+			estimator.estimateSelectivity(table1Name, table2Name, onJoinColName))
+		node.EstimatedBytes = sumEstimatedBytes(children)
+	}
+	return newPlanStep(node, children, func(c *Cluster, step *planStep) (Dataset, error) {
+		dataset2, err := c.executePlan(step.children[0])
+		if err != nil {
+			return Dataset{}, err
+		}
+
+		// a hashmap storing the possible values in the on-join column in table 2
+		srcColIndex := table2Schema.GetColIndexByName(onJoinColName)
+		possibleJoinValueSet := make(ValueSet)
+		for _, row := range dataset2.Rows {
+			possibleJoinValueSet[row[srcColIndex]] = true
+		}
+
+		pkRowMap, err := c.executeReduceTableByColumn(step.children[1:], table1Schema, possibleJoinValueSet)
+		if err != nil {
+			return Dataset{}, err
+		}
+		reducedDataset1 := Dataset{Schema: table1Schema}
+		for _, row := range pkRowMap {
+			reducedDataset1.Rows = append(reducedDataset1.Rows, row)
+		}
+		return reducedDataset1, nil
+	}), nil
+}
+
+// planReduceTableByColumn plans filtering each fragment of a table, reading any replica of it. Fragments holding the
+// column are filtered by calling filterMethod on the nodes, and the others by the row idx of the remaining rows.
+func (c *Cluster) planReduceTableByColumn(tableName string, colName string, filterMethod string) []*planStep {
+	columnSteps := make([]*planStep, 0)
+	pkSteps := make([]*planStep, 0)
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		fragmentName := getFragmentName(tableName, nodeRule.Rule.RuleIdx)
+		nodeIdxs := parseNodeIndices(nodeRule.NodeIndices)
+		node := &PlanNode{
+			Description: "table " + tableName,
+			NodeIdx:     nodeIdxs[0],
+			Fragments:   []string{fragmentName},
+		}
+
+		if nodeRule.Rule.HasColumn(colName) {
+			node.Operator = PlanFilterByColumn
+			node.Description += " on " + colName
+			node.Method = filterMethod
+			step := newPlanStep(node, nil, func(c *Cluster, step *planStep) (Dataset, error) {
+				return c.callNodeStep(step, []interface{}{fragmentName, colName, step.input})
+			})
+			step.replicas = nodeIdxs[1:]
+			columnSteps = append(columnSteps, step)
+		} else {
+			node.Operator = PlanFilterByPKs
+			node.Method = "Node.FilterTableWithPKs"
+			step := newPlanStep(node, nil, func(c *Cluster, step *planStep) (Dataset, error) {
+				// filterByPKArgs[0] = fragment name, filterByPKArgs[1...n] = row idx of the remaining rows
+				filterByPKArgs := append([]interface{}{fragmentName}, step.input.([]interface{})...)
+				return c.callNodeStep(step, filterByPKArgs)
+			})
+			step.replicas = nodeIdxs[1:]
+			pkSteps = append(pkSteps, step)
+		}
+	}
+	return append(columnSteps, pkSteps...)
+}
+
+// executeReduceTableByColumn executes the steps of planReduceTableByColumn with the given filter, and returns the
+// remaining rows of the table keyed by their row idx.
+func (c *Cluster) executeReduceTableByColumn(steps []*planStep, tableSchema TableSchema,
+	filter interface{}) (map[interface{}]Row, error) {
+	// the steps filtering by columns come first, so all the remaining row idx are known after them
+	pkStepsIdx := len(steps)
+	for i, step := range steps {
+		if step.node.Operator == PlanFilterByPKs {
+			pkStepsIdx = i
+			break
+		}
+		step.input = filter
+	}
+
+	pkRowMap := make(map[interface{}]Row)
+	nodeDatasets, err := c.executePlanSteps(steps[:pkStepsIdx])
+	if err != nil {
+		return nil, err
+	}
+	for _, nodeDataset := range nodeDatasets {
+		nodeDataset.ReconstructTable(pkRowMap, tableSchema, true)
+	}
+	if pkStepsIdx == len(steps) {
+		return pkRowMap, nil
+	}
+
+	pks := make([]interface{}, 0, len(pkRowMap))
+	for pk := range pkRowMap {
+		pks = append(pks, pk)
+	}
+	for _, step := range steps[pkStepsIdx:] {
+		step.input = pks
+	}
+	if nodeDatasets, err = c.executePlanSteps(steps[pkStepsIdx:]); err != nil {
+		return nil, err
+	}
+	for _, nodeDataset := range nodeDatasets {
+		nodeDataset.ReconstructTable(pkRowMap, tableSchema, true)
+	}
+	return pkRowMap, nil
+}
+
+// sumEstimatedBytes returns the total estimated bytes of the given steps.
+func sumEstimatedBytes(steps []*planStep) int64 {
+	var bytes int64
+	for _, step := range steps {
+		bytes += step.node.EstimatedBytes
+	}
+	return bytes
+}
@@ -0,0 +1,127 @@
+package models
+
+// Predicates are written like the predicates of partition rules: Predicate[colName] -> [Condition1, Condition2, ...],
+// and a row satisfies a predicate if it satisfies every condition.
+
+// operators of conditions checking whether a value is in the ValueSet of the condition, so that the values of a
+// subquery are shipped to the nodes like the join values of SemiJoin
+const (
+	OpIn    = "IN"
+	OpNotIn = "NOT IN"
+)
+
+// SatisfiesPredicate returns true if the row (following the schema) satisfies all conditions in the predicate.
+// A nil value does not satisfy any condition.
+func (r *Row) SatisfiesPredicate(schema TableSchema, predicate map[string][]Condition) bool {
+	for colName, conditions := range predicate {
+		colIdx := schema.GetColIndexByName(colName)
+		if colIdx == -1 || (*r)[colIdx] == nil {
+			return false
+		}
+		if !r.SatisfiesColumnConditions(schema, colName, conditions) {
+			return false
+		}
+	}
+	return true
+}
+
+// isConditionsSatisfiable returns false if no value of the data type can satisfy all the conditions. Only the bounds
+// given by the conditions are checked, so it may return true for conditions like (> 1, < 2) on integers.
+func isConditionsSatisfiable(dataType int, conditions []Condition) bool {
+	var lower, upper interface{}
+	hasLower, hasUpper := false, false
+	lowerStrict, upperStrict := false, false
+	notEqualVals := make([]interface{}, 0)
+
+	for _, condition := range conditions {
+		val := condition.Val
+		switch condition.Op {
+		case "==", ">=", ">":
+			strict := condition.Op == ">"
+			if !hasLower || CompareValues(dataType, val, lower) > 0 {
+				lower, lowerStrict = val, strict
+			} else if CompareValues(dataType, val, lower) == 0 {
+				lowerStrict = lowerStrict || strict
+			}
+			hasLower = true
+		}
+		switch condition.Op {
+		case "==", "<=", "<":
+			strict := condition.Op == "<"
+			if !hasUpper || CompareValues(dataType, val, upper) < 0 {
+				upper, upperStrict = val, strict
+			} else if CompareValues(dataType, val, upper) == 0 {
+				upperStrict = upperStrict || strict
+			}
+			hasUpper = true
+		case "!=":
+			notEqualVals = append(notEqualVals, val)
+		case OpIn:
+			if len(val.(ValueSet)) == 0 {
+				return false
+			}
+		}
+	}
+
+	if !hasLower || !hasUpper {
+		return true
+	}
+	order := CompareValues(dataType, lower, upper)
+	if order > 0 || (order == 0 && (lowerStrict || upperStrict)) {
+		return false
+	}
+	// a single possible value is excluded
+	if order == 0 {
+		for _, val := range notEqualVals {
+			if CompareValues(dataType, val, lower) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// arePredicatesDisjoint returns true if no row of the table can satisfy both predicates, i.e., some column is
+// restricted by the two predicates to disjoint ranges, or to an empty set of values. It may return false for some
+// disjoint predicates.
+func arePredicatesDisjoint(schema TableSchema, predicate1 map[string][]Condition,
+	predicate2 map[string][]Condition) bool {
+	for colName, conditions1 := range predicate1 {
+		conditions2 := predicate2[colName]
+		colType := schema.GetColTypeByName(colName)
+		conditions := make([]Condition, 0, len(conditions1)+len(conditions2))
+		conditions = append(conditions, conditions1...)
+		conditions = append(conditions, conditions2...)
+		if !isConditionsSatisfiable(colType, conditions) {
+			return true
+		}
+	}
+	// a predicate that cannot be satisfied by itself is disjoint with any predicate
+	for colName, conditions2 := range predicate2 {
+		if _, ok := predicate1[colName]; !ok && !isConditionsSatisfiable(schema.GetColTypeByName(colName), conditions2) {
+			return true
+		}
+	}
+	return false
+}
+
+// getQueriedNodeRules returns the node rules of a table whose fragments may hold rows satisfying the predicate, the
+// other fragments are pruned. It returns false if some of these fragments do not hold all the given columns, so that
+// the query cannot be answered by each fragment alone.
+func (c *Cluster) getQueriedNodeRules(tableName string, predicate map[string][]Condition,
+	colNames []string) ([]NodeRule, bool) {
+	schema, _ := c.getTableSchema(tableName)
+	nodeRules := make([]NodeRule, 0)
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		if arePredicatesDisjoint(schema, nodeRule.Rule.Predicate, predicate) {
+			continue
+		}
+		for _, colName := range colNames {
+			if !nodeRule.Rule.HasColumn(colName) {
+				return nil, false
+			}
+		}
+		nodeRules = append(nodeRules, nodeRule)
+	}
+	return nodeRules, true
+}
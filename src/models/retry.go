@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryPolicy describes how the coordinator calls a node, as the network may lose a request or its reply.
+type RetryPolicy struct {
+	// maximum number of attempts of a call, including the first one
+	MaxAttempts int
+	// time to wait for the reply of each attempt
+	Timeout time.Duration
+	// time to wait before the first retry, it is doubled before each further retry
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy of a new cluster.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, Timeout: 500 * time.Millisecond, Backoff: 5 * time.Millisecond}
+
+// withoutRetries returns a copy of the policy that only makes one attempt. A lost reply cannot be told apart from a
+// lost request, so a call that should not be executed twice, like inserting a row, is not retried.
+func (policy RetryPolicy) withoutRetries() RetryPolicy {
+	policy.MaxAttempts = 1
+	return policy
+}
+
+// NodeUnavailableError is returned when a node gives no reply to any attempt of a call.
+type NodeUnavailableError struct {
+	NodeIdx  int
+	Method   string
+	Attempts int
+}
+
+func (err *NodeUnavailableError) Error() string {
+	return fmt.Sprintf("%s on Node%d got no reply after %d attempts", err.Method, err.NodeIdx, err.Attempts)
+}
+
+// ErrorCode implements labrpc.ErrorCoder, see ErrUnavailable.
+func (err *NodeUnavailableError) ErrorCode() string {
+	return string(ErrUnavailable)
+}
+
+// SetRetryPolicy changes how the coordinator calls the nodes, see RetryPolicy.
+func (c *Cluster) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicyMu.Lock()
+	defer c.retryPolicyMu.Unlock()
+	c.retryPolicy = policy
+}
+
+// getRetryPolicy returns the current retry policy of the cluster, see SetRetryPolicy.
+func (c *Cluster) getRetryPolicy() RetryPolicy {
+	c.retryPolicyMu.RLock()
+	defer c.retryPolicyMu.RUnlock()
+	return c.retryPolicy
+}
+
+// callNode calls a method on a node, retrying by the retry policy of the cluster if no reply is received. It returns
+// the bytes of the request and the reply of the successful attempt, and the error returned by the method, or a
+// NodeUnavailableError if every attempt fails. An error returned by the method is not retried.
+func (c *Cluster) callNode(nodeIdx int, method string, args interface{}, reply interface{}) (int64, error) {
+	return c.callNodeWithPolicy(c.getRetryPolicy(), nodeIdx, method, args, reply)
+}
+
+// callNodeWithPolicy is callNode with the given retry policy.
+func (c *Cluster) callNodeWithPolicy(policy RetryPolicy, nodeIdx int, method string, args interface{},
+	reply interface{}) (int64, error) {
+	end := c.getNodeEnd(nodeIdx)
+	backoff := policy.Backoff
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), policy.Timeout)
+		ok, bytes, err := end.CallWithBytes(ctx, method, args, reply)
+		cancel()
+		if ok {
+			return bytes, err
+		}
+	}
+	return 0, &NodeUnavailableError{NodeIdx: nodeIdx, Method: method, Attempts: policy.MaxAttempts}
+}
+
+// callReplica calls a method on any node holding the fragment of the given node rule. The nodes are tried in order,
+// failing over to the next replica if a node gives no reply after its retries (see callNode).
+func (c *Cluster) callReplica(nodeRule NodeRule, method string, args interface{}, reply interface{}) error {
+	var err error = newError(ErrInternal, fmt.Sprintf("%s found no node in %q", method, nodeRule.NodeIndices))
+	for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+		_, err = c.callNode(nodeIdx, method, args, reply)
+		var unavailableErr *NodeUnavailableError
+		if !errors.As(err, &unavailableErr) {
+			return err
+		}
+	}
+	return err
+}
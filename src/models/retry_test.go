@@ -0,0 +1,106 @@
+package models
+
+import (
+	"../labrpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a short retry policy, so that the calls to deleted nodes fail fast
+var testRetryPolicy = RetryPolicy{MaxAttempts: 2, Timeout: 50 * time.Millisecond, Backoff: time.Millisecond}
+
+func TestRetryUnreliableNetwork(t *testing.T) {
+	joinStrategySetup()
+
+	expectedDataset := Dataset{}
+	if err := c.GetFullTableDataset(studentTableName, &expectedDataset); err != nil {
+		t.Fatal(err)
+	}
+	expectedSemiJoin := Dataset{}
+	cli.Call("Cluster.SemiJoin", []string{"sid", studentTableName, courseRegistrationTableName}, &expectedSemiJoin)
+
+	// the requests and replies between the coordinator and the nodes are dropped at random, the client is called
+	// directly, so that its own calls are not dropped
+	network.Reliable(false)
+	defer network.Reliable(true)
+	for i := 0; i < 5; i++ {
+		dataset := Dataset{}
+		if err := c.GetFullTableDataset(studentTableName, &dataset); err != nil {
+			t.Fatal(err)
+		}
+		if !compareDataset(dataset, expectedDataset) {
+			t.Fatalf("Incorrect student table on an unreliable network, expected %d rows, actual %d",
+				len(expectedDataset.Rows), len(dataset.Rows))
+		}
+
+		semiJoin := Dataset{}
+		c.SemiJoin([]string{"sid", studentTableName, courseRegistrationTableName}, &semiJoin)
+		if !compareDataset(semiJoin, expectedSemiJoin) {
+			t.Fatalf("Incorrect semi-join results on an unreliable network, expected %d rows, actual %d",
+				len(expectedSemiJoin.Rows), len(semiJoin.Rows))
+		}
+	}
+}
+
+func TestRetryFailsOverToReplica(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+
+	// the fragments on node 1 are also held by node 0 or node 2
+	network.DeleteServer("Node1")
+	pkRowMap, _, err := c.projectTable(studentTableName, []string{"sid", "name"}, nil, false, Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkRowMap) != len(studentRows) {
+		t.Errorf("Expected %d students from the replicas, actual %v", len(studentRows), pkRowMap)
+	}
+
+	// courseRegistration is only held by node 2
+	network.DeleteServer("Node2")
+	if _, _, err := c.projectTable(courseRegistrationTableName, []string{"sid"}, nil, false, Snapshot{}); err == nil {
+		t.Errorf("Expected an error as no replica of courseRegistration replies")
+	}
+}
+
+func TestRetryReturnsError(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+	network.DeleteServer("Node2")
+
+	results := Dataset{}
+	cli.Call("Cluster.Select", SelectQuery{TableName: courseRegistrationTableName}, &results)
+	if len(results.Schema.ColumnSchemas) != 0 {
+		t.Errorf("The query should fail as node 2 is down, actual results %v", results)
+	}
+
+	// the table is kept, so that it can be dropped again when node 2 is back
+	reply := ""
+	cli.Call("Cluster.DropTable", courseRegistrationTableName, &reply)
+	if _, ok := c.TableSchemasMap[courseRegistrationTableName]; !ok || reply != "" {
+		t.Errorf("Dropping a table on node 2 should fail, actual reply %s", reply)
+	}
+}
+
+func TestRetryPolicyChangedConcurrently(t *testing.T) {
+	setOperationSetup()
+
+	// the policy is changed while the clients read the nodes, which is checked by the race detector
+	var wg sync.WaitGroup
+	for _, client := range makeClients(concurrentClientCount) {
+		wg.Add(1)
+		go func(client *labrpc.ClientEnd) {
+			defer wg.Done()
+			results := Dataset{}
+			if err := client.CallWithError("Cluster.Select", SelectQuery{TableName: studentTableName},
+				&results); err != nil {
+				t.Error(err)
+			}
+		}(client)
+	}
+	for i := 0; i < concurrentClientCount; i++ {
+		c.SetRetryPolicy(DefaultRetryPolicy)
+	}
+	wg.Wait()
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"container/list"
+	"math"
+	"sync"
 )
 
 // Row is just an array of objects
@@ -49,6 +51,8 @@ func (r *Row) SatisfiesColumnConditions(schema TableSchema, colName string, cond
 // RowStore manages the storage of rows and provide simple read-write interfaces.
 // Notice that the store does not guarantee any constraints, and it is the responsibility of the caller to check
 // constraints like primary key and uniqueness before calling the methods in RowStore.
+// The methods of a store and its iterators are safe for concurrent use, an iterator sees the rows inserted after it is
+// created if it has not passed them yet.
 type RowStore interface {
 	count() int
 	iterator() RowIterator
@@ -67,6 +71,8 @@ type RowIterator interface {
 // MemoryListRowStore uses a linked list to store rows in memory.
 type MemoryListRowStore struct {
 	rows *list.List
+	// guards rows, the iterators hold the read lock while they move
+	mu sync.RWMutex
 }
 
 func NewMemoryListRowStore() *MemoryListRowStore {
@@ -74,18 +80,24 @@ func NewMemoryListRowStore() *MemoryListRowStore {
 }
 
 func (s *MemoryListRowStore) count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.rows.Len()
 }
 
 func (s *MemoryListRowStore) iterator() RowIterator {
-	return NewMemoryListRowIterator(s.rows)
+	return NewMemoryListRowIterator(s.rows, &s.mu)
 }
 
 func (s *MemoryListRowStore) insert(row *Row) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.rows.PushBack(*row)
 }
 
 func (s *MemoryListRowStore) remove(row *Row) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	curr := s.rows.Front()
 	for curr != nil {
 		// find the first row that equals the argument
@@ -101,18 +113,26 @@ func (s *MemoryListRowStore) remove(row *Row) {
 type MemoryListRowIterator struct {
 	next *list.Element
 	rows *list.List
+	// the lock of the store of rows
+	mu *sync.RWMutex
 }
 
-func NewMemoryListRowIterator(rows *list.List) RowIterator {
-	iter := &MemoryListRowIterator{rows.Front(), rows}
+func NewMemoryListRowIterator(rows *list.List, mu *sync.RWMutex) RowIterator {
+	mu.RLock()
+	defer mu.RUnlock()
+	iter := &MemoryListRowIterator{rows.Front(), rows, mu}
 	return iter
 }
 
 func (iter *MemoryListRowIterator) HasNext() bool {
+	iter.mu.RLock()
+	defer iter.mu.RUnlock()
 	return iter.next != nil
 }
 
 func (iter *MemoryListRowIterator) Next() *Row {
+	iter.mu.RLock()
+	defer iter.mu.RUnlock()
 	if iter.next == nil {
 		return nil
 	} else {
@@ -121,3 +141,159 @@ func (iter *MemoryListRowIterator) Next() *Row {
 		return &t
 	}
 }
+
+// latestTs is the timestamp of reading the latest version of each row, see VersionedRowStore.
+const latestTs int64 = 0
+
+// VersionedRowStore is a RowStore that keeps the versions of its rows, so that the rows can be read as of a timestamp.
+// A version of a row is visible at the timestamps in [createdTs, deletedTs). The methods of RowStore insert and remove
+// the rows at timestamp 0, so such rows are seen at any timestamp, and read the latest versions.
+type VersionedRowStore interface {
+	RowStore
+	insertAt(row *Row, ts int64)
+	// only removes the first visible row at ts that equals to the argument
+	removeAt(row *Row, ts int64)
+	// iterates the rows visible at ts, or the latest versions if ts is latestTs
+	iteratorAt(ts int64) RowIterator
+	// removes the versions removed at or before ts, which are not visible at ts or later, and returns their number
+	prune(ts int64) int
+}
+
+// rowVersion is a version of a row in a MemoryVersionedRowStore.
+type rowVersion struct {
+	row       Row
+	createdTs int64
+	// math.MaxInt64 if the row is not removed
+	deletedTs int64
+	// the version after this one when it is pruned, so that an iterator at this version moves on, see prune
+	prunedNext *list.Element
+}
+
+// isVisibleAt returns true if the version is seen by a read at ts.
+func (version *rowVersion) isVisibleAt(ts int64) bool {
+	if ts == latestTs {
+		return version.deletedTs == math.MaxInt64
+	}
+	return version.createdTs <= ts && ts < version.deletedTs
+}
+
+// MemoryVersionedRowStore uses a linked list to store the versions of rows in memory, the removed versions are kept.
+type MemoryVersionedRowStore struct {
+	versions *list.List
+	// number of versions that are not removed
+	liveCount int
+	// guards the versions and liveCount, the iterators hold the read lock while they move
+	mu sync.RWMutex
+}
+
+func NewMemoryVersionedRowStore() *MemoryVersionedRowStore {
+	return &MemoryVersionedRowStore{versions: list.New()}
+}
+
+func (s *MemoryVersionedRowStore) count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.liveCount
+}
+
+func (s *MemoryVersionedRowStore) iterator() RowIterator {
+	return s.iteratorAt(latestTs)
+}
+
+func (s *MemoryVersionedRowStore) insert(row *Row) {
+	s.insertAt(row, 0)
+}
+
+func (s *MemoryVersionedRowStore) remove(row *Row) {
+	s.removeAt(row, 0)
+}
+
+func (s *MemoryVersionedRowStore) insertAt(row *Row, ts int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions.PushBack(&rowVersion{row: *row, createdTs: ts, deletedTs: math.MaxInt64})
+	s.liveCount++
+}
+
+func (s *MemoryVersionedRowStore) removeAt(row *Row, ts int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for curr := s.versions.Front(); curr != nil; curr = curr.Next() {
+		version := curr.Value.(*rowVersion)
+		if version.deletedTs == math.MaxInt64 && version.row.Equals(row) {
+			version.deletedTs = ts
+			s.liveCount--
+			return
+		}
+	}
+}
+
+// prune keeps the last version in the list, so that the version after a pruned one is never nil, and an iterator at a
+// pruned version still sees the versions inserted later.
+func (s *MemoryVersionedRowStore) prune(ts int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prunedCount := 0
+	for curr := s.versions.Front(); curr != nil && curr != s.versions.Back(); {
+		next := curr.Next()
+		if version := curr.Value.(*rowVersion); version.deletedTs <= ts {
+			version.prunedNext = next
+			s.versions.Remove(curr)
+			prunedCount++
+		}
+		curr = next
+	}
+	return prunedCount
+}
+
+func (s *MemoryVersionedRowStore) iteratorAt(ts int64) RowIterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	iter := &MemoryVersionedRowIterator{next: s.versions.Front(), ts: ts, mu: &s.mu}
+	iter.skipInvisible()
+	return iter
+}
+
+// MemoryVersionedRowIterator iterates the versions of rows visible at a timestamp in a MemoryVersionedRowStore.
+type MemoryVersionedRowIterator struct {
+	next *list.Element
+	ts   int64
+	// the lock of the store of versions
+	mu *sync.RWMutex
+}
+
+// skipInvisible moves next to the first version visible at the timestamp, a pruned version is left by the version
+// after it when it was pruned. The caller should hold the read lock.
+func (iter *MemoryVersionedRowIterator) skipInvisible() {
+	for iter.next != nil {
+		version := iter.next.Value.(*rowVersion)
+		if version.prunedNext != nil {
+			iter.next = version.prunedNext
+		} else if version.isVisibleAt(iter.ts) {
+			return
+		} else {
+			iter.next = iter.next.Next()
+		}
+	}
+}
+
+func (iter *MemoryVersionedRowIterator) HasNext() bool {
+	iter.mu.RLock()
+	defer iter.mu.RUnlock()
+	// a version may be removed after the iterator moved to it
+	iter.skipInvisible()
+	return iter.next != nil
+}
+
+func (iter *MemoryVersionedRowIterator) Next() *Row {
+	iter.mu.RLock()
+	defer iter.mu.RUnlock()
+	iter.skipInvisible()
+	if iter.next == nil {
+		return nil
+	}
+	row := iter.next.Value.(*rowVersion).row
+	iter.next = iter.next.Next()
+	iter.skipInvisible()
+	return &row
+}
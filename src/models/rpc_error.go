@@ -0,0 +1,83 @@
+package models
+
+import (
+	"../labrpc"
+	"errors"
+)
+
+// ErrorCode classifies the errors returned by the RPC methods of Node and Cluster, so that a caller can handle them
+// without parsing their messages. The code of an error is sent back to the caller with it (see labrpc.Error), and
+// ErrorCodeOf gets the code of the error returned by a call.
+type ErrorCode string
+
+const (
+	// the table or the fragment does not exist
+	ErrNoSuchTable ErrorCode = "NoSuchTable"
+	// another table with the same name already exists
+	ErrTableExists ErrorCode = "TableExists"
+	// the columns or the values do not match the schema of the table
+	ErrSchemaMismatch ErrorCode = "SchemaMismatch"
+	// another row with the same key already exists
+	ErrDuplicateKey ErrorCode = "DuplicateKey"
+	// the arguments are malformed, or the request is not supported
+	ErrInvalidArgument ErrorCode = "InvalidArgument"
+	// a node holding the data gives no reply, see NodeUnavailableError
+	ErrUnavailable ErrorCode = "Unavailable"
+	// the transaction is aborted to break a deadlock, see findDeadlockVictim
+	ErrDeadlock ErrorCode = "Deadlock"
+	// another undecided transaction writes the same row, so the write is rejected and may be retried after that
+	// transaction is decided, see Node.Prepare
+	ErrConflict ErrorCode = "Conflict"
+	// the transaction is rolled back as it was idle or waited for a lock for too long, see txnIdleTimeout and
+	// lockWaitTimeout
+	ErrTimeout ErrorCode = "Timeout"
+	// any other error
+	ErrInternal ErrorCode = "Internal"
+)
+
+// Error is an error with an ErrorCode, returned by the RPC methods of Node and Cluster.
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+// newError creates an Error with the given code and message.
+func newError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// noSuchTableError returns the error of a request on a table that does not exist.
+func noSuchTableError(tableName string) *Error {
+	return newError(ErrNoSuchTable, "table "+tableName+" doesn't exist")
+}
+
+// missingColumnError returns the error of a request on a column that the table does not have.
+func missingColumnError(tableName string, colName string) *Error {
+	return newError(ErrSchemaMismatch, "column "+colName+" doesn't exist in table "+tableName)
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+// ErrorCode implements labrpc.ErrorCoder, so that the code is sent back to the caller with the error.
+func (err *Error) ErrorCode() string {
+	return string(err.Code)
+}
+
+// ErrorCodeOf returns the code of an error returned by an RPC method of Node or Cluster, either by calling the method
+// directly or through the network. It returns ErrUnavailable if no reply is received (see labrpc.ErrNoReply),
+// ErrInternal if the error has no code, or an empty code if err is nil.
+func ErrorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, labrpc.ErrNoReply) {
+		return ErrUnavailable
+	}
+	var coder labrpc.ErrorCoder
+	if errors.As(err, &coder) && coder.ErrorCode() != "" {
+		return ErrorCode(coder.ErrorCode())
+	}
+	return ErrInternal
+}
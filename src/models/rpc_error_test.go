@@ -0,0 +1,101 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// checkErrorCode checks that a call failed with an error of the expected code.
+func checkErrorCode(t *testing.T, name string, err error, expectedCode ErrorCode) {
+	if code := ErrorCodeOf(err); code != expectedCode {
+		t.Errorf("%s should fail with %s, actual %q (%v)", name, expectedCode, code, err)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	setOperationSetup()
+
+	results := Dataset{}
+	err := cli.CallWithError("Cluster.Select", SelectQuery{TableName: "teacher"}, &results)
+	checkErrorCode(t, "Selecting from a table that does not exist", err, ErrNoSuchTable)
+	if len(results.Schema.ColumnSchemas) != 0 || len(results.Rows) != 0 {
+		t.Errorf("The reply should be left untouched on error, actual %v", results)
+	}
+
+	err = cli.CallWithError("Cluster.Select", SelectQuery{TableName: studentTableName, Columns: []string{"title"}},
+		&results)
+	checkErrorCode(t, "Selecting a column that does not exist", err, ErrSchemaMismatch)
+
+	err = cli.CallWithError("Cluster.Aggregate", AggregateQuery{TableName: studentTableName,
+		Aggregates: []Aggregate{{Func: "MEDIAN", Column: "grade"}}}, &results)
+	checkErrorCode(t, "An unknown aggregate", err, ErrInvalidArgument)
+
+	reply := ""
+	err = cli.CallWithError("Cluster.BuildTable", []interface{}{*studentTableSchema, studentTablePartitionRules},
+		&reply)
+	checkErrorCode(t, "Building a table again", err, ErrTableExists)
+
+	err = cli.CallWithError("Cluster.FragmentWrite", []interface{}{studentTableName, Row{5, "Ann"}}, &reply)
+	checkErrorCode(t, "Writing a row with missing columns", err, ErrSchemaMismatch)
+	err = cli.CallWithError("Cluster.FragmentWrite", []interface{}{studentTableName, Row{9, "x", 1, "bad"}}, &reply)
+	checkErrorCode(t, "Writing a value of the wrong type", err, ErrSchemaMismatch)
+	insertedCount := 0
+	err = cli.CallWithError("Cluster.BulkInsert", BulkInsert{TableName: studentTableName,
+		Rows: []Row{{9, "x", 1, 3.0}, {10, 7, 1, 3.0}}}, &insertedCount)
+	checkErrorCode(t, "Bulk inserting a value of the wrong type", err, ErrSchemaMismatch)
+
+	err = cli.CallWithError("Cluster.DropTable", "teacher", &reply)
+	checkErrorCode(t, "Dropping a table that does not exist", err, ErrNoSuchTable)
+
+	// the first student (row idx 0) is held by the fragment of the students with grade > 3.6 on node 2
+	for _, nodeRule := range c.TableNodeRulesMap[studentTableName] {
+		if nodeRule.NodeIndices == "1|2" {
+			fragmentName := getFragmentName(studentTableName, nodeRule.Rule.RuleIdx)
+			err = c.getNodeEnd(2).CallWithError("Node.FragmentWrite",
+				[]interface{}{fragmentName, Row{0, 0, "John", 22, 4.0}}, &reply)
+			checkErrorCode(t, "Writing a row idx twice", err, ErrDuplicateKey)
+		}
+	}
+
+	// the table is not changed by the failed requests
+	dataset := Dataset{}
+	if err := c.GetFullTableDataset(studentTableName, &dataset); err != nil {
+		t.Fatal(err)
+	}
+	expectedDataset := Dataset{Schema: *studentTableSchema, Rows: studentRows}
+	if !compareDataset(dataset, expectedDataset) {
+		t.Errorf("Incorrect student table after the failed requests, expected %v, actual %v", expectedDataset, dataset)
+	}
+}
+
+func TestErrorCodeUnavailable(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+	network.DeleteServer("Node2")
+
+	// courseRegistration is only held by node 2
+	results := Dataset{}
+	err := cli.CallWithError("Cluster.Select", SelectQuery{TableName: courseRegistrationTableName}, &results)
+	checkErrorCode(t, "Selecting from a table on a deleted node", err, ErrUnavailable)
+
+	// both replicas of the fragment of the students with grade > 3.6 are lost
+	network.DeleteServer("Node1")
+	err = cli.CallWithError("Cluster.Join", []string{studentTableName, courseRegistrationTableName}, &results)
+	checkErrorCode(t, "Joining tables without live replicas", err, ErrUnavailable)
+}
+
+func TestErrorCodeInvalidRules(t *testing.T) {
+	setupLab3()
+
+	reply := ""
+	err := cli.CallWithError("Cluster.BuildTable", []interface{}{*studentTableSchema, []byte("{")}, &reply)
+	checkErrorCode(t, "Building a table with malformed rules", err, ErrInvalidArgument)
+
+	// the table is not created, so it can be built again with valid rules
+	rules, _ := json.Marshal(map[string]interface{}{
+		"0": gradeRule(">", 0.0, "sid", "name", "age", "grade"),
+	})
+	if err := cli.CallWithError("Cluster.BuildTable", []interface{}{*studentTableSchema, rules}, &reply); err != nil {
+		t.Errorf("Building the table with valid rules failed: %v", err)
+	}
+}
@@ -16,3 +16,13 @@ type NodeRule struct {
 	Rule        Rule
 	NodeIndices string
 }
+
+// HasColumn returns true if the fragment of the rule holds the column.
+func (rule *Rule) HasColumn(colName string) bool {
+	for _, ruleColName := range rule.Column {
+		if ruleColName == colName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,393 @@
+package models
+
+import (
+	"sort"
+)
+
+// SelectQuery reads the rows of a table that satisfy Where, like
+// SELECT [DISTINCT] Columns... FROM TableName WHERE Where ORDER BY OrderBy... LIMIT Limit OFFSET Offset
+type SelectQuery struct {
+	TableName string
+	// return each distinct row of Columns once, the columns in OrderBy should be in Columns
+	Distinct bool
+	// columns to return, all columns of the table are returned if it is empty
+	Columns []string
+	// Where[colName] -> [Condition1, Condition2, ...], see SatisfiesPredicate
+	Where   map[string][]Condition
+	OrderBy []OrderBy
+	// skip the first Offset rows, and return at most Limit rows if HasLimit is true
+	Offset   int
+	HasLimit bool
+	Limit    int
+	// read within the transaction with this identifier (see Begin), so that the rows written by the transaction are
+	// also returned, otherwise only the committed rows are returned
+	TxnId string
+}
+
+// SortedScan asks a node to return the rows of some fragments of a table that satisfy Where, sorted by OrderBy.
+// The returned rows start with their row idx, followed by the values of Columns, and only the first Limit rows are
+// returned if HasLimit is true.
+type SortedScan struct {
+	TableName string
+	// names of the fragments on the node, each of them should hold all columns in Columns and Where
+	FragmentNames []string
+	Columns       []string
+	Where         map[string][]Condition
+	OrderBy       []OrderBy
+	HasLimit      bool
+	Limit         int
+	// the snapshot to read, see Node.snapshotRowIterator
+	Snapshot Snapshot
+}
+
+// ScanSorted returns the rows of the fragments on this node as described by args. A row held by more than one of the
+// fragments is only returned once, and rows that are equal on OrderBy are sorted by their row idx, so that the
+// coordinator can merge the rows from different nodes consistently.
+func (n *Node) ScanSorted(args SortedScan, reply *Dataset) error {
+	rowIdxColName := getRowIdxColumnName(args.TableName)
+	result := Dataset{}
+	result.Schema.TableName = args.TableName
+	isScanned := make(ValueSet)
+
+	for _, fragmentName := range args.FragmentNames {
+		table, ok := n.getTable(fragmentName)
+		if !ok {
+			return noSuchTableError(fragmentName)
+		}
+		schema := *table.schema
+
+		// indices of returned columns in the rows, shifted by one to skip the row idx
+		colIdxs := make([]int, len(args.Columns))
+		for i, colName := range args.Columns {
+			colIdx := schema.GetColIndexByName(colName)
+			if colIdx == -1 {
+				return missingColumnError(fragmentName, colName)
+			}
+			colIdxs[i] = colIdx + 1
+		}
+		if len(result.Schema.ColumnSchemas) == 0 {
+			result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas,
+				ColumnSchema{Name: rowIdxColName, DataType: TypeInt64})
+			for i, colName := range args.Columns {
+				result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas,
+					ColumnSchema{Name: colName, DataType: schema.ColumnSchemas[colIdxs[i]-1].DataType})
+			}
+		}
+
+		rowIterator := n.snapshotRowIterator(table, fragmentName, args.Snapshot)
+		for rowIterator.HasNext() {
+			row := *rowIterator.Next()
+			values := row[1:]
+			if isScanned[row[0]] || !values.SatisfiesPredicate(schema, args.Where) {
+				continue
+			}
+			isScanned[row[0]] = true
+
+			scannedRow := make(Row, len(colIdxs)+1)
+			scannedRow[0] = row[0]
+			for i, colIdx := range colIdxs {
+				scannedRow[i+1] = row[colIdx]
+			}
+			result.Rows = append(result.Rows, scannedRow)
+		}
+	}
+
+	if len(result.Rows) > 0 {
+		orderBy := append(append([]OrderBy{}, args.OrderBy...), OrderBy{Column: rowIdxColName})
+		if err := result.SortRows(orderBy); err != nil {
+			return err
+		}
+	}
+	if args.HasLimit && len(result.Rows) > args.Limit {
+		result.Rows = result.Rows[:args.Limit]
+	}
+	*reply = result
+	return nil
+}
+
+// mergeSortedRows merges lists of rows sorted by compareRows into one sorted list. The rows start with their row idx,
+// and a row appearing in more than one list is only kept once. At most maxCount rows are returned unless it is
+// negative.
+func mergeSortedRows(rowLists [][]Row, compareRows func(rowA Row, rowB Row) int, maxCount int) []Row {
+	merged := make([]Row, 0)
+	isMerged := make(ValueSet)
+	// heads[i] -> index of the next row in the i-th list
+	heads := make([]int, len(rowLists))
+	for maxCount < 0 || len(merged) < maxCount {
+		minListIdx := -1
+		for listIdx, rows := range rowLists {
+			// skip the rows that are already merged from other lists
+			for heads[listIdx] < len(rows) && isMerged[rows[heads[listIdx]][0]] {
+				heads[listIdx]++
+			}
+			if heads[listIdx] == len(rows) {
+				continue
+			}
+			if minListIdx == -1 ||
+				compareRows(rows[heads[listIdx]], rowLists[minListIdx][heads[minListIdx]]) < 0 {
+				minListIdx = listIdx
+			}
+		}
+		if minListIdx == -1 {
+			break
+		}
+		row := rowLists[minListIdx][heads[minListIdx]]
+		merged = append(merged, row)
+		isMerged[row[0]] = true
+		heads[minListIdx]++
+	}
+	return merged
+}
+
+// planSortedScan chooses the fragments of a table to scan on the nodes, it returns the node rules whose fragments hold
+// all the given columns and may hold rows satisfying the predicate, or false if such rows may only be held by the
+// fragments lacking some columns. A fragment lacking columns can be skipped if another fragment with the same partition
+// predicate holds them, as both of them hold the same rows.
+func (c *Cluster) planSortedScan(tableName string, predicate map[string][]Condition,
+	colNames []string) ([]NodeRule, bool) {
+	schema, _ := c.getTableSchema(tableName)
+	nodeRules := make([]NodeRule, 0)
+	isPredicateHeld := make(map[string]bool)
+	lackingNodeRules := make([]NodeRule, 0)
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		if arePredicatesDisjoint(schema, nodeRule.Rule.Predicate, predicate) {
+			continue
+		}
+		isHoldingAll := true
+		for _, colName := range colNames {
+			isHoldingAll = isHoldingAll && nodeRule.Rule.HasColumn(colName)
+		}
+		if isHoldingAll {
+			nodeRules = append(nodeRules, nodeRule)
+			isPredicateHeld[predicateKey(nodeRule.Rule.Predicate)] = true
+		} else {
+			lackingNodeRules = append(lackingNodeRules, nodeRule)
+		}
+	}
+
+	for _, nodeRule := range lackingNodeRules {
+		if !isPredicateHeld[predicateKey(nodeRule.Rule.Predicate)] {
+			return nil, false
+		}
+	}
+	return nodeRules, true
+}
+
+// scanSortedNodes issues a SortedScan on the given nodes concurrently (see fanOut), each of them scanning its fragments
+// in nodeRuleIdxsMap, and returns the rows of the nodes in the same order as nodeIdxs, or the error of the first node
+// that failed.
+func (c *Cluster) scanSortedNodes(args SortedScan, nodeIdxs []int, nodeRuleIdxsMap map[int][]int) ([][]Row, error) {
+	rowLists := make([][]Row, len(nodeIdxs))
+	errs := make([]error, len(nodeIdxs))
+	fanOut(len(nodeIdxs), func(i int) {
+		nodeArgs := args
+		nodeArgs.FragmentNames = nil
+		for _, ruleIdx := range nodeRuleIdxsMap[nodeIdxs[i]] {
+			nodeArgs.FragmentNames = append(nodeArgs.FragmentNames, getFragmentName(args.TableName, ruleIdx))
+		}
+		var nodeDataset Dataset
+		_, errs[i] = c.callNode(nodeIdxs[i], "Node.ScanSorted", nodeArgs, &nodeDataset)
+		rowLists[i] = nodeDataset.Rows
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rowLists, nil
+}
+
+// validate checks that the query is well-formed against the schema of its table.
+func (query *SelectQuery) validate(schema TableSchema) error {
+	for _, colName := range query.Columns {
+		if schema.GetColIndexByName(colName) == -1 {
+			return missingColumnError(query.TableName, colName)
+		}
+	}
+	for colName := range query.Where {
+		if schema.GetColIndexByName(colName) == -1 {
+			return missingColumnError(query.TableName, colName)
+		}
+	}
+	for _, order := range query.OrderBy {
+		if schema.GetColIndexByName(order.Column) == -1 {
+			return missingColumnError(query.TableName, order.Column)
+		}
+	}
+	if query.Offset < 0 || (query.HasLimit && query.Limit < 0) {
+		return newError(ErrInvalidArgument, "offset and limit should not be negative")
+	}
+	return nil
+}
+
+// Select reads the rows of a table as described by the query, see SelectQuery.
+// If the rows can be found in the fragments holding all columns in Where and OrderBy (see planSortedScan), each node
+// only returns its first Offset + Limit rows in order (only one replica of each fragment is chosen by SetCover), and
+// the coordinator merges the sorted rows. Otherwise, the needed columns of the table are fetched and sorted at the
+// coordinator. The other columns to return are fetched for the selected rows at last.
+// Set reply as a Dataset holding the rows in order.
+func (c *Cluster) Select(query SelectQuery, reply *Dataset) error {
+	schema, ok := c.getTableSchema(query.TableName)
+	if !ok {
+		return noSuchTableError(query.TableName)
+	}
+	if err := query.validate(schema); err != nil {
+		return err
+	}
+	// all nodes are read as of the same snapshot
+	snapshot, err := c.getReadSnapshot(query.TxnId, query.TableName)
+	if err != nil {
+		return err
+	}
+
+	outputColNames := query.Columns
+	if len(outputColNames) == 0 {
+		for _, colSchema := range schema.ColumnSchemas {
+			outputColNames = append(outputColNames, colSchema.Name)
+		}
+	}
+
+	if query.Distinct {
+		result, err := c.selectDistinct(query, outputColNames, snapshot)
+		if err != nil {
+			return err
+		}
+		*reply = result
+		return nil
+	}
+
+	// the columns to filter and sort the rows, sort the columns in Where so that the order is deterministic
+	sortColNames := make([]string, 0)
+	for colName := range query.Where {
+		sortColNames = append(sortColNames, colName)
+	}
+	sort.Strings(sortColNames)
+	for _, order := range query.OrderBy {
+		sortColNames = appendIfAbsent(sortColNames, order.Column)
+	}
+
+	rowIdxColName := getRowIdxColumnName(query.TableName)
+	orderBy := append(append([]OrderBy{}, query.OrderBy...), OrderBy{Column: rowIdxColName})
+	maxCount := -1
+	if query.HasLimit {
+		maxCount = query.Offset + query.Limit
+	}
+
+	// the selected rows start with their row idx, followed by the columns in sortedSchema
+	var sortedRows []Row
+	sortedSchema := TableSchema{TableName: query.TableName,
+		ColumnSchemas: []ColumnSchema{{Name: rowIdxColName, DataType: TypeInt64}}}
+	if nodeRules, ok := c.planSortedScan(query.TableName, query.Where, sortColNames); ok {
+		// the output columns held by every fragment are returned together
+		scanColNames := append([]string{}, sortColNames...)
+		for _, colName := range outputColNames {
+			isHeld := true
+			for _, nodeRule := range nodeRules {
+				isHeld = isHeld && nodeRule.Rule.HasColumn(colName)
+			}
+			if isHeld {
+				scanColNames = appendIfAbsent(scanColNames, colName)
+			}
+		}
+		for _, colName := range scanColNames {
+			sortedSchema.ColumnSchemas = append(sortedSchema.ColumnSchemas,
+				ColumnSchema{Name: colName, DataType: schema.GetColTypeByName(colName)})
+		}
+
+		var rowLists [][]Row
+		args := SortedScan{TableName: query.TableName, Columns: scanColNames, Where: query.Where,
+			OrderBy: query.OrderBy, HasLimit: query.HasLimit, Limit: maxCount, Snapshot: snapshot}
+		if err := c.readWithFailover(query.TableName, nodeRules, func(nodeIdxs []int,
+			nodeRuleIdxsMap map[int][]int) error {
+			var err error
+			rowLists, err = c.scanSortedNodes(args, nodeIdxs, nodeRuleIdxsMap)
+			return err
+		}); err != nil {
+			return err
+		}
+
+		compareRows, _ := getRowComparator(sortedSchema, orderBy)
+		sortedRows = mergeSortedRows(rowLists, compareRows, maxCount)
+	} else {
+		// sort at the coordinator
+		neededColNames := append([]string{}, sortColNames...)
+		for _, colName := range outputColNames {
+			neededColNames = appendIfAbsent(neededColNames, colName)
+		}
+		pkRowMap, projectedSchema, err := c.projectTable(query.TableName, neededColNames, nil, false,
+			snapshot)
+		if err != nil {
+			return err
+		}
+		sortedSchema.ColumnSchemas = append(sortedSchema.ColumnSchemas, projectedSchema.ColumnSchemas...)
+
+		sortedDataset := Dataset{Schema: sortedSchema}
+		for pk, row := range pkRowMap {
+			if row.SatisfiesPredicate(projectedSchema, query.Where) {
+				sortedDataset.Rows = append(sortedDataset.Rows, append(Row{pk}, row...))
+			}
+		}
+		_ = sortedDataset.SortRows(orderBy)
+		sortedRows = sortedDataset.Rows
+	}
+
+	// apply offset and limit
+	if query.Offset >= len(sortedRows) {
+		sortedRows = nil
+	} else {
+		sortedRows = sortedRows[query.Offset:]
+	}
+	if query.HasLimit && len(sortedRows) > query.Limit {
+		sortedRows = sortedRows[:query.Limit]
+	}
+
+	// fetch the output columns that are not returned with the selected rows
+	missingColNames := make([]string, 0)
+	for _, colName := range outputColNames {
+		if sortedSchema.GetColIndexByName(colName) == -1 {
+			missingColNames = append(missingColNames, colName)
+		}
+	}
+	var missingSchema TableSchema
+	missingPKRowMap := make(map[interface{}]Row)
+	if len(missingColNames) > 0 && len(sortedRows) > 0 {
+		pks := make(ValueSet)
+		for _, row := range sortedRows {
+			pks[row[0]] = true
+		}
+		if missingPKRowMap, missingSchema, err = c.projectTable(query.TableName, missingColNames, pks,
+			true, snapshot); err != nil {
+			return err
+		}
+	}
+
+	result := Dataset{Schema: TableSchema{TableName: query.TableName}}
+	for _, colName := range outputColNames {
+		result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas,
+			ColumnSchema{Name: colName, DataType: schema.GetColTypeByName(colName)})
+	}
+	result.Rows = make([]Row, len(sortedRows))
+	for i, sortedRow := range sortedRows {
+		row := make(Row, len(outputColNames))
+		for colIdx, colName := range outputColNames {
+			if sortedColIdx := sortedSchema.GetColIndexByName(colName); sortedColIdx != -1 {
+				row[colIdx] = sortedRow[sortedColIdx]
+			} else if missingRow, ok := missingPKRowMap[sortedRow[0]]; ok {
+				row[colIdx] = missingRow[missingSchema.GetColIndexByName(colName)]
+			}
+		}
+		result.Rows[i] = row
+	}
+	*reply = result
+	return nil
+}
+
+// appendIfAbsent appends a string to the list if it is not in the list yet.
+func appendIfAbsent(list []string, s string) []string {
+	for _, item := range list {
+		if item == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"testing"
+)
+
+// compare two datasets, the rows should be in the same order
+func compareOrderedDataset(a Dataset, b Dataset) bool {
+	columnMapping := compareDatasetSchema(a.Schema, b.Schema)
+	if columnMapping == nil || len(a.Rows) != len(b.Rows) {
+		return false
+	}
+	for i := range a.Rows {
+		if !a.Rows[i].EqualsWithColumnMapping(&b.Rows[i], columnMapping) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSelectOrderByLimit(t *testing.T) {
+	aggregateSetup(map[string]interface{}{
+		"0|1": gradeRule("<=", 3.6, "sid", "name", "age", "grade"),
+		"1|2": gradeRule(">", 3.6, "sid", "name", "age", "grade"),
+	})
+
+	query := SelectQuery{
+		TableName: studentTableName,
+		Columns:   []string{"name", "grade"},
+		OrderBy:   []OrderBy{{Column: "grade", Desc: true}, {Column: "name"}},
+	}
+	results := Dataset{}
+	cli.Call("Cluster.Select", query, &results)
+	expectedDataset := Dataset{
+		Schema: TableSchema{studentTableName, []ColumnSchema{{"name", TypeString}, {"grade", TypeFloat}}},
+		Rows: []Row{
+			{"Hana", 4.0},
+			{"John", 4.0},
+			{"Tom", 4.0},
+			{"Smith", 3.5},
+			{"Lewis", 3.0},
+		},
+	}
+	if !compareOrderedDataset(expectedDataset, results) {
+		t.Errorf("Incorrect select results, expected %v, actual %v", expectedDataset, results)
+	}
+
+	query.HasLimit = true
+	query.Limit = 2
+	query.Offset = 1
+	results = Dataset{}
+	cli.Call("Cluster.Select", query, &results)
+	expectedDataset.Rows = expectedDataset.Rows[1:3]
+	if !compareOrderedDataset(expectedDataset, results) {
+		t.Errorf("Incorrect select results, expected %v, actual %v", expectedDataset, results)
+	}
+
+	// the offset is beyond the rows
+	query.Offset = 10
+	results = Dataset{}
+	cli.Call("Cluster.Select", query, &results)
+	if len(results.Rows) != 0 {
+		t.Errorf("No rows should be selected, actual %v", results)
+	}
+}
+
+func TestSelectOverlappingPartitions(t *testing.T) {
+	// Smith is held by both fragments
+	aggregateSetup(map[string]interface{}{
+		"0":   gradeRule("<=", 3.6, "sid", "name", "age", "grade"),
+		"1|2": gradeRule(">=", 3.5, "sid", "name", "age", "grade"),
+	})
+
+	query := SelectQuery{
+		TableName: studentTableName,
+		Columns:   []string{"name"},
+		Where:     map[string][]Condition{"grade": {{Op: ">=", Val: 3.2}}},
+		OrderBy:   []OrderBy{{Column: "age"}},
+		HasLimit:  true,
+		Limit:     3,
+	}
+	results := Dataset{}
+	cli.Call("Cluster.Select", query, &results)
+	// students of the same age are ordered by their row idx
+	expectedDataset := Dataset{
+		Schema: TableSchema{studentTableName, []ColumnSchema{{"name", TypeString}}},
+		Rows:   []Row{{"Hana"}, {"John"}, {"Smith"}},
+	}
+	if !compareOrderedDataset(expectedDataset, results) {
+		t.Errorf("Incorrect select results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+func TestSelectVerticalPartitions(t *testing.T) {
+	aggregateSetup(map[string]interface{}{
+		"0": gradeRule(">=", 0.0, "sid", "name"),
+		"1": gradeRule(">=", 0.0, "age", "grade"),
+	})
+
+	query := SelectQuery{
+		TableName: studentTableName,
+		Columns:   []string{"sid", "name"},
+		Where:     map[string][]Condition{"age": {{Op: "==", Val: 23}}},
+		OrderBy:   []OrderBy{{Column: "grade"}},
+	}
+	// the fragment holding age and grade holds every row
+	if nodeRules, ok := c.planSortedScan(studentTableName, query.Where, []string{"age", "grade"}); !ok ||
+		len(nodeRules) != 1 {
+		t.Errorf("Only one fragment should be sorted on the nodes, actual %v", nodeRules)
+	}
+
+	results := Dataset{}
+	cli.Call("Cluster.Select", query, &results)
+	expectedDataset := Dataset{
+		Schema: TableSchema{studentTableName, []ColumnSchema{{"sid", TypeInt32}, {"name", TypeString}}},
+		Rows:   []Row{{1, "Smith"}, {4, "Tom"}},
+	}
+	if !compareOrderedDataset(expectedDataset, results) {
+		t.Errorf("Incorrect select results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+func TestSelectSortAtCoordinator(t *testing.T) {
+	// the rows with higher grades are only held by a fragment without name
+	aggregateSetup(map[string]interface{}{
+		"0": gradeRule("<=", 3.6, "sid", "name", "age"),
+		"1": gradeRule(">=", 0.0, "sid", "grade"),
+	})
+
+	query := SelectQuery{
+		TableName: studentTableName,
+		Columns:   []string{"sid", "grade"},
+		OrderBy:   []OrderBy{{Column: "name", Desc: true}, {Column: "grade"}},
+	}
+	if _, ok := c.planSortedScan(studentTableName, query.Where, []string{"name", "grade"}); ok {
+		t.Errorf("The rows should be sorted at the coordinator")
+	}
+
+	results := Dataset{}
+	cli.Call("Cluster.Select", query, &results)
+	// nil is less than any name
+	expectedDataset := Dataset{
+		Schema: TableSchema{studentTableName, []ColumnSchema{{"sid", TypeInt32}, {"grade", TypeFloat}}},
+		Rows:   []Row{{1, 3.5}, {3, 3.0}, {0, 4.0}, {2, 4.0}, {4, 4.0}},
+	}
+	if !compareOrderedDataset(expectedDataset, results) {
+		t.Errorf("Incorrect select results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+func TestSelectWhereIn(t *testing.T) {
+	aggregateSetup(map[string]interface{}{
+		"0|1": gradeRule("<=", 3.6, "sid", "name", "age", "grade"),
+		"1|2": gradeRule(">", 3.6, "sid", "name", "age", "grade"),
+	})
+
+	query := SelectQuery{
+		TableName: studentTableName,
+		Columns:   []string{"name"},
+		Where:     map[string][]Condition{"sid": {{Op: OpIn, Val: ValueSet{1: true, 2: true, 7: true}}}},
+		OrderBy:   []OrderBy{{Column: "name"}},
+	}
+	results := Dataset{}
+	cli.Call("Cluster.Select", query, &results)
+	expectedDataset := Dataset{
+		Schema: TableSchema{studentTableName, []ColumnSchema{{"name", TypeString}}},
+		Rows:   []Row{{"Hana"}, {"Smith"}},
+	}
+	if !compareOrderedDataset(expectedDataset, results) {
+		t.Errorf("Incorrect select results, expected %v, actual %v", expectedDataset, results)
+	}
+
+	query.Where["sid"][0].Op = OpNotIn
+	results = Dataset{}
+	cli.Call("Cluster.Select", query, &results)
+	expectedDataset.Rows = []Row{{"John"}, {"Lewis"}, {"Tom"}}
+	if !compareOrderedDataset(expectedDataset, results) {
+		t.Errorf("Incorrect select results, expected %v, actual %v", expectedDataset, results)
+	}
+
+	// no fragment can hold a value in an empty set
+	if nodeRules, ok := c.getQueriedNodeRules(studentTableName,
+		map[string][]Condition{"sid": {{Op: OpIn, Val: ValueSet{}}}}, nil); !ok || len(nodeRules) != 0 {
+		t.Errorf("All fragments should be pruned, actual %v", nodeRules)
+	}
+}
@@ -0,0 +1,296 @@
+package models
+
+import (
+	"math"
+)
+
+// SemiJoinStep is one reduction in a semi-join program: the rows of Target that cannot be joined with Source on
+// Columns are removed (Target ⋉ Source).
+type SemiJoinStep struct {
+	Target  string
+	Source  string
+	Columns []string
+	// estimated bytes saved when fetching Target for the final join
+	EstimatedBenefit float64
+	// estimated bytes sent to perform the reduction
+	EstimatedCost float64
+}
+
+// getCommonColumns returns the names of the columns shared by two table schemas, in the order of the first one.
+func getCommonColumns(schema1 TableSchema, schema2 TableSchema) []string {
+	commonColNames := make([]string, 0)
+	for _, colSchema := range schema1.ColumnSchemas {
+		if schema2.GetColIndexByName(colSchema.Name) != -1 {
+			commonColNames = append(commonColNames, colSchema.Name)
+		}
+	}
+	return commonColNames
+}
+
+// estimateSemiJoin estimates the benefit, the cost and the selectivity of reducing target by source on the columns.
+func estimateSemiJoin(target *TableStatistics, source *TableStatistics, colNames []string) (float64, float64,
+	float64) {
+	sourceDistinctCount := source.KeyDistinctCount(colNames)
+	targetDistinctCount := target.KeyDistinctCount(colNames)
+
+	// fraction of target rows that remain after the reduction
+	selectivity := 1.0
+	if targetDistinctCount > 0 {
+		selectivity = math.Min(1, sourceDistinctCount/targetDistinctCount)
+	}
+
+	benefit := (1 - selectivity) * float64(target.RowCount) * target.RowWidth()
+
+	keyWidth := source.KeyWidth(colNames)
+	// fetch the join columns of the source
+	cost := float64(source.RowCount) * (keyWidth + rowIdxWidth)
+	// send the distinct join values to every fragment of the target
+	cost += sourceDistinctCount * keyWidth * float64(target.FragmentCount)
+	// return the row idx of the remaining target rows
+	cost += selectivity * float64(target.RowCount) * rowIdxWidth
+
+	return benefit, cost, selectivity
+}
+
+// planSemiJoinProgram chooses a sequence of semi-joins among the tables with the SDD-1 greedy heuristic: at each step,
+// the semi-join with the largest profit (benefit - cost in bytes) is applied, and the estimated statistics of its
+// target are updated. A semi-join may be applied again in the other direction or after its source is reduced, and the
+// program ends when no semi-join is profitable.
+func planSemiJoinProgram(tableSchemas []TableSchema, statsMap map[string]TableStatistics) []SemiJoinStep {
+	// copy the statistics as they are updated during planning
+	estimatedStatsMap := make(map[string]*TableStatistics)
+	for tableName, stats := range statsMap {
+		estimatedStats := stats
+		estimatedStats.DistinctCounts = make(map[string]int)
+		for colName, distinctCount := range stats.DistinctCounts {
+			estimatedStats.DistinctCounts[colName] = distinctCount
+		}
+		estimatedStatsMap[tableName] = &estimatedStats
+	}
+
+	// the version of a table increases each time it is reduced, and a semi-join is only worth applying again when its
+	// source has been reduced since the last time
+	versions := make(map[string]int)
+	appliedSourceVersions := make(map[string]int)
+
+	program := make([]SemiJoinStep, 0)
+	// each step reduces a table, which can happen at most once per pair of tables and source version
+	maxStepCount := len(tableSchemas) * len(tableSchemas) * len(tableSchemas)
+	for len(program) < maxStepCount {
+		var bestStep *SemiJoinStep
+		bestSelectivity := 1.0
+		for _, targetSchema := range tableSchemas {
+			for _, sourceSchema := range tableSchemas {
+				if targetSchema.TableName == sourceSchema.TableName {
+					continue
+				}
+				colNames := getCommonColumns(targetSchema, sourceSchema)
+				if len(colNames) == 0 {
+					continue
+				}
+				pairKey := targetSchema.TableName + "|" + sourceSchema.TableName
+				if sourceVersion, ok := appliedSourceVersions[pairKey]; ok &&
+					sourceVersion == versions[sourceSchema.TableName] {
+					continue
+				}
+
+				benefit, cost, selectivity := estimateSemiJoin(estimatedStatsMap[targetSchema.TableName],
+					estimatedStatsMap[sourceSchema.TableName], colNames)
+				profit := benefit - cost
+				if profit > 0 && (bestStep == nil || profit > bestStep.EstimatedBenefit-bestStep.EstimatedCost) {
+					bestStep = &SemiJoinStep{
+						Target:           targetSchema.TableName,
+						Source:           sourceSchema.TableName,
+						Columns:          colNames,
+						EstimatedBenefit: benefit,
+						EstimatedCost:    cost,
+					}
+					bestSelectivity = selectivity
+				}
+			}
+		}
+
+		if bestStep == nil {
+			break
+		}
+		program = append(program, *bestStep)
+
+		// update the estimated statistics of the reduced table
+		target := estimatedStatsMap[bestStep.Target]
+		source := estimatedStatsMap[bestStep.Source]
+		target.RowCount = int(math.Ceil(float64(target.RowCount) * bestSelectivity))
+		for colName, distinctCount := range target.DistinctCounts {
+			if sourceDistinctCount, ok := source.DistinctCounts[colName]; ok && sourceDistinctCount < distinctCount {
+				distinctCount = sourceDistinctCount
+			}
+			if distinctCount > target.RowCount {
+				distinctCount = target.RowCount
+			}
+			target.DistinctCounts[colName] = distinctCount
+		}
+		versions[bestStep.Target]++
+		appliedSourceVersions[bestStep.Target+"|"+bestStep.Source] = versions[bestStep.Source]
+	}
+
+	return program
+}
+
+// PlanSemiJoinProgram collects the statistics of the tables and chooses the semi-join program to reduce them before
+// joining, see planSemiJoinProgram.
+func (c *Cluster) PlanSemiJoinProgram(tableNames []string) ([]SemiJoinStep, error) {
+	tableSchemas := make([]TableSchema, len(tableNames))
+	statsMap := make(map[string]TableStatistics)
+	for i, tableName := range tableNames {
+		schema, ok := c.getTableSchema(tableName)
+		if !ok {
+			return nil, noSuchTableError(tableName)
+		}
+		tableSchemas[i] = schema
+		stats, err := c.getTableStatistics(tableName)
+		if err != nil {
+			return nil, err
+		}
+		statsMap[tableName] = stats
+	}
+	return planSemiJoinProgram(tableSchemas, statsMap), nil
+}
+
+// reduceTableByKeys returns the row idx of the rows in a table whose values of the columns form a key in keys.
+// Only the rows in pks are considered if filterByPK is true. The table is read as of the given snapshot.
+func (c *Cluster) reduceTableByKeys(tableName string, colNames []string, keys ValueSet, pks ValueSet,
+	filterByPK bool, snapshot Snapshot) (ValueSet, error) {
+	remainingPKs := make(ValueSet)
+
+	// check whether some fragment only holds part of the columns
+	isSplit := false
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		holdCount := 0
+		for _, colName := range colNames {
+			if nodeRule.Rule.HasColumn(colName) {
+				holdCount++
+			}
+		}
+		if holdCount > 0 && holdCount < len(colNames) {
+			isSplit = true
+			break
+		}
+	}
+
+	if isSplit {
+		// the keys have to be checked at the coordinator
+		pkRowMap, _, err := c.projectTable(tableName, colNames, pks, filterByPK, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		keyColIdxs := make([]int, len(colNames))
+		for i := range keyColIdxs {
+			keyColIdxs[i] = i
+		}
+		for pk, row := range pkRowMap {
+			if keys[getRowKey(row, keyColIdxs)] {
+				remainingPKs[pk] = true
+			}
+		}
+		return remainingPKs, nil
+	}
+
+	// each fragment holding the columns checks its rows, and only returns the row idx of the remaining ones
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		rule := nodeRule.Rule
+		scan := FragmentScan{
+			FragmentName: getFragmentName(tableName, rule.RuleIdx),
+			FilterByPK:   filterByPK,
+			PKs:          pks,
+			FilterByKey:  true,
+			KeyColumns:   colNames,
+			Keys:         keys,
+			Snapshot:     snapshot,
+		}
+		// the fragment holds none of the columns
+		if !rule.HasColumn(colNames[0]) {
+			continue
+		}
+
+		var nodeDataset Dataset
+		if err := c.callReplica(nodeRule, "Node.ScanFragment", scan, &nodeDataset); err != nil {
+			return nil, err
+		}
+		for _, row := range nodeDataset.Rows {
+			remainingPKs[row[0]] = true
+		}
+	}
+	return remainingPKs, nil
+}
+
+// ReduceAndJoin joins all tables in the given list using NATURAL JOIN like Join, but the tables are first reduced by a
+// semi-join program (see PlanSemiJoinProgram), so that only the rows that may be joined are fetched.
+// Set reply as a Dataset of the joined results.
+func (c *Cluster) ReduceAndJoin(tableNames []string, reply *Dataset) error {
+	program, err := c.PlanSemiJoinProgram(tableNames)
+	if err != nil {
+		return err
+	}
+	// all tables are read as of the same snapshot
+	snapshot := c.takeSnapshot()
+
+	// remainingPKsMap[tableName] -> row idx of the remaining rows, a table is not reduced if it is absent
+	remainingPKsMap := make(map[string]ValueSet)
+	for _, step := range program {
+		sourcePKs, isSourceReduced := remainingPKsMap[step.Source]
+		targetPKs, isTargetReduced := remainingPKsMap[step.Target]
+
+		// collect the join values of the source
+		sourceRows, _, err := c.projectTable(step.Source, step.Columns, sourcePKs, isSourceReduced,
+			snapshot)
+		if err != nil {
+			return err
+		}
+		keyColIdxs := make([]int, len(step.Columns))
+		for i := range keyColIdxs {
+			keyColIdxs[i] = i
+		}
+		keys := make(ValueSet)
+		for _, row := range sourceRows {
+			keys[getRowKey(row, keyColIdxs)] = true
+		}
+
+		if remainingPKsMap[step.Target], err = c.reduceTableByKeys(step.Target, step.Columns, keys, targetPKs,
+			isTargetReduced, snapshot); err != nil {
+			return err
+		}
+	}
+
+	// fetch the remaining rows of each table and join them at the coordinator
+	datasetPtrs := make([]*Dataset, len(tableNames))
+	for i, tableName := range tableNames {
+		datasetPtrs[i] = &Dataset{}
+		pks, isReduced := remainingPKsMap[tableName]
+		if !isReduced {
+			if *datasetPtrs[i], err = c.getFullTableDataset(tableName, snapshot); err != nil {
+				return err
+			}
+			continue
+		}
+
+		schema, _ := c.getTableSchema(tableName)
+		colNames := make([]string, len(schema.ColumnSchemas))
+		for colIdx, colSchema := range schema.ColumnSchemas {
+			colNames[colIdx] = colSchema.Name
+		}
+		pkRowMap, projectedSchema, err := c.projectTable(tableName, colNames, pks, true, snapshot)
+		if err != nil {
+			return err
+		}
+		datasetPtrs[i].Schema = projectedSchema
+		for _, row := range pkRowMap {
+			datasetPtrs[i].Rows = append(datasetPtrs[i].Rows, row)
+		}
+	}
+
+	result, err := NaturalJoin(datasetPtrs)
+	if err != nil {
+		return err
+	}
+	*reply = result
+	return nil
+}
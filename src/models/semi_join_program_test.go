@@ -0,0 +1,227 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestPlanSemiJoinProgram(t *testing.T) {
+	defineMultiTables()
+
+	statsMap := map[string]TableStatistics{
+		studentTableName: {
+			RowCount:       1000,
+			DistinctCounts: map[string]int{"sid": 1000, "name": 1000, "age": 10, "grade": 20},
+			ColumnWidths:   map[string]float64{"sid": 8, "name": 30, "age": 8, "grade": 8},
+			FragmentCount:  2,
+		},
+		courseRegistrationTableName: {
+			RowCount:       10,
+			DistinctCounts: map[string]int{"sid": 5, "courseId": 3},
+			ColumnWidths:   map[string]float64{"sid": 8, "courseId": 8},
+			FragmentCount:  1,
+		},
+	}
+
+	program := planSemiJoinProgram([]TableSchema{*studentTableSchema, *courseRegistrationTableSchema}, statsMap)
+	if len(program) != 1 {
+		t.Fatalf("Expected exactly one semi-join, actual program %v", program)
+	}
+	step := program[0]
+	if step.Target != studentTableName || step.Source != courseRegistrationTableName ||
+		len(step.Columns) != 1 || step.Columns[0] != "sid" {
+		t.Errorf("The student table should be reduced by courseRegistration on sid, actual step %v", step)
+	}
+	if step.EstimatedBenefit <= step.EstimatedCost {
+		t.Errorf("A chosen semi-join should be profitable, actual step %v", step)
+	}
+
+	// the statistics of the input should not be changed by planning
+	if statsMap[studentTableName].RowCount != 1000 || statsMap[studentTableName].DistinctCounts["sid"] != 1000 {
+		t.Errorf("The statistics should not be modified, actual %v", statsMap[studentTableName])
+	}
+}
+
+// student table is large and vertically fragmented, while the other tables only hold a few students
+func TestReduceAndJoin(t *testing.T) {
+	MultiTableSetup()
+
+	studentRows = make([]Row, 0)
+	for i := 0; i < 60; i++ {
+		studentRows = append(studentRows,
+			Row{i, fmt.Sprintf("Student %03d with a rather long name", i), 20 + i%5, 3.0 + float64(i%10)/10})
+	}
+	studentClassRows = []Row{
+		{0, studentRows[0][1], studentRows[0][2], "81"},
+		{1, studentRows[1][1], studentRows[1][2], "82"},
+		{2, studentRows[2][1], studentRows[2][2], "83"},
+	}
+
+	// the join columns of student and studentClass (sid, name, age) are split into two fragments
+	m := map[string]interface{}{
+		"0": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{
+					"op":  ">=",
+					"val": 0.0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name",
+			},
+		},
+		"1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{
+					"op":  ">=",
+					"val": 0.0,
+				},
+				},
+			},
+			"column": [...]string{
+				"age", "grade",
+			},
+		},
+	}
+	studentTablePartitionRules, _ = json.Marshal(m)
+
+	m = map[string]interface{}{
+		"2": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"courseId": [...]map[string]interface{}{{
+					"op":  ">=",
+					"val": 0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "courseId",
+			},
+		},
+	}
+	courseRegistrationTablePartitionRules, _ = json.Marshal(m)
+
+	m = map[string]interface{}{
+		"3": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"sid": [...]map[string]interface{}{{
+					"op":  ">=",
+					"val": 0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "class",
+			},
+		},
+	}
+	studentClassTablePartitionRules, _ = json.Marshal(m)
+
+	replyMsg := ""
+	cli.Call("Cluster.BuildTable", []interface{}{studentTableSchema, studentTablePartitionRules}, &replyMsg)
+	cli.Call("Cluster.BuildTable",
+		[]interface{}{courseRegistrationTableSchema, courseRegistrationTablePartitionRules}, &replyMsg)
+	cli.Call("Cluster.BuildTable", []interface{}{studentClassTableSchema, studentClassTablePartitionRules}, &replyMsg)
+	for _, row := range studentRows {
+		cli.Call("Cluster.FragmentWrite", []interface{}{studentTableName, row}, &replyMsg)
+	}
+	for _, row := range courseRegistrationRows {
+		cli.Call("Cluster.FragmentWrite", []interface{}{courseRegistrationTableName, row}, &replyMsg)
+	}
+	for _, row := range studentClassRows {
+		cli.Call("Cluster.FragmentWrite", []interface{}{studentClassTableName, row}, &replyMsg)
+	}
+
+	tableNames := []string{studentTableName, courseRegistrationTableName, studentClassTableName}
+	program, err := c.PlanSemiJoinProgram(tableNames)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	isStudentReduced := false
+	for _, step := range program {
+		if step.Target == studentTableName {
+			isStudentReduced = true
+		}
+	}
+	if !isStudentReduced {
+		t.Errorf("The student table should be reduced, actual program %v", program)
+	}
+
+	expectedDataset := Dataset{}
+	cli.Call("Cluster.Join", tableNames, &expectedDataset)
+	if len(expectedDataset.Rows) != 4 {
+		t.Fatalf("Expected 4 joined rows, actual %v", expectedDataset)
+	}
+
+	results := Dataset{}
+	cli.Call("Cluster.ReduceAndJoin", tableNames, &results)
+	if !datasetDuplicateChecking(expectedDataset, results) {
+		t.Errorf("Incorrect join results, expected %v, actual %v", expectedDataset, results)
+	}
+}
+
+// courseRegistration table is empty, so every table is reduced to nothing
+func TestReduceAndJoinEmptyTable(t *testing.T) {
+	setupLab3()
+
+	courseRegistrationRows = []Row{}
+
+	m := map[string]interface{}{
+		"0|1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{
+					"op":  "<=",
+					"val": 3.6,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+		"1|2": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{
+					"op":  ">",
+					"val": 3.6,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "name", "age", "grade",
+			},
+		},
+	}
+	studentTablePartitionRules, _ = json.Marshal(m)
+
+	m = map[string]interface{}{
+		"3": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"courseId": [...]map[string]interface{}{{
+					"op":  ">=",
+					"val": 0,
+				},
+				},
+			},
+			"column": [...]string{
+				"sid", "courseId",
+			},
+		},
+	}
+	courseRegistrationTablePartitionRules, _ = json.Marshal(m)
+
+	buildTablesLab3(cli)
+	insertDataLab3(cli)
+
+	results := Dataset{}
+	cli.Call("Cluster.ReduceAndJoin", []string{studentTableName, courseRegistrationTableName}, &results)
+	expectedDataset := Dataset{
+		Schema: joinedTableSchema,
+		Rows:   []Row{},
+	}
+	if !datasetDuplicateChecking(expectedDataset, results) {
+		t.Errorf("Incorrect join results, expected %v, actual %v", expectedDataset, results)
+	}
+}
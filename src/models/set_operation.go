@@ -0,0 +1,150 @@
+package models
+
+import (
+	"fmt"
+)
+
+// set operations combining the rows of two datasets
+const (
+	// rows in either dataset, without duplicates
+	SetUnion = "UNION"
+	// rows in either dataset, with duplicates
+	SetUnionAll = "UNION ALL"
+	// rows in both datasets, without duplicates
+	SetIntersect = "INTERSECT"
+	// rows in the first dataset but not in the second one, without duplicates
+	SetExcept = "EXCEPT"
+)
+
+// SetOperationQuery combines the results of two select queries, like
+// SELECT ... FROM Left.TableName ... Op SELECT ... FROM Right.TableName ...
+type SetOperationQuery struct {
+	// one of the Set* operations
+	Op    string
+	Left  SelectQuery
+	Right SelectQuery
+}
+
+// getValuesKey returns a key of the given values, rows with the same values have the same key.
+func getValuesKey(values Row) string {
+	// an empty row may be decoded as nil, so they share the same key
+	if len(values) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%#v", []interface{}(values))
+}
+
+// DistinctDataset returns the rows of the dataset without duplicates, keeping the first occurrence of each row.
+func DistinctDataset(dataset Dataset) Dataset {
+	result := Dataset{Schema: dataset.Schema}
+	rowKeySet := make(map[string]bool)
+	for _, row := range dataset.Rows {
+		key := getValuesKey(row)
+		if !rowKeySet[key] {
+			rowKeySet[key] = true
+			result.Rows = append(result.Rows, row)
+		}
+	}
+	return result
+}
+
+// SetOperationDatasets combines the rows of two datasets by the given set operation. The datasets should have the same
+// number of columns with the same data types, the columns are matched by their positions and the result is named
+// after the first dataset.
+func SetOperationDatasets(op string, left *Dataset, right *Dataset) (Dataset, error) {
+	if len(left.Schema.ColumnSchemas) != len(right.Schema.ColumnSchemas) {
+		return Dataset{}, newError(ErrSchemaMismatch, op+" needs the same number of columns on both sides")
+	}
+	for i, colSchema := range left.Schema.ColumnSchemas {
+		if colSchema.DataType != right.Schema.ColumnSchemas[i].DataType {
+			return Dataset{}, newError(ErrSchemaMismatch, "column "+colSchema.Name+" and column "+
+				right.Schema.ColumnSchemas[i].Name+" have different data types")
+		}
+	}
+
+	result := Dataset{Schema: left.Schema}
+	switch op {
+	case SetUnionAll:
+		result.Rows = append(append(result.Rows, left.Rows...), right.Rows...)
+		return result, nil
+	case SetUnion:
+		result.Rows = append(append(result.Rows, left.Rows...), right.Rows...)
+		return DistinctDataset(result), nil
+	case SetIntersect, SetExcept:
+		rightKeySet := make(map[string]bool)
+		for _, row := range right.Rows {
+			rightKeySet[getValuesKey(row)] = true
+		}
+		for _, row := range left.Rows {
+			if rightKeySet[getValuesKey(row)] == (op == SetIntersect) {
+				result.Rows = append(result.Rows, row)
+			}
+		}
+		return DistinctDataset(result), nil
+	}
+	return Dataset{}, newError(ErrInvalidArgument, "unknown set operation "+op)
+}
+
+// selectDistinct selects the distinct values of the output columns of a query. The nodes deduplicate the rows of their
+// fragments as partial aggregates grouped by the output columns (see Aggregate), so each distinct row is sent at most
+// once by a node, and the coordinator merges them before sorting and applying the offset and the limit. The nodes are
+// read as of the given snapshot.
+func (c *Cluster) selectDistinct(query SelectQuery, outputColNames []string, snapshot Snapshot) (Dataset, error) {
+	for _, order := range query.OrderBy {
+		isSelected := false
+		for _, colName := range outputColNames {
+			isSelected = isSelected || colName == order.Column
+		}
+		if !isSelected {
+			return Dataset{}, newError(ErrInvalidArgument,
+				"column "+order.Column+" to order by should be selected with DISTINCT")
+		}
+	}
+
+	result, err := c.aggregate(AggregateQuery{TableName: query.TableName, Where: query.Where, GroupBy: outputColNames},
+		snapshot)
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	// the groups are not ordered, so the rows are also sorted by all columns to make the order deterministic
+	orderBy := append([]OrderBy{}, query.OrderBy...)
+	for _, colName := range outputColNames {
+		orderBy = append(orderBy, OrderBy{Column: colName})
+	}
+	if err := result.SortRows(orderBy); err != nil {
+		return Dataset{}, err
+	}
+
+	if query.Offset >= len(result.Rows) {
+		result.Rows = nil
+	} else {
+		result.Rows = result.Rows[query.Offset:]
+	}
+	if query.HasLimit && len(result.Rows) > query.Limit {
+		result.Rows = result.Rows[:query.Limit]
+	}
+	result.Schema.TableName = query.TableName
+	return result, nil
+}
+
+// SetOperation runs the two select queries and combines their results at the coordinator, see SetOperationDatasets.
+// For example, the students without registrations are
+// SetOperationQuery{Op: SetExcept, Left: {TableName: "student", Columns: ["sid"]},
+// Right: {TableName: "courseRegistration", Columns: ["sid"]}}.
+// Set reply as the combined results.
+func (c *Cluster) SetOperation(query SetOperationQuery, reply *Dataset) error {
+	datasets := make([]Dataset, 2)
+	for i, selectQuery := range []SelectQuery{query.Left, query.Right} {
+		if err := c.Select(selectQuery, &datasets[i]); err != nil {
+			return err
+		}
+	}
+
+	result, err := SetOperationDatasets(query.Op, &datasets[0], &datasets[1])
+	if err != nil {
+		return err
+	}
+	*reply = result
+	return nil
+}
@@ -0,0 +1,72 @@
+package models
+
+import "testing"
+
+// singleColumnDataset creates a dataset of a single column with the given rows.
+func singleColumnDataset(colName string, dataType int, rows ...Row) Dataset {
+	return Dataset{Schema: TableSchema{"", []ColumnSchema{{colName, dataType}}}, Rows: rows}
+}
+
+func TestSelectDistinct(t *testing.T) {
+	setOperationSetup()
+
+	results := Dataset{}
+	cli.Call("Cluster.Select", SelectQuery{TableName: studentTableName, Distinct: true, Columns: []string{"age"},
+		OrderBy: []OrderBy{{Column: "age", Desc: true}}}, &results)
+	if !compareOrderedDataset(results, singleColumnDataset("age", TypeInt32, Row{23}, Row{22}, Row{21})) {
+		t.Errorf("Incorrect distinct ages: %v", results)
+	}
+
+	results = Dataset{}
+	cli.Call("Cluster.Select", SelectQuery{TableName: studentTableName, Distinct: true,
+		Columns: []string{"age", "grade"}, Where: map[string][]Condition{"grade": {{Op: ">", Val: 3.2}}},
+		Offset: 1, HasLimit: true, Limit: 2}, &results)
+	if !compareOrderedDataset(results, Dataset{
+		Schema: TableSchema{"", []ColumnSchema{{"age", TypeInt32}, {"grade", TypeFloat}}},
+		Rows:   []Row{{22, 4.0}, {23, 3.5}},
+	}) {
+		t.Errorf("Incorrect distinct ages and grades: %v", results)
+	}
+
+	// the rows can only be ordered by the selected columns
+	results = Dataset{}
+	cli.Call("Cluster.Select", SelectQuery{TableName: studentTableName, Distinct: true, Columns: []string{"age"},
+		OrderBy: []OrderBy{{Column: "grade"}}}, &results)
+	if len(results.Schema.ColumnSchemas) != 0 {
+		t.Errorf("The query should fail, actual results %v", results)
+	}
+}
+
+func TestSetOperation(t *testing.T) {
+	setOperationSetup()
+
+	studentQuery := SelectQuery{TableName: studentTableName, Columns: []string{"sid"}}
+	registrationQuery := SelectQuery{TableName: courseRegistrationTableName, Columns: []string{"sid"}}
+	expectedRowsMap := map[string][]Row{
+		// students without registrations
+		SetExcept:    {{3}, {4}},
+		SetIntersect: {{0}, {1}, {2}},
+		SetUnion:     {{0}, {1}, {2}, {3}, {4}},
+		SetUnionAll:  {{0}, {1}, {2}, {3}, {4}, {0}, {0}, {1}, {2}},
+	}
+	for op, expectedRows := range expectedRowsMap {
+		results := Dataset{}
+		cli.Call("Cluster.SetOperation", SetOperationQuery{Op: op, Left: studentQuery, Right: registrationQuery},
+			&results)
+		if !compareOrderedDataset(results, singleColumnDataset("sid", TypeInt32, expectedRows...)) {
+			t.Errorf("Incorrect results of %s, expected %v, actual %v", op, expectedRows, results)
+		}
+	}
+}
+
+func TestSetOperationDatasetsMismatch(t *testing.T) {
+	left := Dataset{Schema: TableSchema{"", []ColumnSchema{{"sid", TypeInt32}}}, Rows: []Row{{0}}}
+	right := Dataset{Schema: TableSchema{"", []ColumnSchema{{"name", TypeString}}}, Rows: []Row{{"John"}}}
+	if _, err := SetOperationDatasets(SetUnion, &left, &right); err == nil {
+		t.Errorf("Columns of different data types should not be combined")
+	}
+	right.Schema.ColumnSchemas = append(right.Schema.ColumnSchemas, ColumnSchema{"sid", TypeInt32})
+	if _, err := SetOperationDatasets(SetExcept, &left, &right); err == nil {
+		t.Errorf("Datasets with different numbers of columns should not be combined")
+	}
+}
@@ -0,0 +1,239 @@
+package models
+
+import (
+	"time"
+)
+
+// initialTs is the timestamp of the initial state of the cluster, the commit timestamps start after it, so that the
+// timestamp of a snapshot is never latestTs.
+const initialTs int64 = 1
+
+// versionRetention is how long the versions of rows removed by a commit are kept on the nodes, so that the reads that
+// took their snapshots before the commit still see them, see pruneHistory. A read is expected to end well before then.
+const versionRetention = time.Minute
+
+// PruneHistory asks a node to prune the history it keeps for the snapshots and the transactions, see Node.PruneHistory.
+type PruneHistory struct {
+	// the versions of rows removed at or before Ts are pruned
+	Ts int64
+	// the transactions decided longer than DecidedRetention ago are forgotten
+	DecidedRetention time.Duration
+}
+
+// Snapshot is the view of a read on the nodes. The rows committed at or before Ts are read (see VersionedRowStore), so
+// that every node returns its rows as of the same timestamp, and the rows staged by the transaction TxnId are read as
+// well if it is not empty. The zero value reads the latest committed rows.
+type Snapshot struct {
+	Ts    int64
+	TxnId string
+}
+
+// takeSnapshot returns a snapshot of the committed rows. Its timestamp is the latest one at which every committed
+// transaction has been applied by all of its participants, so that a transaction whose commit is still being sent to
+// some participant (see sendDecision) is not seen half-written. The decisions missed by some participants are sent
+// again first (see resolveTxns), so that a lost commit holds the snapshots back only while some of its participants
+// are unavailable, even if no write follows it.
+func (c *Cluster) takeSnapshot() Snapshot {
+	c.resolveTxns()
+	c.txnLog.mu.Lock()
+	defer c.txnLog.mu.Unlock()
+	return Snapshot{Ts: c.getSnapshotTsLocked()}
+}
+
+// takeLatestSnapshot returns a snapshot of the latest committed rows, which sees a transaction whose commit is not
+// applied by some participant yet, unlike takeSnapshot. The decisions missed by some participants are sent again first
+// (see resolveTxns), so a commit is only missed by the participants that are unavailable, and the rows held by them
+// are read from the other replicas, see readWithFailover. A commit still being sent by others may be seen
+// half-written, so the snapshot is only for the writes that should see every committed row, e.g. Upsert.
+func (c *Cluster) takeLatestSnapshot() Snapshot {
+	c.resolveTxns()
+	c.txnLog.mu.Lock()
+	defer c.txnLog.mu.Unlock()
+	return Snapshot{Ts: c.txnLog.lastCommitTs}
+}
+
+// getSnapshotTsLocked returns the timestamp of a new snapshot, see takeSnapshot. The caller should hold c.txnLog.mu.
+func (c *Cluster) getSnapshotTsLocked() int64 {
+	ts := c.txnLog.lastCommitTs
+	for _, decision := range c.txnLog.decisions {
+		if decision.commit && decision.commitTs <= ts {
+			ts = decision.commitTs - 1
+		}
+	}
+	return ts
+}
+
+// getPruneTs returns the timestamp before which no snapshot reads the nodes any longer, which is the oldest of the
+// snapshot of a new read, the snapshots of the open transactions and cursors, and the snapshots taken by the reads
+// within versionRetention. The commits older than versionRetention are no longer recorded. The transactions and the
+// cursors abandoned by their clients should be closed first (see abortIdleTxns and closeIdleCursors), so that they do
+// not hold the pruning back.
+func (c *Cluster) getPruneTs() int64 {
+	c.txnLog.mu.Lock()
+	pruneTs := c.getSnapshotTsLocked()
+	recentCommits := c.txnLog.recentCommits
+	for len(recentCommits) > 0 && time.Since(recentCommits[0].committedAt) >= c.txnLog.versionRetention {
+		recentCommits = recentCommits[1:]
+	}
+	c.txnLog.recentCommits = recentCommits
+	if len(recentCommits) > 0 && recentCommits[0].commitTs-1 < pruneTs {
+		pruneTs = recentCommits[0].commitTs - 1
+	}
+	for _, txn := range c.txnLog.open {
+		if txn.snapshotTs < pruneTs {
+			pruneTs = txn.snapshotTs
+		}
+	}
+	c.txnLog.mu.Unlock()
+
+	c.cursorsMu.Lock()
+	defer c.cursorsMu.Unlock()
+	for _, cur := range c.cursors {
+		if cur.snapshot.Ts != latestTs && cur.snapshot.Ts < pruneTs {
+			pruneTs = cur.snapshot.Ts
+		}
+	}
+	return pruneTs
+}
+
+// pruneHistory prunes the versions of rows that no snapshot reads any longer on every node (see getPruneTs), and the
+// transactions decided or aborted longer than versionRetention ago, whose late calls are no longer expected. It returns
+// the number of pruned versions. A node that is unavailable is pruned next time.
+func (c *Cluster) pruneHistory() int {
+	c.abortIdleTxns()
+	c.closeIdleCursors()
+	c.txnLog.mu.Lock()
+	retention := c.txnLog.versionRetention
+	for _, abortedTxns := range []map[string]time.Time{c.txnLog.deadlocked, c.txnLog.expired} {
+		for txnId, abortedAt := range abortedTxns {
+			if time.Since(abortedAt) >= retention {
+				delete(abortedTxns, txnId)
+			}
+		}
+	}
+	c.txnLog.mu.Unlock()
+	args := PruneHistory{Ts: c.getPruneTs(), DecidedRetention: retention}
+
+	prunedCounts := make([]int, len(c.nodeIds))
+	fanOut(len(c.nodeIds), func(nodeIdx int) {
+		c.callNodeWithPolicy(c.getRetryPolicy().withoutRetries(), nodeIdx, "Node.PruneHistory", args,
+			&prunedCounts[nodeIdx])
+	})
+	prunedCount := 0
+	for _, count := range prunedCounts {
+		prunedCount += count
+	}
+	return prunedCount
+}
+
+// PruneHistory removes the versions of rows removed at or before args.Ts from the fragments on this node, and forgets
+// the transactions decided longer than args.DecidedRetention ago, and sets reply as the number of removed versions.
+func (n *Node) PruneHistory(args PruneHistory, reply *int) error {
+	n.tablesMu.RLock()
+	prunedCount := 0
+	for _, t := range n.TableMap {
+		prunedCount += t.PruneVersions(args.Ts)
+	}
+	n.tablesMu.RUnlock()
+
+	n.txns.mu.Lock()
+	defer n.txns.mu.Unlock()
+	for txnId, decidedAt := range n.txns.decided {
+		if time.Since(decidedAt) >= args.DecidedRetention {
+			delete(n.txns.decided, txnId)
+		}
+	}
+	*reply = prunedCount
+	return nil
+}
+
+// getReadSnapshot returns the snapshot of a read of a table within the transaction with the given identifier, which is
+// taken when the transaction begins, or a new snapshot if txnId is empty. A serializable transaction locks the table
+// in shared mode first, and reads the latest committed rows, which are not changed by others until it ends.
+func (c *Cluster) getReadSnapshot(txnId string, tableName string) (Snapshot, error) {
+	if txnId == "" {
+		return c.takeSnapshot(), nil
+	}
+	txn, err := c.getOpenTxn(txnId)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if !txn.serializable {
+		return Snapshot{Ts: txn.snapshotTs, TxnId: txnId}, nil
+	}
+	if err := c.lockTable(txnId, tableName, LockShared); err != nil {
+		return Snapshot{}, err
+	}
+	// a transaction committing rows into the table holds its locks until the commit is applied by every node, so the
+	// latest commit timestamp is safe to read
+	c.txnLog.mu.Lock()
+	defer c.txnLog.mu.Unlock()
+	return Snapshot{Ts: c.txnLog.lastCommitTs, TxnId: txnId}, nil
+}
+
+// splitSnapshot returns the arguments of a node method without the trailing Snapshot appended by callNodeStep, and
+// the snapshot, which is the zero value if there is none.
+func splitSnapshot(args []interface{}) ([]interface{}, Snapshot) {
+	if len(args) > 0 {
+		if snapshot, ok := args[len(args)-1].(Snapshot); ok {
+			return args[:len(args)-1], snapshot
+		}
+	}
+	return args, Snapshot{}
+}
+
+// snapshotRowIterator iterates the rows of a fragment as seen by a snapshot, which are the rows committed as of the
+// timestamp of the snapshot, followed by the rows staged by its transaction. The deletes staged by the transaction
+// are not applied until it commits.
+func (n *Node) snapshotRowIterator(table *Table, fragmentName string, snapshot Snapshot) RowIterator {
+	committed := table.RowIteratorAt(snapshot.Ts)
+	if snapshot.TxnId == "" {
+		return committed
+	}
+	n.txns.mu.Lock()
+	defer n.txns.mu.Unlock()
+	stagedRows := make([]Row, 0)
+	for _, write := range n.txns.prepared[snapshot.TxnId] {
+		if write.FragmentName == fragmentName && !write.IsDelete {
+			stagedRows = append(stagedRows, write.Row)
+		}
+	}
+	return &stagedRowIterator{committed: committed, staged: stagedRows}
+}
+
+// stagedRowIterator iterates the committed rows of a fragment, and then the rows staged by a transaction.
+type stagedRowIterator struct {
+	committed RowIterator
+	staged    []Row
+}
+
+func (iter *stagedRowIterator) HasNext() bool {
+	return iter.committed.HasNext() || len(iter.staged) > 0
+}
+
+func (iter *stagedRowIterator) Next() *Row {
+	if iter.committed.HasNext() {
+		return iter.committed.Next()
+	}
+	if len(iter.staged) == 0 {
+		return nil
+	}
+	row := iter.staged[0]
+	iter.staged = iter.staged[1:]
+	return &row
+}
+
+// setSnapshot sets the snapshot read by a step and its children.
+func (step *planStep) setSnapshot(snapshot Snapshot) {
+	step.snapshot = snapshot
+	for _, child := range step.children {
+		child.setSnapshot(snapshot)
+	}
+}
+
+// executePlanAtSnapshot executes a plan reading a new snapshot (see takeSnapshot), so that all of its steps read the
+// nodes as of the same timestamp.
+func (c *Cluster) executePlanAtSnapshot(step *planStep) (Dataset, error) {
+	step.setSnapshot(c.takeSnapshot())
+	return c.executePlan(step)
+}
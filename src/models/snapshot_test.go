@@ -0,0 +1,289 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// collectRows returns the rows of an iterator.
+func collectRows(iterator RowIterator) []Row {
+	rows := make([]Row, 0)
+	for iterator.HasNext() {
+		rows = append(rows, *iterator.Next())
+	}
+	return rows
+}
+
+func TestVersionedRowStore(t *testing.T) {
+	store := NewMemoryVersionedRowStore()
+	store.insert(&Row{0, "John"})
+	store.insertAt(&Row{1, "Smith"}, 3)
+	store.insertAt(&Row{2, "Hana"}, 5)
+	store.removeAt(&Row{0, "John"}, 4)
+
+	for _, testCase := range []struct {
+		ts           int64
+		expectedRows []Row
+	}{
+		{2, []Row{{0, "John"}}},
+		{3, []Row{{0, "John"}, {1, "Smith"}}},
+		{4, []Row{{1, "Smith"}}},
+		{5, []Row{{1, "Smith"}, {2, "Hana"}}},
+		{latestTs, []Row{{1, "Smith"}, {2, "Hana"}}},
+	} {
+		rows := collectRows(store.iteratorAt(testCase.ts))
+		if len(rows) != len(testCase.expectedRows) {
+			t.Errorf("Expected rows %v at %d, actual %v", testCase.expectedRows, testCase.ts, rows)
+			continue
+		}
+		for i := range rows {
+			if !rows[i].Equals(&testCase.expectedRows[i]) {
+				t.Errorf("Expected rows %v at %d, actual %v", testCase.expectedRows, testCase.ts, rows)
+				break
+			}
+		}
+	}
+	if store.count() != 2 {
+		t.Errorf("Expected 2 rows in the store, actual %d", store.count())
+	}
+}
+
+// checkRows checks the rows of an iterator against the expected rows in order.
+func checkRows(t *testing.T, msg string, iterator RowIterator, expectedRows ...Row) {
+	rows := collectRows(iterator)
+	if len(rows) != len(expectedRows) {
+		t.Errorf("%s: expected rows %v, actual %v", msg, expectedRows, rows)
+		return
+	}
+	for i := range rows {
+		if !rows[i].Equals(&expectedRows[i]) {
+			t.Errorf("%s: expected rows %v, actual %v", msg, expectedRows, rows)
+			return
+		}
+	}
+}
+
+func TestVersionedRowStorePrune(t *testing.T) {
+	store := NewMemoryVersionedRowStore()
+	store.insert(&Row{0, "John"})
+	store.insertAt(&Row{1, "Smith"}, 3)
+	store.insertAt(&Row{2, "Hana"}, 5)
+	store.removeAt(&Row{0, "John"}, 4)
+	store.removeAt(&Row{2, "Hana"}, 6)
+	// the iterator is at the version of John, which is pruned before it moves on
+	iterator := store.iteratorAt(3)
+
+	// the last version is kept even if it is removed
+	if prunedCount := store.prune(6); prunedCount != 1 {
+		t.Errorf("Expected 1 version to be pruned, actual %d", prunedCount)
+	}
+	checkRows(t, "Reading at the pruning timestamp", store.iteratorAt(6), Row{1, "Smith"})
+	checkRows(t, "Reading the latest versions", store.iteratorAt(latestTs), Row{1, "Smith"})
+	store.insertAt(&Row{3, "Lewis"}, 7)
+	checkRows(t, "Reading at a later timestamp", store.iteratorAt(7), Row{1, "Smith"}, Row{3, "Lewis"})
+	checkRows(t, "Moving on from a pruned version", iterator, Row{1, "Smith"})
+	if prunedCount := store.prune(7); prunedCount != 1 {
+		t.Errorf("Expected 1 version to be pruned, actual %d", prunedCount)
+	}
+	if store.count() != 2 {
+		t.Errorf("Expected 2 rows in the store, actual %d", store.count())
+	}
+}
+
+func TestSnapshotHidesUndeliveredCommit(t *testing.T) {
+	setOperationSetup()
+	fragmentName := getStudentFragmentName("1|2")
+
+	// a student is prepared on both replicas, but the commit is only applied by node 1 so far
+	reply := ""
+	for _, nodeIdx := range []int{1, 2} {
+		if err := c.getNodeEnd(nodeIdx).CallWithError("Node.Prepare", TxnPrepare{TxnId: "half",
+			Writes: []FragmentRow{{FragmentName: fragmentName, Row: Row{5, 5, "Ann", 20, 3.9}}}},
+			&reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.txnLog.lastCommitTs++
+	commitTs := c.txnLog.lastCommitTs
+	// the commit is still being sent to node 2, so the reads do not send it again
+	c.txnLog.decisions["half"] = &txnDecision{commit: true, commitTs: commitTs, pendingNodeIdxs: []int{2},
+		sending: true}
+	if err := c.getNodeEnd(1).CallWithError("Node.Commit", TxnCommit{TxnId: "half", CommitTs: commitTs},
+		&reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// whichever replica is read, the student is not seen until the commit is applied by all of them
+	expectedDataset := Dataset{Schema: *studentTableSchema, Rows: studentRows}
+	dataset := Dataset{}
+	if err := c.GetFullTableDataset(studentTableName, &dataset); err != nil {
+		t.Fatal(err)
+	}
+	if !compareDataset(dataset, expectedDataset) {
+		t.Errorf("The half-committed row should not be seen, expected %v, actual %v", expectedDataset, dataset)
+	}
+	checkSids(t, "Before the commit is applied", studentTableName, "", 0, 1, 2, 3, 4)
+	results := Dataset{}
+	cli.Call("Cluster.Aggregate", AggregateQuery{TableName: studentTableName,
+		Aggregates: []Aggregate{{Func: AggregateCount, Column: "*"}}}, &results)
+	if len(results.Rows) != 1 || results.Rows[0][0] != int64(len(studentRows)) {
+		t.Errorf("Expected %d students before the commit is applied, actual %v", len(studentRows), results)
+	}
+
+	c.txnLog.mu.Lock()
+	c.txnLog.decisions["half"].sending = false
+	c.txnLog.mu.Unlock()
+	checkSids(t, "After the commit is applied", studentTableName, "", 0, 1, 2, 3, 4, 5)
+}
+
+func TestSnapshotCursor(t *testing.T) {
+	setOperationSetup()
+
+	cursorId := ""
+	if err := cli.CallWithError("Cluster.OpenCursor", SelectQuery{TableName: studentTableName,
+		Columns: []string{"sid"}}, &cursorId); err != nil {
+		t.Fatal(err)
+	}
+	// the rows written after the cursor is opened are not returned by the cursor
+	reply := ""
+	if err := cli.CallWithError("Cluster.FragmentWrite", []interface{}{studentTableName, Row{5, "Ann", 20, 3.9}},
+		&reply); err != nil {
+		t.Fatal(err)
+	}
+
+	sids := make(ValueSet)
+	for batch := (ScanBatch{}); !batch.Done; {
+		batch = ScanBatch{}
+		if err := cli.CallWithError("Cluster.FetchCursor", CursorFetch{CursorId: cursorId, BatchSize: 2},
+			&batch); err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range batch.Rows.Rows {
+			sids[row[0]] = true
+		}
+	}
+	if len(sids) != len(studentRows) || sids[5] {
+		t.Errorf("Expected the %d students as of the opening of the cursor, actual %v", len(studentRows), sids)
+	}
+	checkSids(t, "After the cursor", studentTableName, "", 0, 1, 2, 3, 4, 5)
+}
+
+func TestSnapshotResendsLostCommit(t *testing.T) {
+	setOperationSetup()
+	fragmentName := getStudentFragmentName("1|2")
+
+	// the commit of a student is lost on the way to node 2, and no write follows it
+	prepareStudent := func(txnId string, row Row) {
+		reply := ""
+		for _, nodeIdx := range []int{1, 2} {
+			if err := c.getNodeEnd(nodeIdx).CallWithError("Node.Prepare", TxnPrepare{TxnId: txnId,
+				Writes: []FragmentRow{{FragmentName: fragmentName, Row: row}}}, &reply); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	prepareStudent("lost", Row{5, 5, "Ann", 20, 3.9})
+	c.txnLog.mu.Lock()
+	c.txnLog.lastCommitTs++
+	commitTs := c.txnLog.lastCommitTs
+	c.txnLog.decisions["lost"] = &txnDecision{commit: true, commitTs: commitTs, pendingNodeIdxs: []int{2}}
+	c.txnLog.mu.Unlock()
+	reply := ""
+	if err := c.getNodeEnd(1).CallWithError("Node.Commit", TxnCommit{TxnId: "lost", CommitTs: commitTs},
+		&reply); err != nil {
+		t.Fatal(err)
+	}
+	// the read sends the commit again before it takes its snapshot
+	checkSids(t, "After the lost commit", studentTableName, "", 0, 1, 2, 3, 4, 5)
+
+	// the snapshots stay before a commit as long as its participant is unavailable, and node 1 is read instead
+	prepareStudent("pending", Row{6, 6, "Ben", 21, 3.9})
+	network.DeleteServer("Node2")
+	c.decideTxn("pending", true, []int{1, 2})
+	if snapshot := c.takeSnapshot(); snapshot.Ts != c.txnLog.lastCommitTs-1 {
+		t.Errorf("Expected the snapshot before the pending commit at %d, actual %d", c.txnLog.lastCommitTs-1,
+			snapshot.Ts)
+	}
+	checkSids(t, "While the commit is pending", studentTableName, "", 0, 1, 2, 3, 4, 5)
+}
+
+func TestPruneHistory(t *testing.T) {
+	setOperationSetup()
+	defer func() { c.txnLog.versionRetention = versionRetention }()
+
+	// each update removes a version of the student on both of its replicas
+	for _, age := range []int{30, 31} {
+		updatedCount := 0
+		if err := cli.CallWithError("Cluster.Update", UpdateQuery{TableName: studentTableName,
+			Where: map[string][]Condition{"sid": {{Op: "==", Val: 1}}}, Set: map[string]interface{}{"age": age}},
+			&updatedCount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the versions are kept for the reads that may have taken their snapshots before the updates
+	if prunedCount := c.pruneHistory(); prunedCount != 0 {
+		t.Errorf("Expected no version to be pruned within the retention, actual %d", prunedCount)
+	}
+
+	// an open transaction still reads the versions before the last update, and the history is only pruned by the test
+	// instead of in the background, see resolveTxns
+	c.txnLog.mu.Lock()
+	c.txnLog.versionRetention = 0
+	c.txnLog.lastPrunedAt = time.Now().Add(time.Hour)
+	c.txnLog.mu.Unlock()
+	txnId := ""
+	if err := c.Begin(nil, &txnId); err != nil {
+		t.Fatal(err)
+	}
+	c.txnLog.mu.Lock()
+	c.txnLog.open[txnId].snapshotTs--
+	c.txnLog.mu.Unlock()
+	if prunedCount := c.pruneHistory(); prunedCount != 2 {
+		t.Errorf("Expected the versions of the first update to be pruned, actual %d", prunedCount)
+	}
+	reply := ""
+	if err := c.Rollback(txnId, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if prunedCount := c.pruneHistory(); prunedCount != 2 {
+		t.Errorf("Expected the versions of the last update to be pruned, actual %d", prunedCount)
+	}
+	checkSids(t, "After the pruning", studentTableName, "", 0, 1, 2, 3, 4)
+	checkStudent(t, "After the pruning", Row{1, "Smith", 31, 3.5})
+
+	// an idle transaction is rolled back, instead of holding the pruning back
+	if err := cli.CallWithError("Cluster.Begin", 0, &txnId); err != nil {
+		t.Fatal(err)
+	}
+	updatedCount := 0
+	if err := cli.CallWithError("Cluster.Update", UpdateQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"sid": {{Op: "==", Val: 1}}}, Set: map[string]interface{}{"age": 32}},
+		&updatedCount); err != nil {
+		t.Fatal(err)
+	}
+	c.txnLog.mu.Lock()
+	c.txnLog.txnIdleTimeout = 0
+	c.txnLog.mu.Unlock()
+	defer func() { c.txnLog.txnIdleTimeout = txnIdleTimeout }()
+	c.abortIdleTxns()
+	err := cli.CallWithError("Cluster.Commit", txnId, &reply)
+	checkErrorCode(t, "Committing an idle transaction", err, ErrTimeout)
+	if prunedCount := c.pruneHistory(); prunedCount != 2 {
+		t.Errorf("Expected the versions read by the idle transaction to be pruned, actual %d", prunedCount)
+	}
+}
+
+func TestNodePruneHistory(t *testing.T) {
+	n := NewNode("0")
+	n.txns.decided["old"] = time.Now().Add(-time.Hour)
+	n.txns.decided["new"] = time.Now()
+
+	// the transactions decided within the retention are kept, so that their late calls are still told apart
+	prunedCount := 0
+	if err := n.PruneHistory(PruneHistory{Ts: initialTs, DecidedRetention: time.Minute}, &prunedCount); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.txns.decided["old"]; ok || len(n.txns.decided) != 1 {
+		t.Errorf("Expected only the transaction decided within the retention to be kept, actual %v", n.txns.decided)
+	}
+}
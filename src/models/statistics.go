@@ -0,0 +1,154 @@
+package models
+
+import "math"
+
+// FragmentStatistics summarizes the rows of a table fragment on a node.
+type FragmentStatistics struct {
+	RowCount int
+	// DistinctCounts[colName] -> number of distinct values in the column
+	DistinctCounts map[string]int
+	// ColumnBytes[colName] -> estimated bytes of all values in the column
+	ColumnBytes map[string]int64
+}
+
+// TableStatistics estimates the size of a table from the statistics of its fragments.
+type TableStatistics struct {
+	RowCount int
+	// DistinctCounts[colName] -> estimated number of distinct values in the column
+	DistinctCounts map[string]int
+	// ColumnWidths[colName] -> estimated average bytes of a value in the column
+	ColumnWidths map[string]float64
+	// number of fragments of the table, without counting replicas
+	FragmentCount int
+}
+
+// rowIdxWidth is the estimated bytes of a row idx, which is shipped with the rows of every fragment.
+const rowIdxWidth = 8
+
+// estimateValueBytes returns the approximate number of bytes needed to send a value through the network.
+func estimateValueBytes(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 1
+	case bool:
+		return 1
+	case int32, float32:
+		return 4
+	case string:
+		return int64(len(v)) + 1
+	default:
+		return 8
+	}
+}
+
+// GetFragmentStatistics computes the statistics of a fragment on this node.
+func (n *Node) GetFragmentStatistics(fragmentName string, reply *FragmentStatistics) error {
+	table, ok := n.getTable(fragmentName)
+	if !ok {
+		return noSuchTableError(fragmentName)
+	}
+
+	colCount := table.GetColumnCount()
+	distinctValueSets := make([]ValueSet, colCount)
+	columnBytes := make([]int64, colCount)
+	for i := range distinctValueSets {
+		distinctValueSets[i] = make(ValueSet)
+	}
+
+	reply.RowCount = 0
+	rowIterator := table.RowIterator()
+	for rowIterator.HasNext() {
+		row := *rowIterator.Next()
+		reply.RowCount++
+		// skip the row idx in the first column
+		for colIdx := 0; colIdx < colCount; colIdx++ {
+			distinctValueSets[colIdx][row[colIdx+1]] = true
+			columnBytes[colIdx] += estimateValueBytes(row[colIdx+1])
+		}
+	}
+
+	reply.DistinctCounts = make(map[string]int)
+	reply.ColumnBytes = make(map[string]int64)
+	for colIdx := 0; colIdx < colCount; colIdx++ {
+		reply.DistinctCounts[table.GetColumnName(colIdx)] = len(distinctValueSets[colIdx])
+		reply.ColumnBytes[table.GetColumnName(colIdx)] = columnBytes[colIdx]
+	}
+	return nil
+}
+
+// getTableStatistics collects the statistics of one replica of each fragment of a table and combines them.
+// Fragments of different rules are assumed to hold disjoint rows, so the numbers are upper bounds if the partition
+// predicates overlap. It returns an error if no replica of some fragment replies.
+func (c *Cluster) getTableStatistics(tableName string) (TableStatistics, error) {
+	schema, _ := c.getTableSchema(tableName)
+	stats := TableStatistics{
+		DistinctCounts: make(map[string]int),
+		ColumnWidths:   make(map[string]float64),
+		FragmentCount:  len(c.getNodeRules(tableName)),
+	}
+
+	// colRowCounts[colName] -> number of rows holding the column
+	colRowCounts := make(map[string]int)
+	colBytes := make(map[string]int64)
+	for _, nodeRule := range c.getNodeRules(tableName) {
+		var fragmentStats FragmentStatistics
+		fragmentName := getFragmentName(tableName, nodeRule.Rule.RuleIdx)
+		if err := c.callReplica(nodeRule, "Node.GetFragmentStatistics", fragmentName, &fragmentStats); err != nil {
+			return TableStatistics{}, err
+		}
+
+		for colName, distinctCount := range fragmentStats.DistinctCounts {
+			colRowCounts[colName] += fragmentStats.RowCount
+			colBytes[colName] += fragmentStats.ColumnBytes[colName]
+			stats.DistinctCounts[colName] += distinctCount
+		}
+	}
+
+	// each row holds every column in some fragment
+	for _, colRowCount := range colRowCounts {
+		if colRowCount > stats.RowCount {
+			stats.RowCount = colRowCount
+		}
+	}
+
+	for _, colSchema := range schema.ColumnSchemas {
+		colName := colSchema.Name
+		if stats.DistinctCounts[colName] > stats.RowCount {
+			stats.DistinctCounts[colName] = stats.RowCount
+		}
+		if colRowCounts[colName] > 0 {
+			stats.ColumnWidths[colName] = float64(colBytes[colName]) / float64(colRowCounts[colName])
+		} else {
+			stats.ColumnWidths[colName] = float64(estimateValueBytes(nil))
+		}
+	}
+
+	return stats, nil
+}
+
+// RowWidth returns the estimated bytes of a full row of the table, including its row idx.
+func (stats *TableStatistics) RowWidth() float64 {
+	width := float64(rowIdxWidth)
+	for _, colWidth := range stats.ColumnWidths {
+		width += colWidth
+	}
+	return width
+}
+
+// KeyWidth returns the estimated bytes of the values of the given columns in a row.
+func (stats *TableStatistics) KeyWidth(colNames []string) float64 {
+	width := 0.0
+	for _, colName := range colNames {
+		width += stats.ColumnWidths[colName]
+	}
+	return width
+}
+
+// KeyDistinctCount estimates the number of distinct combinations of values of the given columns.
+func (stats *TableStatistics) KeyDistinctCount(colNames []string) float64 {
+	distinctCount := 1.0
+	for _, colName := range colNames {
+		distinctCount *= float64(stats.DistinctCounts[colName])
+	}
+	return math.Min(distinctCount, float64(stats.RowCount))
+}
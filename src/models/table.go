@@ -36,11 +36,30 @@ func (t *Table) RowIterator() RowIterator {
 	return t.rowStore.iterator()
 }
 
+// RowIteratorAt iterates the rows visible at the given timestamp if the store keeps the versions of its rows (see
+// VersionedRowStore), otherwise the current rows.
+func (t *Table) RowIteratorAt(ts int64) RowIterator {
+	if store, ok := t.rowStore.(VersionedRowStore); ok {
+		return store.iteratorAt(ts)
+	}
+	return t.rowStore.iterator()
+}
+
 // Insert inserts a row into the store. The row will be copied by the store.
 func (t *Table) Insert(row *Row) {
 	t.rowStore.insert(row)
 }
 
+// InsertAt inserts a row committed at the given timestamp into the store, the timestamp is ignored if the store does
+// not keep the versions of its rows.
+func (t *Table) InsertAt(row *Row, ts int64) {
+	if store, ok := t.rowStore.(VersionedRowStore); ok {
+		store.insertAt(row, ts)
+		return
+	}
+	t.rowStore.insert(row)
+}
+
 // Remove removes a row from the store, and does not concern whether it exists.
 func (t *Table) Remove(row *Row) {
 	t.rowStore.remove(row)
@@ -50,3 +69,22 @@ func (t *Table) Remove(row *Row) {
 func (t *Table) Count() int {
 	return t.rowStore.count()
 }
+
+// RemoveAt removes a row from the store at the given timestamp, the removed row is still visible at the earlier
+// timestamps if the store keeps the versions of its rows.
+func (t *Table) RemoveAt(row *Row, ts int64) {
+	if store, ok := t.rowStore.(VersionedRowStore); ok {
+		store.removeAt(row, ts)
+		return
+	}
+	t.rowStore.remove(row)
+}
+
+// PruneVersions removes the versions of rows removed at or before the given timestamp if the store keeps the versions
+// of its rows, as no read at the timestamp or later sees them, and returns the number of removed versions.
+func (t *Table) PruneVersions(ts int64) int {
+	if store, ok := t.rowStore.(VersionedRowStore); ok {
+		return store.prune(ts)
+	}
+	return 0
+}
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// txnIdleTimeout is how long a transaction begun by Begin is kept open without being used, a transaction abandoned by
+// its client is rolled back after that (see abortIdleTxns), so that it does not hold its locks, or the versions of rows
+// read by its snapshot, forever.
+const txnIdleTimeout = time.Minute
+
+// TxnOptions is the optional argument of Begin.
+type TxnOptions struct {
+	// whether the transaction is serializable, see Begin
+	Serializable bool
+}
+
+// TxnWrite inserts a row into a table within a transaction begun by Begin.
+type TxnWrite struct {
+	TxnId     string
+	TableName string
+	// Un-partitioned row (follows cluster's table schema)
+	Row Row
+}
+
+// openTxn is a transaction begun by Begin that is not committed or rolled back yet.
+type openTxn struct {
+	// the timestamp of the snapshot read by the transaction, taken when it begins
+	snapshotTs int64
+	// the nodes where the transaction may have staged writes or taken locks, they are the participants of its commit
+	nodeIdxs map[int]bool
+	// whether the transaction takes locks, see Begin
+	serializable bool
+	// when the transaction is begun or used last time, see txnIdleTimeout
+	lastUsedAt time.Time
+}
+
+// Begin begins a transaction and sets reply as its identifier. The rows written by the transaction (see TxnWrite) are
+// staged on the nodes, they are only seen by the reads within the transaction (see SelectQuery.TxnId) until the
+// transaction is committed by Commit, and they are discarded if it is rolled back by Rollback. The reads within the
+// transaction see the snapshot taken when it begins, along with the rows written by the transaction.
+// If args is TxnOptions with Serializable set, the transaction takes the locks of two-phase locking instead (see
+// Node.Lock), a read locks the table in shared mode and a write locks its row in exclusive mode, and the locks are
+// held until the transaction ends. The transactions waiting for the locks of each other are aborted by deadlock
+// detection (see findDeadlockVictim). The serializable transactions are only isolated from each other, as the other
+// writes do not take locks.
+func (c *Cluster) Begin(args interface{}, reply *string) error {
+	options, _ := args.(TxnOptions)
+	txnId := c.newTxnId()
+	snapshot := c.takeSnapshot()
+	c.txnLog.mu.Lock()
+	c.txnLog.open[txnId] = &openTxn{snapshotTs: snapshot.Ts, nodeIdxs: make(map[int]bool),
+		serializable: options.Serializable, lastUsedAt: time.Now()}
+	c.txnLog.mu.Unlock()
+	*reply = txnId
+	return nil
+}
+
+// getOpenTxn returns the open transaction with the given identifier, or an error if it is not open.
+func (c *Cluster) getOpenTxn(txnId string) (*openTxn, error) {
+	c.txnLog.mu.Lock()
+	defer c.txnLog.mu.Unlock()
+	return c.getOpenTxnLocked(txnId)
+}
+
+// getOpenTxnLocked is getOpenTxn, the caller should hold c.txnLog.mu. The transaction is used, so that it is not
+// rolled back as idle, see txnIdleTimeout.
+func (c *Cluster) getOpenTxnLocked(txnId string) (*openTxn, error) {
+	txn, ok := c.txnLog.open[txnId]
+	if !ok {
+		if _, ok := c.txnLog.deadlocked[txnId]; ok {
+			return nil, newError(ErrDeadlock, "transaction "+txnId+" was aborted to break a deadlock")
+		}
+		if _, ok := c.txnLog.expired[txnId]; ok {
+			return nil, newError(ErrTimeout, "transaction "+txnId+" was rolled back as it timed out")
+		}
+		return nil, newError(ErrInvalidArgument, "transaction "+txnId+" is not open")
+	}
+	txn.lastUsedAt = time.Now()
+	return txn, nil
+}
+
+// abortIdleTxns rolls back the open transactions that are not used within txnIdleTimeout, e.g. as their clients are
+// gone.
+func (c *Cluster) abortIdleTxns() {
+	c.txnLog.mu.Lock()
+	idleTxnIds := make([]string, 0)
+	for txnId, txn := range c.txnLog.open {
+		if time.Since(txn.lastUsedAt) >= c.txnLog.txnIdleTimeout {
+			idleTxnIds = append(idleTxnIds, txnId)
+		}
+	}
+	c.txnLog.mu.Unlock()
+
+	for _, txnId := range idleTxnIds {
+		c.abortTimedOutTxn(txnId)
+	}
+}
+
+// abortTimedOutTxn rolls back an open transaction that timed out, so that its later requests fail with ErrTimeout. It
+// does nothing if the transaction is no longer open.
+func (c *Cluster) abortTimedOutTxn(txnId string) {
+	c.txnLog.mu.Lock()
+	nodeIdxs, err := c.closeTxnLocked(txnId)
+	if err == nil {
+		c.txnLog.expired[txnId] = time.Now()
+	}
+	c.txnLog.mu.Unlock()
+	if err == nil {
+		c.decideTxn(txnId, false, nodeIdxs)
+	}
+}
+
+// addParticipant adds a node as a participant of an open transaction, before the transaction changes anything on the
+// node, so that the node receives the decision of the transaction.
+func (c *Cluster) addParticipant(txnId string, nodeIdx int) error {
+	c.txnLog.mu.Lock()
+	defer c.txnLog.mu.Unlock()
+	txn, err := c.getOpenTxnLocked(txnId)
+	if err != nil {
+		return err
+	}
+	txn.nodeIdxs[nodeIdx] = true
+	return nil
+}
+
+// closeTxn removes an open transaction, and returns its participants in ascending order.
+func (c *Cluster) closeTxn(txnId string) ([]int, error) {
+	c.txnLog.mu.Lock()
+	defer c.txnLog.mu.Unlock()
+	return c.closeTxnLocked(txnId)
+}
+
+// closeTxnLocked is closeTxn, the caller should hold c.txnLog.mu.
+func (c *Cluster) closeTxnLocked(txnId string) ([]int, error) {
+	txn, err := c.getOpenTxnLocked(txnId)
+	if err != nil {
+		return nil, err
+	}
+	delete(c.txnLog.open, txnId)
+	nodeIdxs := make([]int, 0, len(txn.nodeIdxs))
+	for nodeIdx := range txn.nodeIdxs {
+		nodeIdxs = append(nodeIdxs, nodeIdx)
+	}
+	sort.Ints(nodeIdxs)
+	return nodeIdxs, nil
+}
+
+// TxnWrite inserts a row into a table within a transaction, the row is staged on the nodes holding its fragments
+// (see Node.Stage). The row idx of the row is allocated at once, and it is not reused if the transaction is rolled
+// back. If the row cannot be staged on some node, the transaction is rolled back, so that it is not committed
+// without the row. A serializable transaction locks the row on every node before staging it.
+func (c *Cluster) TxnWrite(args TxnWrite, reply *string) error {
+	txn, err := c.getOpenTxn(args.TxnId)
+	if err != nil {
+		return err
+	}
+	schema, err := c.checkRow(args.TableName, args.Row)
+	if err != nil {
+		return err
+	}
+	rowIdx := c.allocateRowIdx(args.TableName)
+	nodeWrites := c.routeRow(schema, rowIdx, args.Row)
+
+	if txn.serializable {
+		if err := c.lockRows(args.TxnId, nodeWrites); err != nil {
+			c.abortTxn(args.TxnId)
+			return err
+		}
+	}
+	calls := make([]nodeCall, 0)
+	for nodeIdx, writes := range nodeWrites {
+		// a node is a participant even if the call is lost, as it may stage the row anyway
+		if err := c.addParticipant(args.TxnId, nodeIdx); err != nil {
+			return err
+		}
+		calls = append(calls, nodeCall{nodeIdx: nodeIdx, method: "Node.Stage",
+			args: TxnPrepare{TxnId: args.TxnId, Writes: writes}})
+	}
+
+	// a staged row is skipped when it is staged again, so the calls can be retried
+	if err := c.callNodes(calls, c.getRetryPolicy()); err != nil {
+		c.abortTxn(args.TxnId)
+		return err
+	}
+	*reply = fmt.Sprintf("Successfully wrote row %d of table %s in transaction %s", rowIdx, args.TableName,
+		args.TxnId)
+	return nil
+}
+
+// Commit commits a transaction by two-phase commit (see commitTxn), so that either all or none of the rows written by
+// the transaction are applied. The transaction is rolled back if some participant fails to prepare it, and the error
+// is returned then.
+func (c *Cluster) Commit(txnId string, reply *string) error {
+	nodeIdxs, err := c.closeTxn(txnId)
+	if err != nil {
+		return err
+	}
+	c.resolveTxns()
+	// the writes are staged on the participants already
+	nodeWrites := make(map[int][]FragmentRow)
+	for _, nodeIdx := range nodeIdxs {
+		nodeWrites[nodeIdx] = nil
+	}
+	if err := c.commitTxn(txnId, nodeWrites); err != nil {
+		return err
+	}
+	*reply = "Successfully committed transaction " + txnId
+	return nil
+}
+
+// abortTxn aborts an open transaction that cannot be committed, it does nothing if the transaction is not open.
+func (c *Cluster) abortTxn(txnId string) {
+	if nodeIdxs, err := c.closeTxn(txnId); err == nil {
+		c.decideTxn(txnId, false, nodeIdxs)
+	}
+}
+
+// Rollback rolls back a transaction, the rows written by the transaction are discarded by the participants.
+func (c *Cluster) Rollback(txnId string, reply *string) error {
+	nodeIdxs, err := c.closeTxn(txnId)
+	if err != nil {
+		return err
+	}
+	c.decideTxn(txnId, false, nodeIdxs)
+	*reply = "Successfully rolled back transaction " + txnId
+	return nil
+}
+
+// Stage stages the writes of a transaction on this node without changing the fragments, they are applied when the
+// transaction is committed (see Commit), or discarded when it is aborted (see Abort). Until then, they are only seen
+// by the scans of the transaction (see snapshotRowIterator).
+func (n *Node) Stage(args TxnPrepare, reply *string) error {
+	n.txns.mu.Lock()
+	defer n.txns.mu.Unlock()
+	if err := n.stageWrites(args); err != nil {
+		return err
+	}
+	*reply = fmt.Sprintf("Successfully staged %d rows of transaction %s on Node %s", len(args.Writes), args.TxnId,
+		n.Identifier)
+	return nil
+}
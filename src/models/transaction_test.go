@@ -0,0 +1,151 @@
+package models
+
+import (
+	"testing"
+)
+
+// selectSids returns the sids of a table in order, read within the transaction with the given identifier.
+func selectSids(t *testing.T, tableName string, txnId string) []interface{} {
+	results := Dataset{}
+	if err := cli.CallWithError("Cluster.Select", SelectQuery{TableName: tableName, Columns: []string{"sid"},
+		OrderBy: []OrderBy{{Column: "sid"}}, TxnId: txnId}, &results); err != nil {
+		t.Fatal(err)
+	}
+	sids := make([]interface{}, len(results.Rows))
+	for i, row := range results.Rows {
+		sids[i] = row[0]
+	}
+	return sids
+}
+
+// checkSids checks that the sids of a table read within a transaction are the expected ones.
+func checkSids(t *testing.T, name string, tableName string, txnId string, expectedSids ...interface{}) {
+	sids := selectSids(t, tableName, txnId)
+	if len(sids) != len(expectedSids) {
+		t.Errorf("%s: expected sids %v in %s, actual %v", name, expectedSids, tableName, sids)
+		return
+	}
+	for i := range sids {
+		if sids[i] != expectedSids[i] {
+			t.Errorf("%s: expected sids %v in %s, actual %v", name, expectedSids, tableName, sids)
+			return
+		}
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	setOperationSetup()
+
+	txnId := ""
+	if err := cli.CallWithError("Cluster.Begin", 0, &txnId); err != nil {
+		t.Fatal(err)
+	}
+	reply := ""
+	for _, write := range []TxnWrite{
+		{TxnId: txnId, TableName: studentTableName, Row: Row{5, "Ann", 20, 3.9}},
+		{TxnId: txnId, TableName: courseRegistrationTableName, Row: Row{5, 0}},
+	} {
+		if err := cli.CallWithError("Cluster.TxnWrite", write, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the rows are only seen within the transaction until it is committed
+	checkSids(t, "Before the commit", studentTableName, "", 0, 1, 2, 3, 4)
+	checkSids(t, "Before the commit", courseRegistrationTableName, "", 0, 0, 1, 2)
+	checkSids(t, "Within the transaction", studentTableName, txnId, 0, 1, 2, 3, 4, 5)
+	checkSids(t, "Within the transaction", courseRegistrationTableName, txnId, 0, 0, 1, 2, 5)
+	results := Dataset{}
+	if err := cli.CallWithError("Cluster.Select", SelectQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"sid": {{Op: ">", Val: 4}}}, TxnId: txnId}, &results); err != nil {
+		t.Fatal(err)
+	}
+	if !compareDataset(results, Dataset{Schema: *studentTableSchema, Rows: []Row{{5, "Ann", 20, 3.9}}}) {
+		t.Errorf("Incorrect students written by the transaction: %v", results)
+	}
+
+	if err := cli.CallWithError("Cluster.Commit", txnId, &reply); err != nil {
+		t.Fatal(err)
+	}
+	checkSids(t, "After the commit", studentTableName, "", 0, 1, 2, 3, 4, 5)
+	checkSids(t, "After the commit", courseRegistrationTableName, "", 0, 0, 1, 2, 5)
+
+	err := cli.CallWithError("Cluster.Commit", txnId, &reply)
+	checkErrorCode(t, "Committing a transaction twice", err, ErrInvalidArgument)
+	err = cli.CallWithError("Cluster.Select", SelectQuery{TableName: studentTableName, TxnId: txnId}, &results)
+	checkErrorCode(t, "Reading within a committed transaction", err, ErrInvalidArgument)
+}
+
+func TestTransactionRollback(t *testing.T) {
+	setOperationSetup()
+
+	txnId := ""
+	cli.Call("Cluster.Begin", 0, &txnId)
+	reply := ""
+	if err := cli.CallWithError("Cluster.TxnWrite",
+		TxnWrite{TxnId: txnId, TableName: studentTableName, Row: Row{5, "Ann", 20, 3.0}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.CallWithError("Cluster.Rollback", txnId, &reply); err != nil {
+		t.Fatal(err)
+	}
+	checkSids(t, "After the rollback", studentTableName, "", 0, 1, 2, 3, 4)
+
+	err := cli.CallWithError("Cluster.TxnWrite",
+		TxnWrite{TxnId: txnId, TableName: studentTableName, Row: Row{6, "Ben", 20, 3.0}}, &reply)
+	checkErrorCode(t, "Writing within a rolled back transaction", err, ErrInvalidArgument)
+	err = cli.CallWithError("Cluster.Commit", txnId, &reply)
+	checkErrorCode(t, "Committing a rolled back transaction", err, ErrInvalidArgument)
+}
+
+func TestTransactionIsolation(t *testing.T) {
+	setOperationSetup()
+
+	txnIds := make([]string, 2)
+	reply := ""
+	for i := range txnIds {
+		cli.Call("Cluster.Begin", 0, &txnIds[i])
+		if err := cli.CallWithError("Cluster.TxnWrite", TxnWrite{TxnId: txnIds[i], TableName: studentTableName,
+			Row: Row{5 + i, "Ann", 20, 3.0}}, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a transaction does not see the rows written by another open transaction
+	checkSids(t, "Within the first transaction", studentTableName, txnIds[0], 0, 1, 2, 3, 4, 5)
+	checkSids(t, "Within the second transaction", studentTableName, txnIds[1], 0, 1, 2, 3, 4, 6)
+
+	// the first transaction still reads the snapshot taken when it began
+	cli.Call("Cluster.Commit", txnIds[1], &reply)
+	checkSids(t, "Within the first transaction", studentTableName, txnIds[0], 0, 1, 2, 3, 4, 5)
+	cli.Call("Cluster.Rollback", txnIds[0], &reply)
+	checkSids(t, "After both transactions", studentTableName, "", 0, 1, 2, 3, 4, 6)
+}
+
+func TestTransactionWriteFailure(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+
+	txnId := ""
+	cli.Call("Cluster.Begin", 0, &txnId)
+	reply := ""
+	if err := cli.CallWithError("Cluster.TxnWrite",
+		TxnWrite{TxnId: txnId, TableName: studentTableName, Row: Row{5, "Ann", 20, 3.0}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// the student cannot be staged on node 2, so the transaction is rolled back
+	network.DeleteServer("Node2")
+	err := cli.CallWithError("Cluster.TxnWrite",
+		TxnWrite{TxnId: txnId, TableName: studentTableName, Row: Row{6, "Ben", 20, 3.9}}, &reply)
+	checkErrorCode(t, "Writing a row to a deleted node", err, ErrUnavailable)
+	err = cli.CallWithError("Cluster.Commit", txnId, &reply)
+	checkErrorCode(t, "Committing a rolled back transaction", err, ErrInvalidArgument)
+
+	fragmentName := getStudentFragmentName("0|1")
+	for _, nodeIdx := range []int{0, 1} {
+		if getFragmentSids(t, nodeIdx, fragmentName)[5] {
+			t.Errorf("The row of the rolled back transaction should not be written to node %d", nodeIdx)
+		}
+	}
+}
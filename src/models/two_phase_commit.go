@@ -0,0 +1,430 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FragmentRow is a row to write into a fragment, the first column of the row is its row idx. If IsDelete is true, the
+// row with the row idx is deleted from the fragment instead, and Row only holds the row idx.
+type FragmentRow struct {
+	FragmentName string
+	Row          Row
+	IsDelete     bool
+	// the timestamp of the snapshot the row to delete was found by, if it is not 0 the delete is rejected with
+	// ErrConflict when the row in the fragment has changed since then, see stageWrites
+	ReadTs int64
+}
+
+// TxnCommit asks a node to commit a transaction, the rows written by the transaction are committed at CommitTs.
+type TxnCommit struct {
+	TxnId    string
+	CommitTs int64
+}
+
+// TxnPrepare asks a node to prepare the writes of a transaction, see Node.Prepare.
+type TxnPrepare struct {
+	TxnId  string
+	Writes []FragmentRow
+}
+
+// nodeTxns holds the writes staged by the transactions of a node that are not decided yet, and the decided
+// transactions, so that a late Stage, Prepare or Lock of a decided transaction is rejected. It holds the locks of the
+// transactions as well, see Node.Lock.
+type nodeTxns struct {
+	prepared map[string][]FragmentRow
+	// transaction -> when it is decided on this node, the old ones are pruned, see Node.PruneHistory
+	decided map[string]time.Time
+	// lock key -> transaction -> mode of the lock held by the transaction, see getLockKey
+	locks map[string]map[string]LockMode
+	// transaction -> keys of the locks held by the transaction
+	heldLockKeys map[string][]string
+	// transaction -> the transactions holding the locks it waits for
+	waitsFor map[string][]string
+	mu       sync.Mutex
+}
+
+func newNodeTxns() *nodeTxns {
+	return &nodeTxns{prepared: make(map[string][]FragmentRow), decided: make(map[string]time.Time),
+		locks: make(map[string]map[string]LockMode), heldLockKeys: make(map[string][]string),
+		waitsFor: make(map[string][]string)}
+}
+
+// Prepare stages the writes of a transaction like Stage, and the node is ready to commit the transaction if no error
+// is returned. The fragments are not changed until Commit, or the staged writes are discarded by Abort. A transaction
+// that is already decided cannot be prepared.
+func (n *Node) Prepare(args TxnPrepare, reply *string) error {
+	n.txns.mu.Lock()
+	defer n.txns.mu.Unlock()
+	if err := n.stageWrites(args); err != nil {
+		return err
+	}
+	*reply = fmt.Sprintf("Successfully prepared transaction %s on Node %s", args.TxnId, n.Identifier)
+	return nil
+}
+
+// stageWrites adds the writes to the staged writes of a transaction, after checking that the fragments exist, the rows
+// match their schemas, and no row idx is written twice into a fragment unless the row is deleted by the transaction
+// first. A row idx written by another undecided transaction is rejected with ErrConflict, so that two transactions
+// never both delete or write a row, e.g. moving it into two fragments, and so is a delete of a row that has changed
+// since its ReadTs, so that a row found by a stale snapshot is not deleted or replaced. A write that is already staged
+// is skipped, so that a lost call can be retried. The caller should hold n.txns.mu.
+func (n *Node) stageWrites(args TxnPrepare) error {
+	if _, ok := n.txns.decided[args.TxnId]; ok {
+		return newError(ErrInvalidArgument, "transaction "+args.TxnId+" is already decided on "+n.Identifier)
+	}
+
+	staged := n.txns.prepared[args.TxnId]
+	// fragment name -> row idx -> the other transaction writing the row idx
+	otherTxnIds := make(map[string]map[interface{}]string)
+	for txnId, writes := range n.txns.prepared {
+		if txnId == args.TxnId {
+			continue
+		}
+		for _, write := range writes {
+			if otherTxnIds[write.FragmentName] == nil {
+				otherTxnIds[write.FragmentName] = make(map[interface{}]string)
+			}
+			otherTxnIds[write.FragmentName][write.Row[0]] = txnId
+		}
+	}
+	checkConflict := func(write FragmentRow) error {
+		if txnId, ok := otherTxnIds[write.FragmentName][write.Row[0]]; ok {
+			return newError(ErrConflict, fmt.Sprintf("row %v of table %s is written by transaction %s",
+				write.Row[0], write.FragmentName, txnId))
+		}
+		return nil
+	}
+	// fragment name -> row idx -> row written by the transaction
+	stagedRows := make(map[string]map[interface{}]Row)
+	// fragment name -> row idxs of the rows deleted by the transaction
+	stagedDeletes := make(map[string]ValueSet)
+	// fragment name -> row idxs of the committed rows, collected once for a batch of writes
+	committedRowIdxs := make(map[string]ValueSet)
+	// fragment name -> read ts -> row idxs of the rows changed since the read ts
+	changedRowIdxs := make(map[string]map[int64]ValueSet)
+	for _, write := range staged {
+		stageWrite(stagedRows, stagedDeletes, write)
+	}
+	for _, write := range args.Writes {
+		t, ok := n.getTable(write.FragmentName)
+		if !ok {
+			return noSuchTableError(write.FragmentName)
+		}
+		if write.IsDelete {
+			if len(write.Row) != 1 {
+				return newError(ErrInvalidArgument, fmt.Sprintf("the delete of %v from table %s should only hold "+
+					"a row idx", write.Row, write.FragmentName))
+			}
+			if !stagedDeletes[write.FragmentName][write.Row[0]] {
+				if err := checkConflict(write); err != nil {
+					return err
+				}
+				if write.ReadTs != 0 {
+					if changedRowIdxs[write.FragmentName] == nil {
+						changedRowIdxs[write.FragmentName] = make(map[int64]ValueSet)
+					}
+					if changedRowIdxs[write.FragmentName][write.ReadTs] == nil {
+						changedRowIdxs[write.FragmentName][write.ReadTs] = getChangedRowIdxs(t, write.ReadTs)
+					}
+					if changedRowIdxs[write.FragmentName][write.ReadTs][write.Row[0]] {
+						return newError(ErrConflict, fmt.Sprintf("row %v of table %s has changed since %d",
+							write.Row[0], write.FragmentName, write.ReadTs))
+					}
+				}
+				stageWrite(stagedRows, stagedDeletes, write)
+				staged = append(staged, write)
+			}
+			continue
+		}
+
+		if len(write.Row) != len(t.schema.ColumnSchemas)+1 {
+			return newError(ErrSchemaMismatch, fmt.Sprintf("row %v doesn't match the %d columns of table %s",
+				write.Row, len(t.schema.ColumnSchemas), write.FragmentName))
+		}
+		stagedRow, isWritten := stagedRows[write.FragmentName][write.Row[0]]
+		if isWritten && stagedRow.Equals(&write.Row) {
+			continue
+		}
+		if err := checkConflict(write); err != nil {
+			return err
+		}
+		if committedRowIdxs[write.FragmentName] == nil {
+			committedRowIdxs[write.FragmentName] = make(ValueSet)
+			for iterator := t.RowIterator(); iterator.HasNext(); {
+				committedRowIdxs[write.FragmentName][(*iterator.Next())[0]] = true
+			}
+		}
+		// a committed row deleted by the transaction is replaced by the write
+		if isWritten || (committedRowIdxs[write.FragmentName][write.Row[0]] &&
+			!stagedDeletes[write.FragmentName][write.Row[0]]) {
+			return newError(ErrDuplicateKey, fmt.Sprintf("row %v already exists in table %s", write.Row[0],
+				write.FragmentName))
+		}
+		stageWrite(stagedRows, stagedDeletes, write)
+		staged = append(staged, write)
+	}
+
+	n.txns.prepared[args.TxnId] = staged
+	return nil
+}
+
+// getChangedRowIdxs returns the row idxs of the rows of a fragment that are inserted, removed or replaced since the
+// given timestamp, by comparing the rows visible at the timestamp with the latest ones.
+func getChangedRowIdxs(t *Table, ts int64) ValueSet {
+	// row idx -> row visible at ts
+	readRows := make(map[interface{}]Row)
+	for iterator := t.RowIteratorAt(ts); iterator.HasNext(); {
+		row := iterator.Next()
+		readRows[(*row)[0]] = *row
+	}
+	changed := make(ValueSet)
+	for iterator := t.RowIterator(); iterator.HasNext(); {
+		row := iterator.Next()
+		if readRow, ok := readRows[(*row)[0]]; !ok || !readRow.Equals(row) {
+			changed[(*row)[0]] = true
+		}
+		delete(readRows, (*row)[0])
+	}
+	for rowIdx := range readRows {
+		changed[rowIdx] = true
+	}
+	return changed
+}
+
+// stageWrite records a staged write in the rows written and the row idxs deleted by a transaction, see stageWrites.
+func stageWrite(stagedRows map[string]map[interface{}]Row, stagedDeletes map[string]ValueSet, write FragmentRow) {
+	if write.IsDelete {
+		if stagedDeletes[write.FragmentName] == nil {
+			stagedDeletes[write.FragmentName] = make(ValueSet)
+		}
+		stagedDeletes[write.FragmentName][write.Row[0]] = true
+		return
+	}
+	if stagedRows[write.FragmentName] == nil {
+		stagedRows[write.FragmentName] = make(map[interface{}]Row)
+	}
+	stagedRows[write.FragmentName][write.Row[0]] = write.Row
+}
+
+// Commit applies the staged writes of a transaction at its commit timestamp, so that they are only seen by the
+// snapshots at or after the timestamp (see Snapshot), and releases the locks of the transaction. The staged deletes are
+// applied before the rows are inserted, so that a row replaced by the transaction is not deleted with the old one.
+// Committing a transaction that is not prepared on this node does nothing, so a Commit can be retried.
+func (n *Node) Commit(args TxnCommit, reply *string) error {
+	n.txns.mu.Lock()
+	defer n.txns.mu.Unlock()
+	writes := n.txns.prepared[args.TxnId]
+	for _, write := range writes {
+		// the fragment may be dropped after the transaction is prepared
+		if t, ok := n.getTable(write.FragmentName); ok && write.IsDelete {
+			removeRowAt(t, write.Row[0], args.CommitTs)
+		}
+	}
+	for _, write := range writes {
+		row := write.Row
+		if t, ok := n.getTable(write.FragmentName); ok && !write.IsDelete {
+			t.InsertAt(&row, args.CommitTs)
+		}
+	}
+	delete(n.txns.prepared, args.TxnId)
+	n.txns.decided[args.TxnId] = time.Now()
+	n.releaseLocks(args.TxnId)
+	*reply = fmt.Sprintf("Successfully committed transaction %s on Node %s", args.TxnId, n.Identifier)
+	return nil
+}
+
+// removeRowAt removes the row with the given row idx from a fragment at the given timestamp, it does nothing if the
+// fragment has no such row.
+func removeRowAt(t *Table, rowIdx interface{}, ts int64) {
+	for iterator := t.RowIterator(); iterator.HasNext(); {
+		if row := iterator.Next(); (*row)[0] == rowIdx {
+			t.RemoveAt(row, ts)
+			return
+		}
+	}
+}
+
+// Abort discards the staged writes of a transaction and releases its locks. Aborting a transaction that is not
+// prepared on this node does nothing but rejecting its late Prepare, so an Abort can be retried.
+func (n *Node) Abort(txnId string, reply *string) error {
+	n.txns.mu.Lock()
+	defer n.txns.mu.Unlock()
+	delete(n.txns.prepared, txnId)
+	n.txns.decided[txnId] = time.Now()
+	n.releaseLocks(txnId)
+	*reply = fmt.Sprintf("Successfully aborted transaction %s on Node %s", txnId, n.Identifier)
+	return nil
+}
+
+// txnDecision is an entry of the decision log of the coordinator, it is kept until every participant of the
+// transaction has received the decision.
+type txnDecision struct {
+	commit bool
+	// the commit timestamp of a committed transaction
+	commitTs int64
+	// the participants that have not received the decision yet
+	pendingNodeIdxs []int
+	// whether the decision is being sent to the pending participants, so that it is not sent twice concurrently
+	sending bool
+}
+
+// commitRecord is when a transaction is committed, so that the versions removed by it are kept for versionRetention,
+// see getPruneTs.
+type commitRecord struct {
+	commitTs    int64
+	committedAt time.Time
+}
+
+// txnLog is the decision log of the coordinator, and the transactions begun by Begin that are not decided yet.
+type txnLog struct {
+	decisions map[string]*txnDecision
+	open      map[string]*openTxn
+	// the transactions aborted to break deadlocks, see abortVictim, and when
+	deadlocked map[string]time.Time
+	// the transactions rolled back as they timed out, see abortTimedOutTxn, and when
+	expired   map[string]time.Time
+	nextTxnId int
+	// the commit timestamp of the last committed transaction, see Snapshot
+	lastCommitTs int64
+	// the commits that are not older than versionRetention yet, in the order of their timestamps
+	recentCommits []commitRecord
+	// when the history of the nodes is pruned last time, see pruneHistory
+	lastPrunedAt time.Time
+	// see txnIdleTimeout, lockWaitTimeout and versionRetention, they are shortened by the tests
+	txnIdleTimeout   time.Duration
+	lockWaitTimeout  time.Duration
+	versionRetention time.Duration
+	mu               sync.Mutex
+}
+
+func newTxnLog() *txnLog {
+	return &txnLog{decisions: make(map[string]*txnDecision), open: make(map[string]*openTxn),
+		deadlocked: make(map[string]time.Time), expired: make(map[string]time.Time), lastCommitTs: initialTs,
+		lastPrunedAt: time.Now(), txnIdleTimeout: txnIdleTimeout, lockWaitTimeout: lockWaitTimeout,
+		versionRetention: versionRetention}
+}
+
+// writeAtomically writes the rows of fragments on the nodes, nodeWrites[nodeIdx] being the writes on a node, so that
+// either all or none of them are applied, see commitTxn.
+func (c *Cluster) writeAtomically(nodeWrites map[int][]FragmentRow) error {
+	c.resolveTxns()
+	return c.commitTxn(c.newTxnId(), nodeWrites)
+}
+
+// newTxnId returns the identifier of a new transaction.
+func (c *Cluster) newTxnId() string {
+	c.txnLog.mu.Lock()
+	defer c.txnLog.mu.Unlock()
+	c.txnLog.nextTxnId++
+	return "txn" + strconv.Itoa(c.txnLog.nextTxnId)
+}
+
+// commitTxn commits a transaction on its participants by two-phase commit, nodeWrites[nodeIdx] being the writes to
+// stage on a participant, which may be empty if the participant has staged its writes already (see Node.Stage). Every
+// participant prepares the transaction first, and the transaction is committed only if all of them are prepared, or
+// aborted otherwise. The decision is recorded in the decision log before it is sent to the participants, so that a
+// participant missing the decision receives it later (see resolveTxns).
+// It returns the error of the first participant that failed to prepare, in which case no write is applied.
+func (c *Cluster) commitTxn(txnId string, nodeWrites map[int][]FragmentRow) error {
+	nodeIdxs := make([]int, 0, len(nodeWrites))
+	for nodeIdx := range nodeWrites {
+		nodeIdxs = append(nodeIdxs, nodeIdx)
+	}
+	sort.Ints(nodeIdxs)
+
+	// phase 1: prepare on every node
+	errs := make([]error, len(nodeIdxs))
+	fanOut(len(nodeIdxs), func(i int) {
+		var prepareReply string
+		_, errs[i] = c.callNode(nodeIdxs[i], "Node.Prepare",
+			TxnPrepare{TxnId: txnId, Writes: nodeWrites[nodeIdxs[i]]}, &prepareReply)
+	})
+	var prepareErr error
+	for _, err := range errs {
+		if err != nil {
+			prepareErr = err
+			break
+		}
+	}
+
+	// phase 2: record the decision, and send it to every node
+	c.decideTxn(txnId, prepareErr == nil, nodeIdxs)
+	return prepareErr
+}
+
+// decideTxn records the decision of a transaction in the decision log, and sends it to the participants. A committed
+// transaction is assigned the next commit timestamp.
+func (c *Cluster) decideTxn(txnId string, commit bool, nodeIdxs []int) {
+	decision := &txnDecision{commit: commit, pendingNodeIdxs: nodeIdxs, sending: true}
+	c.txnLog.mu.Lock()
+	if commit {
+		c.txnLog.lastCommitTs++
+		decision.commitTs = c.txnLog.lastCommitTs
+		c.txnLog.recentCommits = append(c.txnLog.recentCommits,
+			commitRecord{commitTs: decision.commitTs, committedAt: time.Now()})
+	}
+	c.txnLog.decisions[txnId] = decision
+	c.txnLog.mu.Unlock()
+	c.sendDecision(txnId, decision)
+}
+
+// sendDecision sends the decision of a transaction to its pending participants, the participants that receive it are
+// no longer pending, and the decision is removed from the log once no participant is pending. The caller should have
+// marked the decision as being sent, and the pending participants are not changed by others until it is sent.
+func (c *Cluster) sendDecision(txnId string, decision *txnDecision) {
+	var method string
+	var args interface{}
+	if decision.commit {
+		method, args = "Node.Commit", TxnCommit{TxnId: txnId, CommitTs: decision.commitTs}
+	} else {
+		method, args = "Node.Abort", txnId
+	}
+	isSent := make([]bool, len(decision.pendingNodeIdxs))
+	fanOut(len(decision.pendingNodeIdxs), func(i int) {
+		var decisionReply string
+		_, err := c.callNode(decision.pendingNodeIdxs[i], method, args, &decisionReply)
+		isSent[i] = err == nil
+	})
+
+	c.txnLog.mu.Lock()
+	defer c.txnLog.mu.Unlock()
+	pendingNodeIdxs := make([]int, 0)
+	for i, nodeIdx := range decision.pendingNodeIdxs {
+		if !isSent[i] {
+			pendingNodeIdxs = append(pendingNodeIdxs, nodeIdx)
+		}
+	}
+	decision.pendingNodeIdxs = pendingNodeIdxs
+	decision.sending = false
+	if len(pendingNodeIdxs) == 0 {
+		delete(c.txnLog.decisions, txnId)
+	}
+}
+
+// resolveTxns sends the logged decisions to the participants that have not received them yet, e.g. as they were
+// unavailable when the transactions were decided. The decisions being sent by others are skipped. The idle transactions
+// are rolled back first (see abortIdleTxns), and the history of the nodes is pruned once in a while, see pruneHistory.
+func (c *Cluster) resolveTxns() {
+	c.abortIdleTxns()
+	c.txnLog.mu.Lock()
+	if time.Since(c.txnLog.lastPrunedAt) >= c.txnLog.versionRetention {
+		c.txnLog.lastPrunedAt = time.Now()
+		go c.pruneHistory()
+	}
+	decisions := make(map[string]*txnDecision, len(c.txnLog.decisions))
+	for txnId, decision := range c.txnLog.decisions {
+		if !decision.sending {
+			decision.sending = true
+			decisions[txnId] = decision
+		}
+	}
+	c.txnLog.mu.Unlock()
+
+	for txnId, decision := range decisions {
+		c.sendDecision(txnId, decision)
+	}
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"strconv"
+	"testing"
+)
+
+// getStudentFragmentName returns the name of the fragment of student held by the given nodes, e.g. "1|2".
+func getStudentFragmentName(nodeIndices string) string {
+	for _, nodeRule := range c.TableNodeRulesMap[studentTableName] {
+		if nodeRule.NodeIndices == nodeIndices {
+			return getFragmentName(studentTableName, nodeRule.Rule.RuleIdx)
+		}
+	}
+	return ""
+}
+
+// getFragmentSids returns the sids of the students in a fragment of student on a node.
+func getFragmentSids(t *testing.T, nodeIdx int, fragmentName string) ValueSet {
+	dataset := Dataset{}
+	if err := c.getNodeEnd(nodeIdx).CallWithError("Node.ScanTable", fragmentName, &dataset); err != nil {
+		t.Fatal(err)
+	}
+	sids := make(ValueSet)
+	for _, row := range dataset.Rows {
+		sids[row[0]] = true
+	}
+	return sids
+}
+
+func TestTwoPhaseCommitAllOrNone(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+	highGradeFragmentName := getStudentFragmentName("1|2")
+
+	// the student is written to the replicas on node 1 and 2, node 2 cannot prepare the write
+	network.DeleteServer("Node2")
+	reply := ""
+	err := cli.CallWithError("Cluster.FragmentWrite", []interface{}{studentTableName, Row{5, "Ann", 20, 3.9}},
+		&reply)
+	checkErrorCode(t, "Writing a row to a deleted node", err, ErrUnavailable)
+	if getFragmentSids(t, 1, highGradeFragmentName)[5] {
+		t.Errorf("The aborted row should not be written to node 1")
+	}
+
+	// the row idx of the aborted row is reused by the next row
+	if err := cli.CallWithError("Cluster.FragmentWrite", []interface{}{studentTableName, Row{6, "Ben", 20, 3.0}},
+		&reply); err != nil {
+		t.Fatal(err)
+	}
+	if c.TableRowCountMap[studentTableName] != len(studentRows)+1 {
+		t.Errorf("Expected %d rows in student, actual %d", len(studentRows)+1, c.TableRowCountMap[studentTableName])
+	}
+	lowGradeFragmentName := getStudentFragmentName("0|1")
+	for _, nodeIdx := range []int{0, 1} {
+		if !getFragmentSids(t, nodeIdx, lowGradeFragmentName)[6] {
+			t.Errorf("The committed row should be written to node %d", nodeIdx)
+		}
+	}
+}
+
+func TestTwoPhaseCommitPrepare(t *testing.T) {
+	setOperationSetup()
+	fragmentName := getStudentFragmentName("0|1")
+	node := c.getNodeEnd(0)
+
+	reply := ""
+	err := node.CallWithError("Node.Prepare", TxnPrepare{TxnId: "t1",
+		Writes: []FragmentRow{{FragmentName: fragmentName, Row: Row{1, 1, "Smith", 23, 3.5}}}}, &reply)
+	checkErrorCode(t, "Preparing a row idx that is already written", err, ErrDuplicateKey)
+	err = node.CallWithError("Node.Prepare", TxnPrepare{TxnId: "t1", Writes: []FragmentRow{
+		{FragmentName: fragmentName, Row: Row{7, 7, "Amy", 20, 3.0}},
+		{FragmentName: fragmentName, Row: Row{7, 8, "Bob", 20, 3.0}},
+	}}, &reply)
+	checkErrorCode(t, "Preparing a row idx twice", err, ErrDuplicateKey)
+
+	// a prepared row is not visible until it is committed
+	if err := node.CallWithError("Node.Prepare", TxnPrepare{TxnId: "t2",
+		Writes: []FragmentRow{{FragmentName: fragmentName, Row: Row{7, 7, "Amy", 20, 3.0}}}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if getFragmentSids(t, 0, fragmentName)[7] {
+		t.Errorf("The prepared row should not be visible before the commit")
+	}
+	if err := node.CallWithError("Node.Commit", TxnCommit{TxnId: "t2", CommitTs: 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !getFragmentSids(t, 0, fragmentName)[7] {
+		t.Errorf("The committed row should be visible")
+	}
+
+	// a decided transaction cannot be prepared again
+	err = node.CallWithError("Node.Prepare", TxnPrepare{TxnId: "t2"}, &reply)
+	checkErrorCode(t, "Preparing a committed transaction", err, ErrInvalidArgument)
+}
+
+func TestTwoPhaseCommitPrepareConflict(t *testing.T) {
+	setOperationSetup()
+	fragmentName := getStudentFragmentName("0|1")
+	node := c.getNodeEnd(0)
+
+	// only one of the transactions deleting the same row concurrently is prepared
+	errs := make([]error, concurrentClientCount)
+	fanOut(concurrentClientCount, func(i int) {
+		reply := ""
+		errs[i] = node.CallWithError("Node.Prepare", TxnPrepare{TxnId: "t" + strconv.Itoa(i),
+			Writes: []FragmentRow{{FragmentName: fragmentName, Row: Row{1}, IsDelete: true}}}, &reply)
+	})
+	preparedTxnId := ""
+	for i, err := range errs {
+		if err == nil {
+			if preparedTxnId != "" {
+				t.Errorf("Transactions %s and t%d should not both be prepared", preparedTxnId, i)
+			}
+			preparedTxnId = "t" + strconv.Itoa(i)
+		} else {
+			checkErrorCode(t, "Deleting a row deleted by another transaction", err, ErrConflict)
+		}
+	}
+	if preparedTxnId == "" {
+		t.Fatal("One of the transactions should be prepared")
+	}
+
+	// a row idx written by the prepared transaction cannot be written by others until it is decided
+	reply := ""
+	err := node.CallWithError("Node.Prepare", TxnPrepare{TxnId: "t-insert",
+		Writes: []FragmentRow{{FragmentName: fragmentName, Row: Row{1, 1, "Smith", 24, 3.5}}}}, &reply)
+	checkErrorCode(t, "Writing a row deleted by another transaction", err, ErrConflict)
+	if err := node.CallWithError("Node.Abort", preparedTxnId, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.CallWithError("Node.Prepare", TxnPrepare{TxnId: "t-delete",
+		Writes: []FragmentRow{{FragmentName: fragmentName, Row: Row{1}, IsDelete: true}}}, &reply); err != nil {
+		t.Errorf("The row should be deleted after the other transaction is aborted: %v", err)
+	}
+}
+
+func TestTwoPhaseCommitDecisionRedelivery(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+	lowGradeFragmentName := getStudentFragmentName("0|1")
+	highGradeFragmentName := getStudentFragmentName("1|2")
+
+	// the participants missed the decisions of two prepared transactions, which are still in the log
+	reply := ""
+	if err := c.getNodeEnd(0).CallWithError("Node.Prepare", TxnPrepare{TxnId: "committed",
+		Writes: []FragmentRow{{FragmentName: lowGradeFragmentName, Row: Row{10, 5, "Ann", 20, 3.0}}}},
+		&reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.getNodeEnd(2).CallWithError("Node.Prepare", TxnPrepare{TxnId: "aborted",
+		Writes: []FragmentRow{{FragmentName: highGradeFragmentName, Row: Row{11, 6, "Ben", 20, 4.0}}}},
+		&reply); err != nil {
+		t.Fatal(err)
+	}
+	c.txnLog.decisions["committed"] = &txnDecision{commit: true, pendingNodeIdxs: []int{0}}
+	c.txnLog.decisions["aborted"] = &txnDecision{commit: false, pendingNodeIdxs: []int{2}}
+
+	// the decisions are delivered before the next write
+	if err := cli.CallWithError("Cluster.FragmentWrite", []interface{}{studentTableName, Row{7, "Amy", 20, 3.0}},
+		&reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.txnLog.decisions) != 0 {
+		t.Errorf("Every decision should be delivered, actual %d pending", len(c.txnLog.decisions))
+	}
+	if !getFragmentSids(t, 0, lowGradeFragmentName)[5] {
+		t.Errorf("The committed row should be written to node 0")
+	}
+	if getFragmentSids(t, 2, highGradeFragmentName)[6] {
+		t.Errorf("The aborted row should not be written to node 2")
+	}
+	// the aborted transaction cannot be prepared late
+	err := c.getNodeEnd(2).CallWithError("Node.Prepare", TxnPrepare{TxnId: "aborted"}, &reply)
+	checkErrorCode(t, "Preparing an aborted transaction", err, ErrInvalidArgument)
+}
@@ -0,0 +1,326 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// LockMode is the mode of a lock taken by a transaction, see Node.Lock.
+type LockMode int
+
+const (
+	// taken on a fragment before locking some of its rows in shared mode
+	LockIntentionShared LockMode = iota
+	// taken on a fragment before locking some of its rows in exclusive mode
+	LockIntentionExclusive
+	LockShared
+	LockExclusive
+)
+
+// lockCompatibility[held][requested] tells whether a lock can be granted while another transaction holds a lock of the
+// same key.
+var lockCompatibility = [][]bool{
+	LockIntentionShared:    {true, true, true, false},
+	LockIntentionExclusive: {true, true, false, false},
+	LockShared:             {true, false, true, false},
+	LockExclusive:          {false, false, false, false},
+}
+
+// tableLockIdx is the RowIdx of a TxnLock on a whole fragment.
+const tableLockIdx = -1
+
+// lockRetryInterval is how long a transaction waits before requesting a lock held by others again.
+const lockRetryInterval = 5 * time.Millisecond
+
+// lockWaitTimeout is how long a transaction waits for a lock, it is rolled back after that, as the lock may be held by
+// a transaction that is not deadlocked with it but never ends, e.g. as its client is gone (see txnIdleTimeout).
+const lockWaitTimeout = 10 * time.Second
+
+// TxnLock asks a node to lock a fragment or a row of it for a transaction.
+type TxnLock struct {
+	TxnId        string
+	FragmentName string
+	// the row idx of the row to lock, or tableLockIdx to lock the whole fragment
+	RowIdx int
+	Mode   LockMode
+}
+
+// LockReply is the reply of Node.Lock.
+type LockReply struct {
+	Granted bool
+	// the transactions holding the conflicting locks if the lock is not granted
+	Blockers []string
+}
+
+// getLockKey returns the key of the lock of a row in a fragment, or of the whole fragment if rowIdx is tableLockIdx.
+func getLockKey(fragmentName string, rowIdx int) string {
+	if rowIdx == tableLockIdx {
+		return fragmentName
+	}
+	return fragmentName + "#" + strconv.Itoa(rowIdx)
+}
+
+// getIntentionMode returns the mode of the lock on a fragment taken before locking one of its rows in the given mode.
+func getIntentionMode(mode LockMode) LockMode {
+	if mode == LockShared || mode == LockIntentionShared {
+		return LockIntentionShared
+	}
+	return LockIntentionExclusive
+}
+
+// combineLockModes returns the mode of a lock held by a transaction after it requests the lock again in another mode,
+// which is the weaker one covering both modes.
+func combineLockModes(held LockMode, requested LockMode) LockMode {
+	switch {
+	case held == requested || held == LockExclusive || requested == LockIntentionShared:
+		return held
+	case requested == LockExclusive || held == LockIntentionShared:
+		return requested
+	default:
+		// a shared lock and an intention exclusive lock are only covered by an exclusive lock
+		return LockExclusive
+	}
+}
+
+// Lock takes a lock of a fragment or a row of it for a transaction on this node, locking a row takes the intention lock
+// on its fragment as well. Set reply as whether the lock is granted, or the transactions holding the conflicting locks
+// otherwise, which are recorded as waited for by the transaction until it requests a lock again (see WaitsFor). The
+// locks are held until the transaction is committed or aborted on this node, and a decided transaction cannot take
+// locks. Requesting a lock that is already held does nothing, so a Lock can be retried.
+func (n *Node) Lock(args TxnLock, reply *LockReply) error {
+	n.txns.mu.Lock()
+	defer n.txns.mu.Unlock()
+	if _, ok := n.txns.decided[args.TxnId]; ok {
+		return newError(ErrInvalidArgument, "transaction "+args.TxnId+" is already decided on "+n.Identifier)
+	}
+	if _, ok := n.getTable(args.FragmentName); !ok {
+		return noSuchTableError(args.FragmentName)
+	}
+
+	keys := []string{getLockKey(args.FragmentName, args.RowIdx)}
+	modes := []LockMode{args.Mode}
+	if args.RowIdx != tableLockIdx {
+		keys = append(keys, getLockKey(args.FragmentName, tableLockIdx))
+		modes = append(modes, getIntentionMode(args.Mode))
+	}
+	blockers := make([]string, 0)
+	for i, key := range keys {
+		for txnId, mode := range n.txns.locks[key] {
+			if txnId != args.TxnId && !lockCompatibility[mode][modes[i]] {
+				blockers = appendIfAbsent(blockers, txnId)
+			}
+		}
+	}
+	if len(blockers) > 0 {
+		sort.Strings(blockers)
+		n.txns.waitsFor[args.TxnId] = blockers
+		reply.Granted, reply.Blockers = false, blockers
+		return nil
+	}
+
+	delete(n.txns.waitsFor, args.TxnId)
+	for i, key := range keys {
+		if n.txns.locks[key] == nil {
+			n.txns.locks[key] = make(map[string]LockMode)
+		}
+		if held, ok := n.txns.locks[key][args.TxnId]; ok {
+			n.txns.locks[key][args.TxnId] = combineLockModes(held, modes[i])
+		} else {
+			n.txns.locks[key][args.TxnId] = modes[i]
+			n.txns.heldLockKeys[args.TxnId] = append(n.txns.heldLockKeys[args.TxnId], key)
+		}
+	}
+	reply.Granted = true
+	return nil
+}
+
+// releaseLocks releases the locks of a transaction on this node, and it no longer waits for others. The caller should
+// hold n.txns.mu.
+func (n *Node) releaseLocks(txnId string) {
+	for _, key := range n.txns.heldLockKeys[txnId] {
+		delete(n.txns.locks[key], txnId)
+		if len(n.txns.locks[key]) == 0 {
+			delete(n.txns.locks, key)
+		}
+	}
+	delete(n.txns.heldLockKeys, txnId)
+	delete(n.txns.waitsFor, txnId)
+}
+
+// WaitsFor sets reply as the wait-for graph of this node, reply[txnId] being the transactions holding the locks that
+// the transaction txnId waits for.
+func (n *Node) WaitsFor(args interface{}, reply *map[string][]string) error {
+	n.txns.mu.Lock()
+	defer n.txns.mu.Unlock()
+	graph := make(map[string][]string, len(n.txns.waitsFor))
+	for txnId, blockers := range n.txns.waitsFor {
+		graph[txnId] = append([]string(nil), blockers...)
+	}
+	*reply = graph
+	return nil
+}
+
+// lock takes a lock on a node for a transaction, waiting until it is granted. While the lock is held by others, the
+// coordinator looks for a deadlock and aborts its victim, and it returns an error if the victim is the transaction
+// itself. The transaction is rolled back if the lock is not granted within lockWaitTimeout, and ErrTimeout is
+// returned then.
+func (c *Cluster) lock(nodeIdx int, args TxnLock) error {
+	c.txnLog.mu.Lock()
+	timeout := c.txnLog.lockWaitTimeout
+	c.txnLog.mu.Unlock()
+	for startedAt := time.Now(); ; {
+		// the lock is released by the decision of the transaction, so the node should receive the decision
+		if err := c.addParticipant(args.TxnId, nodeIdx); err != nil {
+			return err
+		}
+		reply := LockReply{}
+		if _, err := c.callNode(nodeIdx, "Node.Lock", args, &reply); err != nil {
+			// the transaction may be aborted by the deadlock detection of another one meanwhile
+			if _, openErr := c.getOpenTxn(args.TxnId); openErr != nil {
+				return openErr
+			}
+			return err
+		}
+		if reply.Granted {
+			return nil
+		}
+		if victim := c.findDeadlockVictim(); victim != "" {
+			c.abortVictim(victim)
+		}
+		if time.Since(startedAt) >= timeout {
+			c.abortTimedOutTxn(args.TxnId)
+			return newError(ErrTimeout, "transaction "+args.TxnId+" was rolled back as it waited for a lock on "+
+				args.FragmentName+" longer than "+timeout.String())
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// lockTable locks every fragment of a table on every node holding it for a transaction.
+func (c *Cluster) lockTable(txnId string, tableName string, mode LockMode) error {
+	nodeRules := c.getNodeRules(tableName)
+	if nodeRules == nil {
+		return noSuchTableError(tableName)
+	}
+	for _, nodeRule := range nodeRules {
+		fragmentName := getFragmentName(tableName, nodeRule.Rule.RuleIdx)
+		for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+			if err := c.lock(nodeIdx, TxnLock{TxnId: txnId, FragmentName: fragmentName, RowIdx: tableLockIdx,
+				Mode: mode}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lockRows locks the rows to write on the nodes in exclusive mode for a transaction, nodeWrites[nodeIdx] being the
+// rows to write on a node. The nodes are locked in ascending order.
+func (c *Cluster) lockRows(txnId string, nodeWrites map[int][]FragmentRow) error {
+	nodeIdxs := make([]int, 0, len(nodeWrites))
+	for nodeIdx := range nodeWrites {
+		nodeIdxs = append(nodeIdxs, nodeIdx)
+	}
+	sort.Ints(nodeIdxs)
+	for _, nodeIdx := range nodeIdxs {
+		for _, write := range nodeWrites[nodeIdx] {
+			if err := c.lock(nodeIdx, TxnLock{TxnId: txnId, FragmentName: write.FragmentName,
+				RowIdx: write.Row[0].(int), Mode: LockExclusive}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findDeadlockVictim aggregates the wait-for graphs of the nodes (see Node.WaitsFor), and returns the youngest
+// transaction of a cycle in the graph, or an empty string if there is no cycle. The identifiers of the transactions
+// grow in the order they begin (see newTxnId). A node that gives no reply is skipped, its waits are checked again by
+// the next lock request.
+func (c *Cluster) findDeadlockVictim() string {
+	graphs := make([]map[string][]string, len(c.nodeIds))
+	fanOut(len(c.nodeIds), func(i int) {
+		c.callNode(i, "Node.WaitsFor", 0, &graphs[i])
+	})
+	waitsFor := make(map[string][]string)
+	for _, graph := range graphs {
+		for txnId, blockers := range graph {
+			waitsFor[txnId] = append(waitsFor[txnId], blockers...)
+		}
+	}
+
+	txnIds := make([]string, 0, len(waitsFor))
+	for txnId := range waitsFor {
+		txnIds = append(txnIds, txnId)
+	}
+	sort.Strings(txnIds)
+	// depth-first search from every transaction, a transaction on the path being searched is visited again by a cycle
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	states := make(map[string]int)
+	path := make([]string, 0)
+	var findCycle func(txnId string) []string
+	findCycle = func(txnId string) []string {
+		states[txnId] = onPath
+		path = append(path, txnId)
+		for _, blocker := range waitsFor[txnId] {
+			switch states[blocker] {
+			case onPath:
+				for i := range path {
+					if path[i] == blocker {
+						return path[i:]
+					}
+				}
+			case unvisited:
+				if cycle := findCycle(blocker); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		states[txnId] = done
+		path = path[:len(path)-1]
+		return nil
+	}
+	for _, txnId := range txnIds {
+		if states[txnId] != unvisited {
+			continue
+		}
+		if cycle := findCycle(txnId); cycle != nil {
+			victim := cycle[0]
+			for _, txnId := range cycle[1:] {
+				if isYoungerTxn(txnId, victim) {
+					victim = txnId
+				}
+			}
+			return victim
+		}
+	}
+	return ""
+}
+
+// isYoungerTxn returns true if the transaction txnId1 begins after txnId2, see newTxnId.
+func isYoungerTxn(txnId1 string, txnId2 string) bool {
+	if len(txnId1) != len(txnId2) {
+		return len(txnId1) > len(txnId2)
+	}
+	return txnId1 > txnId2
+}
+
+// abortVictim aborts the victim of a deadlock, so that its locks are released, and the later requests of the
+// transaction fail with ErrDeadlock. It does nothing if the transaction is not open, e.g. it is aborted already.
+func (c *Cluster) abortVictim(txnId string) {
+	c.txnLog.mu.Lock()
+	nodeIdxs, err := c.closeTxnLocked(txnId)
+	if err == nil {
+		c.txnLog.deadlocked[txnId] = time.Now()
+	}
+	c.txnLog.mu.Unlock()
+	if err != nil {
+		return
+	}
+	c.decideTxn(txnId, false, nodeIdxs)
+}
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// requestLock requests a lock on a node and returns whether it is granted.
+func requestLock(t *testing.T, nodeIdx int, args TxnLock) bool {
+	reply := LockReply{}
+	if err := c.getNodeEnd(nodeIdx).CallWithError("Node.Lock", args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply.Granted
+}
+
+// beginSerializable begins a serializable transaction and returns its identifier.
+func beginSerializable(t *testing.T) string {
+	txnId := ""
+	if err := cli.CallWithError("Cluster.Begin", TxnOptions{Serializable: true}, &txnId); err != nil {
+		t.Fatal(err)
+	}
+	return txnId
+}
+
+func TestNodeLocks(t *testing.T) {
+	setOperationSetup()
+	fragmentName := getStudentFragmentName("0|1")
+
+	for _, testCase := range []struct {
+		name            string
+		lock            TxnLock
+		expectedGranted bool
+	}{
+		{"Locking a row", TxnLock{"t1", fragmentName, 0, LockExclusive}, true},
+		{"Locking another row", TxnLock{"t2", fragmentName, 1, LockExclusive}, true},
+		{"Locking a row held by another transaction", TxnLock{"t2", fragmentName, 0, LockShared}, false},
+		{"Locking a row held by the transaction itself", TxnLock{"t1", fragmentName, 0, LockShared}, true},
+		{"Locking a fragment with locked rows", TxnLock{"t3", fragmentName, tableLockIdx, LockShared}, false},
+		{"Locking a row in a fragment with locked rows", TxnLock{"t3", fragmentName, 2, LockShared}, true},
+	} {
+		if granted := requestLock(t, 0, testCase.lock); granted != testCase.expectedGranted {
+			t.Errorf("%s: expected granted %v, actual %v", testCase.name, testCase.expectedGranted, granted)
+		}
+	}
+
+	graph := make(map[string][]string)
+	c.getNodeEnd(0).Call("Node.WaitsFor", 0, &graph)
+	// t3 no longer waits after its lock is granted
+	if len(graph) != 1 || len(graph["t2"]) != 1 || graph["t2"][0] != "t1" {
+		t.Errorf("Expected t2 to wait for t1, actual %v", graph)
+	}
+
+	// the locks of t1 are released when it is aborted
+	reply := ""
+	if err := c.getNodeEnd(0).CallWithError("Node.Abort", "t1", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !requestLock(t, 0, TxnLock{"t2", fragmentName, 0, LockShared}) {
+		t.Errorf("The lock released by an aborted transaction should be granted")
+	}
+	err := c.getNodeEnd(0).CallWithError("Node.Lock", TxnLock{"t1", fragmentName, 0, LockShared}, &LockReply{})
+	checkErrorCode(t, "Locking a row by an aborted transaction", err, ErrInvalidArgument)
+}
+
+func TestDeadlockVictim(t *testing.T) {
+	setOperationSetup()
+	studentFragmentName := getStudentFragmentName("0|1")
+	courseRegistrationFragmentName := getFragmentName(courseRegistrationTableName, 0)
+
+	// t1 and t2 hold a row on node 0 and node 2 respectively, and wait for the row held by each other
+	requestLock(t, 0, TxnLock{"t1", studentFragmentName, 0, LockExclusive})
+	requestLock(t, 2, TxnLock{"t2", courseRegistrationFragmentName, 0, LockExclusive})
+	requestLock(t, 2, TxnLock{"t1", courseRegistrationFragmentName, 0, LockShared})
+	if victim := c.findDeadlockVictim(); victim != "" {
+		t.Errorf("Expected no deadlock, actual victim %s", victim)
+	}
+	requestLock(t, 0, TxnLock{"t2", studentFragmentName, 0, LockShared})
+	if victim := c.findDeadlockVictim(); victim != "t2" {
+		t.Errorf("Expected the younger transaction t2 to be the victim, actual %q", victim)
+	}
+}
+
+func TestDeadlockAcrossNodes(t *testing.T) {
+	setOperationSetup()
+
+	// the first transaction reads student on node 0, 1 and 2, the second one reads courseRegistration on node 2, then
+	// each of them writes the table read by the other
+	txnIds := []string{beginSerializable(t), beginSerializable(t)}
+	selectSids(t, studentTableName, txnIds[0])
+	selectSids(t, courseRegistrationTableName, txnIds[1])
+
+	errs := make([]error, 2)
+	done := make(chan int)
+	for i, write := range []TxnWrite{
+		{TxnId: txnIds[0], TableName: courseRegistrationTableName, Row: Row{5, 0}},
+		{TxnId: txnIds[1], TableName: studentTableName, Row: Row{6, "Ben", 20, 3.0}},
+	} {
+		go func(i int, write TxnWrite) {
+			reply := ""
+			errs[i] = cli.CallWithError("Cluster.TxnWrite", write, &reply)
+			done <- i
+		}(i, write)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The deadlock is not broken")
+		}
+	}
+
+	// the younger transaction is aborted, so the older one gets its lock
+	if errs[0] != nil {
+		t.Errorf("The write of the older transaction should succeed, actual %v", errs[0])
+	}
+	checkErrorCode(t, "Writing within the victim of a deadlock", errs[1], ErrDeadlock)
+	reply := ""
+	err := cli.CallWithError("Cluster.Commit", txnIds[1], &reply)
+	checkErrorCode(t, "Committing the victim of a deadlock", err, ErrDeadlock)
+	if err := cli.CallWithError("Cluster.Commit", txnIds[0], &reply); err != nil {
+		t.Fatal(err)
+	}
+	checkSids(t, "After the deadlock", courseRegistrationTableName, "", 0, 0, 1, 2, 5)
+	checkSids(t, "After the deadlock", studentTableName, "", 0, 1, 2, 3, 4)
+}
+
+func TestSerializableReadBlocksWrite(t *testing.T) {
+	setOperationSetup()
+
+	reader := beginSerializable(t)
+	writer := beginSerializable(t)
+	lateReader := beginSerializable(t)
+	checkSids(t, "Within the reader", studentTableName, reader, 0, 1, 2, 3, 4)
+
+	// the student cannot be written until the reader ends
+	done := make(chan error)
+	go func() {
+		reply := ""
+		done <- cli.CallWithError("Cluster.TxnWrite",
+			TxnWrite{TxnId: writer, TableName: studentTableName, Row: Row{5, "Ann", 20, 3.9}}, &reply)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("The write should wait for the reader, actual %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	checkSids(t, "Within the reader", studentTableName, reader, 0, 1, 2, 3, 4)
+
+	reply := ""
+	if err := cli.CallWithError("Cluster.Commit", reader, &reply); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The write should be done after the reader commits")
+	}
+	if err := cli.CallWithError("Cluster.Commit", writer, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// a reader begun before the commit reads the latest committed rows once it gets its lock
+	checkSids(t, "After the writer commits", studentTableName, lateReader, 0, 1, 2, 3, 4, 5)
+}
+
+func TestLockWaitTimeout(t *testing.T) {
+	setOperationSetup()
+	c.txnLog.mu.Lock()
+	c.txnLog.lockWaitTimeout = 100 * time.Millisecond
+	c.txnLog.mu.Unlock()
+
+	// the reader holds its lock but never ends, so the writer gives up waiting for it
+	reader := beginSerializable(t)
+	writer := beginSerializable(t)
+	checkSids(t, "Within the reader", studentTableName, reader, 0, 1, 2, 3, 4)
+	reply := ""
+	err := cli.CallWithError("Cluster.TxnWrite",
+		TxnWrite{TxnId: writer, TableName: studentTableName, Row: Row{5, "Ann", 20, 3.9}}, &reply)
+	checkErrorCode(t, "Waiting for a lock held by an idle transaction", err, ErrTimeout)
+	err = cli.CallWithError("Cluster.Commit", writer, &reply)
+	checkErrorCode(t, "Committing a transaction that timed out", err, ErrTimeout)
+
+	// the reader is not affected, and the write is not applied
+	if err := cli.CallWithError("Cluster.Commit", reader, &reply); err != nil {
+		t.Fatal(err)
+	}
+	checkSids(t, "After the timeout", studentTableName, "", 0, 1, 2, 3, 4)
+
+	// the aborted transactions are forgotten with the decided ones
+	c.txnLog.mu.Lock()
+	c.txnLog.versionRetention = 0
+	c.txnLog.deadlocked["t0"] = time.Now()
+	c.txnLog.mu.Unlock()
+	c.pruneHistory()
+	c.txnLog.mu.Lock()
+	defer c.txnLog.mu.Unlock()
+	if len(c.txnLog.deadlocked) != 0 || len(c.txnLog.expired) != 0 {
+		t.Errorf("The aborted transactions should be pruned, actual %v and %v", c.txnLog.deadlocked,
+			c.txnLog.expired)
+	}
+}
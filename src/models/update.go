@@ -0,0 +1,93 @@
+package models
+
+// UpdateQuery sets the columns of the rows of a table that satisfy Where, like
+// UPDATE TableName SET Set... WHERE Where
+type UpdateQuery struct {
+	TableName string
+	// Where[colName] -> [Condition1, Condition2, ...], see SatisfiesPredicate, every row is updated if it is empty
+	Where map[string][]Condition
+	// Set[colName] -> the new value of the column
+	Set map[string]interface{}
+	// identifies the update, so that the rows are updated only once if it is retried, see RequestId
+	RequestId RequestId
+}
+
+// Update sets the columns of the rows of a table that satisfy the predicate of the query, and sets reply as the number
+// of updated rows. The rows are found as of a snapshot like Delete, then each of them is replaced by the updated row
+// with the same row idx: the old row is deleted from every fragment, and the updated row is written to the fragments
+// whose predicates it satisfies, so a row whose partition columns are updated moves to other horizontal fragments.
+// All rows are replaced atomically (see writeAtomically), so that a row is never held by both its old and new
+// fragments, or by neither of them. If a found row is changed by others before it is replaced, e.g. by a concurrent
+// update, the update is retried on a new snapshot (see retryConflicts), so that the changes are not lost. An updated
+// row must still satisfy some partition rule, see checkRouted.
+func (c *Cluster) Update(query UpdateQuery, reply *int) error {
+	schema, ok := c.getTableSchema(query.TableName)
+	if !ok {
+		return noSuchTableError(query.TableName)
+	}
+	for colName := range query.Where {
+		if schema.GetColIndexByName(colName) == -1 {
+			return missingColumnError(query.TableName, colName)
+		}
+	}
+	if len(query.Set) == 0 {
+		return newError(ErrInvalidArgument, "no column of table "+query.TableName+" is set by the update")
+	}
+	for colName, val := range query.Set {
+		colIdx := schema.GetColIndexByName(colName)
+		if colIdx == -1 {
+			return missingColumnError(query.TableName, colName)
+		}
+		if err := checkValue(schema, colIdx, val); err != nil {
+			return err
+		}
+	}
+
+	updatedCount, err := c.dedup.applyCount(query.RequestId, func() (int, error) {
+		return retryConflicts(func() (int, error) {
+			snapshot := c.takeSnapshot()
+			rowIdxs, err := c.findRowIdxs(query.TableName, query.Where, snapshot)
+			if err != nil || len(rowIdxs) == 0 {
+				return 0, err
+			}
+			pkRowMap, err := c.fetchRows(schema, rowIdxs, snapshot)
+			if err != nil {
+				return 0, err
+			}
+
+			// the old rows are deleted before the updated rows are written on each node, see Node.Commit
+			nodeWrites := c.routeDeletes(query.TableName, rowIdxs, snapshot.Ts)
+			for rowIdx, row := range pkRowMap {
+				for colName, val := range query.Set {
+					row[schema.GetColIndexByName(colName)] = val
+				}
+				if err := c.checkRouted(schema, row); err != nil {
+					return 0, err
+				}
+				for nodeIdx, writes := range c.routeRow(schema, rowIdx.(int), row) {
+					nodeWrites[nodeIdx] = append(nodeWrites[nodeIdx], writes...)
+				}
+			}
+			if err := c.writeAtomically(nodeWrites); err != nil {
+				return 0, err
+			}
+			return len(pkRowMap), nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	*reply = updatedCount
+	return nil
+}
+
+// fetchRows returns the rows of a table with the given row idxs as of the given snapshot, keyed by their row idx. The
+// rows follow the schema of the table.
+func (c *Cluster) fetchRows(schema TableSchema, rowIdxs ValueSet, snapshot Snapshot) (map[interface{}]Row, error) {
+	colNames := make([]string, len(schema.ColumnSchemas))
+	for i, colSchema := range schema.ColumnSchemas {
+		colNames[i] = colSchema.Name
+	}
+	pkRowMap, _, err := c.projectTable(schema.TableName, colNames, rowIdxs, true, snapshot)
+	return pkRowMap, err
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"../labrpc"
+	"testing"
+	"time"
+)
+
+// updateRows updates the rows of a table as described by the query, and returns the number of updated rows.
+func updateRows(t *testing.T, query UpdateQuery) int {
+	updatedCount := 0
+	if err := cli.CallWithError("Cluster.Update", query, &updatedCount); err != nil {
+		t.Fatal(err)
+	}
+	return updatedCount
+}
+
+// checkStudent checks the columns of the student with the given sid.
+func checkStudent(t *testing.T, name string, expectedRow Row) {
+	results := Dataset{}
+	if err := cli.CallWithError("Cluster.Select", SelectQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"sid": {{Op: "==", Val: expectedRow[0]}}}}, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Rows) != 1 || !results.Rows[0].Equals(&expectedRow) {
+		t.Errorf("%s: expected student %v, actual %v", name, expectedRow, results.Rows)
+	}
+}
+
+func TestUpdateReroutesRows(t *testing.T) {
+	setOperationSetup()
+	lowGradeFragmentName := getStudentFragmentName("0|1")
+	highGradeFragmentName := getStudentFragmentName("1|2")
+
+	// raising the grade moves the student to the fragment of high grades
+	if updatedCount := updateRows(t, UpdateQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"sid": {{Op: "==", Val: 1}}},
+		Set:   map[string]interface{}{"grade": 3.9}}); updatedCount != 1 {
+		t.Errorf("Expected 1 student to be updated, actual %d", updatedCount)
+	}
+	checkStudent(t, "After raising the grade", Row{1, "Smith", 23, 3.9})
+	for _, nodeIdx := range []int{0, 1} {
+		if sids := getFragmentSids(t, nodeIdx, lowGradeFragmentName); len(sids) != 1 || !sids[3] {
+			t.Errorf("Expected student 3 in %s on node %d, actual %v", lowGradeFragmentName, nodeIdx, sids)
+		}
+	}
+	for _, nodeIdx := range []int{1, 2} {
+		if sids := getFragmentSids(t, nodeIdx, highGradeFragmentName); len(sids) != 4 || !sids[1] {
+			t.Errorf("Expected student 1 in %s on node %d, actual %v", highGradeFragmentName, nodeIdx, sids)
+		}
+	}
+
+	// the students staying in their fragments keep their row idxs
+	if updatedCount := updateRows(t, UpdateQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"grade": {{Op: ">", Val: 3.6}}},
+		Set:   map[string]interface{}{"age": 30}}); updatedCount != 4 {
+		t.Errorf("Expected 4 students to be updated, actual %d", updatedCount)
+	}
+	checkSids(t, "After updating the ages", studentTableName, "", 0, 1, 2, 3, 4)
+	checkStudent(t, "After updating the ages", Row{1, "Smith", 30, 3.9})
+	checkStudent(t, "After updating the ages", Row{3, "Lewis", 21, 3.0})
+	for _, nodeIdx := range []int{1, 2} {
+		rowIdxs := getFragmentRowIdxs(t, nodeIdx, highGradeFragmentName)
+		for _, rowIdx := range []int{0, 1, 2, 4} {
+			if !rowIdxs[rowIdx] {
+				t.Errorf("Expected row %d in %s on node %d, actual %v", rowIdx, highGradeFragmentName, nodeIdx,
+					rowIdxs)
+			}
+		}
+	}
+}
+
+func TestUpdateVerticalFragments(t *testing.T) {
+	// the students with low grades are split into two vertical fragments
+	aggregateSetup(map[string]interface{}{
+		"0|1": gradeRule("<=", 3.6, "sid", "name"),
+		"1|2": gradeRule("<=", 3.6, "sid", "age", "grade"),
+		"3|4": gradeRule(">", 3.6, "sid", "name", "age", "grade"),
+	})
+
+	// the columns of the moved student are split into the vertical fragments
+	if updatedCount := updateRows(t, UpdateQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"name": {{Op: "==", Val: "John"}}},
+		Set:   map[string]interface{}{"grade": 3.0, "age": 25}}); updatedCount != 1 {
+		t.Errorf("Expected 1 student to be updated, actual %d", updatedCount)
+	}
+	checkStudent(t, "After lowering the grade", Row{0, "John", 25, 3.0})
+	checkSids(t, "After lowering the grade", studentTableName, "", 0, 1, 2, 3, 4)
+	for _, nodeRule := range c.TableNodeRulesMap[studentTableName] {
+		fragmentName := getFragmentName(studentTableName, nodeRule.Rule.RuleIdx)
+		isHeld := nodeRule.NodeIndices != "3|4"
+		for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+			if rowIdxs := getFragmentRowIdxs(t, nodeIdx, fragmentName); rowIdxs[0] != isHeld {
+				t.Errorf("Expected row 0 held by %s on node %d to be %v, actual %v", fragmentName, nodeIdx, isHeld,
+					rowIdxs)
+			}
+		}
+	}
+}
+
+func TestUpdateAllOrNone(t *testing.T) {
+	setOperationSetup()
+	c.SetRetryPolicy(testRetryPolicy)
+	lowGradeFragmentName := getStudentFragmentName("0|1")
+	highGradeFragmentName := getStudentFragmentName("1|2")
+
+	// node 2 cannot write the student moved to the fragment of high grades, so the student is not moved
+	network.DeleteServer("Node2")
+	updatedCount := 0
+	err := cli.CallWithError("Cluster.Update", UpdateQuery{TableName: studentTableName,
+		Where: map[string][]Condition{"sid": {{Op: "==", Val: 3}}},
+		Set:   map[string]interface{}{"grade": 3.9}}, &updatedCount)
+	checkErrorCode(t, "Moving a student to a deleted node", err, ErrUnavailable)
+	for _, nodeIdx := range []int{0, 1} {
+		if sids := getFragmentSids(t, nodeIdx, lowGradeFragmentName); !sids[3] {
+			t.Errorf("Student 3 should stay in %s on node %d, actual %v", lowGradeFragmentName, nodeIdx, sids)
+		}
+	}
+	if sids := getFragmentSids(t, 1, highGradeFragmentName); sids[3] {
+		t.Errorf("Student 3 should not be written to %s on node 1, actual %v", highGradeFragmentName, sids)
+	}
+}
+
+// concurrentUpdateDelay is the delay of each call to the nodes in the tests of concurrent writes, so that the later
+// write of two staggered ones reads the rows before the earlier one commits, and writes them after that, see
+// callStaggered.
+const concurrentUpdateDelay = 20 * time.Millisecond
+
+// callStaggered calls the given methods of the cluster by two clients, the second call starts the given number of
+// concurrentUpdateDelay after the first one, and fails the test if either of them fails.
+func callStaggered(t *testing.T, clients []*labrpc.ClientEnd, delayCount int, methods []string, args []interface{}) {
+	errs := make([]error, len(clients))
+	fanOut(len(clients), func(i int) {
+		time.Sleep(time.Duration(i*delayCount) * concurrentUpdateDelay)
+		count := 0
+		errs[i] = clients[i].CallWithError(methods[i], args[i], &count)
+	})
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// setNodeDelays delays every call to the nodes of the cluster by the given delay, or removes the delays if it is 0.
+func setNodeDelays(delay time.Duration) {
+	for _, nodeId := range c.nodeIds {
+		network.SetDelay(nodeId, delay)
+	}
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	setOperationSetup()
+	clients := makeClients(2)
+	setNodeDelays(concurrentUpdateDelay)
+	defer setNodeDelays(0)
+	lowGradeFragmentName, highGradeFragmentName := getStudentFragmentName("0|1"), getStudentFragmentName("1|2")
+
+	// one update moves the student between the fragments, and the other one changes its age, neither change is lost.
+	// The second update starts after the first one reads the row, and prepares after it commits
+	where := map[string][]Condition{"sid": {{Op: "==", Val: 1}}}
+	callStaggered(t, clients, 2, []string{"Cluster.Update", "Cluster.Update"}, []interface{}{
+		UpdateQuery{TableName: studentTableName, Where: where, Set: map[string]interface{}{"grade": 4.0}},
+		UpdateQuery{TableName: studentTableName, Where: where, Set: map[string]interface{}{"age": 30}},
+	})
+	checkStudent(t, "After the concurrent updates", Row{1, "Smith", 30, 4.0})
+	// node 1 holds both fragments
+	if getFragmentRowIdxs(t, 1, lowGradeFragmentName)[1] || !getFragmentRowIdxs(t, 1, highGradeFragmentName)[1] {
+		t.Errorf("The student should only be held by %s", highGradeFragmentName)
+	}
+
+	// the student deleted concurrently is not written back by the update, which reads the row before it is deleted
+	where = map[string][]Condition{"sid": {{Op: "==", Val: 3}}}
+	callStaggered(t, clients, 1, []string{"Cluster.Delete", "Cluster.Update"}, []interface{}{
+		DeleteQuery{TableName: studentTableName, Where: where},
+		UpdateQuery{TableName: studentTableName, Where: where, Set: map[string]interface{}{"grade": 4.0}},
+	})
+	checkSids(t, "After the concurrent delete and update", studentTableName, "", 0, 1, 2, 4)
+	checkStudentRowIdxs(t, "After the concurrent delete and update", 0, 1, 2, 4)
+}
+
+func TestUpdateErrors(t *testing.T) {
+	setOperationSetup()
+
+	updatedCount := 0
+	err := cli.CallWithError("Cluster.Update", UpdateQuery{TableName: "teacher",
+		Set: map[string]interface{}{"name": "Ann"}}, &updatedCount)
+	checkErrorCode(t, "Updating a missing table", err, ErrNoSuchTable)
+	err = cli.CallWithError("Cluster.Update", UpdateQuery{TableName: studentTableName,
+		Set: map[string]interface{}{"height": 180}}, &updatedCount)
+	checkErrorCode(t, "Updating a missing column", err, ErrSchemaMismatch)
+	err = cli.CallWithError("Cluster.Update", UpdateQuery{TableName: studentTableName}, &updatedCount)
+	checkErrorCode(t, "Updating no column", err, ErrInvalidArgument)
+	err = cli.CallWithError("Cluster.Update", UpdateQuery{TableName: studentTableName,
+		Set: map[string]interface{}{"grade": nil}}, &updatedCount)
+	checkErrorCode(t, "Updating a partition column to NULL", err, ErrInvalidArgument)
+	err = cli.CallWithError("Cluster.Update", UpdateQuery{TableName: studentTableName,
+		Set: map[string]interface{}{"grade": "high"}}, &updatedCount)
+	checkErrorCode(t, "Updating a column to a value of the wrong type", err, ErrSchemaMismatch)
+	checkSids(t, "After the failed updates", studentTableName, "", 0, 1, 2, 3, 4)
+}
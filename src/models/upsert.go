@@ -0,0 +1,205 @@
+package models
+
+import (
+	"fmt"
+)
+
+// Upsert inserts rows into a table, or merges them into the existing rows with the same key, see Cluster.Upsert.
+type Upsert struct {
+	TableName string
+	// the columns of Rows, all columns of the table in order if it is empty
+	Columns []string
+	Rows    []Row
+	// the columns identifying a row, they should be in Columns
+	KeyColumns []string
+	// identifies the upsert, so that the rows are written only once if it is retried, see RequestId
+	RequestId RequestId
+}
+
+// Upsert writes the rows into a table like
+// INSERT INTO TableName (Columns...) VALUES Rows... ON CONFLICT (KeyColumns...) DO UPDATE
+// and sets reply as the number of written rows. A row is merged into the existing rows having the same values of
+// KeyColumns, so that the columns in Columns are set, and the other columns keep their values. Otherwise the row is
+// inserted, and the columns not in Columns are NULL, so a new row must be given its partition columns (see
+// checkRouted). The rows with the same key in args.Rows are written as one row, the later one wins.
+// The tables have no keys of their own, so the key is given by each upsert, and the upserts are serialized by the
+// coordinator and read the existing keys as of the latest commit (see takeLatestSnapshot), so that two upserts never
+// insert rows with the same key. The rows written by other requests, e.g. FragmentWrite or BulkInsert, are not
+// serialized with the upserts, so they may still duplicate a key. A merged row is replaced like Update, it is
+// rewritten to every vertical fragment, and it may move to other horizontal fragments, and the upsert is retried on a
+// new snapshot if the row is changed by others meanwhile. All rows are written atomically (see writeAtomically).
+func (c *Cluster) Upsert(args Upsert, reply *int) error {
+	schema, ok := c.getTableSchema(args.TableName)
+	if !ok {
+		return noSuchTableError(args.TableName)
+	}
+	colNames := args.Columns
+	if len(colNames) == 0 {
+		for _, colSchema := range schema.ColumnSchemas {
+			colNames = append(colNames, colSchema.Name)
+		}
+	}
+	for _, colName := range colNames {
+		if schema.GetColIndexByName(colName) == -1 {
+			return missingColumnError(args.TableName, colName)
+		}
+	}
+	if len(args.KeyColumns) == 0 {
+		return newError(ErrInvalidArgument, "the key columns of the upsert into "+args.TableName+" are not given")
+	}
+	// keyColIdxs[i] -> index of the i-th key column in the rows
+	keyColIdxs := make([]int, len(args.KeyColumns))
+	for i, keyColName := range args.KeyColumns {
+		keyColIdxs[i] = -1
+		for colIdx, colName := range colNames {
+			if colName == keyColName {
+				keyColIdxs[i] = colIdx
+			}
+		}
+		if keyColIdxs[i] == -1 {
+			return newError(ErrInvalidArgument, "key column "+keyColName+" of the upsert into "+args.TableName+
+				" should be written")
+		}
+	}
+	for _, row := range args.Rows {
+		if len(row) != len(colNames) {
+			return newError(ErrSchemaMismatch, fmt.Sprintf("row %v doesn't match the %d written columns of table %s",
+				row, len(colNames), args.TableName))
+		}
+		for i, val := range row {
+			if err := checkValue(schema, schema.GetColIndexByName(colNames[i]), val); err != nil {
+				return err
+			}
+		}
+		for i, colIdx := range keyColIdxs {
+			if row[colIdx] == nil {
+				return newError(ErrInvalidArgument, fmt.Sprintf("key column %s of row %v is NULL",
+					args.KeyColumns[i], row))
+			}
+		}
+	}
+
+	writtenCount, err := c.dedup.applyCount(args.RequestId, func() (int, error) {
+		c.upsertMu.Lock()
+		defer c.upsertMu.Unlock()
+		return retryConflicts(func() (int, error) {
+			return c.upsert(schema, colNames, args.KeyColumns, args.Rows)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	*reply = writtenCount
+	return nil
+}
+
+// upsert writes the rows of the given columns into a table, and returns the number of written rows, see Upsert.
+func (c *Cluster) upsert(schema TableSchema, colNames []string, keyColNames []string, rows []Row) (int, error) {
+	tableName := schema.TableName
+	// schemaColIdxs[i] -> index of the i-th written column in the table
+	schemaColIdxs := make([]int, len(colNames))
+	for i, colName := range colNames {
+		schemaColIdxs[i] = schema.GetColIndexByName(colName)
+	}
+	// schemaKeyColIdxs[i] -> index of the i-th key column in the table
+	schemaKeyColIdxs := make([]int, len(keyColNames))
+	for i, keyColName := range keyColNames {
+		schemaKeyColIdxs[i] = schema.GetColIndexByName(keyColName)
+	}
+
+	// the written rows following the table schema, the later row of a key replaces the earlier one
+	writtenRows := make([]Row, 0, len(rows))
+	// key -> index of the written row in writtenRows
+	keyRowIdxs := make(map[interface{}]int)
+	for _, row := range rows {
+		writtenRow := make(Row, len(schema.ColumnSchemas))
+		for i, val := range row {
+			writtenRow[schemaColIdxs[i]] = val
+		}
+		key := getRowKey(writtenRow, schemaKeyColIdxs)
+		if i, ok := keyRowIdxs[key]; ok {
+			writtenRows[i] = writtenRow
+		} else {
+			keyRowIdxs[key] = len(writtenRows)
+			writtenRows = append(writtenRows, writtenRow)
+		}
+	}
+	if len(writtenRows) == 0 {
+		return 0, nil
+	}
+
+	// find the existing rows with the keys, the keys are checked again as each key column is filtered separately
+	predicate := make(map[string][]Condition)
+	for i, keyColName := range keyColNames {
+		values := make(ValueSet)
+		for _, row := range writtenRows {
+			values[row[schemaKeyColIdxs[i]]] = true
+		}
+		predicate[keyColName] = []Condition{{Op: OpIn, Val: values}}
+	}
+	snapshot := c.takeLatestSnapshot()
+	rowIdxs, err := c.findRowIdxs(tableName, predicate, snapshot)
+	if err != nil {
+		return 0, err
+	}
+	pkRowMap, err := c.fetchRows(schema, rowIdxs, snapshot)
+	if err != nil {
+		return 0, err
+	}
+	// key -> row idxs of the existing rows with the key
+	keyPKs := make(map[interface{}][]interface{})
+	for pk, row := range pkRowMap {
+		key := getRowKey(row, schemaKeyColIdxs)
+		if _, ok := keyRowIdxs[key]; ok {
+			keyPKs[key] = append(keyPKs[key], pk)
+		}
+	}
+
+	// the existing rows are merged and replaced, the old rows are deleted before the merged rows are written on each
+	// node, see Node.Commit
+	mergedPKs := make(ValueSet)
+	for _, pks := range keyPKs {
+		for _, pk := range pks {
+			mergedPKs[pk] = true
+		}
+	}
+	nodeWrites := c.routeDeletes(tableName, mergedPKs, snapshot.Ts)
+	appendWrites := func(rowIdx int, row Row) {
+		for nodeIdx, writes := range c.routeRow(schema, rowIdx, row) {
+			nodeWrites[nodeIdx] = append(nodeWrites[nodeIdx], writes...)
+		}
+	}
+	// a merged or inserted row with a NULL partition column would be held by no fragment, see checkRouted
+	insertedRows := make([]Row, 0)
+	for _, writtenRow := range writtenRows {
+		pks := keyPKs[getRowKey(writtenRow, schemaKeyColIdxs)]
+		if len(pks) == 0 {
+			if err := c.checkRouted(schema, writtenRow); err != nil {
+				return 0, err
+			}
+			insertedRows = append(insertedRows, writtenRow)
+			continue
+		}
+		for _, pk := range pks {
+			mergedRow := pkRowMap[pk]
+			for _, colIdx := range schemaColIdxs {
+				mergedRow[colIdx] = writtenRow[colIdx]
+			}
+			if err := c.checkRouted(schema, mergedRow); err != nil {
+				return 0, err
+			}
+			appendWrites(pk.(int), mergedRow)
+		}
+	}
+
+	// the inserted rows take a block of row idxs
+	firstRowIdx := c.allocateRowIdxs(tableName, len(insertedRows))
+	for i, row := range insertedRows {
+		appendWrites(firstRowIdx+i, row)
+	}
+	if err := c.writeAtomically(nodeWrites); err != nil {
+		c.releaseRowIdxs(tableName, firstRowIdx, len(insertedRows))
+		return 0, err
+	}
+	return len(writtenRows), nil
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"../labrpc"
+	"sync"
+	"testing"
+)
+
+// upsertRows upserts the rows into a table, and returns the number of written rows.
+func upsertRows(t *testing.T, args Upsert) int {
+	writtenCount := 0
+	if err := cli.CallWithError("Cluster.Upsert", args, &writtenCount); err != nil {
+		t.Fatal(err)
+	}
+	return writtenCount
+}
+
+func TestUpsertVerticalFragments(t *testing.T) {
+	// the students with low grades are split into two vertical fragments
+	aggregateSetup(map[string]interface{}{
+		"0|1": gradeRule("<=", 3.6, "sid", "name"),
+		"1|2": gradeRule("<=", 3.6, "sid", "age", "grade"),
+		"3|4": gradeRule(">", 3.6, "sid", "name", "age", "grade"),
+	})
+
+	// the names are not written, so the existing students keep their names, and the new student has no name
+	args := Upsert{TableName: studentTableName, Columns: []string{"sid", "age", "grade"},
+		Rows: []Row{{1, 30, 3.5}, {3, 21, 3.9}, {5, 25, 3.2}}, KeyColumns: []string{"sid"}}
+	for i := 0; i < 2; i++ {
+		if writtenCount := upsertRows(t, args); writtenCount != 3 {
+			t.Errorf("Expected 3 students to be written, actual %d", writtenCount)
+		}
+		checkSids(t, "After the upsert", studentTableName, "", 0, 1, 2, 3, 4, 5)
+		checkStudent(t, "After the upsert", Row{1, "Smith", 30, 3.5})
+		checkStudent(t, "After the upsert", Row{3, "Lewis", 21, 3.9})
+		checkStudent(t, "After the upsert", Row{5, nil, 25, 3.2})
+	}
+	if c.TableRowCountMap[studentTableName] != 6 {
+		t.Errorf("Expected 6 rows in student, actual %d", c.TableRowCountMap[studentTableName])
+	}
+
+	// the student moved to the fragment of high grades is no longer held by the vertical fragments
+	for _, nodeRule := range c.TableNodeRulesMap[studentTableName] {
+		fragmentName := getFragmentName(studentTableName, nodeRule.Rule.RuleIdx)
+		isHeld := nodeRule.NodeIndices == "3|4"
+		for _, nodeIdx := range parseNodeIndices(nodeRule.NodeIndices) {
+			if rowIdxs := getFragmentRowIdxs(t, nodeIdx, fragmentName); rowIdxs[3] != isHeld {
+				t.Errorf("Expected row 3 held by %s on node %d to be %v, actual %v", fragmentName, nodeIdx, isHeld,
+					rowIdxs)
+			}
+		}
+	}
+}
+
+func TestUpsertPartialColumns(t *testing.T) {
+	setOperationSetup()
+
+	// the existing student keeps the grade routing it
+	if writtenCount := upsertRows(t, Upsert{TableName: studentTableName, Columns: []string{"sid", "name"},
+		Rows: []Row{{1, "Smyth"}}, KeyColumns: []string{"sid"}}); writtenCount != 1 {
+		t.Errorf("Expected 1 student to be written, actual %d", writtenCount)
+	}
+	checkStudent(t, "After the upsert", Row{1, "Smyth", 23, 3.5})
+
+	// the new student has no grade, so it satisfies no partition rule, and none of the students is written
+	writtenCount := 0
+	err := cli.CallWithError("Cluster.Upsert", Upsert{TableName: studentTableName, Columns: []string{"sid", "name"},
+		Rows: []Row{{3, "Lewes"}, {77, "New"}}, KeyColumns: []string{"sid"}}, &writtenCount)
+	checkErrorCode(t, "Upserting a new student without a grade", err, ErrInvalidArgument)
+	checkSids(t, "After the failed upsert", studentTableName, "", 0, 1, 2, 3, 4)
+	checkStudent(t, "After the failed upsert", Row{3, "Lewis", 21, 3.0})
+}
+
+func TestUpsertCompositeKey(t *testing.T) {
+	setOperationSetup()
+
+	// the rows with the same key in an upsert are written once
+	if writtenCount := upsertRows(t, Upsert{TableName: courseRegistrationTableName,
+		Rows: []Row{{0, 1}, {3, 1}, {3, 1}}, KeyColumns: []string{"sid", "courseId"}}); writtenCount != 2 {
+		t.Errorf("Expected 2 course registrations to be written, actual %d", writtenCount)
+	}
+	checkSids(t, "After the upsert", courseRegistrationTableName, "", 0, 0, 1, 2, 3)
+}
+
+func TestUpsertConcurrent(t *testing.T) {
+	setOperationSetup()
+
+	// only one of the clients inserts the student, and the others merge into it
+	var wg sync.WaitGroup
+	for i, client := range makeClients(concurrentClientCount) {
+		wg.Add(1)
+		go func(i int, client *labrpc.ClientEnd) {
+			defer wg.Done()
+			writtenCount := 0
+			if err := client.CallWithError("Cluster.Upsert", Upsert{TableName: studentTableName,
+				Rows: []Row{{100, "Ann", 20 + i, 3.0 + float64(i%2)}}, KeyColumns: []string{"sid"}},
+				&writtenCount); err != nil {
+				t.Error(err)
+			}
+		}(i, client)
+	}
+	wg.Wait()
+	checkSids(t, "After the concurrent upserts", studentTableName, "", 0, 1, 2, 3, 4, 100)
+}
+
+func TestUpsertUpdatedConcurrently(t *testing.T) {
+	setOperationSetup()
+	clients := makeClients(2)
+	setNodeDelays(concurrentUpdateDelay)
+	defer setNodeDelays(0)
+
+	// the upsert merges into the student moved by the update, instead of writing back the row it read before that
+	callStaggered(t, clients, 3, []string{"Cluster.Update", "Cluster.Upsert"}, []interface{}{
+		UpdateQuery{TableName: studentTableName, Where: map[string][]Condition{"sid": {{Op: "==", Val: 1}}},
+			Set: map[string]interface{}{"grade": 4.0}},
+		Upsert{TableName: studentTableName, Columns: []string{"sid", "age"}, Rows: []Row{{1, 30}},
+			KeyColumns: []string{"sid"}},
+	})
+	checkSids(t, "After the concurrent update and upsert", studentTableName, "", 0, 1, 2, 3, 4)
+	checkStudent(t, "After the concurrent update and upsert", Row{1, "Smith", 30, 4.0})
+}
+
+func TestUpsertPendingCommit(t *testing.T) {
+	setOperationSetup()
+
+	upsertRows(t, Upsert{TableName: studentTableName, Rows: []Row{{5, "Ann", 20, 3.9}}, KeyColumns: []string{"sid"}})
+	// the commit of the upsert is still being sent to node 2, so the new reads do not see the student yet
+	c.txnLog.mu.Lock()
+	c.txnLog.decisions["pending"] = &txnDecision{commit: true, commitTs: c.txnLog.lastCommitTs,
+		pendingNodeIdxs: []int{2}, sending: true}
+	c.txnLog.mu.Unlock()
+	checkSids(t, "Before the commit is applied", studentTableName, "", 0, 1, 2, 3, 4)
+
+	// the next upsert of the student merges into it instead of inserting it again
+	upsertRows(t, Upsert{TableName: studentTableName, Columns: []string{"sid", "age"}, Rows: []Row{{5, 21}},
+		KeyColumns: []string{"sid"}})
+	c.txnLog.mu.Lock()
+	delete(c.txnLog.decisions, "pending")
+	c.txnLog.mu.Unlock()
+	checkSids(t, "After the upserts", studentTableName, "", 0, 1, 2, 3, 4, 5)
+	checkStudent(t, "After the upserts", Row{5, "Ann", 21, 3.9})
+}
+
+func TestUpsertErrors(t *testing.T) {
+	setOperationSetup()
+
+	writtenCount := 0
+	for _, testCase := range []struct {
+		name         string
+		args         Upsert
+		expectedCode ErrorCode
+	}{
+		{"Upserting into a missing table", Upsert{TableName: "teacher", KeyColumns: []string{"tid"}},
+			ErrNoSuchTable},
+		{"Upserting a missing column", Upsert{TableName: studentTableName, Columns: []string{"sid", "height"},
+			Rows: []Row{{5, 180}}, KeyColumns: []string{"sid"}}, ErrSchemaMismatch},
+		{"Upserting without a key", Upsert{TableName: studentTableName, Rows: []Row{{5, "Ann", 20, 3.0}}},
+			ErrInvalidArgument},
+		{"Upserting without the key column", Upsert{TableName: studentTableName, Columns: []string{"name"},
+			Rows: []Row{{"Ann"}}, KeyColumns: []string{"sid"}}, ErrInvalidArgument},
+		{"Upserting a NULL key", Upsert{TableName: studentTableName, Rows: []Row{{nil, "Ann", 20, 3.0}},
+			KeyColumns: []string{"sid"}}, ErrInvalidArgument},
+		{"Upserting a row not matching the columns", Upsert{TableName: studentTableName,
+			Rows: []Row{{5, "Ann"}}, KeyColumns: []string{"sid"}}, ErrSchemaMismatch},
+		{"Upserting a value of the wrong type", Upsert{TableName: studentTableName,
+			Rows: []Row{{5, "Ann", 20, "high"}}, KeyColumns: []string{"sid"}}, ErrSchemaMismatch},
+	} {
+		err := cli.CallWithError("Cluster.Upsert", testCase.args, &writtenCount)
+		checkErrorCode(t, testCase.name, err, testCase.expectedCode)
+	}
+	checkSids(t, "After the failed upserts", studentTableName, "", 0, 1, 2, 3, 4)
+}
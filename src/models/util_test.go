@@ -1,6 +1,35 @@
 package models
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
+)
+
+// build student table with two horizontal fragments and courseRegistration table on node 2
+func setOperationSetup() {
+	setupLab3()
+
+	studentRows = []Row{
+		{0, "John", 22, 4.0},
+		{1, "Smith", 23, 3.5},
+		{2, "Hana", 21, 4.0},
+		{3, "Lewis", 21, 3.0},
+		{4, "Tom", 23, 4.0},
+	}
+	studentTablePartitionRules, _ = json.Marshal(map[string]interface{}{
+		"0|1": gradeRule("<=", 3.6, "sid", "name", "age", "grade"),
+		"1|2": gradeRule(">", 3.6, "sid", "name", "age", "grade"),
+	})
+	courseRegistrationTablePartitionRules, _ = json.Marshal(map[string]interface{}{
+		"2": map[string]interface{}{
+			"predicate": map[string]interface{}{},
+			"column":    []string{"sid", "courseId"},
+		},
+	})
+
+	buildTablesLab3(cli)
+	insertDataLab3(cli)
+}
 
 func TestCompareDataset(t *testing.T) {
 	a := Dataset{
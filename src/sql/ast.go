@@ -0,0 +1,135 @@
+package sql
+
+import "../models"
+
+// Statement is a parsed SQL statement, one of *CreateTableStatement, *InsertStatement, *SelectStatement,
+// *CompoundSelectStatement, *DeleteStatement and *DropTableStatement.
+type Statement interface {
+	statement()
+}
+
+// Comparison compares a column with a literal value, like grade <= 3.6. Op is one of the operators of
+// models.Condition, and Value is one of nil, bool, int64, float64 and string, before being converted into the data
+// type of the column.
+// In SELECT statements, the column may be qualified by its table like student.sid, and Value may also be
+//   - a *Subquery returning a single column, with models.OpIn or models.OpNotIn, like sid IN (SELECT sid FROM ...),
+//   - a *Subquery returning at most one value, with other operators, like grade > (SELECT AVG(grade) FROM ...),
+//   - a *Subquery with OpExists or OpNotExists, and an empty Column, like EXISTS (SELECT * FROM ...),
+//   - a ColumnReference, which correlates an EXISTS subquery with a table of the outer statement.
+type Comparison struct {
+	Column string
+	Op     string
+	Value  interface{}
+}
+
+// operators of comparisons checking whether a subquery returns any rows
+const (
+	OpExists    = "EXISTS"
+	OpNotExists = "NOT EXISTS"
+)
+
+// Subquery is a SELECT statement used in a comparison.
+type Subquery struct {
+	// *SelectStatement or *CompoundSelectStatement
+	Statement Statement
+}
+
+// ColumnReference is a column compared with another column, like student.sid.
+type ColumnReference struct {
+	// empty if the column is not qualified by its table
+	TableName string
+	Column    string
+}
+
+// PartitionDefinition defines a fragment of a table, like ON (0, 1) WHERE grade <= 3.6 COLUMNS (sid, name), which
+// becomes a models.Rule held by the given nodes.
+type PartitionDefinition struct {
+	NodeIndices []int
+	Predicate   []Comparison
+	// all columns of the table are held if it is empty
+	Columns []string
+}
+
+// CreateTableStatement is like
+// CREATE TABLE student (sid INT, name VARCHAR, ...) PARTITION BY (ON (0) WHERE ..., ON (1, 2) WHERE ... COLUMNS (...))
+type CreateTableStatement struct {
+	Schema     models.TableSchema
+	Partitions []PartitionDefinition
+}
+
+// InsertStatement is like INSERT INTO student (sid, name) VALUES (0, 'John'), (1, 'Smith')
+type InsertStatement struct {
+	TableName string
+	// all columns of the table in order if it is empty
+	Columns []string
+	Rows    [][]interface{}
+	// the key columns of ON CONFLICT (col, ...) DO UPDATE, the rows are upserted if it is not empty, see models.Upsert
+	ConflictColumns []string
+}
+
+// SelectItem is an item in the select list, which is either a column or an aggregate like COUNT(*) or SUM(grade).
+type SelectItem struct {
+	// "*" selects all columns if Func is empty
+	Column string
+	// one of the aggregate functions in models, empty for a column
+	Func  string
+	Alias string
+}
+
+// SelectStatement is like
+// SELECT [DISTINCT] items FROM table1 NATURAL JOIN table2 WHERE ... GROUP BY ... ORDER BY ... LIMIT n OFFSET m
+type SelectStatement struct {
+	Distinct   bool
+	Items      []SelectItem
+	TableNames []string
+	Where      []Comparison
+	GroupBy    []string
+	OrderBy    []models.OrderBy
+	HasLimit   bool
+	Limit      int
+	Offset     int
+}
+
+// CompoundSelectStatement combines the results of SELECT statements by set operations from left to right, like
+// SELECT sid FROM student EXCEPT SELECT sid FROM courseRegistration ORDER BY sid
+// ORDER BY, LIMIT and OFFSET after the last SELECT apply to the combined results.
+type CompoundSelectStatement struct {
+	Selects []*SelectStatement
+	// Ops[i] combines the results of the statements before Selects[i+1] with it, one of the set operations in models
+	Ops      []string
+	OrderBy  []models.OrderBy
+	HasLimit bool
+	Limit    int
+	Offset   int
+}
+
+// DeleteStatement is like DELETE FROM student WHERE grade < 3.0
+type DeleteStatement struct {
+	TableName string
+	Where     []Comparison
+}
+
+// DropTableStatement is like DROP TABLE student
+type DropTableStatement struct {
+	TableName string
+}
+
+func (*CreateTableStatement) statement()    {}
+func (*InsertStatement) statement()         {}
+func (*SelectStatement) statement()         {}
+func (*CompoundSelectStatement) statement() {}
+func (*DeleteStatement) statement()         {}
+func (*DropTableStatement) statement()      {}
+
+// isAggregate returns true if the statement computes aggregates, so it returns one row per group.
+func (stmt *SelectStatement) isAggregate() bool {
+	if len(stmt.GroupBy) > 0 {
+		return true
+	}
+	for _, item := range stmt.Items {
+		if item.Func != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,569 @@
+package sql
+
+import (
+	"../labrpc"
+	"../models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Executor runs SQL statements on a cluster by compiling them into calls on the coordinator (see models.Cluster)
+// through a client end connected to it.
+type Executor struct {
+	end *labrpc.ClientEnd
+}
+
+// NewExecutor creates an Executor sending requests through the given client end, which should be connected to the
+// coordinator of a cluster and enabled.
+func NewExecutor(end *labrpc.ClientEnd) *Executor {
+	return &Executor{end: end}
+}
+
+// Execute parses and runs a SQL statement. SELECT statements return their results, while the other statements return
+// an empty Dataset.
+func (e *Executor) Execute(statement string) (models.Dataset, error) {
+	stmt, err := Parse(statement)
+	if err != nil {
+		return models.Dataset{}, err
+	}
+	switch s := stmt.(type) {
+	case *CreateTableStatement:
+		return models.Dataset{}, e.executeCreateTable(s)
+	case *InsertStatement:
+		return models.Dataset{}, e.executeInsert(s)
+	case *SelectStatement:
+		return e.executeSelect(s)
+	case *CompoundSelectStatement:
+		return e.executeCompoundSelect(s)
+	case *DeleteStatement:
+		return models.Dataset{}, e.executeDelete(s)
+	case *DropTableStatement:
+		return models.Dataset{}, e.executeDropTable(s)
+	}
+	return models.Dataset{}, errors.New("unsupported statement")
+}
+
+// call calls a method of the coordinator. The error returned by the method is returned as is, so that its code can be
+// checked by models.ErrorCodeOf.
+func (e *Executor) call(method string, args interface{}, reply interface{}) error {
+	err := e.end.CallWithError("Cluster."+method, args, reply)
+	if err == labrpc.ErrNoReply {
+		return fmt.Errorf("failed to call %s on the cluster: %w", method, err)
+	}
+	return err
+}
+
+// getTableSchema returns the schema of a table, or false if the table does not exist.
+func (e *Executor) getTableSchema(tableName string) (models.TableSchema, bool, error) {
+	var schema models.TableSchema
+	if err := e.call("GetTableSchema", tableName, &schema); err != nil {
+		if models.ErrorCodeOf(err) == models.ErrNoSuchTable {
+			return schema, false, nil
+		}
+		return schema, false, err
+	}
+	return schema, true, nil
+}
+
+// getTableSchemas returns the schemas of the tables, or an error if some table does not exist.
+func (e *Executor) getTableSchemas(tableNames []string) ([]models.TableSchema, error) {
+	schemas := make([]models.TableSchema, len(tableNames))
+	for i, tableName := range tableNames {
+		schema, ok, err := e.getTableSchema(tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.New("table " + tableName + " doesn't exist")
+		}
+		schemas[i] = schema
+	}
+	return schemas, nil
+}
+
+// convertValue converts a literal or a value in the results of a subquery into a value of the data type, following the
+// types used in rows: int for TypeInt32, int64 for TypeInt64, float64 for TypeFloat and TypeDouble, bool for
+// TypeBoolean and string for TypeString.
+func convertValue(value interface{}, dataType int) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch dataType {
+	case models.TypeInt32, models.TypeInt64:
+		var intValue int64
+		switch v := value.(type) {
+		case int64:
+			intValue = v
+		case int:
+			intValue = int64(v)
+		case float64:
+			if v != math.Trunc(v) {
+				return nil, fmt.Errorf("%v is not an integer", v)
+			}
+			intValue = int64(v)
+		default:
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		if dataType == models.TypeInt32 {
+			if intValue < math.MinInt32 || intValue > math.MaxInt32 {
+				return nil, fmt.Errorf("%v is out of the range of INT", intValue)
+			}
+			return int(intValue), nil
+		}
+		return intValue, nil
+	case models.TypeFloat, models.TypeDouble:
+		switch v := value.(type) {
+		case int64:
+			return float64(v), nil
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+		return nil, fmt.Errorf("%v is not a number", value)
+	case models.TypeBoolean:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("%v is not a boolean", value)
+	case models.TypeString:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("%v is not a string", value)
+	}
+	return nil, fmt.Errorf("unknown data type %d", dataType)
+}
+
+// buildPredicate converts comparisons on the columns of the schema into a predicate of models.Condition.
+func buildPredicate(schema models.TableSchema, comparisons []Comparison) (map[string][]models.Condition, error) {
+	predicate := make(map[string][]models.Condition)
+	for _, comparison := range comparisons {
+		dataType := schema.GetColTypeByName(comparison.Column)
+		if dataType == -1 {
+			return nil, errors.New("column " + comparison.Column + " doesn't exist")
+		}
+		switch v := comparison.Value.(type) {
+		case nil:
+			return nil, errors.New("cannot compare column " + comparison.Column + " with NULL")
+		case *Subquery, ColumnReference:
+			return nil, errors.New("subqueries are only supported in the WHERE clause of SELECT")
+		case models.ValueSet:
+			// the values of an IN subquery, a NULL value never equals any value
+			values := make(models.ValueSet)
+			for setValue := range v {
+				if setValue == nil {
+					continue
+				}
+				value, err := convertValue(setValue, dataType)
+				if err != nil {
+					return nil, err
+				}
+				values[value] = true
+			}
+			predicate[comparison.Column] = append(predicate[comparison.Column],
+				models.Condition{Op: comparison.Op, Val: values})
+			continue
+		}
+		value, err := convertValue(comparison.Value, dataType)
+		if err != nil {
+			return nil, err
+		}
+		predicate[comparison.Column] = append(predicate[comparison.Column],
+			models.Condition{Op: comparison.Op, Val: value})
+	}
+	return predicate, nil
+}
+
+func (e *Executor) executeCreateTable(stmt *CreateTableStatement) error {
+	schema := stmt.Schema
+	if _, ok, err := e.getTableSchema(schema.TableName); err != nil {
+		return err
+	} else if ok {
+		return errors.New("table " + schema.TableName + " already exists")
+	}
+	for i, colSchema := range schema.ColumnSchemas {
+		for _, another := range schema.ColumnSchemas[:i] {
+			if another.Name == colSchema.Name {
+				return errors.New("duplicated column " + colSchema.Name)
+			}
+		}
+	}
+
+	// the whole table is held by the first node if it is not partitioned
+	partitions := stmt.Partitions
+	if len(partitions) == 0 {
+		partitions = []PartitionDefinition{{NodeIndices: []int{0}}}
+	}
+
+	// build the rules like {"0|1": {"predicate": {"grade": [{"op": "<=", "val": 3.6}]}, "column": ["sid", ...]}}
+	rules := make(map[string]interface{})
+	for _, partition := range partitions {
+		nodeIdxStrs := make([]string, len(partition.NodeIndices))
+		for i, nodeIdx := range partition.NodeIndices {
+			// node indices are parsed digit by digit by the cluster
+			if nodeIdx > 9 {
+				return fmt.Errorf("node index %d should be less than 10", nodeIdx)
+			}
+			nodeIdxStrs[i] = strconv.Itoa(nodeIdx)
+		}
+		nodeIndices := strings.Join(nodeIdxStrs, "|")
+		if _, ok := rules[nodeIndices]; ok {
+			return errors.New("more than one partition on nodes " + nodeIndices)
+		}
+
+		predicate, err := buildPredicate(schema, partition.Predicate)
+		if err != nil {
+			return err
+		}
+		jsonPredicate := make(map[string]interface{})
+		for colName, conditions := range predicate {
+			jsonConditions := make([]map[string]interface{}, len(conditions))
+			for i, condition := range conditions {
+				jsonConditions[i] = map[string]interface{}{"op": condition.Op, "val": condition.Val}
+			}
+			jsonPredicate[colName] = jsonConditions
+		}
+
+		colNames := partition.Columns
+		if len(colNames) == 0 {
+			for _, colSchema := range schema.ColumnSchemas {
+				colNames = append(colNames, colSchema.Name)
+			}
+		}
+		for _, colName := range colNames {
+			if schema.GetColIndexByName(colName) == -1 {
+				return errors.New("column " + colName + " doesn't exist")
+			}
+		}
+
+		rules[nodeIndices] = map[string]interface{}{"predicate": jsonPredicate, "column": colNames}
+	}
+
+	rulesBytes, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	reply := ""
+	return e.call("BuildTable", []interface{}{schema, rulesBytes}, &reply)
+}
+
+// executeInsert inserts the rows of a statement with Cluster.BulkInsert, or with Cluster.Upsert if it has ON CONFLICT.
+// The columns missing from the column list are NULL, so a row is rejected by the cluster if it leaves a partition
+// column NULL, as no fragment would hold it.
+func (e *Executor) executeInsert(stmt *InsertStatement) error {
+	schema, ok, err := e.getTableSchema(stmt.TableName)
+	if err != nil {
+		return err
+	} else if !ok {
+		return errors.New("table " + stmt.TableName + " doesn't exist")
+	}
+
+	// colIdxs[i] -> index of the i-th inserted column in the table
+	colIdxs := make([]int, 0)
+	if len(stmt.Columns) == 0 {
+		for colIdx := range schema.ColumnSchemas {
+			colIdxs = append(colIdxs, colIdx)
+		}
+	}
+	for _, colName := range stmt.Columns {
+		colIdx := schema.GetColIndexByName(colName)
+		if colIdx == -1 {
+			return errors.New("column " + colName + " doesn't exist")
+		}
+		colIdxs = append(colIdxs, colIdx)
+	}
+
+	// convert all rows before inserting any of them
+	rows := make([]models.Row, len(stmt.Rows))
+	for i, values := range stmt.Rows {
+		if len(values) != len(colIdxs) {
+			return fmt.Errorf("expected %d values, actual %d", len(colIdxs), len(values))
+		}
+		rows[i] = make(models.Row, len(schema.ColumnSchemas))
+		for j, value := range values {
+			colIdx := colIdxs[j]
+			if rows[i][colIdx], err = convertValue(value, schema.ColumnSchemas[colIdx].DataType); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the rows are inserted by one batch, so that either all or none of them are inserted
+	insertedCount := 0
+	if len(stmt.ConflictColumns) > 0 {
+		// only the given columns are written into the existing rows
+		colNames := make([]string, len(colIdxs))
+		for i, colIdx := range colIdxs {
+			colNames[i] = schema.ColumnSchemas[colIdx].Name
+		}
+		upsertedRows := make([]models.Row, len(rows))
+		for i, row := range rows {
+			upsertedRows[i] = make(models.Row, len(colIdxs))
+			for j, colIdx := range colIdxs {
+				upsertedRows[i][j] = row[colIdx]
+			}
+		}
+		return e.call("Upsert", models.Upsert{TableName: stmt.TableName, Columns: colNames, Rows: upsertedRows,
+			KeyColumns: stmt.ConflictColumns}, &insertedCount)
+	}
+	return e.call("BulkInsert", models.BulkInsert{TableName: stmt.TableName, Rows: rows}, &insertedCount)
+}
+
+// getAggregateQuery converts the select list of an aggregate statement into an AggregateQuery on the given schema.
+func getAggregateQuery(stmt *SelectStatement, schema models.TableSchema) (models.AggregateQuery, error) {
+	query := models.AggregateQuery{GroupBy: stmt.GroupBy}
+	for _, item := range stmt.Items {
+		if item.Func != "" {
+			query.Aggregates = append(query.Aggregates,
+				models.Aggregate{Func: item.Func, Column: item.Column, Alias: item.Alias})
+			continue
+		}
+		isGrouped := false
+		for _, colName := range stmt.GroupBy {
+			isGrouped = isGrouped || colName == item.Column
+		}
+		if !isGrouped {
+			return query, errors.New("column " + item.Column + " should be in GROUP BY")
+		}
+	}
+	var err error
+	query.Where, err = buildPredicate(schema, stmt.Where)
+	return query, err
+}
+
+// getResultColumnNames returns the names of the result columns of the select list, in the given schema.
+func getResultColumnNames(stmt *SelectStatement, schema models.TableSchema) []string {
+	colNames := make([]string, 0)
+	for _, item := range stmt.Items {
+		switch {
+		case item.Func != "":
+			colNames = append(colNames, (&models.Aggregate{Func: item.Func, Column: item.Column,
+				Alias: item.Alias}).GetName())
+		case item.Column == "*":
+			for _, colSchema := range schema.ColumnSchemas {
+				colNames = append(colNames, colSchema.Name)
+			}
+		default:
+			colNames = append(colNames, item.Column)
+		}
+	}
+	return colNames
+}
+
+// finishDataset sorts the rows of a dataset, applies the offset and the limit, and projects the result columns of the
+// select list, the columns to order by should be in the dataset. With DISTINCT, the rows are projected and
+// deduplicated first, so the columns to order by should be in the select list.
+func finishDataset(dataset models.Dataset, stmt *SelectStatement) (models.Dataset, error) {
+	colNames := getResultColumnNames(stmt, dataset.Schema)
+	if stmt.Distinct {
+		projected, err := projectDataset(dataset, colNames)
+		if err != nil {
+			return models.Dataset{}, err
+		}
+		dataset = models.DistinctDataset(projected)
+	}
+
+	if err := dataset.SortRows(stmt.OrderBy); err != nil {
+		return models.Dataset{}, err
+	}
+	if stmt.Offset >= len(dataset.Rows) {
+		dataset.Rows = nil
+	} else {
+		dataset.Rows = dataset.Rows[stmt.Offset:]
+	}
+	if stmt.HasLimit && len(dataset.Rows) > stmt.Limit {
+		dataset.Rows = dataset.Rows[:stmt.Limit]
+	}
+	return projectDataset(dataset, colNames)
+}
+
+// projectDataset returns the given columns of a dataset.
+func projectDataset(dataset models.Dataset, colNames []string) (models.Dataset, error) {
+	result := models.Dataset{Schema: models.TableSchema{TableName: dataset.Schema.TableName}}
+	colIdxs := make([]int, len(colNames))
+	for i, colName := range colNames {
+		colIdx, dataType := dataset.Schema.GetColumnByName(colName)
+		if colIdx == -1 {
+			return models.Dataset{}, errors.New("column " + colName + " doesn't exist")
+		}
+		colIdxs[i] = colIdx
+		result.Schema.ColumnSchemas = append(result.Schema.ColumnSchemas,
+			models.ColumnSchema{Name: colName, DataType: dataType})
+	}
+	for _, row := range dataset.Rows {
+		resultRow := make(models.Row, len(colIdxs))
+		for i, colIdx := range colIdxs {
+			resultRow[i] = row[colIdx]
+		}
+		result.Rows = append(result.Rows, resultRow)
+	}
+	return result, nil
+}
+
+// executeSelect runs a query on a single table with Cluster.Select or Cluster.Aggregate, and joins are run with
+// Cluster.Join before the rest of the query is applied to the joined rows. Subqueries in WHERE are run before the
+// query, see resolveSubqueries.
+func (e *Executor) executeSelect(stmt *SelectStatement) (models.Dataset, error) {
+	schemas, err := e.getTableSchemas(stmt.TableNames)
+	if err != nil {
+		return models.Dataset{}, err
+	}
+
+	// run the subqueries first, and express the conditions that can never be satisfied on the first column
+	if stmt, err = e.resolveSubqueries(stmt, schemas[0].ColumnSchemas[0].Name); err != nil {
+		return models.Dataset{}, err
+	}
+
+	if len(stmt.TableNames) == 1 {
+		schema := schemas[0]
+		if stmt.isAggregate() {
+			query, err := getAggregateQuery(stmt, schema)
+			if err != nil {
+				return models.Dataset{}, err
+			}
+			query.TableName = schema.TableName
+			var dataset models.Dataset
+			if err := e.call("Aggregate", query, &dataset); err != nil {
+				return models.Dataset{}, err
+			}
+			return finishDataset(dataset, stmt)
+		}
+
+		query := models.SelectQuery{TableName: schema.TableName, Distinct: stmt.Distinct, OrderBy: stmt.OrderBy,
+			Offset: stmt.Offset, HasLimit: stmt.HasLimit, Limit: stmt.Limit}
+		if query.Where, err = buildPredicate(schema, stmt.Where); err != nil {
+			return models.Dataset{}, err
+		}
+		query.Columns = getResultColumnNames(stmt, schema)
+		for _, order := range stmt.OrderBy {
+			if schema.GetColIndexByName(order.Column) == -1 {
+				return models.Dataset{}, errors.New("column " + order.Column + " doesn't exist")
+			}
+			if stmt.Distinct && !containsString(query.Columns, order.Column) {
+				return models.Dataset{}, errors.New("column " + order.Column +
+					" to order by should be selected with DISTINCT")
+			}
+		}
+		for _, colName := range query.Columns {
+			if schema.GetColIndexByName(colName) == -1 {
+				return models.Dataset{}, errors.New("column " + colName + " doesn't exist")
+			}
+		}
+		var dataset models.Dataset
+		if err := e.call("Select", query, &dataset); err != nil {
+			return models.Dataset{}, err
+		}
+		return dataset, nil
+	}
+
+	// join the tables on their common columns
+	var joined models.Dataset
+	if err := e.call("Join", stmt.TableNames, &joined); err != nil {
+		return models.Dataset{}, err
+	}
+	if len(joined.Schema.ColumnSchemas) == 0 {
+		// the tables have no common columns, or the join has no results, so rebuild the joined schema
+		for _, schema := range schemas {
+			for _, colSchema := range schema.ColumnSchemas {
+				if joined.Schema.GetColIndexByName(colSchema.Name) == -1 {
+					joined.Schema.ColumnSchemas = append(joined.Schema.ColumnSchemas, colSchema)
+				}
+			}
+		}
+		joined.Rows = nil
+	}
+
+	predicate, err := buildPredicate(joined.Schema, stmt.Where)
+	if err != nil {
+		return models.Dataset{}, err
+	}
+	filtered := models.Dataset{Schema: joined.Schema}
+	for _, row := range joined.Rows {
+		if row.SatisfiesPredicate(joined.Schema, predicate) {
+			filtered.Rows = append(filtered.Rows, row)
+		}
+	}
+
+	if stmt.isAggregate() {
+		query, err := getAggregateQuery(stmt, joined.Schema)
+		if err != nil {
+			return models.Dataset{}, err
+		}
+		// the rows are already filtered
+		query.Where = nil
+		if filtered, err = models.AggregateDataset(filtered, query); err != nil {
+			return models.Dataset{}, err
+		}
+	}
+	return finishDataset(filtered, stmt)
+}
+
+// executeCompoundSelect runs the SELECT statements of a compound statement, and combines their results at the client
+// from left to right.
+func (e *Executor) executeCompoundSelect(stmt *CompoundSelectStatement) (models.Dataset, error) {
+	result, err := e.executeSelect(stmt.Selects[0])
+	if err != nil {
+		return models.Dataset{}, err
+	}
+	for i, op := range stmt.Ops {
+		dataset, err := e.executeSelect(stmt.Selects[i+1])
+		if err != nil {
+			return models.Dataset{}, err
+		}
+		if result, err = models.SetOperationDatasets(op, &result, &dataset); err != nil {
+			return models.Dataset{}, err
+		}
+	}
+
+	// order and limit the combined results by their columns
+	return finishDataset(result, &SelectStatement{Items: []SelectItem{{Column: "*"}}, OrderBy: stmt.OrderBy,
+		HasLimit: stmt.HasLimit, Limit: stmt.Limit, Offset: stmt.Offset})
+}
+
+// containsString returns true if the given string is in the list.
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// executeDelete deletes the rows of a table that satisfy the WHERE clause, the subqueries in the clause are run first
+// like those of a SELECT statement.
+func (e *Executor) executeDelete(stmt *DeleteStatement) error {
+	schema, ok, err := e.getTableSchema(stmt.TableName)
+	if err != nil {
+		return err
+	} else if !ok {
+		return errors.New("table " + stmt.TableName + " doesn't exist")
+	}
+
+	selectStmt, err := e.resolveSubqueries(&SelectStatement{Items: []SelectItem{{Column: "*"}},
+		TableNames: []string{stmt.TableName}, Where: stmt.Where}, schema.ColumnSchemas[0].Name)
+	if err != nil {
+		return err
+	}
+	query := models.DeleteQuery{TableName: stmt.TableName}
+	if query.Where, err = buildPredicate(schema, selectStmt.Where); err != nil {
+		return err
+	}
+	deletedCount := 0
+	return e.call("Delete", query, &deletedCount)
+}
+
+func (e *Executor) executeDropTable(stmt *DropTableStatement) error {
+	if _, ok, err := e.getTableSchema(stmt.TableName); err != nil {
+		return err
+	} else if !ok {
+		return errors.New("table " + stmt.TableName + " doesn't exist")
+	}
+	reply := ""
+	return e.call("DropTable", stmt.TableName, &reply)
+}
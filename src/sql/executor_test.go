@@ -0,0 +1,371 @@
+package sql
+
+import (
+	"../labrpc"
+	"../models"
+	"reflect"
+	"testing"
+)
+
+// set up a cluster with 3 nodes, and an executor connected to it
+func setup(t *testing.T) *Executor {
+	network := labrpc.MakeNetwork()
+	c := models.NewCluster(3, network, "MyCluster")
+	cli := network.MakeEnd("ClientA")
+	network.Connect("ClientA", c.Name)
+	network.Enable("ClientA", true)
+	e := NewExecutor(cli)
+
+	statements := []string{
+		`CREATE TABLE student (sid INT, name VARCHAR, age INT, grade FLOAT) PARTITION BY (
+			ON (0) WHERE grade <= 3.6,
+			ON (1, 2) WHERE grade > 3.6
+		)`,
+		`CREATE TABLE courseRegistration (sid INT, courseId INT) PARTITION BY (ON (2))`,
+		`INSERT INTO student VALUES (0, 'John', 22, 4.0), (1, 'Smith', 23, 3.5), (2, 'Hana', 21, 4.0)`,
+		`INSERT INTO student (sid, name, age, grade) VALUES (3, 'Lewis', 21, 3.0), (4, 'Tom', 23, 4.0)`,
+		`INSERT INTO courseRegistration VALUES (0, 0), (0, 1), (1, 0), (2, 2)`,
+	}
+	for _, statement := range statements {
+		if _, err := e.Execute(statement); err != nil {
+			t.Fatalf("Failed to execute %q: %s", statement, err.Error())
+		}
+	}
+	return e
+}
+
+// check the schema and the rows of a dataset, the rows should be in the same order
+func checkDataset(t *testing.T, statement string, dataset models.Dataset, colNames []string, rows []models.Row) {
+	actualColNames := make([]string, 0)
+	for _, colSchema := range dataset.Schema.ColumnSchemas {
+		actualColNames = append(actualColNames, colSchema.Name)
+	}
+	if !reflect.DeepEqual(actualColNames, colNames) {
+		t.Errorf("%q: expected columns %v, actual %v", statement, colNames, actualColNames)
+	}
+	if len(rows) != len(dataset.Rows) {
+		t.Errorf("%q: expected rows %v, actual %v", statement, rows, dataset.Rows)
+		return
+	}
+	for i, row := range rows {
+		if !row.Equals(&dataset.Rows[i]) {
+			t.Errorf("%q: expected rows %v, actual %v", statement, rows, dataset.Rows)
+			return
+		}
+	}
+}
+
+func TestExecuteSelect(t *testing.T) {
+	e := setup(t)
+
+	statement := "SELECT name, grade FROM student WHERE age >= 22 ORDER BY grade DESC, sid LIMIT 2"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"name", "grade"}, []models.Row{{"John", 4.0}, {"Tom", 4.0}})
+
+	statement = "SELECT * FROM student WHERE name = 'Lewis'"
+	dataset, err = e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid", "name", "age", "grade"},
+		[]models.Row{{3, "Lewis", 21, 3.0}})
+}
+
+func TestExecuteAggregate(t *testing.T) {
+	e := setup(t)
+
+	statement := "SELECT age, COUNT(*) AS total, MAX(grade) FROM student WHERE sid > 0 GROUP BY age ORDER BY age DESC"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"age", "total", "MAX(grade)"},
+		[]models.Row{{23, int64(2), 4.0}, {21, int64(2), 4.0}})
+
+	if _, err := e.Execute("SELECT name, COUNT(*) FROM student GROUP BY age"); err == nil {
+		t.Errorf("Columns not in GROUP BY should not be selected")
+	}
+}
+
+func TestExecuteJoin(t *testing.T) {
+	e := setup(t)
+
+	statement := "SELECT name, courseId FROM student JOIN courseRegistration WHERE courseId < 2 ORDER BY sid, courseId"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"name", "courseId"},
+		[]models.Row{{"John", 0}, {"John", 1}, {"Smith", 0}})
+
+	statement = "SELECT courseId, COUNT(*) FROM student NATURAL JOIN courseRegistration GROUP BY courseId " +
+		"ORDER BY COUNT(*) DESC, courseId"
+	dataset, err = e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"courseId", "COUNT(*)"},
+		[]models.Row{{0, int64(2)}, {1, int64(1)}, {2, int64(1)}})
+}
+
+func TestExecuteDistinct(t *testing.T) {
+	e := setup(t)
+
+	statement := "SELECT DISTINCT age FROM student WHERE sid > 0 ORDER BY age DESC"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"age"}, []models.Row{{23}, {21}})
+
+	statement = "SELECT DISTINCT grade FROM student NATURAL JOIN courseRegistration ORDER BY grade"
+	dataset, err = e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"grade"}, []models.Row{{3.5}, {4.0}})
+
+	if _, err := e.Execute("SELECT DISTINCT age FROM student ORDER BY grade"); err == nil {
+		t.Errorf("DISTINCT rows should only be ordered by the selected columns")
+	}
+}
+
+func TestExecuteSetOperations(t *testing.T) {
+	e := setup(t)
+
+	// students without registrations
+	statement := "SELECT sid FROM student EXCEPT SELECT sid FROM courseRegistration ORDER BY sid"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid"}, []models.Row{{3}, {4}})
+
+	statement = "SELECT sid FROM student WHERE age = 21 UNION SELECT sid FROM courseRegistration WHERE courseId = 0 " +
+		"INTERSECT SELECT sid FROM student WHERE grade = 4.0 ORDER BY sid DESC"
+	dataset, err = e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid"}, []models.Row{{2}, {0}})
+
+	statement = "SELECT sid FROM courseRegistration UNION ALL SELECT sid FROM student WHERE age = 23 ORDER BY sid"
+	dataset, err = e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid"}, []models.Row{{0}, {0}, {1}, {1}, {2}, {4}})
+
+	if _, err := e.Execute("SELECT sid FROM student UNION SELECT name FROM student"); err == nil {
+		t.Errorf("Columns of different data types should not be combined")
+	}
+}
+
+func TestExecuteSubqueries(t *testing.T) {
+	e := setup(t)
+
+	tests := []struct {
+		statement string
+		colNames  []string
+		rows      []models.Row
+	}{
+		{"SELECT name FROM student WHERE sid IN (SELECT sid FROM courseRegistration WHERE courseId = 2)",
+			[]string{"name"}, []models.Row{{"Hana"}}},
+		{"SELECT name FROM student WHERE sid NOT IN (SELECT sid FROM courseRegistration) ORDER BY name",
+			[]string{"name"}, []models.Row{{"Lewis"}, {"Tom"}}},
+		// correlated subqueries are decorrelated into IN subqueries
+		{"SELECT name FROM student WHERE EXISTS (SELECT * FROM courseRegistration " +
+			"WHERE courseRegistration.sid = student.sid AND courseId = 0) ORDER BY name",
+			[]string{"name"}, []models.Row{{"John"}, {"Smith"}}},
+		{"SELECT name FROM student WHERE NOT EXISTS (SELECT * FROM courseRegistration WHERE student.sid = sid) " +
+			"ORDER BY name", []string{"name"}, []models.Row{{"Lewis"}, {"Tom"}}},
+		{"SELECT name FROM student WHERE grade > (SELECT AVG(grade) FROM student) ORDER BY name",
+			[]string{"name"}, []models.Row{{"Hana"}, {"John"}, {"Tom"}}},
+		{"SELECT name FROM student WHERE age = (SELECT age FROM student WHERE sid = 9)",
+			[]string{"name"}, []models.Row{}},
+		{"SELECT name, courseId FROM student NATURAL JOIN courseRegistration " +
+			"WHERE courseId IN (SELECT courseId FROM courseRegistration WHERE sid = 1) ORDER BY name",
+			[]string{"name", "courseId"}, []models.Row{{"John", 0}, {"Smith", 0}}},
+	}
+	for _, test := range tests {
+		dataset, err := e.Execute(test.statement)
+		if err != nil {
+			t.Fatalf("%q: %s", test.statement, err.Error())
+		}
+		checkDataset(t, test.statement, dataset, test.colNames, test.rows)
+	}
+
+	// an uncorrelated EXISTS subquery is run once
+	statement := "SELECT COUNT(*) FROM student WHERE EXISTS (SELECT * FROM courseRegistration WHERE courseId = 5)"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"COUNT(*)"}, []models.Row{{int64(0)}})
+}
+
+func TestExecuteSubqueryErrors(t *testing.T) {
+	e := setup(t)
+
+	statements := []string{
+		"SELECT * FROM student WHERE sid = (SELECT sid FROM courseRegistration)",
+		"SELECT * FROM student WHERE sid IN (SELECT sid, courseId FROM courseRegistration)",
+		"SELECT * FROM student WHERE sid IN (SELECT sid FROM courseRegistration WHERE courseId = student.age)",
+		"SELECT * FROM student WHERE EXISTS (SELECT * FROM courseRegistration WHERE courseId > student.age)",
+		"SELECT * FROM student WHERE teacher.tid = 1",
+		"SELECT * FROM student WHERE sid = age",
+		"CREATE TABLE teacher (tid INT) PARTITION BY (ON (0) WHERE tid IN (SELECT sid FROM student))",
+	}
+	for _, statement := range statements {
+		if _, err := e.Execute(statement); err == nil {
+			t.Errorf("Statement %q should fail", statement)
+		}
+	}
+}
+
+func TestExecuteDropTable(t *testing.T) {
+	e := setup(t)
+
+	if _, err := e.Execute("CREATE TABLE student (sid INT)"); err == nil {
+		t.Errorf("A table should not be created twice")
+	}
+	if _, err := e.Execute("DROP TABLE student"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := e.Execute("SELECT * FROM student"); err == nil {
+		t.Errorf("A dropped table should not be selected")
+	}
+
+	// the table can be created again
+	if _, err := e.Execute("CREATE TABLE student (sid INT, name VARCHAR)"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := e.Execute("INSERT INTO student VALUES (5, 'Amy')"); err != nil {
+		t.Fatal(err.Error())
+	}
+	statement := "SELECT * FROM student"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid", "name"}, []models.Row{{5, "Amy"}})
+}
+
+func TestExecuteDelete(t *testing.T) {
+	e := setup(t)
+
+	statements := []string{
+		"DELETE FROM student WHERE grade > 3.6 AND age < 23",
+		"DELETE FROM courseRegistration WHERE sid NOT IN (SELECT sid FROM student)",
+	}
+	for _, statement := range statements {
+		if _, err := e.Execute(statement); err != nil {
+			t.Fatalf("Failed to execute %q: %s", statement, err.Error())
+		}
+	}
+	statement := "SELECT sid FROM student ORDER BY sid"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid"}, []models.Row{{1}, {3}, {4}})
+	statement = "SELECT sid, courseId FROM courseRegistration"
+	if dataset, err = e.Execute(statement); err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid", "courseId"}, []models.Row{{1, 0}})
+
+	if _, err := e.Execute("DELETE FROM student"); err != nil {
+		t.Fatal(err.Error())
+	}
+	statement = "SELECT * FROM student"
+	if dataset, err = e.Execute(statement); err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid", "name", "age", "grade"}, []models.Row{})
+}
+
+func TestExecuteInsertNullPartitionColumn(t *testing.T) {
+	e := setup(t)
+
+	// the student without a grade is held by no fragment, so none of the students is inserted
+	statement := "INSERT INTO student (sid, name, grade) VALUES (8, 'Amy', 3.0), (9, 'Ben', NULL)"
+	if _, err := e.Execute(statement); err == nil {
+		t.Errorf("Statement %q should fail", statement)
+	}
+	statement = "INSERT INTO student (sid, name) VALUES (9, 'Ben')"
+	if _, err := e.Execute(statement); err == nil {
+		t.Errorf("Statement %q should fail", statement)
+	}
+	statement = "SELECT sid FROM student ORDER BY sid"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid"}, []models.Row{{0}, {1}, {2}, {3}, {4}})
+
+	// the columns missing from the list are NULL, as long as the grade routing the student is given
+	statement = "INSERT INTO student (sid, grade) VALUES (9, 3.0)"
+	if _, err := e.Execute(statement); err != nil {
+		t.Fatal(err.Error())
+	}
+	statement = "SELECT * FROM student WHERE sid = 9"
+	if dataset, err = e.Execute(statement); err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid", "name", "age", "grade"}, []models.Row{{9, nil, nil, 3.0}})
+}
+
+func TestExecuteUpsert(t *testing.T) {
+	e := setup(t)
+
+	// Smith moves to the fragment of high grades keeping the name, and Ann is inserted without a name
+	statement := "INSERT INTO student (sid, age, grade) VALUES (1, 24, 3.8), (5, 20, 3.2) ON CONFLICT (sid) DO UPDATE"
+	for i := 0; i < 2; i++ {
+		if _, err := e.Execute(statement); err != nil {
+			t.Fatalf("Failed to execute %q: %s", statement, err.Error())
+		}
+	}
+	statement = "SELECT * FROM student WHERE sid IN (SELECT sid FROM courseRegistration WHERE courseId = 0) ORDER BY sid"
+	dataset, err := e.Execute(statement)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid", "name", "age", "grade"},
+		[]models.Row{{0, "John", 22, 4.0}, {1, "Smith", 24, 3.8}})
+	statement = "SELECT sid, age FROM student WHERE grade < 3.6 ORDER BY sid"
+	if dataset, err = e.Execute(statement); err != nil {
+		t.Fatal(err.Error())
+	}
+	checkDataset(t, statement, dataset, []string{"sid", "age"}, []models.Row{{3, 21}, {5, 20}})
+
+	// a new student without a grade is held by no fragment
+	statement = "INSERT INTO student (sid, name) VALUES (9, 'Ben') ON CONFLICT (sid) DO UPDATE"
+	if _, err := e.Execute(statement); err == nil {
+		t.Errorf("Statement %q should fail", statement)
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	e := setup(t)
+
+	statements := []string{
+		"SELECT * FROM teacher",
+		"SELECT height FROM student",
+		"SELECT * FROM student WHERE grade = 'high'",
+		"SELECT * FROM student ORDER BY height",
+		"INSERT INTO student VALUES (5, 'Amy')",
+		"INSERT INTO student VALUES ('5', 'Amy', 20, 3.0)",
+		"CREATE TABLE teacher (tid INT) PARTITION BY (ON (0) WHERE age > 1)",
+		"DELETE FROM teacher",
+		"DELETE FROM student WHERE height > 180",
+	}
+	for _, statement := range statements {
+		if _, err := e.Execute(statement); err == nil {
+			t.Errorf("Statement %q should fail", statement)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// enumeration of token kinds
+const (
+	TokenEOF = iota
+	// names of tables and columns, and keywords, which are identifiers matched case-insensitively
+	TokenIdentifier
+	TokenNumber
+	// a string quoted by single quotes, the quotes are not included in the text of the token
+	TokenString
+	// operators and punctuations like "(", ",", "<="
+	TokenSymbol
+)
+
+// Token is a lexical unit of a SQL statement.
+type Token struct {
+	Kind int
+	Text string
+	// position of the token in the statement, used in error messages
+	Pos int
+}
+
+// isKeyword returns true if the token is the given keyword (case-insensitive).
+func (token *Token) isKeyword(keyword string) bool {
+	return token.Kind == TokenIdentifier && strings.EqualFold(token.Text, keyword)
+}
+
+// isSymbol returns true if the token is the given symbol.
+func (token *Token) isSymbol(symbol string) bool {
+	return token.Kind == TokenSymbol && token.Text == symbol
+}
+
+// symbols made of two characters, they are matched before single-character symbols
+var twoCharSymbols = []string{"<=", ">=", "!=", "<>", "=="}
+
+// tokenize splits a SQL statement into tokens, the returned tokens always end with a TokenEOF.
+func tokenize(statement string) ([]Token, error) {
+	tokens := make([]Token, 0)
+	runes := []rune(statement)
+	for pos := 0; pos < len(runes); {
+		r := runes[pos]
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+		case r == '-' && pos+1 < len(runes) && runes[pos+1] == '-':
+			// skip the comment until the end of the line
+			for pos < len(runes) && runes[pos] != '\n' {
+				pos++
+			}
+		case unicode.IsLetter(r) || r == '_':
+			start := pos
+			for pos < len(runes) && (unicode.IsLetter(runes[pos]) || unicode.IsDigit(runes[pos]) || runes[pos] == '_') {
+				pos++
+			}
+			tokens = append(tokens, Token{Kind: TokenIdentifier, Text: string(runes[start:pos]), Pos: start})
+		case unicode.IsDigit(r) || (r == '.' && pos+1 < len(runes) && unicode.IsDigit(runes[pos+1])):
+			start := pos
+			for pos < len(runes) && (unicode.IsDigit(runes[pos]) || runes[pos] == '.') {
+				pos++
+			}
+			tokens = append(tokens, Token{Kind: TokenNumber, Text: string(runes[start:pos]), Pos: start})
+		case r == '\'':
+			start := pos
+			var builder strings.Builder
+			pos++
+			for {
+				if pos >= len(runes) {
+					return nil, fmt.Errorf("unterminated string at position %d", start)
+				}
+				// two single quotes stand for a single quote in the string
+				if runes[pos] == '\'' {
+					if pos+1 < len(runes) && runes[pos+1] == '\'' {
+						builder.WriteRune('\'')
+						pos += 2
+						continue
+					}
+					pos++
+					break
+				}
+				builder.WriteRune(runes[pos])
+				pos++
+			}
+			tokens = append(tokens, Token{Kind: TokenString, Text: builder.String(), Pos: start})
+		default:
+			matched := false
+			for _, symbol := range twoCharSymbols {
+				if strings.HasPrefix(string(runes[pos:]), symbol) {
+					tokens = append(tokens, Token{Kind: TokenSymbol, Text: symbol, Pos: pos})
+					pos += len(symbol)
+					matched = true
+					break
+				}
+			}
+			if matched {
+				continue
+			}
+			if !strings.ContainsRune("(),;*=<>-+.", r) {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, pos)
+			}
+			tokens = append(tokens, Token{Kind: TokenSymbol, Text: string(r), Pos: pos})
+			pos++
+		}
+	}
+	return append(tokens, Token{Kind: TokenEOF, Pos: len(runes)}), nil
+}
@@ -0,0 +1,704 @@
+package sql
+
+import (
+	"../models"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parser is a recursive descent parser over the tokens of a statement.
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+// Parse parses a single SQL statement, the trailing semicolon is optional.
+//
+// Supported statements:
+//
+//	CREATE TABLE name (col type, ...) [PARTITION BY (ON (node, ...) [WHERE cond AND ...] [COLUMNS (col, ...)], ...)]
+//	INSERT INTO name [(col, ...)] VALUES (value, ...), ... [ON CONFLICT (col, ...) DO UPDATE]
+//	SELECT [DISTINCT] item, ... FROM name [[NATURAL] JOIN name ...] [WHERE cond AND ...] [GROUP BY col, ...]
+//		[ORDER BY col [ASC|DESC], ...] [LIMIT n [OFFSET m]]
+//	SELECT ... {UNION [ALL] | INTERSECT | EXCEPT} SELECT ... [ORDER BY ...] [LIMIT n [OFFSET m]]
+//	DELETE FROM name [WHERE cond AND ...]
+//	DROP TABLE name
+//
+// where a condition compares a column with a literal, and a select item is *, a column or an aggregate like COUNT(*)
+// or AVG(grade), optionally followed by AS alias. In SELECT statements, a condition may also be
+// "[NOT] EXISTS (SELECT ...)", "col [NOT] IN (SELECT ...)" or "col op (SELECT ...)".
+func Parse(statement string) (Statement, error) {
+	tokens, err := tokenize(statement)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	var stmt Statement
+	switch {
+	case p.peek().isKeyword("CREATE"):
+		stmt, err = p.parseCreateTable()
+	case p.peek().isKeyword("INSERT"):
+		stmt, err = p.parseInsert()
+	case p.peek().isKeyword("SELECT"):
+		stmt, err = p.parseQuery()
+	case p.peek().isKeyword("DELETE"):
+		stmt, err = p.parseDelete()
+	case p.peek().isKeyword("DROP"):
+		stmt, err = p.parseDropTable()
+	default:
+		return nil, p.errorf("expected a statement")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().isSymbol(";") {
+		p.next()
+	}
+	if p.peek().Kind != TokenEOF {
+		return nil, p.errorf("unexpected %q", p.peek().Text)
+	}
+	return stmt, nil
+}
+
+func (p *parser) peek() *Token {
+	return &p.tokens[p.pos]
+}
+
+func (p *parser) next() *Token {
+	token := &p.tokens[p.pos]
+	if token.Kind != TokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+// errorf returns an error at the position of the current token.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at position %d: %s", p.peek().Pos, fmt.Sprintf(format, args...))
+}
+
+// acceptKeyword consumes the current token if it is the keyword.
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.peek().isKeyword(keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// acceptSymbol consumes the current token if it is the symbol.
+func (p *parser) acceptSymbol(symbol string) bool {
+	if p.peek().isSymbol(symbol) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.errorf("expected %s", keyword)
+	}
+	return nil
+}
+
+func (p *parser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.errorf("expected %q", symbol)
+	}
+	return nil
+}
+
+// keywords that cannot be used as names
+var reservedKeywords = []string{"SELECT", "FROM", "WHERE", "AND", "GROUP", "ORDER", "BY", "LIMIT", "OFFSET", "JOIN",
+	"NATURAL", "ON", "USING", "AS", "ASC", "DESC", "INSERT", "INTO", "VALUES", "CREATE", "TABLE", "PARTITION",
+	"COLUMNS", "DELETE", "DROP", "NULL", "TRUE", "FALSE", "DISTINCT", "UNION", "ALL", "INTERSECT", "EXCEPT", "IN", "NOT",
+	"EXISTS"}
+
+func (p *parser) parseName() (string, error) {
+	token := p.peek()
+	if token.Kind != TokenIdentifier {
+		return "", p.errorf("expected a name")
+	}
+	for _, keyword := range reservedKeywords {
+		if token.isKeyword(keyword) {
+			return "", p.errorf("expected a name instead of keyword %s", keyword)
+		}
+	}
+	p.next()
+	return token.Text, nil
+}
+
+// parseNameList parses (name, name, ...)
+func (p *parser) parseNameList() ([]string, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for {
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	return names, p.expectSymbol(")")
+}
+
+// parseLiteral parses NULL, TRUE, FALSE, a number or a string, numbers without a fraction are parsed as int64.
+func (p *parser) parseLiteral() (interface{}, error) {
+	switch {
+	case p.acceptKeyword("NULL"):
+		return nil, nil
+	case p.acceptKeyword("TRUE"):
+		return true, nil
+	case p.acceptKeyword("FALSE"):
+		return false, nil
+	case p.peek().Kind == TokenString:
+		return p.next().Text, nil
+	}
+
+	negative := false
+	if p.acceptSymbol("-") {
+		negative = true
+	} else {
+		p.acceptSymbol("+")
+	}
+	token := p.peek()
+	if token.Kind != TokenNumber {
+		return nil, p.errorf("expected a literal")
+	}
+	p.next()
+	if intValue, err := strconv.ParseInt(token.Text, 10, 64); err == nil {
+		if negative {
+			intValue = -intValue
+		}
+		return intValue, nil
+	}
+	floatValue, err := strconv.ParseFloat(token.Text, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %s", token.Text)
+	}
+	if negative {
+		floatValue = -floatValue
+	}
+	return floatValue, nil
+}
+
+// operators of comparisons, and the operators with the column and the literal swapped
+var comparisonOps = map[string]string{"=": "==", "==": "==", "!=": "!=", "<>": "!=", "<": "<", "<=": "<=", ">": ">",
+	">=": ">="}
+var swappedOps = map[string]string{"==": "==", "!=": "!=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// parseColumnName parses a column name, which may be qualified by its table like student.sid.
+func (p *parser) parseColumnName() (string, error) {
+	name, err := p.parseName()
+	if err != nil || !p.acceptSymbol(".") {
+		return name, err
+	}
+	colName, err := p.parseName()
+	return name + "." + colName, err
+}
+
+// parseSubquery parses a SELECT statement in parentheses.
+func (p *parser) parseSubquery() (*Subquery, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	if !p.peek().isKeyword("SELECT") {
+		return nil, p.errorf("expected a subquery")
+	}
+	stmt, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	return &Subquery{Statement: stmt}, p.expectSymbol(")")
+}
+
+// parseComparison parses "column op literal" or "literal op column". In SELECT statements, a comparison may also be
+// "[NOT] EXISTS (subquery)", "column [NOT] IN (subquery)", "column op (subquery)" or "column op table.column".
+func (p *parser) parseComparison() (Comparison, error) {
+	var comparison Comparison
+	var err error
+	if p.acceptKeyword("NOT") {
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return comparison, err
+		}
+		comparison.Op = OpNotExists
+		comparison.Value, err = p.parseSubquery()
+		return comparison, err
+	}
+	if p.acceptKeyword("EXISTS") {
+		comparison.Op = OpExists
+		comparison.Value, err = p.parseSubquery()
+		return comparison, err
+	}
+
+	isColumnFirst := p.peek().Kind == TokenIdentifier && !p.peek().isKeyword("NULL") &&
+		!p.peek().isKeyword("TRUE") && !p.peek().isKeyword("FALSE")
+	if isColumnFirst {
+		if comparison.Column, err = p.parseColumnName(); err != nil {
+			return comparison, err
+		}
+		if p.acceptKeyword("NOT") {
+			if err := p.expectKeyword("IN"); err != nil {
+				return comparison, err
+			}
+			comparison.Op = models.OpNotIn
+			comparison.Value, err = p.parseSubquery()
+			return comparison, err
+		}
+		if p.acceptKeyword("IN") {
+			comparison.Op = models.OpIn
+			comparison.Value, err = p.parseSubquery()
+			return comparison, err
+		}
+	} else if comparison.Value, err = p.parseLiteral(); err != nil {
+		return comparison, err
+	}
+
+	op, ok := comparisonOps[p.peek().Text]
+	if p.peek().Kind != TokenSymbol || !ok {
+		return comparison, p.errorf("expected a comparison operator")
+	}
+	p.next()
+
+	if !isColumnFirst {
+		comparison.Op = swappedOps[op]
+		comparison.Column, err = p.parseColumnName()
+		return comparison, err
+	}
+	comparison.Op = op
+	switch {
+	case p.peek().isSymbol("("):
+		comparison.Value, err = p.parseSubquery()
+	case p.peek().Kind == TokenIdentifier && !p.peek().isKeyword("NULL") && !p.peek().isKeyword("TRUE") &&
+		!p.peek().isKeyword("FALSE"):
+		var colName string
+		if colName, err = p.parseColumnName(); err == nil {
+			tableName, colName := splitColumnName(colName)
+			comparison.Value = ColumnReference{TableName: tableName, Column: colName}
+		}
+	default:
+		comparison.Value, err = p.parseLiteral()
+	}
+	return comparison, err
+}
+
+// parseConditions parses "cond AND cond AND ..."
+func (p *parser) parseConditions() ([]Comparison, error) {
+	comparisons := make([]Comparison, 0)
+	for {
+		comparison, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		comparisons = append(comparisons, comparison)
+		if !p.acceptKeyword("AND") {
+			return comparisons, nil
+		}
+	}
+}
+
+// names of data types, and the data types in models
+var dataTypes = map[string]int{
+	"INT":     models.TypeInt32,
+	"INT32":   models.TypeInt32,
+	"INTEGER": models.TypeInt32,
+	"BIGINT":  models.TypeInt64,
+	"INT64":   models.TypeInt64,
+	"FLOAT":   models.TypeFloat,
+	"REAL":    models.TypeFloat,
+	"DOUBLE":  models.TypeDouble,
+	"BOOL":    models.TypeBoolean,
+	"BOOLEAN": models.TypeBoolean,
+	"VARCHAR": models.TypeString,
+	"CHAR":    models.TypeString,
+	"TEXT":    models.TypeString,
+	"STRING":  models.TypeString,
+}
+
+func (p *parser) parseCreateTable() (*CreateTableStatement, error) {
+	p.next()
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	stmt := &CreateTableStatement{}
+	var err error
+	if stmt.Schema.TableName, err = p.parseName(); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		colName, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		dataType, ok := dataTypes[strings.ToUpper(p.peek().Text)]
+		if p.peek().Kind != TokenIdentifier || !ok {
+			return nil, p.errorf("expected a data type")
+		}
+		p.next()
+		// the length like VARCHAR(20) is ignored
+		if p.acceptSymbol("(") {
+			if p.next().Kind != TokenNumber {
+				return nil, p.errorf("expected a length")
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+		}
+		stmt.Schema.ColumnSchemas = append(stmt.Schema.ColumnSchemas,
+			models.ColumnSchema{Name: colName, DataType: dataType})
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+
+	if !p.acceptKeyword("PARTITION") {
+		return stmt, nil
+	}
+	if err := p.expectKeyword("BY"); err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		partition := PartitionDefinition{}
+		if err := p.expectKeyword("ON"); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		for {
+			token := p.next()
+			nodeIdx, err := strconv.Atoi(token.Text)
+			if token.Kind != TokenNumber || err != nil {
+				return nil, p.errorf("expected a node index")
+			}
+			partition.NodeIndices = append(partition.NodeIndices, nodeIdx)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("WHERE") {
+			if partition.Predicate, err = p.parseConditions(); err != nil {
+				return nil, err
+			}
+		}
+		if p.acceptKeyword("COLUMNS") {
+			if partition.Columns, err = p.parseNameList(); err != nil {
+				return nil, err
+			}
+		}
+		stmt.Partitions = append(stmt.Partitions, partition)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	return stmt, p.expectSymbol(")")
+}
+
+func (p *parser) parseInsert() (*InsertStatement, error) {
+	p.next()
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	stmt := &InsertStatement{}
+	var err error
+	if stmt.TableName, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if p.peek().isSymbol("(") {
+		if stmt.Columns, err = p.parseNameList(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, 0)
+		for {
+			value, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, value)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		stmt.Rows = append(stmt.Rows, row)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if !p.acceptKeyword("ON") {
+		return stmt, nil
+	}
+	if err := p.expectKeyword("CONFLICT"); err != nil {
+		return nil, err
+	}
+	if stmt.ConflictColumns, err = p.parseNameList(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("DO"); err != nil {
+		return nil, err
+	}
+	return stmt, p.expectKeyword("UPDATE")
+}
+
+// parseSelectItem parses *, a column or an aggregate, optionally followed by AS alias.
+func (p *parser) parseSelectItem() (SelectItem, error) {
+	item := SelectItem{}
+	if p.acceptSymbol("*") {
+		item.Column = "*"
+		return item, nil
+	}
+
+	name, err := p.parseName()
+	if err != nil {
+		return item, err
+	}
+	if p.acceptSymbol("(") {
+		item.Func = strings.ToUpper(name)
+		switch item.Func {
+		case models.AggregateCount, models.AggregateSum, models.AggregateAvg, models.AggregateMin,
+			models.AggregateMax:
+		default:
+			return item, p.errorf("unknown aggregate function %s", name)
+		}
+		if p.acceptSymbol("*") {
+			item.Column = "*"
+		} else if item.Column, err = p.parseName(); err != nil {
+			return item, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return item, err
+		}
+	} else {
+		item.Column = name
+	}
+
+	if p.acceptKeyword("AS") {
+		if item.Alias, err = p.parseName(); err != nil {
+			return item, err
+		}
+	}
+	return item, nil
+}
+
+// parseQuery parses a SELECT statement, or SELECT statements combined by set operations.
+func (p *parser) parseQuery() (Statement, error) {
+	first, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &CompoundSelectStatement{Selects: []*SelectStatement{first}}
+	for {
+		var op string
+		switch {
+		case p.acceptKeyword("UNION"):
+			op = models.SetUnion
+			if p.acceptKeyword("ALL") {
+				op = models.SetUnionAll
+			}
+		case p.acceptKeyword("INTERSECT"):
+			op = models.SetIntersect
+		case p.acceptKeyword("EXCEPT"):
+			op = models.SetExcept
+		}
+		if op == "" {
+			break
+		}
+
+		// only the last statement may be followed by ORDER BY and LIMIT, which apply to the combined results
+		last := stmt.Selects[len(stmt.Selects)-1]
+		if len(last.OrderBy) > 0 || last.HasLimit || last.Offset > 0 {
+			return nil, p.errorf("ORDER BY and LIMIT should follow the last SELECT")
+		}
+		next, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Selects = append(stmt.Selects, next)
+		stmt.Ops = append(stmt.Ops, op)
+	}
+	if len(stmt.Selects) == 1 {
+		return first, nil
+	}
+
+	last := stmt.Selects[len(stmt.Selects)-1]
+	stmt.OrderBy, stmt.HasLimit, stmt.Limit, stmt.Offset = last.OrderBy, last.HasLimit, last.Limit, last.Offset
+	last.OrderBy, last.HasLimit, last.Limit, last.Offset = nil, false, 0, 0
+	return stmt, nil
+}
+
+func (p *parser) parseSelect() (*SelectStatement, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	stmt := &SelectStatement{Distinct: p.acceptKeyword("DISTINCT")}
+	for {
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Items = append(stmt.Items, item)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	for {
+		tableName, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.TableNames = append(stmt.TableNames, tableName)
+
+		// the tables are always joined on their common columns
+		if p.acceptKeyword("NATURAL") {
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return nil, err
+			}
+		} else if !p.acceptKeyword("JOIN") {
+			break
+		}
+	}
+	if p.peek().isKeyword("ON") || p.peek().isKeyword("USING") {
+		return nil, p.errorf("only NATURAL JOIN is supported")
+	}
+
+	var err error
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseConditions(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			colName, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, colName)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			// aggregates are ordered by their names in the results
+			item, err := p.parseSelectItem()
+			if err != nil {
+				return nil, err
+			}
+			if (item.Column == "*" && item.Func == "") || item.Alias != "" {
+				return nil, p.errorf("expected a column or an aggregate to order by")
+			}
+			order := models.OrderBy{Column: item.Column}
+			if item.Func != "" {
+				order.Column = (&models.Aggregate{Func: item.Func, Column: item.Column}).GetName()
+			}
+			if p.acceptKeyword("DESC") {
+				order.Desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, order)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		stmt.HasLimit = true
+		if stmt.Limit, err = p.parseCount(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("OFFSET") {
+		if stmt.Offset, err = p.parseCount(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+// parseCount parses a non-negative integer.
+func (p *parser) parseCount() (int, error) {
+	token := p.peek()
+	count, err := strconv.Atoi(token.Text)
+	if token.Kind != TokenNumber || err != nil || count < 0 {
+		return 0, p.errorf("expected a non-negative integer")
+	}
+	p.next()
+	return count, nil
+}
+
+func (p *parser) parseDelete() (*DeleteStatement, error) {
+	p.next()
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	stmt := &DeleteStatement{}
+	var err error
+	if stmt.TableName, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseConditions(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseDropTable() (*DropTableStatement, error) {
+	p.next()
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	stmt := &DropTableStatement{}
+	var err error
+	stmt.TableName, err = p.parseName()
+	return stmt, err
+}
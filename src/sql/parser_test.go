@@ -0,0 +1,166 @@
+package sql
+
+import (
+	"../models"
+	"reflect"
+	"testing"
+)
+
+func TestParseCreateTable(t *testing.T) {
+	stmt, err := Parse(`CREATE TABLE student (sid INT, name VARCHAR(20), age INT, grade FLOAT)
+		PARTITION BY (
+			ON (0, 1) WHERE grade <= 3.6,
+			ON (2) WHERE 3.6 < grade COLUMNS (sid, name)
+		);`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := &CreateTableStatement{
+		Schema: models.TableSchema{
+			TableName: "student",
+			ColumnSchemas: []models.ColumnSchema{
+				{Name: "sid", DataType: models.TypeInt32},
+				{Name: "name", DataType: models.TypeString},
+				{Name: "age", DataType: models.TypeInt32},
+				{Name: "grade", DataType: models.TypeFloat},
+			},
+		},
+		Partitions: []PartitionDefinition{
+			{NodeIndices: []int{0, 1}, Predicate: []Comparison{{"grade", "<=", 3.6}}},
+			{NodeIndices: []int{2}, Predicate: []Comparison{{"grade", ">", 3.6}}, Columns: []string{"sid", "name"}},
+		},
+	}
+	if !reflect.DeepEqual(stmt, expected) {
+		t.Errorf("Incorrect statement, expected %+v, actual %+v", expected, stmt)
+	}
+}
+
+func TestParseInsert(t *testing.T) {
+	stmt, err := Parse("insert into student (sid, name, grade) values (0, 'O''Brien', -3.5), (1, NULL, 4)")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := &InsertStatement{
+		TableName: "student",
+		Columns:   []string{"sid", "name", "grade"},
+		Rows:      [][]interface{}{{int64(0), "O'Brien", -3.5}, {int64(1), nil, int64(4)}},
+	}
+	if !reflect.DeepEqual(stmt, expected) {
+		t.Errorf("Incorrect statement, expected %+v, actual %+v", expected, stmt)
+	}
+}
+
+func TestParseUpsert(t *testing.T) {
+	stmt, err := Parse("INSERT INTO student (sid, grade) VALUES (0, 3.5) ON CONFLICT (sid) DO UPDATE;")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := &InsertStatement{
+		TableName:       "student",
+		Columns:         []string{"sid", "grade"},
+		Rows:            [][]interface{}{{int64(0), 3.5}},
+		ConflictColumns: []string{"sid"},
+	}
+	if !reflect.DeepEqual(stmt, expected) {
+		t.Errorf("Incorrect statement, expected %+v, actual %+v", expected, stmt)
+	}
+}
+
+func TestParseSelect(t *testing.T) {
+	stmt, err := Parse(`SELECT age, COUNT(*) AS total, avg(grade) FROM student NATURAL JOIN courseRegistration
+		WHERE grade >= 3.0 AND courseId != 2 -- a comment
+		GROUP BY age ORDER BY total DESC, age LIMIT 10 OFFSET 5`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := &SelectStatement{
+		Items: []SelectItem{
+			{Column: "age"},
+			{Column: "*", Func: models.AggregateCount, Alias: "total"},
+			{Column: "grade", Func: models.AggregateAvg},
+		},
+		TableNames: []string{"student", "courseRegistration"},
+		Where:      []Comparison{{"grade", ">=", 3.0}, {"courseId", "!=", int64(2)}},
+		GroupBy:    []string{"age"},
+		OrderBy:    []models.OrderBy{{Column: "total", Desc: true}, {Column: "age"}},
+		HasLimit:   true,
+		Limit:      10,
+		Offset:     5,
+	}
+	if !reflect.DeepEqual(stmt, expected) {
+		t.Errorf("Incorrect statement, expected %+v, actual %+v", expected, stmt)
+	}
+}
+
+func TestParseCompoundSelect(t *testing.T) {
+	stmt, err := Parse(`SELECT DISTINCT sid FROM student UNION ALL SELECT sid FROM courseRegistration
+		EXCEPT SELECT sid FROM student WHERE grade < 3.0 ORDER BY sid DESC LIMIT 3`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := &CompoundSelectStatement{
+		Selects: []*SelectStatement{
+			{Distinct: true, Items: []SelectItem{{Column: "sid"}}, TableNames: []string{"student"}},
+			{Items: []SelectItem{{Column: "sid"}}, TableNames: []string{"courseRegistration"}},
+			{Items: []SelectItem{{Column: "sid"}}, TableNames: []string{"student"},
+				Where: []Comparison{{"grade", "<", 3.0}}},
+		},
+		Ops:      []string{models.SetUnionAll, models.SetExcept},
+		OrderBy:  []models.OrderBy{{Column: "sid", Desc: true}},
+		HasLimit: true,
+		Limit:    3,
+	}
+	if !reflect.DeepEqual(stmt, expected) {
+		t.Errorf("Incorrect statement, expected %+v, actual %+v", expected, stmt)
+	}
+}
+
+func TestParseSubqueries(t *testing.T) {
+	stmt, err := Parse(`SELECT name FROM student WHERE sid NOT IN (SELECT sid FROM courseRegistration)
+		AND EXISTS (SELECT * FROM courseRegistration WHERE courseRegistration.sid = student.sid)
+		AND grade > (SELECT AVG(grade) FROM student)`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := &SelectStatement{
+		Items:      []SelectItem{{Column: "name"}},
+		TableNames: []string{"student"},
+		Where: []Comparison{
+			{"sid", models.OpNotIn, &Subquery{Statement: &SelectStatement{Items: []SelectItem{{Column: "sid"}},
+				TableNames: []string{"courseRegistration"}}}},
+			{"", OpExists, &Subquery{Statement: &SelectStatement{Items: []SelectItem{{Column: "*"}},
+				TableNames: []string{"courseRegistration"},
+				Where: []Comparison{{"courseRegistration.sid", "==",
+					ColumnReference{TableName: "student", Column: "sid"}}}}}},
+			{"grade", ">", &Subquery{Statement: &SelectStatement{
+				Items:      []SelectItem{{Column: "grade", Func: models.AggregateAvg}},
+				TableNames: []string{"student"}}}},
+		},
+	}
+	if !reflect.DeepEqual(stmt, expected) {
+		t.Errorf("Incorrect statement, expected %+v, actual %+v", expected, stmt)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	statements := []string{
+		"SELECT FROM student",
+		"SELECT * FROM student JOIN courseRegistration ON sid",
+		"SELECT * FROM student WHERE grade",
+		"SELECT MEDIAN(grade) FROM student",
+		"SELECT * FROM student LIMIT -1",
+		"CREATE TABLE student (sid UNKNOWN)",
+		"INSERT INTO student VALUES ('unterminated)",
+		"DROP TABLE student extra",
+		"SELECT sid FROM student LIMIT 1 UNION SELECT sid FROM courseRegistration",
+		"SELECT sid FROM student INTERSECT",
+		"SELECT * FROM student WHERE sid IN (1, 2)",
+		"SELECT * FROM student WHERE NOT sid = 1",
+	}
+	for _, statement := range statements {
+		if _, err := Parse(statement); err == nil {
+			t.Errorf("Statement %q should not be parsed", statement)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package sql
+
+import (
+	"../models"
+	"errors"
+	"strings"
+)
+
+// executeQuery runs a SELECT statement or a compound one.
+func (e *Executor) executeQuery(stmt Statement) (models.Dataset, error) {
+	switch s := stmt.(type) {
+	case *SelectStatement:
+		return e.executeSelect(s)
+	case *CompoundSelectStatement:
+		return e.executeCompoundSelect(s)
+	}
+	return models.Dataset{}, errors.New("a subquery should be a SELECT statement")
+}
+
+// splitColumnName splits a column name qualified by its table like student.sid, the table name is empty if the column
+// is not qualified.
+func splitColumnName(colName string) (string, string) {
+	if parts := strings.SplitN(colName, ".", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", colName
+}
+
+// decorrelateExists rewrites a correlated EXISTS subquery like
+// EXISTS (SELECT * FROM courseRegistration WHERE courseRegistration.sid = student.sid AND courseId = 2)
+// into an uncorrelated IN subquery on the outer column, like
+// student.sid IN (SELECT sid FROM courseRegistration WHERE courseId = 2),
+// so that it runs once as a semi-join instead of once per outer row. It returns the rewritten subquery and the outer
+// column, or false if the subquery is not correlated with the outer tables.
+func decorrelateExists(subquery *Subquery, outerTableNames []string) (*SelectStatement, string, bool, error) {
+	stmt, ok := subquery.Statement.(*SelectStatement)
+	if !ok {
+		return nil, "", false, nil
+	}
+	isOuterTable := func(tableName string) bool {
+		return containsString(outerTableNames, tableName) && !containsString(stmt.TableNames, tableName)
+	}
+
+	var innerColName, outerColName string
+	rest := make([]Comparison, 0)
+	for _, comparison := range stmt.Where {
+		tableName, colName := splitColumnName(comparison.Column)
+		ref, isRef := comparison.Value.(ColumnReference)
+		isLeftOuter := isOuterTable(tableName)
+		isRightOuter := isRef && isOuterTable(ref.TableName)
+		if !isLeftOuter && !isRightOuter {
+			rest = append(rest, comparison)
+			continue
+		}
+
+		if outerColName != "" {
+			return nil, "", false, errors.New("a correlated subquery should have a single equality on the outer " +
+				"tables")
+		}
+		if comparison.Op != "==" || (isLeftOuter && !isRef) || (isLeftOuter && isRightOuter) {
+			return nil, "", false, errors.New("a correlated subquery should compare a column of the outer tables " +
+				"with a column of the subquery by =")
+		}
+		if isLeftOuter {
+			innerColName, outerColName = ref.Column, comparison.Column
+		} else {
+			innerColName, outerColName = colName, ref.TableName+"."+ref.Column
+		}
+	}
+	if outerColName == "" {
+		return nil, "", false, nil
+	}
+	if stmt.isAggregate() || stmt.HasLimit || stmt.Offset > 0 {
+		return nil, "", false, errors.New("a correlated subquery should not have aggregates, LIMIT or OFFSET")
+	}
+
+	return &SelectStatement{Items: []SelectItem{{Column: innerColName}}, TableNames: stmt.TableNames, Where: rest},
+		outerColName, true, nil
+}
+
+// getSubqueryValues returns the values of the single column in the results of a subquery.
+func getSubqueryValues(dataset models.Dataset) ([]interface{}, error) {
+	if len(dataset.Schema.ColumnSchemas) != 1 {
+		return nil, errors.New("a subquery compared with a column should return a single column")
+	}
+	values := make([]interface{}, len(dataset.Rows))
+	for i, row := range dataset.Rows {
+		values[i] = row[0]
+	}
+	return values, nil
+}
+
+// resolveSubqueries returns a copy of the statement whose WHERE clause only compares the columns in FROM with values.
+// The columns qualified by the tables in FROM lose their qualifiers, and the subqueries are run first:
+//   - [NOT] IN subqueries become models.OpIn or models.OpNotIn conditions on the ValueSet of their results, which is
+//     shipped to the nodes with the query,
+//   - scalar subqueries become comparisons with their single result,
+//   - uncorrelated [NOT] EXISTS subqueries are dropped if they are satisfied, and correlated ones are decorrelated into
+//     IN subqueries (see decorrelateExists).
+//
+// A condition that can never be satisfied is replaced by falseColName IN (), so that all the fragments are pruned.
+// As any other condition, NOT IN and NOT EXISTS are never satisfied by a NULL column.
+func (e *Executor) resolveSubqueries(stmt *SelectStatement, falseColName string) (*SelectStatement, error) {
+	resolved := *stmt
+	resolved.Where = make([]Comparison, 0, len(stmt.Where))
+	falseComparison := Comparison{Column: falseColName, Op: models.OpIn, Value: models.ValueSet{}}
+
+	for _, comparison := range stmt.Where {
+		if comparison.Op == OpExists || comparison.Op == OpNotExists {
+			subquery := comparison.Value.(*Subquery)
+			inner, outerColName, ok, err := decorrelateExists(subquery, stmt.TableNames)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				op := models.OpIn
+				if comparison.Op == OpNotExists {
+					op = models.OpNotIn
+				}
+				comparison = Comparison{Column: outerColName, Op: op, Value: &Subquery{Statement: inner}}
+			} else {
+				dataset, err := e.executeQuery(subquery.Statement)
+				if err != nil {
+					return nil, err
+				}
+				if (len(dataset.Rows) > 0) != (comparison.Op == OpExists) {
+					resolved.Where = append(resolved.Where, falseComparison)
+				}
+				continue
+			}
+		}
+
+		tableName, colName := splitColumnName(comparison.Column)
+		if tableName != "" && !containsString(stmt.TableNames, tableName) {
+			return nil, errors.New("table " + tableName + " is not in FROM, only EXISTS subqueries can refer to the " +
+				"tables of the outer statement")
+		}
+		comparison.Column = colName
+
+		switch v := comparison.Value.(type) {
+		case ColumnReference:
+			return nil, errors.New("column " + comparison.Column + " can only be compared with column " + v.Column +
+				" of an outer table in an EXISTS subquery")
+		case *Subquery:
+			dataset, err := e.executeQuery(v.Statement)
+			if err != nil {
+				return nil, err
+			}
+			values, err := getSubqueryValues(dataset)
+			if err != nil {
+				return nil, err
+			}
+
+			if comparison.Op == models.OpIn || comparison.Op == models.OpNotIn {
+				valueSet := make(models.ValueSet)
+				for _, value := range values {
+					valueSet[value] = true
+				}
+				comparison.Value = valueSet
+			} else if len(values) > 1 {
+				return nil, errors.New("a scalar subquery should return at most one row")
+			} else if len(values) == 0 || values[0] == nil {
+				// comparing with NULL is never satisfied
+				comparison = falseComparison
+			} else {
+				comparison.Value = values[0]
+			}
+		}
+		resolved.Where = append(resolved.Where, comparison)
+	}
+	return &resolved, nil
+}